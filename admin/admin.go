@@ -0,0 +1,376 @@
+// Package admin builds the microblog command line. The admin commands only
+// use the storage interfaces, so they work against any backend.
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"microblog/dump"
+	"microblog/loadgen"
+	"microblog/storage"
+	"microblog/sweeper"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+// Backend is what the admin commands work with.
+type Backend struct {
+	Storage     storage.Storage
+	Maintenance storage.MaintenanceStore
+	Suspensions storage.SuspensionStore
+	Feeds       storage.FeedStore
+	Export      storage.ExportStore
+	Import      storage.ImportStore
+}
+
+type Config struct {
+	// Serve and Work run the API server and the queue worker until they fail
+	Serve func() error
+	Work  func() error
+	// Open connects to the storage, only admin commands call it
+	Open func() (Backend, error)
+	// Route names the routes in load reports, optional
+	Route func(r *http.Request) string
+}
+
+var errNoUser = errors.New("user id is required")
+
+var errNotStopped = errors.New("unique indexes are missing while they are rebuilt, stop the servers and workers and pass --stopped")
+
+// NewApp builds the CLI, output goes to app.Writer.
+func NewApp(config Config) *cli.App {
+	app := cli.NewApp()
+	app.Name = "microblog"
+	app.Usage = "microblog server, worker and admin tools"
+	app.Version = "0.0.0"
+
+	// backend runs action with an opened backend
+	backend := func(action func(c *cli.Context, b Backend) error) cli.ActionFunc {
+		return func(c *cli.Context) error {
+			b, err := config.Open()
+			if err != nil {
+				return err
+			}
+			return action(c, b)
+		}
+	}
+
+	synthesis := loadgen.DefaultSynthesis()
+
+	app.Commands = []cli.Command{
+		{
+			Name:  "serve",
+			Usage: "run the API server",
+			Action: func(c *cli.Context) error {
+				return config.Serve()
+			},
+		},
+		{
+			Name:  "worker",
+			Usage: "run the queue worker",
+			Action: func(c *cli.Context) error {
+				return config.Work()
+			},
+		},
+		{
+			Name:   "migrate",
+			Usage:  "create missing collections and indexes",
+			Action: backend(migrate),
+		},
+		{
+			Name:  "reindex",
+			Usage: "drop the indexes and build them anew, only while the servers and workers are stopped",
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "stopped", Usage: "confirm that no server or worker is running"},
+			},
+			Action: backend(reindex),
+		},
+		{
+			Name:   "stats",
+			Usage:  "print what the storage keeps, as JSON",
+			Action: backend(stats),
+		},
+		{
+			Name:  "rebuild-feed",
+			Usage: "recompute feeds from subscriptions and posts, print the repairs as JSON",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "user", Usage: "the user whose feed is rebuilt"},
+				cli.BoolFlag{Name: "all", Usage: "rebuild all feeds"},
+			},
+			Action: backend(rebuildFeed),
+		},
+		{
+			Name:  "export",
+			Usage: "write all posts, subscriptions, feeds and poll votes as JSON Lines",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "out", Usage: "the file to write, standard output if not set"},
+				cli.BoolFlag{Name: "resume", Usage: "continue an export that stopped midway in --out"},
+			},
+			Action: backend(export),
+		},
+		{
+			Name:      "import",
+			Usage:     "replay an export into the storage, print what was imported as JSON",
+			ArgsUsage: "[FILE]",
+			Action:    backend(importExport),
+		},
+		{
+			Name:      "replay",
+			Usage:     "replay request traces against a server, print latencies and errors per route as JSON",
+			ArgsUsage: "[FILE]",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "url", Value: "http://localhost:8080", Usage: "the server"},
+				cli.IntFlag{Name: "concurrency", Value: loadgen.DefaultConcurrency, Usage: "requests waiting for an answer at once"},
+				cli.Float64Flag{Name: "rate", Usage: "requests per second, the timing of the traces if not set"},
+			},
+			Action: func(c *cli.Context) error {
+				return replay(c, config.Route)
+			},
+		},
+		{
+			Name:  "synthesize",
+			Usage: "write made up request traces with a power-law follow graph",
+			Flags: []cli.Flag{
+				cli.IntFlag{Name: "users", Value: synthesis.Users},
+				cli.IntFlag{Name: "follows", Value: synthesis.Follows, Usage: "subscriptions a user makes on average"},
+				cli.Float64Flag{Name: "exponent", Value: synthesis.Exponent, Usage: "of the power law, above 1"},
+				cli.DurationFlag{Name: "duration", Value: synthesis.Duration, Usage: "how long the traffic lasts"},
+				cli.Float64Flag{Name: "post-rate", Value: synthesis.PostRate, Usage: "posts per second"},
+				cli.Float64Flag{Name: "read-rate", Value: synthesis.ReadRate, Usage: "feed reads per second"},
+				cli.Int64Flag{Name: "seed", Value: synthesis.Seed},
+			},
+			Action: synthesize,
+		},
+		{
+			Name:  "user",
+			Usage: "manage users",
+			Subcommands: []cli.Command{
+				{
+					Name:      "suspend",
+					Usage:     "keep the user from writing anything",
+					ArgsUsage: "USER",
+					Flags: []cli.Flag{
+						cli.StringFlag{Name: "reason", Usage: "why the user is suspended"},
+					},
+					Action: backend(suspend),
+				},
+				{
+					Name:      "unsuspend",
+					Usage:     "lift the suspension of the user",
+					ArgsUsage: "USER",
+					Action:    backend(unsuspend),
+				},
+			},
+		},
+	}
+
+	return app
+}
+
+func migrate(c *cli.Context, b Backend) error {
+	err := b.Maintenance.Migrate(context.Background())
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(c.App.Writer, "storage is up to date")
+	return nil
+}
+
+func reindex(c *cli.Context, b Backend) error {
+	if !c.Bool("stopped") {
+		return errNotStopped
+	}
+
+	err := b.Maintenance.Reindex(context.Background())
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(c.App.Writer, "indexes are rebuilt")
+	return nil
+}
+
+func stats(c *cli.Context, b Backend) error {
+	stats, err := b.Maintenance.Stats(context.Background())
+	if err != nil {
+		return err
+	}
+
+	return printJSON(c, stats)
+}
+
+func printJSON(c *cli.Context, value interface{}) error {
+	encoder := json.NewEncoder(c.App.Writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func rebuildFeed(c *cli.Context, b Backend) error {
+	user := c.String("user")
+	if user == "" && !c.Bool("all") {
+		return errors.New("either --user or --all is required")
+	}
+
+	var repairs interface{}
+	var err error
+	if user != "" {
+		repairs, err = b.Feeds.RebuildFeed(context.Background(), user)
+	} else {
+		// only the feeds that were out of sync
+		repairs, err = sweeper.NewSweeper(b.Feeds).Sweep(context.Background())
+	}
+	if err != nil {
+		return err
+	}
+
+	return printJSON(c, repairs)
+}
+
+func export(c *cli.Context, b Backend) error {
+	path := c.String("out")
+	if path == "" {
+		if c.Bool("resume") {
+			return errors.New("--resume requires --out")
+		}
+		_, err := dump.NewExporter(b.Export).Export(context.Background(), c.App.Writer, dump.Position{})
+		return err
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if c.Bool("resume") {
+		flags = os.O_RDWR | os.O_CREATE
+	}
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	from := dump.Position{}
+	if c.Bool("resume") {
+		var size int64
+		from, size, err = dump.Resume(file)
+		if err != nil {
+			return err
+		}
+		// drop a line that was only partly written
+		if err = file.Truncate(size); err != nil {
+			return err
+		}
+		if _, err = file.Seek(size, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	written, err := dump.NewExporter(b.Export).Export(context.Background(), file, from)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.App.Writer, "%d records written to %v\n", written, path)
+	return nil
+}
+
+func importExport(c *cli.Context, b Backend) error {
+	var r io.Reader = os.Stdin
+	if path := c.Args().First(); path != "" && path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	stats, err := dump.Import(context.Background(), b.Import, r)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(c, stats)
+}
+
+func replay(c *cli.Context, route func(r *http.Request) string) error {
+	var r io.Reader = os.Stdin
+	if path := c.Args().First(); path != "" && path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	replayer := loadgen.NewReplayer(c.String("url"))
+	replayer.Concurrency = c.Int("concurrency")
+	replayer.Rate = c.Float64("rate")
+	if route != nil {
+		replayer.Route = route
+	}
+	if replayer.Concurrency < 1 {
+		return errors.New("--concurrency must be at least 1")
+	}
+
+	report, err := replayer.Replay(context.Background(), r)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(c, report)
+}
+
+func synthesize(c *cli.Context) error {
+	synthesis := loadgen.Synthesis{
+		Users:    c.Int("users"),
+		Follows:  c.Int("follows"),
+		Exponent: c.Float64("exponent"),
+		Duration: c.Duration("duration"),
+		PostRate: c.Float64("post-rate"),
+		ReadRate: c.Float64("read-rate"),
+		Seed:     c.Int64("seed"),
+	}
+
+	return synthesis.Write(c.App.Writer)
+}
+
+func suspend(c *cli.Context, b Backend) error {
+	user := c.Args().First()
+	if user == "" {
+		return errNoUser
+	}
+
+	suspension := storage.Suspension{
+		User:      user,
+		Reason:    c.String("reason"),
+		CreatedAt: time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+	}
+	err := b.Suspensions.SuspendUser(context.Background(), suspension)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.App.Writer, "%v is suspended\n", user)
+	return nil
+}
+
+func unsuspend(c *cli.Context, b Backend) error {
+	user := c.Args().First()
+	if user == "" {
+		return errNoUser
+	}
+
+	err := b.Suspensions.UnsuspendUser(context.Background(), user)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.App.Writer, "%v is no longer suspended\n", user)
+	return nil
+}
@@ -0,0 +1,228 @@
+// Package dump exports posts, subscriptions, feeds and poll votes as JSON
+// Lines and imports such exports, to move data between storages and to seed
+// them.
+package dump
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"microblog/storage"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const DefaultBatchSize = 500
+
+// Position is where an export stopped: right behind the record of Kind
+// with Cursor. The zero Position is the start.
+type Position struct {
+	Kind   string
+	Cursor string
+}
+
+type Exporter struct {
+	Source storage.ExportStore
+	// how many records are read at once
+	BatchSize int
+}
+
+func NewExporter(source storage.ExportStore) *Exporter {
+	return &Exporter{
+		Source:    source,
+		BatchSize: DefaultBatchSize,
+	}
+}
+
+// Export writes the records that follow from to w, a line each, and returns
+// how many it wrote. Records are written one by one, so an export that stops
+// midway can be continued from the position Resume finds.
+func (e *Exporter) Export(ctx context.Context, w io.Writer, from Position) (int, error) {
+	start := 0
+	if from.Kind != "" {
+		start = kindIndex(from.Kind)
+		if start < 0 {
+			return 0, fmt.Errorf("unknown kind %v - %w", from.Kind, storage.ErrNotFound)
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	written := 0
+	for i, kind := range storage.ExportKinds[start:] {
+		after := ""
+		if i == 0 {
+			after = from.Cursor
+		}
+
+		for {
+			records, err := e.Source.Export(ctx, kind, after, e.BatchSize)
+			if err != nil {
+				return written, err
+			}
+
+			for _, record := range records {
+				if err = encoder.Encode(record); err != nil {
+					return written, err
+				}
+				written++
+			}
+
+			if len(records) < e.BatchSize {
+				break
+			}
+			after = records[len(records)-1].Cursor
+		}
+	}
+
+	return written, nil
+}
+
+func kindIndex(kind string) int {
+	for i, known := range storage.ExportKinds {
+		if known == kind {
+			return i
+		}
+	}
+	return -1
+}
+
+// Resume reads an export and returns the position of its last record with
+// the size of the lines up to it. A last line that was only partly written
+// is not counted, cut it off before writing the rest of the export.
+func Resume(r io.Reader) (Position, int64, error) {
+	reader := bufio.NewReader(r)
+
+	position := Position{}
+	size := int64(0)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return position, size, nil
+		}
+		if err != nil {
+			return position, size, err
+		}
+
+		var record storage.ExportRecord
+		if err = json.Unmarshal(line, &record); err != nil {
+			return position, size, fmt.Errorf("line at byte %d: %w", size, err)
+		}
+		position = Position{Kind: record.Kind, Cursor: record.Cursor}
+		size += int64(len(line))
+	}
+}
+
+// ImportStats counts what Import did.
+type ImportStats struct {
+	Posts         int `json:"posts"`
+	Subscriptions int `json:"subscriptions"`
+	// feed entries are not written, the imported posts and subscriptions
+	// fill the feeds in
+	FeedEntries int `json:"feedEntries"`
+	Votes       int `json:"votes"`
+	// records the storage already had
+	Skipped int `json:"skipped"`
+}
+
+// Import replays an export into dest, posts keep their ids and times. An
+// export has posts in the order they were saved, so storages that make new
+// MongoIDs keep that order. Import skips what dest already has, an import
+// that stopped midway can be run again. Nobody is notified of the imported
+// posts and subscriptions, and they don't go to the outbox. Poll votes are
+// saved without counting them, the posts have their results already.
+func Import(ctx context.Context, dest storage.ImportStore, r io.Reader) (ImportStats, error) {
+	stats := ImportStats{}
+	pins := make([]storage.Post, 0)
+
+	decoder := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var record storage.ExportRecord
+		err := decoder.Decode(&record)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats, fmt.Errorf("record %d: %w", line, err)
+		}
+
+		switch {
+		case record.Kind == storage.ExportPost && record.Post != nil:
+			post := *record.Post
+			post.PinnedAt = record.PinnedAt
+			if post.Poll != nil {
+				post.Poll.Closes = record.PollCloses
+			}
+			if post.PinnedAt != 0 {
+				pins = append(pins, post)
+			}
+
+			imported, err := importPost(ctx, dest, post)
+			if err != nil {
+				return stats, fmt.Errorf("record %d: %w", line, err)
+			}
+			if !imported {
+				stats.Skipped++
+				continue
+			}
+			stats.Posts++
+
+		case record.Kind == storage.ExportSubscription && record.Subscription != nil:
+			err := dest.ImportSubscription(ctx, record.Subscription.User, record.Subscription.ToUser)
+			if errors.Is(err, storage.ErrCollision) {
+				stats.Skipped++
+				continue
+			}
+			if err != nil {
+				return stats, fmt.Errorf("record %d: %w", line, err)
+			}
+			stats.Subscriptions++
+
+		case record.Kind == storage.ExportFeed && record.Feed != nil:
+			stats.FeedEntries++
+
+		case record.Kind == storage.ExportVote && record.Vote != nil:
+			err := dest.ImportVote(ctx, *record.Vote)
+			if errors.Is(err, storage.ErrCollision) {
+				stats.Skipped++
+				continue
+			}
+			if err != nil {
+				return stats, fmt.Errorf("record %d: %w", line, err)
+			}
+			stats.Votes++
+
+		default:
+			return stats, fmt.Errorf("record %d: unknown kind %q", line, record.Kind)
+		}
+	}
+
+	// pinned last comes first
+	sort.SliceStable(pins, func(i, j int) bool {
+		return pins[i].PinnedAt < pins[j].PinnedAt
+	})
+	for _, post := range pins {
+		if err := dest.PinPost(ctx, post.Id, post.AuthorId); err != nil {
+			return stats, fmt.Errorf("pin %v: %w", post.Id, err)
+		}
+	}
+
+	return stats, nil
+}
+
+// importPost saves post unless dest has it already. Pins are left to the
+// end of the import.
+func importPost(ctx context.Context, dest storage.ImportStore, post storage.Post) (bool, error) {
+	post.PinnedAt = 0
+	// a new one is made by storages that have them
+	post.MongoID = primitive.NilObjectID
+
+	err := dest.ImportPost(ctx, post)
+	if errors.Is(err, storage.ErrCollision) {
+		return false, nil
+	}
+	return err == nil, err
+}
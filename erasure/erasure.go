@@ -0,0 +1,118 @@
+// Package erasure erases accounts on request of their users, the work is
+// done by a queue task.
+package erasure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"microblog/queue"
+	"microblog/storage"
+	"time"
+)
+
+// EraseTask is the queue task that erases one account, its payload is the
+// user id.
+const EraseTask = "erase_account"
+
+type Eraser struct {
+	Store storage.ErasureStore
+	// where the media of the users is kept, may be nil
+	Blobs storage.BlobStore
+	Queue queue.Queue
+}
+
+func NewEraser(store storage.ErasureStore, blobs storage.BlobStore, q queue.Queue) *Eraser {
+	return &Eraser{
+		Store: store,
+		Blobs: blobs,
+		Queue: q,
+	}
+}
+
+// Request saves a pending erasure of user and sends the task. It fails with
+// ErrCollision while the last erasure of user is pending.
+func (e *Eraser) Request(ctx context.Context, user string) (storage.Erasure, error) {
+	current, err := e.Store.GetErasure(ctx, user)
+	if err == nil && current.Status == storage.ErasurePending {
+		return current, fmt.Errorf("erasure of %v is pending - %w", user, storage.ErrCollision)
+	}
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return current, err
+	}
+
+	erasure := storage.Erasure{
+		User:        user,
+		Status:      storage.ErasurePending,
+		RequestedAt: time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+	}
+	err = e.Store.SaveErasure(ctx, erasure)
+	if err != nil {
+		return erasure, err
+	}
+
+	err = e.Queue.Send(ctx, queue.Task{Name: EraseTask, Payload: user})
+	if err != nil {
+		// let the user ask again
+		e.finish(ctx, &erasure, err)
+		return erasure, err
+	}
+
+	return erasure, nil
+}
+
+// Erase is the handler of EraseTask.
+func (e *Eraser) Erase(ctx context.Context, user string) error {
+	erasure, err := e.Store.GetErasure(ctx, user)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	if erasure.Status != storage.ErasurePending {
+		// a task sent twice
+		return nil
+	}
+
+	erased, err := e.Store.EraseAccount(ctx, user)
+	if err == nil {
+		err = e.deleteBlobs(ctx, erased.MediaIds)
+	}
+	erasure.Posts = len(erased.PostIds)
+	erasure.Subscriptions = erased.Subscriptions
+	erasure.Media = len(erased.MediaIds)
+	e.finish(ctx, &erasure, err)
+
+	return err
+}
+
+// deleteBlobs deletes the content of the erased media. Blobs that are gone
+// already were deleted by an erasure that failed later on.
+func (e *Eraser) deleteBlobs(ctx context.Context, mediaIds []string) error {
+	if e.Blobs == nil {
+		return nil
+	}
+	for _, mediaId := range mediaIds {
+		err := e.Blobs.DeleteBlob(ctx, mediaId)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// finish saves the outcome of erasure, a failed one can be asked for again.
+func (e *Eraser) finish(ctx context.Context, erasure *storage.Erasure, err error) {
+	erasure.Status = storage.ErasureDone
+	erasure.FinishedAt = time.Now().UTC().Format("2006-01-02T15:04:05Z")
+	if err != nil {
+		erasure.Status = storage.ErasureFailed
+		erasure.Error = err.Error()
+	}
+
+	if save_err := e.Store.SaveErasure(ctx, *erasure); save_err != nil {
+		log.Println("Failed to save erasure of", erasure.User, "due to an error:", save_err)
+	}
+}
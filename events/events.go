@@ -0,0 +1,203 @@
+// Package events relays the outbox of a storage to the consumers of the
+// changes: handlers in the same process and Redis Streams.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"microblog/storage"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultConsumer  = "relay"
+	DefaultBatchSize = 100
+	DefaultInterval  = time.Second
+	// long enough for a batch to be published
+	DefaultLeaseTTL = 30 * time.Second
+
+	DefaultStream       = "microblog:events"
+	DefaultStreamMaxLen = 100000
+)
+
+// Publisher gets the events of the outbox in order. An event may come more
+// than once when the relay fails midway.
+type Publisher interface {
+	Publish(ctx context.Context, event storage.Event) error
+}
+
+// Handler consumes the events of a Bus, it has to tolerate repeats.
+type Handler func(ctx context.Context, event storage.Event) error
+
+// Bus passes events to the handlers subscribed in this process.
+type Bus struct {
+	mu       sync.RWMutex
+	handlers []Handler
+}
+
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+func (b *Bus) Subscribe(handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handlers = append(b.handlers, handler)
+}
+
+// Publish stops at the first failed handler, the relay retries the event
+// later.
+func (b *Bus) Publish(ctx context.Context, event storage.Event) error {
+	b.mu.RLock()
+	handlers := b.handlers
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		if err := handler(ctx, event); err != nil {
+			return fmt.Errorf("failed to handle event %v: %w", event.Id, err)
+		}
+	}
+
+	return nil
+}
+
+// RedisStream appends events to a Redis stream. Entries have the fields id,
+// type and data, the event as JSON.
+type RedisStream struct {
+	client *redis.Client
+	Stream string
+	// older entries are trimmed, approximately
+	MaxLen int64
+}
+
+func NewRedisStream(client *redis.Client) *RedisStream {
+	return &RedisStream{
+		client: client,
+		Stream: DefaultStream,
+		MaxLen: DefaultStreamMaxLen,
+	}
+}
+
+func (r *RedisStream) Publish(ctx context.Context, event storage.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return r.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: r.Stream,
+		MaxLen: r.MaxLen,
+		Approx: true,
+		Values: map[string]interface{}{"id": event.Id, "type": event.Type, "data": string(data)},
+	}).Err()
+}
+
+// Relay publishes the events of Source in order. Its position is saved as
+// the cursor of Consumer, so a restarted relay goes on where it stopped.
+// Relays of the same Consumer in several workers take turns: only the one
+// with the lease on the cursor publishes.
+type Relay struct {
+	Source     storage.EventSource
+	Publishers []Publisher
+	Consumer   string
+	BatchSize  int
+	// who takes the lease, unique to the relay
+	Holder   string
+	LeaseTTL time.Duration
+}
+
+func NewRelay(source storage.EventSource, publishers ...Publisher) *Relay {
+	return &Relay{
+		Source:     source,
+		Publishers: publishers,
+		Consumer:   DefaultConsumer,
+		BatchSize:  DefaultBatchSize,
+		Holder:     uuid.NewString(),
+		LeaseTTL:   DefaultLeaseTTL,
+	}
+}
+
+// RelayOnce publishes the events that are not published yet and returns how
+// many there were. It publishes nothing while another relay has the lease.
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	err := r.Source.LeaseEventCursor(ctx, r.Consumer, r.Holder, r.LeaseTTL)
+	if errors.Is(err, storage.ErrCollision) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	after, err := r.Source.GetEventCursor(ctx, r.Consumer)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for {
+		if count > 0 {
+			// renewed for every batch, so that a long run keeps it
+			if err := r.Source.LeaseEventCursor(ctx, r.Consumer, r.Holder, r.LeaseTTL); err != nil {
+				return count, err
+			}
+		}
+
+		events, err := r.Source.ReadEvents(ctx, after, r.BatchSize)
+		if err != nil {
+			return count, err
+		}
+
+		for _, event := range events {
+			if err = r.publish(ctx, event); err != nil {
+				break
+			}
+			after = event.Id
+			count++
+		}
+		if count > 0 {
+			// keep what is published even when the batch failed
+			if err := r.Source.SetEventCursor(ctx, r.Consumer, after); err != nil {
+				return count, err
+			}
+		}
+		if err != nil || len(events) < r.BatchSize {
+			return count, err
+		}
+	}
+}
+
+func (r *Relay) publish(ctx context.Context, event storage.Event) error {
+	for _, publisher := range r.Publishers {
+		if err := publisher.Publish(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run relays every interval until ctx is done.
+func (r *Relay) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		_, err := r.RelayOnce(ctx)
+		if err != nil {
+			log.Println("event relay failed:", err)
+		}
+	}
+}
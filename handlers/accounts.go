@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"errors"
+	"microblog/storage"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func (h *HTTPHandler) accountsUser(rw http.ResponseWriter, r *http.Request) (string, bool) {
+	user, ok := getUser(r)
+	if !ok {
+		http.Error(rw, "No user specified", http.StatusUnauthorized)
+		return "", false
+	}
+	if h.Accounts == nil {
+		http.Error(rw, "Account settings are not supported", http.StatusNotImplemented)
+		return "", false
+	}
+	return user, true
+}
+
+func (h *HTTPHandler) HandleGetSettings(rw http.ResponseWriter, r *http.Request) {
+	user, ok := h.accountsUser(rw, r)
+	if !ok {
+		return
+	}
+
+	settings, err := h.Accounts.GetSettings(r.Context(), user)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(rw, settings)
+}
+
+func (h *HTTPHandler) HandleUpdateSettings(rw http.ResponseWriter, r *http.Request) {
+	user, ok := h.accountsUser(rw, r)
+	if !ok {
+		return
+	}
+
+	var settings storage.AccountSettings
+	if !h.decodeBody(rw, r, &settings) {
+		return
+	}
+
+	err := h.Accounts.UpdateSettings(r.Context(), user, settings)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(rw, settings)
+}
+
+func (h *HTTPHandler) HandleGetFollowRequests(rw http.ResponseWriter, r *http.Request) {
+	user, ok := h.accountsUser(rw, r)
+	if !ok {
+		return
+	}
+
+	requests, err := h.Accounts.GetFollowRequests(r.Context(), user)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(rw, requests)
+}
+
+func (h *HTTPHandler) HandleApproveFollowRequest(rw http.ResponseWriter, r *http.Request) {
+	user, ok := h.accountsUser(rw, r)
+	if !ok {
+		return
+	}
+	from_user := mux.Vars(r)["userId"]
+
+	err := h.Accounts.ApproveFollowRequest(r.Context(), user, from_user)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(rw, "No follow request from this user", http.StatusNotFound)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.Webhooks.Dispatch(r.Context(), user, storage.EventSubscriberAdded, map[string]string{"user": from_user})
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (h *HTTPHandler) HandleRejectFollowRequest(rw http.ResponseWriter, r *http.Request) {
+	user, ok := h.accountsUser(rw, r)
+	if !ok {
+		return
+	}
+	from_user := mux.Vars(r)["userId"]
+
+	err := h.Accounts.RejectFollowRequest(r.Context(), user, from_user)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(rw, "No follow request from this user", http.StatusNotFound)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"errors"
+	"microblog/storage"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+type DraftRequestData struct {
+	Text       string `json:"text"`
+	ReplyTo    string `json:"replyTo,omitempty"`
+	Visibility string `json:"visibility,omitempty"`
+}
+
+func (h *HTTPHandler) draftsUser(rw http.ResponseWriter, r *http.Request) (string, bool) {
+	user, ok := getUser(r)
+	if !ok {
+		http.Error(rw, "No user specified", http.StatusUnauthorized)
+		return "", false
+	}
+	if h.Drafts == nil {
+		http.Error(rw, "Drafts are not supported", http.StatusNotImplemented)
+		return "", false
+	}
+	return user, true
+}
+
+// decodeDraft reads a draft, unlike posts drafts may be empty.
+func (h *HTTPHandler) decodeDraft(rw http.ResponseWriter, r *http.Request) (DraftRequestData, bool) {
+	var data DraftRequestData
+	if !h.decodeBody(rw, r, &data) {
+		return data, false
+	}
+
+	var field_errors []FieldError
+	data.Text, field_errors = h.Limits.normalizeText("text", data.Text, true)
+	if len(field_errors) > 0 {
+		writeValidationErrors(rw, field_errors)
+		return data, false
+	}
+
+	if data.Visibility == "" {
+		data.Visibility = storage.VisibilityPublic
+	}
+	if !storage.ValidVisibility(data.Visibility) {
+		http.Error(rw, "Unknown visibility", http.StatusBadRequest)
+		return data, false
+	}
+	return data, true
+}
+
+// ownDraft loads the draft from the path if it belongs to the caller,
+// otherwise answers with an error.
+func (h *HTTPHandler) ownDraft(rw http.ResponseWriter, r *http.Request) (storage.Draft, bool) {
+	user, ok := h.draftsUser(rw, r)
+	if !ok {
+		return storage.Draft{}, false
+	}
+
+	draft, err := h.Drafts.GetDraft(r.Context(), mux.Vars(r)["draftId"])
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(rw, "Draft with this draftId does not exist", http.StatusNotFound)
+			return draft, false
+		}
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return draft, false
+	}
+	if draft.AuthorId != user {
+		http.Error(rw, "Draft with this draftId created by other user", http.StatusForbidden)
+		return draft, false
+	}
+
+	return draft, true
+}
+
+func (h *HTTPHandler) HandleCreateDraft(rw http.ResponseWriter, r *http.Request) {
+	user, ok := h.draftsUser(rw, r)
+	if !ok {
+		return
+	}
+
+	data, ok := h.decodeDraft(rw, r)
+	if !ok {
+		return
+	}
+
+	time_now := time.Now()
+	iso_timestamp := time_now.UTC().Format("2006-01-02T15:04:05Z")
+
+	draft := storage.Draft{
+		Id:             uuid.NewString(),
+		AuthorId:       user,
+		Text:           data.Text,
+		ReplyTo:        data.ReplyTo,
+		Visibility:     data.Visibility,
+		CreatedAt:      iso_timestamp,
+		LastModifiedAt: iso_timestamp,
+		Timestamp:      time_now.UnixNano(),
+	}
+
+	err := h.Drafts.AddDraft(r.Context(), draft)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(rw, draft)
+}
+
+func (h *HTTPHandler) HandleGetDrafts(rw http.ResponseWriter, r *http.Request) {
+	user, ok := h.draftsUser(rw, r)
+	if !ok {
+		return
+	}
+
+	drafts, err := h.Drafts.GetDrafts(r.Context(), user)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(rw, drafts)
+}
+
+func (h *HTTPHandler) HandleGetDraft(rw http.ResponseWriter, r *http.Request) {
+	draft, ok := h.ownDraft(rw, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(rw, draft)
+}
+
+func (h *HTTPHandler) HandleUpdateDraft(rw http.ResponseWriter, r *http.Request) {
+	draft, ok := h.ownDraft(rw, r)
+	if !ok {
+		return
+	}
+
+	data, ok := h.decodeDraft(rw, r)
+	if !ok {
+		return
+	}
+
+	time_now := time.Now()
+
+	draft.Text = data.Text
+	draft.ReplyTo = data.ReplyTo
+	draft.Visibility = data.Visibility
+	draft.LastModifiedAt = time_now.UTC().Format("2006-01-02T15:04:05Z")
+	draft.Timestamp = time_now.UnixNano()
+
+	err := h.Drafts.UpdateDraft(r.Context(), draft)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(rw, "Draft with this draftId does not exist", http.StatusNotFound)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(rw, draft)
+}
+
+func (h *HTTPHandler) HandleDeleteDraft(rw http.ResponseWriter, r *http.Request) {
+	draft, ok := h.ownDraft(rw, r)
+	if !ok {
+		return
+	}
+
+	err := h.Drafts.DeleteDraft(r.Context(), draft.Id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(rw, "Draft with this draftId does not exist", http.StatusNotFound)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// HandlePublishDraft turns the draft into a regular post with the same id.
+// The draft is deleted first so concurrent publishes create one post, it is
+// put back if the post can't be saved.
+func (h *HTTPHandler) HandlePublishDraft(rw http.ResponseWriter, r *http.Request) {
+	draft, ok := h.ownDraft(rw, r)
+	if !ok {
+		return
+	}
+
+	if strings.TrimSpace(draft.Text) == "" {
+		writeValidationErrors(rw, []FieldError{{Field: "text", Message: "must not be empty"}})
+		return
+	}
+
+	if draft.ReplyTo != "" {
+		_, err := h.Storage.GetPost(r.Context(), draft.ReplyTo, draft.AuthorId)
+		if err != nil {
+			http.Error(rw, "Replied post does not exist", http.StatusBadRequest)
+			return
+		}
+	}
+
+	time_now := time.Now()
+	iso_timestamp := time_now.UTC().Format("2006-01-02T15:04:05Z")
+
+	post := storage.Post{
+		Id:             draft.Id,
+		Text:           draft.Text,
+		AuthorId:       draft.AuthorId,
+		CreatedAt:      iso_timestamp,
+		LastModifiedAt: iso_timestamp,
+		Timestamp:      time_now.UnixNano(),
+		ReplyTo:        draft.ReplyTo,
+		Visibility:     draft.Visibility,
+	}
+
+	post, ok = h.moderate(rw, r, post)
+	if !ok {
+		return
+	}
+
+	err := h.Drafts.DeleteDraft(r.Context(), draft.Id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(rw, "Draft with this draftId does not exist", http.StatusNotFound)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.Storage.PostPost(r.Context(), post)
+	if err != nil {
+		h.Drafts.AddDraft(r.Context(), draft)
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if post.Moderation == "" {
+		h.Webhooks.Dispatch(r.Context(), post.AuthorId, storage.EventPostCreated, post)
+	}
+	h.moderateLater(r, post)
+
+	writeJSON(rw, post)
+}
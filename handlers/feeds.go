@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// HandleRebuildFeed recomputes the feed of the user and answers what was out
+// of sync.
+func (h *HTTPHandler) HandleRebuildFeed(rw http.ResponseWriter, r *http.Request) {
+	if _, ok := h.adminUser(rw, r); !ok {
+		return
+	}
+	if h.Feeds == nil {
+		http.Error(rw, "Feed rebuilds are not supported", http.StatusNotImplemented)
+		return
+	}
+
+	repair, err := h.Feeds.RebuildFeed(r.Context(), mux.Vars(r)["userId"])
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(rw, repair)
+}
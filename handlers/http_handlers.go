@@ -4,7 +4,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"microblog/erasure"
+	"microblog/moderation"
+	"microblog/pubsub"
+	"microblog/scheduler"
 	"microblog/storage"
+	"microblog/webhooks"
 	"net/http"
 	"regexp"
 	"strconv"
@@ -25,10 +30,84 @@ func HandleRoot(w http.ResponseWriter, _ *http.Request) {
 
 type HTTPHandler struct {
 	Storage storage.Storage
+	// the same PubSub the storage publishes fan-out to
+	PubSub pubsub.PubSub
+
+	// zero means DefaultStreamTimeout
+	StreamTimeout time.Duration
+
+	// nil disables webhooks
+	Webhooks *webhooks.Dispatcher
+
+	// nil disables the notifications API, they are still created by storage
+	Notifications storage.NotificationStore
+
+	// nil disables blocking and muting
+	Relations storage.RelationStore
+
+	// nil disables settings and follow requests, all accounts are public
+	Accounts storage.AccountStore
+
+	// nil disables scheduled posts
+	Scheduler *scheduler.Publisher
+
+	// nil disables drafts
+	Drafts storage.DraftStore
+
+	// nil Media or Blobs disable attachments
+	Media storage.MediaStore
+	Blobs storage.BlobStore
+
+	// zero values mean the defaults
+	Limits PostLimits
+
+	// nil disables moderation and its admin API
+	Moderation *moderation.Pipeline
+	// users allowed to use the admin API
+	Admins []string
+
+	// nil disables reports
+	Reports storage.ReportStore
+	// nil disables suspensions, nobody is rejected
+	Suspensions storage.SuspensionStore
+
+	// nil disables feed rebuilds
+	Feeds storage.FeedStore
+
+	// nil disables account erasure, the export still works
+	Erasure *erasure.Eraser
+}
+
+type SubscribeResponse struct {
+	Status string `json:"status"`
 }
 
 type PostRequestData struct {
 	Text string `json:"text"`
+	// optional id of the answered post
+	ReplyTo string `json:"replyTo,omitempty"`
+	// one of storage.Visibility*, public by default
+	Visibility string `json:"visibility,omitempty"`
+	// optional RFC 3339 time in the future to publish the post at
+	PublishAt string `json:"publishAt,omitempty"`
+	// optional poll attached to the post
+	Poll *PollRequestData `json:"poll,omitempty"`
+	// optional ids of media uploaded by the author
+	Attachments []string `json:"attachments,omitempty"`
+}
+
+type VisibilityRequestData struct {
+	Visibility string `json:"visibility"`
+}
+
+type BatchGetRequestData struct {
+	Ids []string `json:"ids"`
+}
+
+type BatchGetResponse struct {
+	Posts []storage.Post `json:"posts"`
+	// ids of posts that don't exist or the user may not see
+	Missing []string `json:"missing"`
 }
 
 func (h *HTTPHandler) PingHandler(rw http.ResponseWriter, r *http.Request) {
@@ -48,11 +127,10 @@ func (h *HTTPHandler) PingHandler(rw http.ResponseWriter, r *http.Request) {
 func (h *HTTPHandler) HandlePostAPost(rw http.ResponseWriter, r *http.Request) {
 	var data PostRequestData
 
-	err := json.NewDecoder(r.Body).Decode(&data)
-	if err != nil {
-		http.Error(rw, err.Error(), http.StatusBadRequest)
+	if !h.decodeBody(rw, r, &data) {
 		return
 	}
+	var err error
 
 	user_slice, ok := r.Header["System-Design-User-Id"]
 	if !ok || len(user_slice) != 1 {
@@ -71,15 +149,61 @@ func (h *HTTPHandler) HandlePostAPost(rw http.ResponseWriter, r *http.Request) {
 	iso_timestamp := time_now.In(loc).Format("2006-01-02T15:04:05Z")
 	timestamp := time_now.UnixNano()
 
+	if data.Visibility == "" {
+		data.Visibility = storage.VisibilityPublic
+	}
+	if !storage.ValidVisibility(data.Visibility) {
+		http.Error(rw, "Unknown visibility", http.StatusBadRequest)
+		return
+	}
+
+	poll, ok := newPoll(rw, data.Poll)
+	if !ok {
+		return
+	}
+	attachments, ok := h.attachments(rw, r, user, data.Attachments)
+	if !ok {
+		return
+	}
+
+	// a picture alone is a fine post
+	text, field_errors := h.Limits.normalizeText("text", data.Text, len(attachments) > 0)
+	if len(field_errors) > 0 {
+		writeValidationErrors(rw, field_errors)
+		return
+	}
+
+	if data.ReplyTo != "" {
+		_, err = h.Storage.GetPost(r.Context(), data.ReplyTo, user)
+		if err != nil {
+			http.Error(rw, "Replied post does not exist", http.StatusBadRequest)
+			return
+		}
+	}
+
 	id := uuid.NewString()
 
 	var post = storage.Post{
 		Id:             id,
-		Text:           data.Text,
+		Text:           text,
 		AuthorId:       user,
 		CreatedAt:      iso_timestamp,
 		LastModifiedAt: iso_timestamp,
 		Timestamp: 		timestamp,
+		ReplyTo:        data.ReplyTo,
+		Visibility:     data.Visibility,
+		Poll:           poll,
+		Attachments:    attachments,
+	}
+
+	post, ok = h.moderate(rw, r, post)
+	if !ok {
+		return
+	}
+
+	if data.PublishAt != "" {
+		h.schedulePost(rw, r, post, data.PublishAt)
+		return
 	}
 
 	err = h.Storage.PostPost(r.Context(), post)
@@ -88,6 +212,12 @@ func (h *HTTPHandler) HandlePostAPost(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// flagged posts are announced once approved
+	if post.Moderation == "" {
+		h.Webhooks.Dispatch(r.Context(), user, storage.EventPostCreated, post)
+	}
+	h.moderateLater(r, post)
+
 	rawResponse, _ := json.Marshal(post)
 
 	rw.Header().Set("Content-Type", "application/json")
@@ -102,7 +232,10 @@ func (h *HTTPHandler) HandleGetThePost(rw http.ResponseWriter, r *http.Request)
 	params := mux.Vars(r)
 	post_id := params["postId"]
 
-	post, err := h.Storage.GetPost(r.Context(), post_id)
+	// anonymous readers see public posts only
+	viewer, _ := getUser(r)
+
+	post, err := h.Storage.GetPost(r.Context(), post_id, viewer)
 	if err != nil {
 		if errors.Is(err, storage.ErrNotFound) {
 			http.Error(rw, "Post with this postId does not exist", 404)
@@ -123,6 +256,53 @@ func (h *HTTPHandler) HandleGetThePost(rw http.ResponseWriter, r *http.Request)
 	}
 }
 
+// HandleBatchGetPosts answers the posts with the given ids, in the same order.
+func (h *HTTPHandler) HandleBatchGetPosts(rw http.ResponseWriter, r *http.Request) {
+	var data BatchGetRequestData
+	if !h.decodeBody(rw, r, &data) {
+		return
+	}
+
+	// anonymous readers see public posts only
+	viewer, _ := getUser(r)
+
+	ids := make([]string, 0, len(data.Ids))
+	seen := make(map[string]bool)
+	for _, id := range data.Ids {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	switch {
+	case len(ids) == 0:
+		writeValidationErrors(rw, []FieldError{{Field: "ids", Message: "must not be empty"}})
+		return
+	case len(ids) > h.Limits.maxBatchSize():
+		writeValidationErrors(rw, []FieldError{{Field: "ids", Message: fmt.Sprintf("must have at most %v ids", h.Limits.maxBatchSize())}})
+		return
+	}
+
+	posts, err := h.Storage.GetPosts(r.Context(), ids, viewer)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	found := make(map[string]bool, len(posts))
+	for _, post := range posts {
+		found[post.Id] = true
+	}
+	answer := BatchGetResponse{Posts: posts, Missing: make([]string, 0)}
+	for _, id := range ids {
+		if !found[id] {
+			answer.Missing = append(answer.Missing, id)
+		}
+	}
+
+	writeJSON(rw, answer)
+}
+
 func (h *HTTPHandler) HandleGetThePostLine(rw http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	user := params["userId"]
@@ -154,8 +334,30 @@ func (h *HTTPHandler) HandleGetThePostLine(rw http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	answer, err = h.Storage.GetPostLine(r.Context(), user, page_token, size)
+	since_token := query_params.Get("since")
+	match, _ = regexp.MatchString("^[A-Za-z0-9_\\-]*$", since_token)
+	if !match {
+		http.Error(rw, "Wrong since PageToken format", 400)
+		return
+	}
+	if page_token != "" && since_token != "" {
+		http.Error(rw, "Only one of page and since can be specified", 400)
+		return
+	}
+
+	// anonymous readers see public post lines only
+	viewer, _ := getUser(r)
+
+	if since_token != "" {
+		answer, err = h.Storage.GetPostLineSince(r.Context(), user, since_token, size, viewer)
+	} else {
+		answer, err = h.Storage.GetPostLine(r.Context(), user, page_token, size, viewer)
+	}
 	if err != nil {
+		if errors.Is(err, storage.ErrForbidden) {
+			http.Error(rw, "This post line is private", http.StatusForbidden)
+			return
+		}
 		http.Error(rw, "Something bad has hapened: "+err.Error(), 400)
 		return
 	}
@@ -183,16 +385,41 @@ func (h *HTTPHandler) HandleChangeThePostText(rw http.ResponseWriter, r *http.Re
 
 	// read new text
 	var data PostRequestData
-	err := json.NewDecoder(r.Body).Decode(&data)
+	if !h.decodeBody(rw, r, &data) {
+		return
+	}
+
+	text, field_errors := h.Limits.normalizeText("text", data.Text, false)
+	if len(field_errors) > 0 {
+		writeValidationErrors(rw, field_errors)
+		return
+	}
+
+	// the owner is checked first, the moderation of other users' posts is
+	// none of the caller's business
+	current, err := h.Storage.GetPost(r.Context(), post_id, user_slice[0])
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusBadRequest)
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(rw, "Post with this postId does not exist", 404)
+		} else {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	if current.AuthorId != user_slice[0] {
+		http.Error(rw, "Post with this postId created by other user", http.StatusForbidden)
+		return
+	}
+
+	checked, ok := h.moderate(rw, r, storage.Post{Id: post_id, AuthorId: user_slice[0], Text: text})
+	if !ok {
 		return
 	}
 
 	loc, _ := time.LoadLocation("UTC")
 	time_now := time.Now().In(loc).Format("2006-01-02T15:04:05Z")
 
-	post, err := h.Storage.ChangePostText(r.Context(), post_id, user_slice[0], data.Text, time_now)
+	post, err := h.Storage.ChangePostText(r.Context(), post_id, user_slice[0], text, time_now)
 	if err != nil {
 		if errors.Is(err, storage.ErrUnauthorized) {
 			http.Error(rw, "Post with this postId created by other user", http.StatusForbidden)
@@ -206,6 +433,19 @@ func (h *HTTPHandler) HandleChangeThePostText(rw http.ResponseWriter, r *http.Re
 		}
 	}
 
+	if checked.Moderation == storage.ModerationFlagged && post.Moderation == "" {
+		post, err = h.Moderation.Moderation.FlagPost(r.Context(), post.Id, checked.ModerationReason)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if post.Moderation == "" {
+		h.Webhooks.Dispatch(r.Context(), post.AuthorId, storage.EventPostEdited, post)
+	}
+	h.moderateLater(r, post)
+
 	rawResponse, _ := json.Marshal(post)
 
 	rw.Header().Set("Content-Type", "application/json")
@@ -216,6 +456,45 @@ func (h *HTTPHandler) HandleChangeThePostText(rw http.ResponseWriter, r *http.Re
 	}
 }
 
+func (h *HTTPHandler) HandleChangeThePostVisibility(rw http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	post_id := params["postId"]
+
+	user, ok := getUser(r)
+	if !ok {
+		http.Error(rw, "No user specified", http.StatusUnauthorized)
+		return
+	}
+
+	var data VisibilityRequestData
+	if !h.decodeBody(rw, r, &data) {
+		return
+	}
+	if !storage.ValidVisibility(data.Visibility) {
+		http.Error(rw, "Unknown visibility", http.StatusBadRequest)
+		return
+	}
+
+	time_now := time.Now().UTC().Format("2006-01-02T15:04:05Z")
+
+	post, err := h.Storage.ChangePostVisibility(r.Context(), post_id, user, data.Visibility, time_now)
+	if err != nil {
+		if errors.Is(err, storage.ErrUnauthorized) {
+			http.Error(rw, "Post with this postId created by other user", http.StatusForbidden)
+			return
+		} else if errors.Is(err, storage.ErrNotFound) {
+			http.Error(rw, "Post with this postId does not exist", 404)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.Webhooks.Dispatch(r.Context(), post.AuthorId, storage.EventPostEdited, post)
+
+	writeJSON(rw, post)
+}
+
 func (h *HTTPHandler) HandleSubscribe(rw http.ResponseWriter, r *http.Request) {
 	user_slice, ok := r.Header["System-Design-User-Id"]
 	if !ok || len(user_slice) != 1 {
@@ -228,12 +507,27 @@ func (h *HTTPHandler) HandleSubscribe(rw http.ResponseWriter, r *http.Request) {
 	to_user := params["userId"]
 
 	// TODO Subscribe in storage
-	err := h.Storage.Subscribe(r.Context(), user, to_user)
+	status, err := h.Storage.Subscribe(r.Context(), user, to_user)
 	if err != nil {
+		if errors.Is(err, storage.ErrForbidden) {
+			http.Error(rw, "Subscription is blocked", http.StatusForbidden)
+			return
+		}
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	if status == storage.SubscriptionPending {
+		// to_user is private and has to approve
+		rawResponse, _ := json.Marshal(SubscribeResponse{Status: status})
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusAccepted)
+		rw.Write(rawResponse)
+		return
+	}
+
+	h.Webhooks.Dispatch(r.Context(), to_user, storage.EventSubscriberAdded, map[string]string{"user": user})
+
 	rw.WriteHeader(200)
 
 	// ничего не отвечаем?
@@ -331,7 +625,23 @@ func (h *HTTPHandler) GetFeed(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	posts, err := h.Storage.GetFeed(r.Context(), user, page_token, size)
+	since_token := query_params.Get("since")
+	match, _ = regexp.MatchString("^[A-Za-z0-9_\\-]*$", since_token)
+	if !match {
+		http.Error(rw, "Wrong since PageToken format", http.StatusBadRequest)
+		return
+	}
+	if page_token != "" && since_token != "" {
+		http.Error(rw, "Only one of page and since can be specified", http.StatusBadRequest)
+		return
+	}
+
+	var posts storage.PostLineAnswer
+	if since_token != "" {
+		posts, err = h.Storage.GetFeedSince(r.Context(), user, since_token, size)
+	} else {
+		posts, err = h.Storage.GetFeed(r.Context(), user, page_token, size)
+	}
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
@@ -346,3 +656,44 @@ func (h *HTTPHandler) GetFeed(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+type NewPostsCount struct {
+	Count int64 `json:"count"`
+}
+
+// GetFeedNewCount answers how many posts landed in the feed after the since
+// token, so that clients can poll cheaply.
+func (h *HTTPHandler) GetFeedNewCount(rw http.ResponseWriter, r *http.Request) {
+	user_slice, ok := r.Header["System-Design-User-Id"]
+	if !ok || len(user_slice) != 1 {
+		http.Error(rw, "No user specified", http.StatusBadRequest)
+		return
+	}
+	user := user_slice[0]
+
+	since_token := r.URL.Query().Get("since")
+	match, _ := regexp.MatchString("^[A-Za-z0-9_\\-]+$", since_token)
+	if !match {
+		http.Error(rw, "Wrong since PageToken format", http.StatusBadRequest)
+		return
+	}
+
+	count, err := h.Storage.CountFeedSince(r.Context(), user, since_token)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(rw, "Unknown since PageToken", http.StatusBadRequest)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rawResponse, _ := json.Marshal(NewPostsCount{Count: count})
+
+	rw.Header().Set("Content-Type", "application/json")
+	_, err = rw.Write(rawResponse)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
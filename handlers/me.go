@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"microblog/storage"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// exportPageSize is how many posts HandleExportAccount reads at once.
+const exportPageSize = 100
+
+// AccountArchive is everything of a user, as answered by /api/v1/me/export.
+type AccountArchive struct {
+	User       string `json:"user"`
+	ExportedAt string `json:"exportedAt"`
+	// missing when account settings are not supported
+	Profile *storage.AccountSettings `json:"profile,omitempty"`
+	// newest first, pinned ones too
+	Posts         []storage.Post `json:"posts"`
+	Subscriptions []string       `json:"subscriptions"`
+	Subscribers   []string       `json:"subscribers"`
+}
+
+// HandleExportAccount answers the archive of the user as a JSON file.
+func (h *HTTPHandler) HandleExportAccount(rw http.ResponseWriter, r *http.Request) {
+	user, ok := getUser(r)
+	if !ok {
+		http.Error(rw, "No user specified", http.StatusUnauthorized)
+		return
+	}
+
+	archive := AccountArchive{
+		User:       user,
+		ExportedAt: time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+		Posts:      make([]storage.Post, 0),
+	}
+
+	if h.Accounts != nil {
+		settings, err := h.Accounts.GetSettings(r.Context(), user)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		archive.Profile = &settings
+	}
+
+	token := ""
+	for {
+		page, err := h.Storage.GetPostLine(r.Context(), user, token, exportPageSize, user)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		archive.Posts = append(archive.Posts, page.Pinned...)
+		archive.Posts = append(archive.Posts, page.Posts...)
+
+		if page.Token == "" {
+			break
+		}
+		token = page.Token
+	}
+	sort.SliceStable(archive.Posts, func(i, j int) bool {
+		return archive.Posts[i].Timestamp > archive.Posts[j].Timestamp
+	})
+
+	subscriptions, err := h.Storage.GetSubscriptions(r.Context(), user)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	archive.Subscriptions = subscriptions.Users
+
+	subscribers, err := h.Storage.GetSubscribers(r.Context(), user)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	archive.Subscribers = subscribers.Users
+
+	rw.Header().Set("Content-Disposition", `attachment; filename="microblog-`+user+`.json"`)
+	writeJSON(rw, archive)
+}
+
+func (h *HTTPHandler) erasureUser(rw http.ResponseWriter, r *http.Request) (string, bool) {
+	user, ok := getUser(r)
+	if !ok {
+		http.Error(rw, "No user specified", http.StatusUnauthorized)
+		return "", false
+	}
+	if h.Erasure == nil {
+		http.Error(rw, "Account erasure is not supported", http.StatusNotImplemented)
+		return "", false
+	}
+	return user, true
+}
+
+// HandleEraseAccount asks the worker to erase the account of the user, the
+// status endpoint tells when it is done.
+func (h *HTTPHandler) HandleEraseAccount(rw http.ResponseWriter, r *http.Request) {
+	user, ok := h.erasureUser(rw, r)
+	if !ok {
+		return
+	}
+
+	erasure, err := h.Erasure.Request(r.Context(), user)
+	if err != nil {
+		if errors.Is(err, storage.ErrCollision) {
+			http.Error(rw, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rawResponse, _ := json.Marshal(erasure)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusAccepted)
+	rw.Write(rawResponse)
+}
+
+func (h *HTTPHandler) HandleGetErasure(rw http.ResponseWriter, r *http.Request) {
+	user, ok := h.erasureUser(rw, r)
+	if !ok {
+		return
+	}
+
+	erasure, err := h.Erasure.Store.GetErasure(r.Context(), user)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(rw, "No erasure requested", http.StatusNotFound)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(rw, erasure)
+}
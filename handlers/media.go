@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"errors"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"microblog/storage"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+func (h *HTTPHandler) mediaSupported(rw http.ResponseWriter) bool {
+	if h.Media == nil || h.Blobs == nil {
+		http.Error(rw, "Media are not supported", http.StatusNotImplemented)
+		return false
+	}
+	return true
+}
+
+// HandleUploadMedia takes a multipart form with the file in the "file" field.
+// The type is detected from the content, the one sent by the client is not
+// trusted.
+func (h *HTTPHandler) HandleUploadMedia(rw http.ResponseWriter, r *http.Request) {
+	user, ok := getUser(r)
+	if !ok {
+		http.Error(rw, "No user specified", http.StatusUnauthorized)
+		return
+	}
+	if !h.mediaSupported(rw) {
+		return
+	}
+
+	// leave some room for the rest of the form
+	r.Body = http.MaxBytesReader(rw, r.Body, storage.MaxMediaSize+1<<20)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		if bodyTooLarge(err) {
+			http.Error(rw, "File is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(rw, "No file uploaded", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	if header.Size > storage.MaxMediaSize {
+		http.Error(rw, "File is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	sniff := make([]byte, 512)
+	n, err := io.ReadFull(file, sniff)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		http.Error(rw, "Empty file", http.StatusBadRequest)
+		return
+	}
+	media_type := http.DetectContentType(sniff[:n])
+	if !storage.MediaTypes[media_type] {
+		http.Error(rw, "Unsupported media type "+media_type, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	media := storage.Media{
+		Id:        uuid.NewString(),
+		Type:      media_type,
+		Size:      header.Size,
+		Owner:     user,
+		CreatedAt: time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+	}
+	media.URL = storage.MediaURL(media.Id)
+
+	if strings.HasPrefix(media_type, "image/") && media_type != "image/webp" {
+		file.Seek(0, io.SeekStart)
+		config, _, err := image.DecodeConfig(file)
+		if err != nil {
+			http.Error(rw, "Broken image", http.StatusBadRequest)
+			return
+		}
+		media.Width = config.Width
+		media.Height = config.Height
+	}
+
+	file.Seek(0, io.SeekStart)
+	err = h.Blobs.PutBlob(r.Context(), media.Id, file)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.Media.AddMedia(r.Context(), media)
+	if err != nil {
+		if err := h.Blobs.DeleteBlob(r.Context(), media.Id); err != nil {
+			log.Println("Failed to delete blob", media.Id, "due to an error:", err)
+		}
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(rw, media)
+}
+
+// HandleGetMedia serves the file, with range requests supported.
+func (h *HTTPHandler) HandleGetMedia(rw http.ResponseWriter, r *http.Request) {
+	if !h.mediaSupported(rw) {
+		return
+	}
+	media_id := mux.Vars(r)["mediaId"]
+
+	media, err := h.Media.GetMedia(r.Context(), media_id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(rw, "Media with this mediaId does not exist", http.StatusNotFound)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	blob, err := h.Blobs.OpenBlob(r.Context(), media_id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(rw, "Media with this mediaId does not exist", http.StatusNotFound)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer blob.Close()
+
+	modified, _ := time.Parse(time.RFC3339, media.CreatedAt)
+
+	rw.Header().Set("Content-Type", media.Type)
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(rw, r, "", modified, blob)
+}
+
+// attachments loads the media of the caller to attach to a post.
+func (h *HTTPHandler) attachments(rw http.ResponseWriter, r *http.Request, user string, media_ids []string) ([]storage.Media, bool) {
+	if len(media_ids) == 0 {
+		return nil, true
+	}
+	if !h.mediaSupported(rw) {
+		return nil, false
+	}
+	if len(media_ids) > storage.MaxAttachments {
+		http.Error(rw, "Too many attachments", http.StatusBadRequest)
+		return nil, false
+	}
+
+	attachments := make([]storage.Media, 0, len(media_ids))
+	for _, media_id := range media_ids {
+		media, err := h.Media.GetMedia(r.Context(), media_id)
+		if err != nil || media.Owner != user {
+			http.Error(rw, "Attached media "+media_id+" does not exist", http.StatusBadRequest)
+			return nil, false
+		}
+		attachments = append(attachments, media)
+	}
+
+	return attachments, true
+}
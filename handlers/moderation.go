@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"microblog/moderation"
+	"microblog/storage"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// moderate runs the in-request moderation of post. A flagged post comes back
+// marked, a rejected one is answered with 422.
+func (h *HTTPHandler) moderate(rw http.ResponseWriter, r *http.Request, post storage.Post) (storage.Post, bool) {
+	if h.Moderation == nil {
+		return post, true
+	}
+
+	post, decision, err := h.Moderation.Check(r.Context(), post)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return post, false
+	}
+	if decision.Action == moderation.Reject {
+		writeValidationErrors(rw, []FieldError{{Field: "text", Message: decision.Reason}})
+		return post, false
+	}
+
+	return post, true
+}
+
+// moderateLater queues the moderation of a saved post, when it runs in the
+// worker.
+func (h *HTTPHandler) moderateLater(r *http.Request, post storage.Post) {
+	if h.Moderation == nil {
+		return
+	}
+
+	err := h.Moderation.Saved(r.Context(), post)
+	if err != nil {
+		log.Println("Failed to queue moderation of post", post.Id, "due to an error:", err)
+	}
+}
+
+func (h *HTTPHandler) adminUser(rw http.ResponseWriter, r *http.Request) (string, bool) {
+	user, ok := getUser(r)
+	if !ok {
+		http.Error(rw, "No user specified", http.StatusUnauthorized)
+		return "", false
+	}
+	for _, admin := range h.Admins {
+		if admin == user {
+			return user, true
+		}
+	}
+	http.Error(rw, "Admins only", http.StatusForbidden)
+	return "", false
+}
+
+func (h *HTTPHandler) moderationAdmin(rw http.ResponseWriter, r *http.Request) bool {
+	if _, ok := h.adminUser(rw, r); !ok {
+		return false
+	}
+	if h.Moderation == nil {
+		http.Error(rw, "Moderation is not supported", http.StatusNotImplemented)
+		return false
+	}
+	return true
+}
+
+func (h *HTTPHandler) HandleGetFlaggedPosts(rw http.ResponseWriter, r *http.Request) {
+	if !h.moderationAdmin(rw, r) {
+		return
+	}
+
+	posts, err := h.Moderation.Moderation.GetFlaggedPosts(r.Context())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(rw, posts)
+}
+
+// HandleApproveFlaggedPost publishes a flagged post as if it was just created.
+func (h *HTTPHandler) HandleApproveFlaggedPost(rw http.ResponseWriter, r *http.Request) {
+	if !h.moderationAdmin(rw, r) {
+		return
+	}
+
+	post, err := h.Moderation.Moderation.ApprovePost(r.Context(), mux.Vars(r)["postId"])
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(rw, "Flagged post with this postId does not exist", http.StatusNotFound)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.Webhooks.Dispatch(r.Context(), post.AuthorId, storage.EventPostCreated, post)
+
+	writeJSON(rw, post)
+}
+
+func (h *HTTPHandler) HandleRemoveFlaggedPost(rw http.ResponseWriter, r *http.Request) {
+	if !h.moderationAdmin(rw, r) {
+		return
+	}
+
+	_, err := h.Moderation.Moderation.RemovePost(r.Context(), mux.Vars(r)["postId"])
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(rw, "Post with this postId does not exist", http.StatusNotFound)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
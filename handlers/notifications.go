@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"errors"
+	"microblog/storage"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+type ReadNotificationsRequestData struct {
+	// the last read notification, empty means all
+	UpTo string `json:"upTo"`
+}
+
+func (h *HTTPHandler) notificationsUser(rw http.ResponseWriter, r *http.Request) (string, bool) {
+	user, ok := getUser(r)
+	if !ok {
+		http.Error(rw, "No user specified", http.StatusUnauthorized)
+		return "", false
+	}
+	if h.Notifications == nil {
+		http.Error(rw, "Notifications are not supported", http.StatusNotImplemented)
+		return "", false
+	}
+	return user, true
+}
+
+func (h *HTTPHandler) HandleGetNotifications(rw http.ResponseWriter, r *http.Request) {
+	user, ok := h.notificationsUser(rw, r)
+	if !ok {
+		return
+	}
+
+	query_params := r.URL.Query()
+	size := 10
+	if size_query := query_params.Get("size"); size_query != "" {
+		var err error
+		size, err = strconv.Atoi(size_query)
+		if err != nil || size < 0 {
+			http.Error(rw, "Wrong size query", http.StatusBadRequest)
+			return
+		}
+	}
+
+	page_token := query_params.Get("page")
+	match, _ := regexp.MatchString("^[A-Za-z0-9_\\-]*$", page_token)
+	if !match {
+		http.Error(rw, "Wrong PageToken format", http.StatusBadRequest)
+		return
+	}
+
+	answer, err := h.Notifications.GetNotifications(r.Context(), user, page_token, size)
+	if err != nil {
+		http.Error(rw, "Something bad has hapened: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(rw, answer)
+}
+
+func (h *HTTPHandler) HandleReadNotifications(rw http.ResponseWriter, r *http.Request) {
+	user, ok := h.notificationsUser(rw, r)
+	if !ok {
+		return
+	}
+
+	// the body is optional
+	var data ReadNotificationsRequestData
+	if !h.decodeOptionalBody(rw, r, &data) {
+		return
+	}
+
+	err := h.Notifications.MarkNotificationsRead(r.Context(), user, data.UpTo)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(rw, "Notification with this id does not exist", http.StatusNotFound)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.writeUnreadCount(rw, r, user)
+}
+
+func (h *HTTPHandler) HandleGetUnreadNotificationsCount(rw http.ResponseWriter, r *http.Request) {
+	user, ok := h.notificationsUser(rw, r)
+	if !ok {
+		return
+	}
+
+	h.writeUnreadCount(rw, r, user)
+}
+
+func (h *HTTPHandler) writeUnreadCount(rw http.ResponseWriter, r *http.Request, user string) {
+	count, err := h.Notifications.CountUnreadNotifications(r.Context(), user)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(rw, storage.UnreadNotificationsCount{Count: count})
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"microblog/storage"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func (h *HTTPHandler) handlePin(rw http.ResponseWriter, r *http.Request, action func(storage.Storage, context.Context, string, string) error) {
+	user, ok := getUser(r)
+	if !ok {
+		http.Error(rw, "No user specified", http.StatusUnauthorized)
+		return
+	}
+	post_id := mux.Vars(r)["postId"]
+
+	err := action(h.Storage, r.Context(), post_id, user)
+	if err != nil {
+		if errors.Is(err, storage.ErrUnauthorized) {
+			http.Error(rw, "Post with this postId created by other user", http.StatusForbidden)
+			return
+		} else if errors.Is(err, storage.ErrNotFound) {
+			http.Error(rw, "Post with this postId does not exist", http.StatusNotFound)
+			return
+		} else if errors.Is(err, storage.ErrCollision) {
+			http.Error(rw, fmt.Sprintf("At most %v posts can be pinned", storage.MaxPinnedPosts), http.StatusConflict)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (h *HTTPHandler) HandlePinPost(rw http.ResponseWriter, r *http.Request) {
+	h.handlePin(rw, r, storage.Storage.PinPost)
+}
+
+func (h *HTTPHandler) HandleUnpinPost(rw http.ResponseWriter, r *http.Request) {
+	h.handlePin(rw, r, storage.Storage.UnpinPost)
+}
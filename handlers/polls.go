@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"microblog/storage"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type PollRequestData struct {
+	Options []string `json:"options"`
+	// RFC 3339 time in the future
+	ClosesAt string `json:"closesAt"`
+}
+
+type VoteRequestData struct {
+	// index of the option in the poll
+	Option *int `json:"option"`
+}
+
+// newPoll checks the requested poll, nil data means no poll.
+func newPoll(rw http.ResponseWriter, data *PollRequestData) (*storage.Poll, bool) {
+	if data == nil {
+		return nil, true
+	}
+
+	if len(data.Options) < storage.MinPollOptions || len(data.Options) > storage.MaxPollOptions {
+		http.Error(rw, fmt.Sprintf("A poll must have %v to %v options", storage.MinPollOptions, storage.MaxPollOptions), http.StatusBadRequest)
+		return nil, false
+	}
+
+	poll := storage.Poll{Options: make([]storage.PollOption, 0, len(data.Options))}
+	for _, text := range data.Options {
+		if text == "" {
+			http.Error(rw, "Poll options must not be empty", http.StatusBadRequest)
+			return nil, false
+		}
+		poll.Options = append(poll.Options, storage.PollOption{Text: text})
+	}
+
+	closes_at, err := time.Parse(time.RFC3339, data.ClosesAt)
+	if err != nil {
+		http.Error(rw, "Wrong closesAt format", http.StatusBadRequest)
+		return nil, false
+	}
+	if !closes_at.After(time.Now()) {
+		http.Error(rw, "closesAt must be in the future", http.StatusBadRequest)
+		return nil, false
+	}
+	poll.ClosesAt = closes_at.UTC().Format(time.RFC3339)
+	poll.Closes = closes_at.UnixNano()
+
+	return &poll, true
+}
+
+func (h *HTTPHandler) HandleVotePoll(rw http.ResponseWriter, r *http.Request) {
+	user, ok := getUser(r)
+	if !ok {
+		http.Error(rw, "No user specified", http.StatusUnauthorized)
+		return
+	}
+
+	var data VoteRequestData
+	if !h.decodeBody(rw, r, &data) {
+		return
+	}
+	if data.Option == nil {
+		http.Error(rw, "No option specified", http.StatusBadRequest)
+		return
+	}
+
+	post, err := h.Storage.VotePoll(r.Context(), mux.Vars(r)["postId"], user, *data.Option)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(rw, "Post with this postId has no such poll option", http.StatusNotFound)
+			return
+		} else if errors.Is(err, storage.ErrForbidden) {
+			http.Error(rw, "The poll is closed", http.StatusForbidden)
+			return
+		} else if errors.Is(err, storage.ErrCollision) {
+			http.Error(rw, "Already voted", http.StatusConflict)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(rw, post)
+}
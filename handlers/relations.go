@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"microblog/storage"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// handleRelation runs action (a RelationStore method) of the caller on the
+// user from the path.
+func (h *HTTPHandler) handleRelation(rw http.ResponseWriter, r *http.Request, action func(storage.RelationStore, context.Context, string, string) error) {
+	user, ok := getUser(r)
+	if !ok {
+		http.Error(rw, "No user specified", http.StatusUnauthorized)
+		return
+	}
+	if h.Relations == nil {
+		http.Error(rw, "Blocking and muting are not supported", http.StatusNotImplemented)
+		return
+	}
+
+	target := mux.Vars(r)["userId"]
+	if target == user {
+		http.Error(rw, "Can not block or mute yourself", http.StatusBadRequest)
+		return
+	}
+
+	err := action(h.Relations, r.Context(), user, target)
+	if err != nil {
+		if errors.Is(err, storage.ErrStorage) {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (h *HTTPHandler) HandleBlock(rw http.ResponseWriter, r *http.Request) {
+	h.handleRelation(rw, r, storage.RelationStore.Block)
+}
+
+func (h *HTTPHandler) HandleUnblock(rw http.ResponseWriter, r *http.Request) {
+	h.handleRelation(rw, r, storage.RelationStore.Unblock)
+}
+
+func (h *HTTPHandler) HandleMute(rw http.ResponseWriter, r *http.Request) {
+	h.handleRelation(rw, r, storage.RelationStore.Mute)
+}
+
+func (h *HTTPHandler) HandleUnmute(rw http.ResponseWriter, r *http.Request) {
+	h.handleRelation(rw, r, storage.RelationStore.Unmute)
+}
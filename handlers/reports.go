@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"microblog/storage"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+type ReportRequestData struct {
+	Reason string `json:"reason"`
+}
+
+const (
+	ReportActionRemove  = "remove"
+	ReportActionDismiss = "dismiss"
+)
+
+type ResolveReportRequestData struct {
+	// ReportActionRemove or ReportActionDismiss
+	Action string `json:"action"`
+}
+
+type SuspendRequestData struct {
+	Reason string `json:"reason"`
+}
+
+func (h *HTTPHandler) HandleReportPost(rw http.ResponseWriter, r *http.Request) {
+	user, ok := getUser(r)
+	if !ok {
+		http.Error(rw, "No user specified", http.StatusUnauthorized)
+		return
+	}
+	if h.Reports == nil {
+		http.Error(rw, "Reports are not supported", http.StatusNotImplemented)
+		return
+	}
+
+	var data ReportRequestData
+	if !h.decodeBody(rw, r, &data) {
+		return
+	}
+	reason, field_errors := h.Limits.normalizeText("reason", data.Reason, false)
+	if len(field_errors) > 0 {
+		writeValidationErrors(rw, field_errors)
+		return
+	}
+
+	post, err := h.Storage.GetPost(r.Context(), mux.Vars(r)["postId"], user)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(rw, "Post with this postId does not exist", http.StatusNotFound)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if post.AuthorId == user {
+		http.Error(rw, "Can't report own post", http.StatusBadRequest)
+		return
+	}
+
+	time_now := time.Now()
+	report := storage.Report{
+		Id:        uuid.NewString(),
+		PostId:    post.Id,
+		AuthorId:  post.AuthorId,
+		Reporter:  user,
+		Reason:    reason,
+		Status:    storage.ReportOpen,
+		CreatedAt: time_now.UTC().Format("2006-01-02T15:04:05Z"),
+		Timestamp: time_now.UnixNano(),
+	}
+
+	err = h.Reports.AddReport(r.Context(), report)
+	if err != nil {
+		if errors.Is(err, storage.ErrCollision) {
+			http.Error(rw, "Post is already reported", http.StatusConflict)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(rw, report)
+}
+
+func (h *HTTPHandler) reportsAdmin(rw http.ResponseWriter, r *http.Request) (string, bool) {
+	admin, ok := h.adminUser(rw, r)
+	if !ok {
+		return "", false
+	}
+	if h.Reports == nil {
+		http.Error(rw, "Reports are not supported", http.StatusNotImplemented)
+		return "", false
+	}
+	return admin, true
+}
+
+// HandleGetReports lists the reports with the status from the query, open by
+// default, oldest first.
+func (h *HTTPHandler) HandleGetReports(rw http.ResponseWriter, r *http.Request) {
+	if _, ok := h.reportsAdmin(rw, r); !ok {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = storage.ReportOpen
+	case storage.ReportOpen, storage.ReportResolved, storage.ReportDismissed:
+	default:
+		http.Error(rw, "Unknown status", http.StatusBadRequest)
+		return
+	}
+
+	reports, err := h.Reports.GetReports(r.Context(), status)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(rw, reports)
+}
+
+// HandleResolveReport closes all open reports of the reported post, removing
+// the post or keeping it.
+func (h *HTTPHandler) HandleResolveReport(rw http.ResponseWriter, r *http.Request) {
+	admin, ok := h.reportsAdmin(rw, r)
+	if !ok {
+		return
+	}
+
+	var data ResolveReportRequestData
+	if !h.decodeBody(rw, r, &data) {
+		return
+	}
+
+	report, err := h.Reports.GetReport(r.Context(), mux.Vars(r)["reportId"])
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(rw, "Report with this reportId does not exist", http.StatusNotFound)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var status string
+	switch data.Action {
+	case ReportActionRemove:
+		if h.Moderation == nil {
+			http.Error(rw, "Moderation is not supported", http.StatusNotImplemented)
+			return
+		}
+		_, err = h.Moderation.Moderation.RemovePost(r.Context(), report.PostId)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		status = storage.ReportResolved
+	case ReportActionDismiss:
+		status = storage.ReportDismissed
+	default:
+		writeValidationErrors(rw, []FieldError{{Field: "action", Message: "must be remove or dismiss"}})
+		return
+	}
+
+	resolved_at := time.Now().UTC().Format("2006-01-02T15:04:05Z")
+	err = h.Reports.CloseReports(r.Context(), report.PostId, status, admin, resolved_at)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err = h.Reports.GetReport(r.Context(), report.Id)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(rw, report)
+}
+
+func (h *HTTPHandler) suspensionsAdmin(rw http.ResponseWriter, r *http.Request) bool {
+	if _, ok := h.adminUser(rw, r); !ok {
+		return false
+	}
+	if h.Suspensions == nil {
+		http.Error(rw, "Suspensions are not supported", http.StatusNotImplemented)
+		return false
+	}
+	return true
+}
+
+func (h *HTTPHandler) HandleSuspendUser(rw http.ResponseWriter, r *http.Request) {
+	if !h.suspensionsAdmin(rw, r) {
+		return
+	}
+
+	var data SuspendRequestData
+	if !h.decodeBody(rw, r, &data) {
+		return
+	}
+
+	suspension := storage.Suspension{
+		User:      mux.Vars(r)["userId"],
+		Reason:    data.Reason,
+		CreatedAt: time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+	}
+
+	err := h.Suspensions.SuspendUser(r.Context(), suspension)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(rw, suspension)
+}
+
+func (h *HTTPHandler) HandleUnsuspendUser(rw http.ResponseWriter, r *http.Request) {
+	if !h.suspensionsAdmin(rw, r) {
+		return
+	}
+
+	err := h.Suspensions.UnsuspendUser(r.Context(), mux.Vars(r)["userId"])
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// RejectSuspended is a middleware that answers writes of suspended users with
+// 403, reads are still allowed. So are the export and the erasure of the own
+// account, a suspension does not take those rights away.
+func (h *HTTPHandler) RejectSuspended(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		user, ok := getUser(r)
+		if !ok || h.Suspensions == nil || isRead(r) || isOwnAccount(r) {
+			next.ServeHTTP(rw, r)
+			return
+		}
+
+		_, err := h.Suspensions.GetSuspension(r.Context(), user)
+		if err == nil {
+			http.Error(rw, "User is suspended", http.StatusForbidden)
+			return
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Println("Failed to check suspension of", user, "due to an error:", err)
+			http.Error(rw, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// isOwnAccount tells if r exports or erases the account of its user.
+func isOwnAccount(r *http.Request) bool {
+	switch r.URL.Path {
+	case "/api/v1/me":
+		return r.Method == http.MethodDelete
+	case "/api/v1/me/export", "/api/v1/me/erasure":
+		return true
+	}
+	return false
+}
+
+// isRead tells if r changes nothing, batch gets are POSTs only to carry the
+// ids in the body.
+func isRead(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodHead || strings.HasSuffix(r.URL.Path, ":batchGet")
+}
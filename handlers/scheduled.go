@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"microblog/storage"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// parsePublishAt checks that value is an RFC 3339 time in the future.
+func parsePublishAt(rw http.ResponseWriter, value string) (time.Time, bool) {
+	publish_at, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		http.Error(rw, "Wrong publishAt format", http.StatusBadRequest)
+		return publish_at, false
+	}
+	if !publish_at.After(time.Now()) {
+		http.Error(rw, "publishAt must be in the future", http.StatusBadRequest)
+		return publish_at, false
+	}
+	return publish_at, true
+}
+
+func (h *HTTPHandler) schedulePost(rw http.ResponseWriter, r *http.Request, post storage.Post, publish_at_value string) {
+	if h.Scheduler == nil {
+		http.Error(rw, "Scheduled posts are not supported", http.StatusNotImplemented)
+		return
+	}
+
+	publish_at, ok := parsePublishAt(rw, publish_at_value)
+	if !ok {
+		return
+	}
+	if post.Poll != nil && post.Poll.Closes <= publish_at.UnixNano() {
+		http.Error(rw, "closesAt must be after publishAt", http.StatusBadRequest)
+		return
+	}
+
+	scheduled, err := h.Scheduler.Add(r.Context(), post, publish_at)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rawResponse, _ := json.Marshal(scheduled)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusAccepted)
+	rw.Write(rawResponse)
+}
+
+func (h *HTTPHandler) schedulerUser(rw http.ResponseWriter, r *http.Request) (string, bool) {
+	user, ok := getUser(r)
+	if !ok {
+		http.Error(rw, "No user specified", http.StatusUnauthorized)
+		return "", false
+	}
+	if h.Scheduler == nil {
+		http.Error(rw, "Scheduled posts are not supported", http.StatusNotImplemented)
+		return "", false
+	}
+	return user, true
+}
+
+// ownScheduledPost loads the scheduled post from the path if it belongs to
+// the caller, otherwise answers with an error.
+func (h *HTTPHandler) ownScheduledPost(rw http.ResponseWriter, r *http.Request) (storage.ScheduledPost, bool) {
+	user, ok := h.schedulerUser(rw, r)
+	if !ok {
+		return storage.ScheduledPost{}, false
+	}
+
+	scheduled, err := h.Scheduler.Schedule.GetScheduledPost(r.Context(), mux.Vars(r)["postId"])
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(rw, "Scheduled post with this postId does not exist", http.StatusNotFound)
+			return scheduled, false
+		}
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return scheduled, false
+	}
+	if scheduled.Post.AuthorId != user {
+		http.Error(rw, "Scheduled post with this postId created by other user", http.StatusForbidden)
+		return scheduled, false
+	}
+
+	return scheduled, true
+}
+
+func (h *HTTPHandler) HandleGetScheduledPosts(rw http.ResponseWriter, r *http.Request) {
+	user, ok := h.schedulerUser(rw, r)
+	if !ok {
+		return
+	}
+
+	posts, err := h.Scheduler.Schedule.GetScheduledPosts(r.Context(), user)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(rw, posts)
+}
+
+// HandleUpdateScheduledPost replaces text, visibility and time of a scheduled
+// post, the body is the same as for a new post.
+func (h *HTTPHandler) HandleUpdateScheduledPost(rw http.ResponseWriter, r *http.Request) {
+	scheduled, ok := h.ownScheduledPost(rw, r)
+	if !ok {
+		return
+	}
+
+	var data PostRequestData
+	if !h.decodeBody(rw, r, &data) {
+		return
+	}
+
+	text, field_errors := h.Limits.normalizeText("text", data.Text, len(scheduled.Post.Attachments) > 0)
+	if len(field_errors) > 0 {
+		writeValidationErrors(rw, field_errors)
+		return
+	}
+
+	if data.Visibility == "" {
+		data.Visibility = storage.VisibilityPublic
+	}
+	if !storage.ValidVisibility(data.Visibility) {
+		http.Error(rw, "Unknown visibility", http.StatusBadRequest)
+		return
+	}
+
+	publish_at, ok := parsePublishAt(rw, data.PublishAt)
+	if !ok {
+		return
+	}
+
+	scheduled.Post.Text = text
+	scheduled.Post.Visibility = data.Visibility
+	scheduled.Post.Moderation = ""
+	scheduled.Post.ModerationReason = ""
+
+	scheduled.Post, ok = h.moderate(rw, r, scheduled.Post)
+	if !ok {
+		return
+	}
+
+	scheduled, err := h.Scheduler.Update(r.Context(), scheduled, publish_at)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(rw, "Scheduled post with this postId does not exist", http.StatusNotFound)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(rw, scheduled)
+}
+
+func (h *HTTPHandler) HandleCancelScheduledPost(rw http.ResponseWriter, r *http.Request) {
+	scheduled, ok := h.ownScheduledPost(rw, r)
+	if !ok {
+		return
+	}
+
+	err := h.Scheduler.Schedule.DeleteScheduledPost(r.Context(), scheduled.Post.Id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(rw, "Scheduled post with this postId does not exist", http.StatusNotFound)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
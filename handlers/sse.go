@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"microblog/storage"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// The server WriteTimeout cuts long responses, so by default a stream is
+// closed a bit earlier and the client reconnects with Last-Event-ID.
+const DefaultStreamTimeout = 10 * time.Second
+
+const (
+	streamKeepAlive  = 5 * time.Second
+	streamReplayPage = 50
+	streamRetryMs    = 1000
+)
+
+// HandleFeedStream sends new posts of the caller's feed as Server-Sent Events.
+// Every event id is a feed page token, so a reconnecting client gets everything
+// it missed via Last-Event-ID before the live posts.
+func (h *HTTPHandler) HandleFeedStream(rw http.ResponseWriter, r *http.Request) {
+	user_slice, ok := r.Header["System-Design-User-Id"]
+	if !ok || len(user_slice) != 1 {
+		http.Error(rw, "No user specified", http.StatusBadRequest)
+		return
+	}
+	user := user_slice[0]
+
+	last_event_id := r.Header.Get("Last-Event-ID")
+	match, _ := regexp.MatchString("^[A-Za-z0-9_\\-]*$", last_event_id)
+	if !match {
+		http.Error(rw, "Wrong Last-Event-ID format", http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := rw.(http.Flusher)
+	if !ok || h.PubSub == nil {
+		http.Error(rw, "Streaming is not supported", http.StatusNotImplemented)
+		return
+	}
+
+	// subscribe before the replay, so that nothing falls in between
+	sub, err := h.PubSub.Subscribe(r.Context(), storage.FeedChannel(user))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer sub.Close()
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("Connection", "keep-alive")
+	rw.WriteHeader(http.StatusOK)
+	fmt.Fprintf(rw, "retry: %d\n\n", streamRetryMs)
+
+	replayed := make(map[string]bool)
+	if last_event_id != "" {
+		replayed, err = h.replayFeed(rw, r, user, last_event_id)
+		if err != nil {
+			log.Println("Failed to replay feed of", user, "since", last_event_id, "due to an error:", err)
+			writeEvent(rw, "", "resync", struct{}{})
+		}
+	}
+	flusher.Flush()
+
+	timeout := h.StreamTimeout
+	if timeout == 0 {
+		timeout = DefaultStreamTimeout
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	keep_alive := time.NewTicker(streamKeepAlive)
+	defer keep_alive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-deadline.C:
+			return
+		case <-keep_alive.C:
+			fmt.Fprint(rw, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-sub.Dropped:
+			// posts were lost on the way, the client reloads the feed
+			writeEvent(rw, "", "resync", struct{}{})
+			flusher.Flush()
+		case data, ok := <-sub.C:
+			if !ok {
+				return
+			}
+
+			var event storage.FeedEvent
+			if err := json.Unmarshal(data, &event); err != nil {
+				log.Println("Bad feed event:", err)
+				continue
+			}
+			if replayed[event.Post.Id] {
+				continue
+			}
+
+			writeEvent(rw, event.Token, "post", event.Post)
+			flusher.Flush()
+		}
+	}
+}
+
+// replayFeed writes all posts newer than since_token, oldest first. Only the
+// last post of every page gets an id: if the stream breaks in the middle of a
+// page, the client gets the whole page again. Returns ids of sent posts.
+func (h *HTTPHandler) replayFeed(rw http.ResponseWriter, r *http.Request, user string, since_token string) (map[string]bool, error) {
+	replayed := make(map[string]bool)
+
+	for {
+		page, err := h.Storage.GetFeedSince(r.Context(), user, since_token, streamReplayPage)
+		if err != nil {
+			return replayed, err
+		}
+		if len(page.Posts) == 0 {
+			return replayed, nil
+		}
+
+		for i := len(page.Posts) - 1; i >= 0; i-- {
+			id := ""
+			if i == 0 {
+				id = page.PrevToken
+			}
+			writeEvent(rw, id, "post", page.Posts[i])
+			replayed[page.Posts[i].Id] = true
+		}
+
+		since_token = page.PrevToken
+	}
+}
+
+func writeEvent(rw http.ResponseWriter, id string, event string, data interface{}) {
+	raw, _ := json.Marshal(data)
+
+	if id != "" {
+		fmt.Fprintf(rw, "id: %s\n", id)
+	}
+	fmt.Fprintf(rw, "event: %s\ndata: %s\n\n", event, raw)
+}
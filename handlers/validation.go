@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	DefaultMaxTextLength = 500
+	DefaultMaxBodySize   = 64 << 10
+	DefaultMaxBatchSize  = 100
+)
+
+// PostLimits restrict what a post may contain, fields below one mean the
+// defaults.
+type PostLimits struct {
+	// in grapheme clusters, that is characters as users see them
+	MaxTextLength int
+	// of a request body in bytes, uploads have their own limit
+	MaxBodySize int64
+	// how many posts may be asked for at once
+	MaxBatchSize int
+}
+
+func (l PostLimits) maxTextLength() int {
+	if l.MaxTextLength < 1 {
+		return DefaultMaxTextLength
+	}
+	return l.MaxTextLength
+}
+
+func (l PostLimits) maxBodySize() int64 {
+	if l.MaxBodySize < 1 {
+		return DefaultMaxBodySize
+	}
+	return l.MaxBodySize
+}
+
+func (l PostLimits) maxBatchSize() int {
+	if l.MaxBatchSize < 1 {
+		return DefaultMaxBatchSize
+	}
+	return l.MaxBatchSize
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors is the body of 422 answers.
+type ValidationErrors struct {
+	Errors []FieldError `json:"errors"`
+}
+
+// normalizeText checks the text of a post and brings it to NFC, so that the
+// same text is always stored (and counted) the same way.
+func (l PostLimits) normalizeText(field string, text string, allow_empty bool) (string, []FieldError) {
+	text = norm.NFC.String(text)
+
+	if !allow_empty && strings.TrimSpace(text) == "" {
+		return text, []FieldError{{Field: field, Message: "must not be empty"}}
+	}
+	if uniseg.GraphemeClusterCount(text) > l.maxTextLength() {
+		return text, []FieldError{{Field: field, Message: fmt.Sprintf("must be at most %v characters", l.maxTextLength())}}
+	}
+
+	return text, nil
+}
+
+// decodeBody reads the JSON body into data, answering with an error if the
+// body is too large or broken. The body is checked for UTF-8 before decoding,
+// as the decoder quietly replaces wrong bytes.
+func (h *HTTPHandler) decodeBody(rw http.ResponseWriter, r *http.Request, data interface{}) bool {
+	return h.decode(rw, r, data, false)
+}
+
+// decodeOptionalBody is decodeBody for bodies that may be left out, data is
+// left alone then.
+func (h *HTTPHandler) decodeOptionalBody(rw http.ResponseWriter, r *http.Request, data interface{}) bool {
+	return h.decode(rw, r, data, true)
+}
+
+func (h *HTTPHandler) decode(rw http.ResponseWriter, r *http.Request, data interface{}, optional bool) bool {
+	r.Body = http.MaxBytesReader(rw, r.Body, h.Limits.maxBodySize())
+
+	raw, err := io.ReadAll(r.Body)
+	if err == nil && !utf8.Valid(raw) {
+		http.Error(rw, "Request body must be valid UTF-8", http.StatusBadRequest)
+		return false
+	}
+	if err == nil {
+		err = json.NewDecoder(bytes.NewReader(raw)).Decode(data)
+	}
+	if optional && errors.Is(err, io.EOF) {
+		return true
+	}
+	if err != nil {
+		if bodyTooLarge(err) {
+			http.Error(rw, "Request body is too large", http.StatusRequestEntityTooLarge)
+			return false
+		}
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// bodyTooLarge tells if err comes from http.MaxBytesReader.
+func bodyTooLarge(err error) bool {
+	return strings.Contains(err.Error(), "request body too large")
+}
+
+func writeValidationErrors(rw http.ResponseWriter, errors []FieldError) {
+	rawResponse, _ := json.Marshal(ValidationErrors{Errors: errors})
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusUnprocessableEntity)
+	rw.Write(rawResponse)
+}
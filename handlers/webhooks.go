@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"microblog/storage"
+	"microblog/webhooks"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+type WebhookRequestData struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// getUser returns the caller given in the System-Design-User-Id header.
+func getUser(r *http.Request) (string, bool) {
+	user_slice, ok := r.Header["System-Design-User-Id"]
+	if !ok || len(user_slice) != 1 {
+		return "", false
+	}
+	return user_slice[0], true
+}
+
+func writeJSON(rw http.ResponseWriter, data interface{}) {
+	rawResponse, _ := json.Marshal(data)
+
+	rw.Header().Set("Content-Type", "application/json")
+	_, err := rw.Write(rawResponse)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func validWebhookEvent(event string) bool {
+	for _, known := range storage.WebhookEvents {
+		if event == known {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *HTTPHandler) HandleCreateWebhook(rw http.ResponseWriter, r *http.Request) {
+	user, ok := getUser(r)
+	if !ok {
+		http.Error(rw, "No user specified", http.StatusUnauthorized)
+		return
+	}
+	if h.Webhooks == nil {
+		http.Error(rw, "Webhooks are not supported", http.StatusNotImplemented)
+		return
+	}
+
+	var data WebhookRequestData
+	if !h.decodeBody(rw, r, &data) {
+		return
+	}
+
+	hook_url, err := url.Parse(data.URL)
+	if err != nil || (hook_url.Scheme != "http" && hook_url.Scheme != "https") || hook_url.Host == "" {
+		http.Error(rw, "Wrong url, an absolute http(s) url is expected", http.StatusBadRequest)
+		return
+	}
+	if err = h.Webhooks.CheckURL(r.Context(), hook_url); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(data.Events) == 0 {
+		http.Error(rw, "No events specified", http.StatusBadRequest)
+		return
+	}
+	for _, event := range data.Events {
+		if !validWebhookEvent(event) {
+			http.Error(rw, "Unknown event "+event, http.StatusBadRequest)
+			return
+		}
+	}
+
+	hook := storage.Webhook{
+		Id:        uuid.NewString(),
+		User:      user,
+		URL:       hook_url.String(),
+		Events:    data.Events,
+		Secret:    webhooks.NewSecret(),
+		CreatedAt: time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+	}
+
+	err = h.Webhooks.Store.AddWebhook(r.Context(), hook)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the only time the secret is shown
+	writeJSON(rw, hook)
+}
+
+func (h *HTTPHandler) HandleGetWebhooks(rw http.ResponseWriter, r *http.Request) {
+	user, ok := getUser(r)
+	if !ok {
+		http.Error(rw, "No user specified", http.StatusUnauthorized)
+		return
+	}
+	if h.Webhooks == nil {
+		http.Error(rw, "Webhooks are not supported", http.StatusNotImplemented)
+		return
+	}
+
+	hooks, err := h.Webhooks.Store.GetWebhooks(r.Context(), user, "")
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+
+	writeJSON(rw, storage.Webhooks{Webhooks: hooks})
+}
+
+// ownWebhook loads the webhook from the url and checks that the caller owns
+// it. It answers with an error itself and returns false if something is wrong.
+func (h *HTTPHandler) ownWebhook(rw http.ResponseWriter, r *http.Request) (storage.Webhook, bool) {
+	var hook storage.Webhook
+
+	user, ok := getUser(r)
+	if !ok {
+		http.Error(rw, "No user specified", http.StatusUnauthorized)
+		return hook, false
+	}
+	if h.Webhooks == nil {
+		http.Error(rw, "Webhooks are not supported", http.StatusNotImplemented)
+		return hook, false
+	}
+
+	hook, err := h.Webhooks.Store.GetWebhook(r.Context(), mux.Vars(r)["webhookId"])
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(rw, "Webhook with this webhookId does not exist", http.StatusNotFound)
+			return hook, false
+		}
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return hook, false
+	}
+	if hook.User != user {
+		http.Error(rw, "Webhook with this webhookId created by other user", http.StatusForbidden)
+		return hook, false
+	}
+
+	return hook, true
+}
+
+func (h *HTTPHandler) HandleDeleteWebhook(rw http.ResponseWriter, r *http.Request) {
+	hook, ok := h.ownWebhook(rw, r)
+	if !ok {
+		return
+	}
+
+	err := h.Webhooks.Store.DeleteWebhook(r.Context(), hook.Id)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// HandleEnableWebhook turns a webhook disabled after failures back on.
+func (h *HTTPHandler) HandleEnableWebhook(rw http.ResponseWriter, r *http.Request) {
+	hook, ok := h.ownWebhook(rw, r)
+	if !ok {
+		return
+	}
+
+	hook, err := h.Webhooks.Store.ResetWebhookFailures(r.Context(), hook.Id, true)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hook.Secret = ""
+	writeJSON(rw, hook)
+}
+
+func (h *HTTPHandler) HandleGetWebhookDeliveries(rw http.ResponseWriter, r *http.Request) {
+	hook, ok := h.ownWebhook(rw, r)
+	if !ok {
+		return
+	}
+
+	size := 20
+	if size_query := r.URL.Query().Get("size"); size_query != "" {
+		var err error
+		size, err = strconv.Atoi(size_query)
+		if err != nil || size < 0 {
+			http.Error(rw, "Wrong size query", http.StatusBadRequest)
+			return
+		}
+	}
+
+	deliveries, err := h.Webhooks.Store.GetDeliveries(r.Context(), hook.Id, size)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(rw, storage.WebhookDeliveries{Deliveries: deliveries})
+}
@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"microblog/pubsub"
+	"microblog/storage"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket protocol. The client sends
+//
+//	{"action": "subscribe", "topic": "feed"}
+//	{"action": "subscribe", "topic": "mentions"}
+//	{"action": "subscribe", "topic": "post", "postId": "..."}
+//
+// and the same with "unsubscribe". The server answers with "subscribed",
+// "unsubscribed" or "error" messages and then pushes
+//
+//	{"type": "post", "topic": "feed", "token": "...", "post": {...}}
+//	{"type": "mention", "topic": "mentions", "post": {...}}
+//	{"type": "post.edited", "topic": "post", "post": {...}}
+//	{"type": "post.deleted", "topic": "post", "post": {...}}
+//
+// If the client does not read fast enough, queued messages are dropped and it
+// gets {"type": "resync"}: everything has to be refetched with the REST API.
+const (
+	wsTopicFeed     = "feed"
+	wsTopicMentions = "mentions"
+	wsTopicPost     = "post"
+
+	wsSendBuffer     = 64
+	wsMaxPostTopics  = 500
+	wsMaxMessageSize = 4096
+	wsWriteWait      = 10 * time.Second
+	wsPongWait       = 60 * time.Second
+	wsPingPeriod     = wsPongWait * 9 / 10
+)
+
+type WSRequest struct {
+	Action string `json:"action"`
+	Topic  string `json:"topic"`
+	PostId string `json:"postId,omitempty"`
+}
+
+type WSMessage struct {
+	Type   string        `json:"type"`
+	Topic  string        `json:"topic,omitempty"`
+	PostId string        `json:"postId,omitempty"`
+	Token  string        `json:"token,omitempty"`
+	Post   *storage.Post `json:"post,omitempty"`
+	Error  string        `json:"error,omitempty"`
+}
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+type wsConn struct {
+	conn    *websocket.Conn
+	user    string
+	pubsub  pubsub.PubSub
+	storage storage.Storage
+
+	// closed when the connection is done
+	done chan struct{}
+
+	sendMu sync.Mutex
+	send   chan WSMessage
+
+	// channel -> subscription, only used by the reading goroutine
+	subscriptions map[string]*pubsub.Subscription
+	post_topics   int
+}
+
+// HandleWebSocket multiplexes real-time updates of the caller's feed, mentions
+// and the posts the client shows over one WebSocket.
+func (h *HTTPHandler) HandleWebSocket(rw http.ResponseWriter, r *http.Request) {
+	user_slice, ok := r.Header["System-Design-User-Id"]
+	if !ok || len(user_slice) != 1 {
+		http.Error(rw, "No user specified", http.StatusUnauthorized)
+		return
+	}
+	user := user_slice[0]
+
+	if h.PubSub == nil {
+		http.Error(rw, "Streaming is not supported", http.StatusNotImplemented)
+		return
+	}
+
+	conn, err := wsUpgrader.Upgrade(rw, r, nil)
+	if err != nil {
+		// Upgrade has already answered
+		return
+	}
+
+	c := &wsConn{
+		conn:          conn,
+		user:          user,
+		pubsub:        h.PubSub,
+		storage:       h.Storage,
+		done:          make(chan struct{}),
+		send:          make(chan WSMessage, wsSendBuffer),
+		subscriptions: make(map[string]*pubsub.Subscription),
+	}
+
+	go c.writeLoop()
+	c.readLoop()
+}
+
+func (c *wsConn) readLoop() {
+	defer func() {
+		close(c.done)
+		for _, sub := range c.subscriptions {
+			sub.Close()
+		}
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Println("WebSocket of", c.user, "failed:", err)
+			}
+			return
+		}
+
+		var request WSRequest
+		if err = json.Unmarshal(data, &request); err != nil {
+			c.push(WSMessage{Type: "error", Error: "Wrong message format"})
+			continue
+		}
+
+		c.handleRequest(request)
+	}
+}
+
+func (c *wsConn) handleRequest(request WSRequest) {
+	reply := WSMessage{Topic: request.Topic, PostId: request.PostId}
+
+	var channel string
+	switch request.Topic {
+	case wsTopicFeed:
+		channel = storage.FeedChannel(c.user)
+	case wsTopicMentions:
+		channel = storage.MentionsChannel(c.user)
+	case wsTopicPost:
+		match, _ := regexp.MatchString("^[A-Za-z0-9_\\-]+$", request.PostId)
+		if !match {
+			reply.Type, reply.Error = "error", "Wrong postId format"
+			c.push(reply)
+			return
+		}
+		if request.Action == "subscribe" {
+			// only the posts the user can see
+			_, err := c.storage.GetPost(context.Background(), request.PostId, c.user)
+			if err != nil {
+				reply.Type, reply.Error = "error", "Post does not exist"
+				c.push(reply)
+				return
+			}
+		}
+		channel = storage.PostChannel(request.PostId)
+	default:
+		reply.Type, reply.Error = "error", "Unknown topic"
+		c.push(reply)
+		return
+	}
+
+	switch request.Action {
+	case "subscribe":
+		reply.Type = "subscribed"
+		if _, ok := c.subscriptions[channel]; ok {
+			break
+		}
+		if request.Topic == wsTopicPost && c.post_topics >= wsMaxPostTopics {
+			reply.Type, reply.Error = "error", "Too many post subscriptions"
+			c.push(reply)
+			return
+		}
+
+		sub, err := c.pubsub.Subscribe(context.Background(), channel)
+		if err != nil {
+			reply.Type, reply.Error = "error", err.Error()
+			c.push(reply)
+			return
+		}
+		c.subscriptions[channel] = sub
+		if request.Topic == wsTopicPost {
+			c.post_topics++
+		}
+		go c.forward(request.Topic, sub)
+	case "unsubscribe":
+		reply.Type = "unsubscribed"
+		sub, ok := c.subscriptions[channel]
+		if !ok {
+			break
+		}
+		sub.Close()
+		delete(c.subscriptions, channel)
+		if request.Topic == wsTopicPost {
+			c.post_topics--
+		}
+	default:
+		reply.Type, reply.Error = "error", "Unknown action"
+		c.push(reply)
+		return
+	}
+
+	c.push(reply)
+}
+
+// forward turns events of one subscription into messages for the client.
+func (c *wsConn) forward(topic string, sub *pubsub.Subscription) {
+	for data := range sub.C {
+		message := WSMessage{Topic: topic}
+
+		switch topic {
+		case wsTopicFeed, wsTopicMentions:
+			var event storage.FeedEvent
+			if err := json.Unmarshal(data, &event); err != nil {
+				log.Println("Bad feed event:", err)
+				continue
+			}
+			message.Type = "post"
+			if topic == wsTopicMentions {
+				message.Type = "mention"
+			}
+			message.Token = event.Token
+			message.Post = &event.Post
+		case wsTopicPost:
+			var event storage.PostEvent
+			if err := json.Unmarshal(data, &event); err != nil {
+				log.Println("Bad post event:", err)
+				continue
+			}
+			message.Type = event.Type
+			message.PostId = event.Post.Id
+			message.Post = &event.Post
+		}
+
+		c.push(message)
+	}
+}
+
+// push queues message without blocking. When the queue is full the client is
+// too slow: the queue is thrown away and replaced by a single resync.
+func (c *wsConn) push(message WSMessage) {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	select {
+	case c.send <- message:
+		return
+	default:
+	}
+
+	for len(c.send) > 0 {
+		select {
+		case <-c.send:
+		default:
+		}
+	}
+	c.send <- WSMessage{Type: "resync"}
+}
+
+func (c *wsConn) writeLoop() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case <-c.done:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+			return
+		case message := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteJSON(message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import "testing"
+
+func TestWSPushResync(t *testing.T) {
+	c := &wsConn{send: make(chan WSMessage, wsSendBuffer)}
+
+	for i := 0; i < wsSendBuffer; i++ {
+		c.push(WSMessage{Type: "post"})
+	}
+	if len(c.send) != wsSendBuffer {
+		t.Fatalf("got %d queued messages, want %d", len(c.send), wsSendBuffer)
+	}
+
+	// the client does not read: everything is replaced by one resync
+	c.push(WSMessage{Type: "post"})
+	if len(c.send) != 1 {
+		t.Fatalf("got %d queued messages, want 1", len(c.send))
+	}
+	if message := <-c.send; message.Type != "resync" {
+		t.Fatalf("got %q message, want resync", message.Type)
+	}
+
+	c.push(WSMessage{Type: "post"})
+	if message := <-c.send; message.Type != "post" {
+		t.Fatalf("got %q message, want post", message.Type)
+	}
+}
@@ -0,0 +1,299 @@
+// Package loadgen replays request traces against a running server and
+// reports how it held up, it can also make up traces for capacity tests.
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const DefaultConcurrency = 10
+
+// Trace is a recorded request, a line of a trace file.
+type Trace struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+	// when the request was sent, in milliseconds from the start of the trace
+	At int64 `json:"at"`
+}
+
+type Replayer struct {
+	// the server, like http://localhost:8080
+	BaseURL string
+	Client  *http.Client
+	// how many requests may wait for an answer at once
+	Concurrency int
+	// requests per second, zero keeps the timing of the traces
+	Rate float64
+	// Route names the route of a request in the report, the method with the
+	// path by default
+	Route func(r *http.Request) string
+}
+
+func NewReplayer(base_url string) *Replayer {
+	return &Replayer{
+		BaseURL:     strings.TrimSuffix(base_url, "/"),
+		Client:      http.DefaultClient,
+		Concurrency: DefaultConcurrency,
+		Route:       pathRoute,
+	}
+}
+
+func pathRoute(r *http.Request) string {
+	return r.Method + " " + r.URL.Path
+}
+
+// MuxRoute names requests by the template of the route they match in
+// router, without the patterns of the variables.
+func MuxRoute(router *mux.Router) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		var match mux.RouteMatch
+		if !router.Match(r, &match) || match.Route == nil {
+			return pathRoute(r)
+		}
+		template, err := match.Route.GetPathTemplate()
+		if err != nil {
+			return pathRoute(r)
+		}
+		return r.Method + " " + stripPatterns(template)
+	}
+}
+
+// stripPatterns turns {postId:[a-z]+} into {postId}.
+func stripPatterns(template string) string {
+	var b strings.Builder
+	depth := 0
+	skip := false
+	for _, c := range template {
+		switch c {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				skip = false
+			}
+		case ':':
+			if depth == 1 {
+				skip = true
+			}
+		}
+		if !skip {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// scheduled is a trace with the time it is due.
+type scheduled struct {
+	trace Trace
+	due   time.Time
+}
+
+// result is the outcome of a replayed request. The latency counts from when
+// the request was due, so that requests waiting for a free worker count the
+// wait too, lag is that wait.
+type result struct {
+	route   string
+	status  int
+	failed  bool
+	latency time.Duration
+	lag     time.Duration
+}
+
+// Replay sends the traces read from r, a line each, and waits for all the
+// answers. Requests that can't be sent or get a 5xx are counted as errors.
+func (p *Replayer) Replay(ctx context.Context, r io.Reader) (Report, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	traces := make(chan scheduled)
+	results := make(chan result)
+
+	var workers sync.WaitGroup
+	for i := 0; i < p.Concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for next := range traces {
+				results <- p.send(ctx, next.trace, next.due)
+			}
+		}()
+	}
+
+	collected := make(chan Report)
+	start := time.Now()
+	go func() {
+		collected <- collect(results, start)
+	}()
+
+	err := p.dispatch(ctx, r, traces, start)
+	close(traces)
+	workers.Wait()
+	close(results)
+	report := <-collected
+
+	return report, err
+}
+
+// dispatch hands the traces to the workers when they are due.
+func (p *Replayer) dispatch(ctx context.Context, r io.Reader, traces chan<- scheduled, start time.Time) error {
+	decoder := json.NewDecoder(r)
+	for i := 0; ; i++ {
+		var trace Trace
+		err := decoder.Decode(&trace)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		due := start.Add(time.Duration(trace.At) * time.Millisecond)
+		if p.Rate > 0 {
+			due = start.Add(time.Duration(float64(i) / p.Rate * float64(time.Second)))
+		}
+		if wait := time.Until(due); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		select {
+		case traces <- scheduled{trace: trace, due: due}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (p *Replayer) send(ctx context.Context, trace Trace, due time.Time) result {
+	var body io.Reader
+	if len(trace.Body) > 0 {
+		body = bytes.NewReader(trace.Body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, trace.Method, p.BaseURL+trace.Path, body)
+	if err != nil {
+		return result{route: trace.Method + " " + trace.Path, failed: true}
+	}
+	for name, value := range trace.Headers {
+		req.Header.Set(name, value)
+	}
+	if body != nil && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res := result{route: p.Route(req)}
+	res.lag = time.Since(due)
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		res.failed = true
+		res.latency = time.Since(due)
+		return res
+	}
+	// the whole answer counts
+	_, err = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	res.latency = time.Since(due)
+	res.status = resp.StatusCode
+	res.failed = err != nil || resp.StatusCode >= 500
+
+	return res
+}
+
+// RouteStats tells how the requests of a route went, latencies are in
+// milliseconds from when the requests were due.
+type RouteStats struct {
+	Route    string `json:"route"`
+	Requests int    `json:"requests"`
+	Errors   int    `json:"errors"`
+	// status code -> requests, requests that got no answer are left out
+	Statuses map[int]int `json:"statuses"`
+	P50      float64     `json:"p50"`
+	P90      float64     `json:"p90"`
+	P99      float64     `json:"p99"`
+	Max      float64     `json:"max"`
+}
+
+type Report struct {
+	Requests int `json:"requests"`
+	Errors   int `json:"errors"`
+	// how long the replay took, in seconds
+	Seconds float64 `json:"seconds"`
+	// how far a request was sent behind its time at most, in milliseconds,
+	// more than a few means the concurrency is too low for the traces
+	MaxLag float64      `json:"maxLag"`
+	Routes []RouteStats `json:"routes"`
+}
+
+func collect(results <-chan result, start time.Time) Report {
+	routes := make(map[string]*RouteStats)
+	latencies := make(map[string][]time.Duration)
+
+	report := Report{Routes: make([]RouteStats, 0)}
+	for res := range results {
+		stats, ok := routes[res.route]
+		if !ok {
+			stats = &RouteStats{Route: res.route, Statuses: make(map[int]int)}
+			routes[res.route] = stats
+		}
+
+		stats.Requests++
+		report.Requests++
+		if res.failed {
+			stats.Errors++
+			report.Errors++
+		}
+		if res.status != 0 {
+			stats.Statuses[res.status]++
+		}
+		latencies[res.route] = append(latencies[res.route], res.latency)
+		if lag := milliseconds(res.lag); lag > report.MaxLag {
+			report.MaxLag = lag
+		}
+	}
+	report.Seconds = time.Since(start).Seconds()
+
+	for route, stats := range routes {
+		sorted := latencies[route]
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		stats.P50 = milliseconds(percentile(sorted, 50))
+		stats.P90 = milliseconds(percentile(sorted, 90))
+		stats.P99 = milliseconds(percentile(sorted, 99))
+		stats.Max = milliseconds(sorted[len(sorted)-1])
+		report.Routes = append(report.Routes, *stats)
+	}
+	sort.Slice(report.Routes, func(i, j int) bool {
+		return report.Routes[i].Route < report.Routes[j].Route
+	})
+
+	return report
+}
+
+// percentile picks the nearest rank of sorted.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (len(sorted)*p + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,122 @@
+package loadgen
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+)
+
+// userHeader tells the server who sends a request.
+const userHeader = "System-Design-User-Id"
+
+// Synthesis describes made up traffic. Users follow others and post by a
+// power law: a few users have most of the subscribers and write most of the
+// posts, which is what makes fan-out expensive.
+type Synthesis struct {
+	Users int
+	// subscriptions a user makes on average
+	Follows int
+	// exponent of the power law, above 1, larger is more skewed
+	Exponent float64
+	// how long the traffic lasts after the follow graph is made
+	Duration time.Duration
+	// posts and feed reads per second, over all users
+	PostRate float64
+	ReadRate float64
+	Seed     int64
+}
+
+func DefaultSynthesis() Synthesis {
+	return Synthesis{
+		Users:    1000,
+		Follows:  20,
+		Exponent: 1.5,
+		Duration: time.Minute,
+		PostRate: 10,
+		ReadRate: 50,
+		Seed:     1,
+	}
+}
+
+// SynthUser is the id of the i-th made up user.
+func SynthUser(i int) string {
+	return fmt.Sprintf("%08x", i+1)
+}
+
+// Write writes the traces: first the subscriptions, all at the start, then
+// posts and feed reads arriving at random with the given rates.
+func (s Synthesis) Write(w io.Writer) error {
+	if s.Users < 2 {
+		return fmt.Errorf("at least 2 users are needed, got %d", s.Users)
+	}
+	if s.Exponent <= 1 {
+		return fmt.Errorf("the exponent must be above 1, got %v", s.Exponent)
+	}
+
+	r := rand.New(rand.NewSource(s.Seed))
+	// user 0 is the most popular and the most active one
+	popular := rand.NewZipf(r, s.Exponent, 1, uint64(s.Users-1))
+
+	encoder := json.NewEncoder(w)
+	write := func(user string, method string, path string, body interface{}, at time.Duration) error {
+		trace := Trace{
+			Method:  method,
+			Path:    path,
+			Headers: map[string]string{userHeader: user},
+			At:      at.Milliseconds(),
+		}
+		if body != nil {
+			raw, err := json.Marshal(body)
+			if err != nil {
+				return err
+			}
+			trace.Body = raw
+		}
+		return encoder.Encode(trace)
+	}
+
+	for i := 0; i < s.Users; i++ {
+		user := SynthUser(i)
+		follows := make(map[int]bool)
+		// tries are bounded, as the popular users are taken quickly
+		for tries := 0; len(follows) < s.Follows && tries < 4*s.Follows; tries++ {
+			to := int(popular.Uint64())
+			if to == i || follows[to] {
+				continue
+			}
+			follows[to] = true
+			if err := write(user, "POST", "/api/v1/users/"+SynthUser(to)+"/subscribe", nil, 0); err != nil {
+				return err
+			}
+		}
+	}
+
+	rate := s.PostRate + s.ReadRate
+	if rate <= 0 {
+		return nil
+	}
+	at := time.Duration(0)
+	for posts := 0; ; {
+		at += time.Duration(r.ExpFloat64() / rate * float64(time.Second))
+		if at >= s.Duration {
+			return nil
+		}
+
+		var err error
+		if r.Float64() < s.PostRate/rate {
+			posts++
+			author := SynthUser(int(popular.Uint64()))
+			text := fmt.Sprintf("post %d by %v", posts, author)
+			err = write(author, "POST", "/api/v1/posts", map[string]string{"text": text}, at)
+		} else {
+			// everyone reads
+			reader := SynthUser(r.Intn(s.Users))
+			err = write(reader, "GET", "/api/v1/feed", nil, at)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -1,47 +1,30 @@
 package main
 
 import (
+	"context"
 	"log"
-	"microblog/handlers"
+	"microblog/queue"
+	"microblog/setup"
 	"microblog/storage/mongostore"
-	"net/http"
 	"os"
-	"time"
-
-	"github.com/gorilla/mux"
 )
 
-func NewServer() *http.Server {
-	r := mux.NewRouter()
+func main() {
+	ps := setup.NewPubSub()
 
 	mongoUrl := os.Getenv("MONGO_URL")
-	mongostorage := mongostore.NewStorage(mongoUrl)
-
-	handler := &handlers.HTTPHandler{
-		Storage: mongostorage,
-	}
+	mongostorage := mongostore.NewStorage(mongoUrl, ps)
 
-	r.HandleFunc("/", handlers.HandleRoot)
-	r.HandleFunc("/api/v1/posts", handler.HandlePostAPost).Methods("POST")
-	r.HandleFunc("/api/v1/posts/{postId:[A-Za-z0-9_\\-]+}", handler.HandleGetThePost).Methods("GET")
-	r.HandleFunc("/api/v1/users/{userId:[0-9a-f]+}/posts", handler.HandleGetThePostLine).Methods("GET")
-	r.HandleFunc("/maintenance/ping", handler.PingHandler).Methods("GET")
+	// without the worker tasks run right here
+	tasks := queue.NewLocal()
 
-	r.HandleFunc("/api/v1/users/{userId:[0-9a-f]+}/subscribe", handler.HandleSubscribe).Methods("POST")
-	r.HandleFunc("/api/v1/subscriptions", handler.HandleGetSubscriptions).Methods("GET")
-	r.HandleFunc("/api/v1/subscribers", handler.HandleGetSubscribers).Methods("GET")
-	r.HandleFunc("/api/v1/feed", handler.GetFeed).Methods("GET")   // behave like posts
-
-	return &http.Server{
-		Handler:      r,
-		Addr:         "0.0.0.0:8080",
-		WriteTimeout: 15 * time.Second,
-		ReadTimeout:  15 * time.Second,
+	services := setup.NewServices(mongostorage, tasks)
+	for name, handler := range services.Tasks() {
+		tasks.Register(name, handler)
 	}
-}
+	services.RunBackground(context.Background())
 
-func main() {
-	srv := NewServer()
+	srv := setup.NewServer("0.0.0.0:8080", services.Handler(ps))
 	log.Printf("Start serving on %s", srv.Addr)
 	log.Fatal(srv.ListenAndServe())
 }
@@ -0,0 +1,2524 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"microblog/admin"
+	"microblog/erasure"
+	"microblog/dump"
+	"microblog/events"
+	"microblog/handlers"
+	"microblog/loadgen"
+	"microblog/moderation"
+	"microblog/pubsub"
+	"microblog/queue"
+	"microblog/scheduler"
+	"microblog/setup"
+	"microblog/storage"
+	"microblog/storage/blobfs"
+	"microblog/storage/localstorage"
+	"microblog/webhooks"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+type testServer struct {
+	*httptest.Server
+	t       *testing.T
+	handler *handlers.HTTPHandler
+}
+
+// newTestServer serves everything from memory, configure may change the
+// handler before the server starts.
+func newTestServer(t *testing.T, configure ...func(*handlers.HTTPHandler)) *testServer {
+	ps := pubsub.NewLocal()
+	store := localstorage.NewStorage(ps)
+
+	tasks := queue.NewLocal()
+	dispatcher := webhooks.NewDispatcher(store, tasks)
+	dispatcher.BaseDelay = 10 * time.Millisecond
+	dispatcher.MaxAttempts = 3
+	dispatcher.DisableAfter = 2
+	// the receivers listen on loopback
+	dispatcher.AllowPrivate = true
+	tasks.Register(webhooks.DeliverTask, dispatcher.Deliver)
+
+	// no moderator, posts are only reviewed by hand
+	pipeline := moderation.NewPipeline(nil, store, store, nil)
+	tasks.Register(moderation.ModerateTask, pipeline.ModeratePost)
+
+	publisher := scheduler.NewPublisher(store, store, tasks, dispatcher)
+	publisher.Moderation = pipeline
+	publisher.Suspensions = store
+	publisher.SuspendedDelay = 50 * time.Millisecond
+	tasks.Register(scheduler.PublishTask, publisher.Publish)
+
+	blobs := blobfs.NewStorage(t.TempDir())
+
+	eraser := erasure.NewEraser(store, blobs, tasks)
+	tasks.Register(erasure.EraseTask, eraser.Erase)
+
+	handler := &handlers.HTTPHandler{
+		Storage:       store,
+		PubSub:        ps,
+		Webhooks:      dispatcher,
+		Notifications: store,
+		Relations:     store,
+		Accounts:      store,
+		Scheduler:     publisher,
+		Drafts:        store,
+		Media:         store,
+		Blobs:         blobs,
+		Moderation:    pipeline,
+		Admins:        []string{"ad"},
+		Reports:       store,
+		Suspensions:   store,
+		Feeds:         store,
+		Erasure:       eraser,
+	}
+	for _, change := range configure {
+		change(handler)
+	}
+
+	srv := httptest.NewServer(setup.NewServer("", handler).Handler)
+	t.Cleanup(srv.Close)
+
+	return &testServer{Server: srv, t: t, handler: handler}
+}
+
+// do sends a request on behalf of user (no System-Design-User-Id header when
+// user is empty) and returns the status code and the raw body.
+func (s *testServer) do(method string, path string, user string, body interface{}) (int, []byte) {
+	s.t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		reader = bytes.NewReader(raw)
+	}
+
+	req, err := http.NewRequest(method, s.URL+path, reader)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	if user != "" {
+		req.Header.Set("System-Design-User-Id", user)
+	}
+
+	resp, err := s.Client().Do(req)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	return resp.StatusCode, raw
+}
+
+// doJSON is like do but also checks the status and decodes the answer into out.
+func (s *testServer) doJSON(method string, path string, user string, body interface{}, status int, out interface{}) {
+	s.t.Helper()
+
+	code, raw := s.do(method, path, user, body)
+	if code != status {
+		s.t.Fatalf("%s %s: got status %d, want %d, body: %s", method, path, code, status, raw)
+	}
+	if out == nil {
+		return
+	}
+	if err := json.Unmarshal(raw, out); err != nil {
+		s.t.Fatalf("%s %s: bad json %q: %v", method, path, raw, err)
+	}
+}
+
+func (s *testServer) createPost(user string, text string) storage.Post {
+	s.t.Helper()
+
+	var post storage.Post
+	s.doJSON("POST", "/api/v1/posts", user, handlers.PostRequestData{Text: text}, http.StatusOK, &post)
+	return post
+}
+
+func (s *testServer) getPage(path string, user string) storage.PostLineAnswer {
+	s.t.Helper()
+
+	var page storage.PostLineAnswer
+	s.doJSON("GET", path, user, nil, http.StatusOK, &page)
+	return page
+}
+
+func postTexts(posts []storage.Post) []string {
+	texts := make([]string, 0, len(posts))
+	for _, post := range posts {
+		texts = append(texts, post.Text)
+	}
+	return texts
+}
+
+func assertTexts(t *testing.T, posts []storage.Post, want ...string) {
+	t.Helper()
+
+	got := postTexts(posts)
+	if len(got) != len(want) {
+		t.Fatalf("got posts %q, want %q", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("got posts %q, want %q", got, want)
+		}
+	}
+}
+
+func TestPing(t *testing.T) {
+	srv := newTestServer(t)
+
+	code, _ := srv.do("GET", "/maintenance/ping", "", nil)
+	if code != http.StatusOK {
+		t.Fatalf("ping: got status %d", code)
+	}
+}
+
+func TestCreateAndGetPost(t *testing.T) {
+	srv := newTestServer(t)
+
+	post := srv.createPost("a1", "hello")
+	if post.Id == "" || post.AuthorId != "a1" || post.Text != "hello" {
+		t.Fatalf("unexpected post %+v", post)
+	}
+	if post.CreatedAt == "" || post.CreatedAt != post.LastModifiedAt {
+		t.Fatalf("bad timestamps %+v", post)
+	}
+
+	var got map[string]interface{}
+	srv.doJSON("GET", "/api/v1/posts/"+post.Id, "", nil, http.StatusOK, &got)
+	for _, field := range []string{"id", "text", "authorId", "createdAt", "lastModifiedAt"} {
+		if _, ok := got[field]; !ok {
+			t.Errorf("field %q is missing in %v", field, got)
+		}
+	}
+	if got["id"] != post.Id || got["text"] != "hello" {
+		t.Fatalf("unexpected post %v", got)
+	}
+
+	code, _ := srv.do("GET", "/api/v1/posts/unknown", "", nil)
+	if code != http.StatusNotFound {
+		t.Fatalf("unknown post: got status %d", code)
+	}
+}
+
+func TestCreatePostErrors(t *testing.T) {
+	srv := newTestServer(t)
+
+	code, _ := srv.do("POST", "/api/v1/posts", "", handlers.PostRequestData{Text: "hello"})
+	if code != http.StatusUnauthorized {
+		t.Errorf("no user: got status %d", code)
+	}
+
+	code, _ = srv.do("POST", "/api/v1/posts", "NotHex", handlers.PostRequestData{Text: "hello"})
+	if code != http.StatusUnauthorized {
+		t.Errorf("bad user: got status %d", code)
+	}
+
+	code, _ = srv.do("POST", "/api/v1/posts", "a1", "not an object")
+	if code != http.StatusBadRequest {
+		t.Errorf("bad body: got status %d", code)
+	}
+}
+
+func TestChangePostText(t *testing.T) {
+	srv := newTestServer(t)
+
+	post := srv.createPost("a1", "first version")
+
+	var changed storage.Post
+	srv.doJSON("PATCH", "/api/v1/posts/"+post.Id, "a1", handlers.PostRequestData{Text: "second version"}, http.StatusOK, &changed)
+	if changed.Id != post.Id || changed.Text != "second version" {
+		t.Fatalf("unexpected post %+v", changed)
+	}
+
+	var got storage.Post
+	srv.doJSON("GET", "/api/v1/posts/"+post.Id, "", nil, http.StatusOK, &got)
+	if got.Text != "second version" {
+		t.Fatalf("text was not changed: %+v", got)
+	}
+
+	code, _ := srv.do("PATCH", "/api/v1/posts/"+post.Id, "b2", handlers.PostRequestData{Text: "hijack"})
+	if code != http.StatusForbidden {
+		t.Errorf("other user: got status %d", code)
+	}
+
+	code, _ = srv.do("PATCH", "/api/v1/posts/unknown", "a1", handlers.PostRequestData{Text: "x"})
+	if code != http.StatusNotFound {
+		t.Errorf("unknown post: got status %d", code)
+	}
+
+	code, _ = srv.do("PATCH", "/api/v1/posts/"+post.Id, "", handlers.PostRequestData{Text: "x"})
+	if code != http.StatusUnauthorized {
+		t.Errorf("no user: got status %d", code)
+	}
+}
+
+func TestPostLinePagination(t *testing.T) {
+	srv := newTestServer(t)
+
+	for i := 0; i < 5; i++ {
+		srv.createPost("a1", "post "+strconv.Itoa(i))
+	}
+	srv.createPost("b2", "somebody else")
+
+	page := srv.getPage("/api/v1/users/a1/posts?size=2", "")
+	assertTexts(t, page.Posts, "post 4", "post 3")
+	if page.Token == "" {
+		t.Fatal("expected next page token")
+	}
+
+	page = srv.getPage("/api/v1/users/a1/posts?size=2&page="+page.Token, "")
+	assertTexts(t, page.Posts, "post 2", "post 1")
+
+	page = srv.getPage("/api/v1/users/a1/posts?size=2&page="+page.Token, "")
+	assertTexts(t, page.Posts, "post 0")
+	if page.Token != "" {
+		t.Fatalf("unexpected next page token %q", page.Token)
+	}
+
+	// default size is 10
+	page = srv.getPage("/api/v1/users/a1/posts", "")
+	if len(page.Posts) != 5 {
+		t.Fatalf("got %d posts, want 5", len(page.Posts))
+	}
+
+	var raw map[string]interface{}
+	srv.doJSON("GET", "/api/v1/users/c3/posts", "", nil, http.StatusOK, &raw)
+	if posts, ok := raw["posts"].([]interface{}); !ok || len(posts) != 0 {
+		t.Fatalf("expected empty posts array, got %v", raw)
+	}
+	if _, ok := raw["nextPage"]; ok {
+		t.Fatalf("unexpected nextPage in %v", raw)
+	}
+
+	for _, query := range []string{"?size=-1", "?size=abc", "?page=bad%20token"} {
+		code, _ := srv.do("GET", "/api/v1/users/a1/posts"+query, "", nil)
+		if code != http.StatusBadRequest {
+			t.Errorf("query %s: got status %d", query, code)
+		}
+	}
+}
+
+func TestSubscriptions(t *testing.T) {
+	srv := newTestServer(t)
+
+	srv.doJSON("POST", "/api/v1/users/b2/subscribe", "a1", nil, http.StatusOK, nil)
+	srv.doJSON("POST", "/api/v1/users/c3/subscribe", "a1", nil, http.StatusOK, nil)
+	srv.doJSON("POST", "/api/v1/users/c3/subscribe", "b2", nil, http.StatusOK, nil)
+
+	code, _ := srv.do("POST", "/api/v1/users/b2/subscribe", "a1", nil)
+	if code != http.StatusBadRequest {
+		t.Errorf("double subscription: got status %d", code)
+	}
+
+	code, _ = srv.do("POST", "/api/v1/users/b2/subscribe", "", nil)
+	if code != http.StatusUnauthorized {
+		t.Errorf("no user: got status %d", code)
+	}
+
+	var subscriptions storage.Subscriptions
+	srv.doJSON("GET", "/api/v1/subscriptions", "a1", nil, http.StatusOK, &subscriptions)
+	if len(subscriptions.Users) != 2 || subscriptions.Users[0] != "b2" || subscriptions.Users[1] != "c3" {
+		t.Fatalf("unexpected subscriptions %v", subscriptions.Users)
+	}
+
+	var subscribers storage.Subscribers
+	srv.doJSON("GET", "/api/v1/subscribers", "c3", nil, http.StatusOK, &subscribers)
+	if len(subscribers.Users) != 2 || subscribers.Users[0] != "a1" || subscribers.Users[1] != "b2" {
+		t.Fatalf("unexpected subscribers %v", subscribers.Users)
+	}
+
+	var raw map[string]interface{}
+	srv.doJSON("GET", "/api/v1/subscribers", "a1", nil, http.StatusOK, &raw)
+	if users, ok := raw["users"].([]interface{}); !ok || len(users) != 0 {
+		t.Fatalf("expected empty users array, got %v", raw)
+	}
+
+	for _, path := range []string{"/api/v1/subscriptions", "/api/v1/subscribers", "/api/v1/feed"} {
+		code, _ := srv.do("GET", path, "", nil)
+		if code != http.StatusBadRequest {
+			t.Errorf("%s without user: got status %d", path, code)
+		}
+	}
+}
+
+func TestFeed(t *testing.T) {
+	srv := newTestServer(t)
+
+	srv.createPost("b2", "b2 old")
+	srv.createPost("c3", "c3 old")
+
+	// subscription copies existing posts into the feed
+	srv.doJSON("POST", "/api/v1/users/b2/subscribe", "a1", nil, http.StatusOK, nil)
+
+	feed := srv.getPage("/api/v1/feed", "a1")
+	assertTexts(t, feed.Posts, "b2 old")
+
+	// backfilled posts are merged by time with the existing feed
+	srv.doJSON("POST", "/api/v1/users/c3/subscribe", "a1", nil, http.StatusOK, nil)
+	feed = srv.getPage("/api/v1/feed", "a1")
+	assertTexts(t, feed.Posts, "c3 old", "b2 old")
+
+	// new posts are fanned out to subscribers
+	srv.createPost("b2", "b2 new")
+	srv.createPost("a1", "own post")
+	srv.createPost("c3", "c3 new")
+
+	feed = srv.getPage("/api/v1/feed?size=2", "a1")
+	assertTexts(t, feed.Posts, "c3 new", "b2 new")
+	if feed.Token == "" {
+		t.Fatal("expected next page token")
+	}
+
+	feed = srv.getPage("/api/v1/feed?size=2&page="+feed.Token, "a1")
+	assertTexts(t, feed.Posts, "c3 old", "b2 old")
+	if feed.Token != "" {
+		t.Fatalf("unexpected next page token %q", feed.Token)
+	}
+
+	// edits are visible in the feed
+	line := srv.getPage("/api/v1/users/b2/posts?size=1", "")
+	srv.doJSON("PATCH", "/api/v1/posts/"+line.Posts[0].Id, "b2", handlers.PostRequestData{Text: "b2 edited"}, http.StatusOK, nil)
+	feed = srv.getPage("/api/v1/feed?size=2", "a1")
+	assertTexts(t, feed.Posts, "c3 new", "b2 edited")
+
+	var empty map[string]interface{}
+	srv.doJSON("GET", "/api/v1/feed", "d4", nil, http.StatusOK, &empty)
+	if posts, ok := empty["posts"].([]interface{}); !ok || len(posts) != 0 {
+		t.Fatalf("expected empty posts array, got %v", empty)
+	}
+
+	for _, query := range []string{"?size=-1", "?page=bad%20token"} {
+		code, _ := srv.do("GET", "/api/v1/feed"+query, "a1", nil)
+		if code != http.StatusBadRequest {
+			t.Errorf("query %s: got status %d", query, code)
+		}
+	}
+}
+
+func TestPostLineSince(t *testing.T) {
+	srv := newTestServer(t)
+
+	for i := 0; i < 3; i++ {
+		srv.createPost("a1", "post "+strconv.Itoa(i))
+	}
+
+	page := srv.getPage("/api/v1/users/a1/posts?size=2", "")
+	assertTexts(t, page.Posts, "post 2", "post 1")
+	if page.PrevToken == "" {
+		t.Fatal("expected prev page token")
+	}
+	top := page.PrevToken
+
+	// nothing new yet
+	page = srv.getPage("/api/v1/users/a1/posts?since="+top, "")
+	assertTexts(t, page.Posts)
+	if page.PrevToken != top {
+		t.Fatalf("got prev page token %q, want %q", page.PrevToken, top)
+	}
+
+	for i := 3; i < 6; i++ {
+		srv.createPost("a1", "post "+strconv.Itoa(i))
+	}
+
+	// the posts right after the token come first, newest first inside a page
+	page = srv.getPage("/api/v1/users/a1/posts?size=2&since="+top, "")
+	assertTexts(t, page.Posts, "post 4", "post 3")
+
+	page = srv.getPage("/api/v1/users/a1/posts?size=2&since="+page.PrevToken, "")
+	assertTexts(t, page.Posts, "post 5")
+
+	// and the next page goes back down
+	page = srv.getPage("/api/v1/users/a1/posts?size=10&page="+page.Token, "")
+	assertTexts(t, page.Posts, "post 4", "post 3", "post 2", "post 1", "post 0")
+
+	code, _ := srv.do("GET", "/api/v1/users/a1/posts?page="+top+"&since="+top, "", nil)
+	if code != http.StatusBadRequest {
+		t.Errorf("page and since: got status %d", code)
+	}
+}
+
+func TestFeedSinceAndNewCount(t *testing.T) {
+	srv := newTestServer(t)
+
+	srv.doJSON("POST", "/api/v1/users/b2/subscribe", "a1", nil, http.StatusOK, nil)
+	srv.createPost("b2", "first")
+
+	feed := srv.getPage("/api/v1/feed", "a1")
+	assertTexts(t, feed.Posts, "first")
+	top := feed.PrevToken
+
+	var count handlers.NewPostsCount
+	srv.doJSON("GET", "/api/v1/feed/new-count?since="+top, "a1", nil, http.StatusOK, &count)
+	if count.Count != 0 {
+		t.Fatalf("got %d new posts, want 0", count.Count)
+	}
+
+	srv.createPost("b2", "second")
+	srv.createPost("b2", "third")
+
+	srv.doJSON("GET", "/api/v1/feed/new-count?since="+top, "a1", nil, http.StatusOK, &count)
+	if count.Count != 2 {
+		t.Fatalf("got %d new posts, want 2", count.Count)
+	}
+
+	feed = srv.getPage("/api/v1/feed?since="+top, "a1")
+	assertTexts(t, feed.Posts, "third", "second")
+
+	srv.doJSON("GET", "/api/v1/feed/new-count?since="+feed.PrevToken, "a1", nil, http.StatusOK, &count)
+	if count.Count != 0 {
+		t.Fatalf("got %d new posts, want 0", count.Count)
+	}
+
+	for _, query := range []string{"", "?since=unknown", "?since=bad%20token"} {
+		code, _ := srv.do("GET", "/api/v1/feed/new-count"+query, "a1", nil)
+		if code != http.StatusBadRequest {
+			t.Errorf("query %q: got status %d", query, code)
+		}
+	}
+}
+
+type sseEvent struct {
+	Id    string
+	Event string
+	Data  string
+}
+
+// openStream connects to the feed stream of user and sends parsed events to
+// the returned channel, which is closed when the stream ends.
+func (s *testServer) openStream(user string, last_event_id string) <-chan sseEvent {
+	s.t.Helper()
+
+	req, err := http.NewRequest("GET", s.URL+"/api/v1/feed/stream", nil)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	req.Header.Set("System-Design-User-Id", user)
+	if last_event_id != "" {
+		req.Header.Set("Last-Event-ID", last_event_id)
+	}
+
+	resp, err := s.Client().Do(req)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		s.t.Fatalf("stream: got status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	// cleanups run in reverse order, so the stream is gone before the server closes
+	s.t.Cleanup(func() { resp.Body.Close() })
+
+	events := make(chan sseEvent, 16)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+
+		var event sseEvent
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if event.Event != "" {
+					events <- event
+				}
+				event = sseEvent{}
+			case strings.HasPrefix(line, "id: "):
+				event.Id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				event.Event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				event.Data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+
+	return events
+}
+
+func nextEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	t.Helper()
+
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("stream closed")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event in time")
+	}
+	return sseEvent{}
+}
+
+func eventPost(t *testing.T, event sseEvent) storage.Post {
+	t.Helper()
+
+	if event.Event != "post" {
+		t.Fatalf("got %q event, want post", event.Event)
+	}
+	var post storage.Post
+	if err := json.Unmarshal([]byte(event.Data), &post); err != nil {
+		t.Fatal(err)
+	}
+	return post
+}
+
+func TestFeedStream(t *testing.T) {
+	srv := newTestServer(t)
+
+	srv.doJSON("POST", "/api/v1/users/b2/subscribe", "a1", nil, http.StatusOK, nil)
+	srv.createPost("b2", "seen")
+	top := srv.getPage("/api/v1/feed", "a1").PrevToken
+
+	// posted while the client was away
+	srv.createPost("b2", "missed 1")
+	srv.createPost("b2", "missed 2")
+
+	events := srv.openStream("a1", top)
+
+	if post := eventPost(t, nextEvent(t, events)); post.Text != "missed 1" {
+		t.Fatalf("got %q, want missed 1", post.Text)
+	}
+	event := nextEvent(t, events)
+	if post := eventPost(t, event); post.Text != "missed 2" {
+		t.Fatalf("got %q, want missed 2", post.Text)
+	}
+	if event.Id == "" {
+		t.Fatal("expected event id on the last replayed post")
+	}
+
+	srv.createPost("c3", "not subscribed")
+	srv.createPost("b2", "live")
+
+	event = nextEvent(t, events)
+	if post := eventPost(t, event); post.Text != "live" || post.AuthorId != "b2" {
+		t.Fatalf("unexpected post %+v", post)
+	}
+
+	// the event id resumes right after the live post
+	if count := srv.getPage("/api/v1/feed?since="+event.Id, "a1"); len(count.Posts) != 0 {
+		t.Fatalf("unexpected posts after the last event: %q", postTexts(count.Posts))
+	}
+}
+
+func TestFeedStreamResyncAndTimeout(t *testing.T) {
+	srv := newTestServer(t)
+	srv.handler.StreamTimeout = 100 * time.Millisecond
+
+	events := srv.openStream("a1", "unknown")
+	if event := nextEvent(t, events); event.Event != "resync" {
+		t.Fatalf("got %q event, want resync", event.Event)
+	}
+
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatal("unexpected event")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream was not closed after the timeout")
+	}
+
+	code, _ := srv.do("GET", "/api/v1/feed/stream", "", nil)
+	if code != http.StatusBadRequest {
+		t.Errorf("no user: got status %d", code)
+	}
+}
+
+func TestFeedStreamResyncAfterDrops(t *testing.T) {
+	srv := newTestServer(t)
+
+	events := srv.openStream("a1", "")
+
+	// far more than a subscriber buffers, so some are dropped on the way
+	post := storage.Post{Id: "p1", AuthorId: "b2", Text: strings.Repeat("flood ", 100)}
+	for i := 0; i < 20*pubsub.SubscriptionBuffer; i++ {
+		storage.PublishFeedEvent(context.Background(), srv.handler.PubSub, "a1", storage.FeedEvent{Token: strconv.Itoa(i), Post: post})
+	}
+
+	for {
+		event := nextEvent(t, events)
+		if event.Event == "resync" {
+			break
+		}
+		eventPost(t, event)
+	}
+}
+
+func (s *testServer) openWebSocket(user string) *websocket.Conn {
+	s.t.Helper()
+
+	header := http.Header{}
+	header.Set("System-Design-User-Id", user)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http")+"/api/v1/ws", header)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	s.t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func wsSend(t *testing.T, conn *websocket.Conn, request handlers.WSRequest) {
+	t.Helper()
+
+	if err := conn.WriteJSON(request); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func wsReceive(t *testing.T, conn *websocket.Conn, message_type string) handlers.WSMessage {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var message handlers.WSMessage
+	if err := conn.ReadJSON(&message); err != nil {
+		t.Fatal(err)
+	}
+	if message.Type != message_type {
+		t.Fatalf("got message %+v, want %s", message, message_type)
+	}
+	return message
+}
+
+func TestWebSocket(t *testing.T) {
+	srv := newTestServer(t)
+
+	srv.doJSON("POST", "/api/v1/users/b2/subscribe", "a1", nil, http.StatusOK, nil)
+	old := srv.createPost("b2", "on screen")
+
+	conn := srv.openWebSocket("a1")
+
+	wsSend(t, conn, handlers.WSRequest{Action: "subscribe", Topic: "feed"})
+	wsReceive(t, conn, "subscribed")
+	wsSend(t, conn, handlers.WSRequest{Action: "subscribe", Topic: "mentions"})
+	wsReceive(t, conn, "subscribed")
+	wsSend(t, conn, handlers.WSRequest{Action: "subscribe", Topic: "post", PostId: old.Id})
+	wsReceive(t, conn, "subscribed")
+
+	srv.createPost("b2", "new post")
+	message := wsReceive(t, conn, "post")
+	if message.Topic != "feed" || message.Post == nil || message.Post.Text != "new post" || message.Token == "" {
+		t.Fatalf("unexpected message %+v", message)
+	}
+
+	srv.createPost("c3", "hi @a1")
+	message = wsReceive(t, conn, "mention")
+	if message.Post == nil || message.Post.AuthorId != "c3" {
+		t.Fatalf("unexpected message %+v", message)
+	}
+
+	srv.doJSON("PATCH", "/api/v1/posts/"+old.Id, "b2", handlers.PostRequestData{Text: "edited"}, http.StatusOK, nil)
+	message = wsReceive(t, conn, "post.edited")
+	if message.PostId != old.Id || message.Post.Text != "edited" {
+		t.Fatalf("unexpected message %+v", message)
+	}
+
+	wsSend(t, conn, handlers.WSRequest{Action: "unsubscribe", Topic: "feed"})
+	wsReceive(t, conn, "unsubscribed")
+	srv.createPost("b2", "not delivered")
+
+	wsSend(t, conn, handlers.WSRequest{Action: "subscribe", Topic: "unknown"})
+	wsReceive(t, conn, "error")
+	wsSend(t, conn, handlers.WSRequest{Action: "subscribe", Topic: "post", PostId: "bad id"})
+	wsReceive(t, conn, "error")
+
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/api/v1/ws", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("no user: got %v, %v", resp, err)
+	}
+}
+
+// waitFor polls condition until it holds or a few seconds pass.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+type webhookReceiver struct {
+	*httptest.Server
+
+	mu sync.Mutex
+	// how many next requests fail with 500
+	failures int
+	payloads []webhooks.Payload
+	headers  []http.Header
+	bodies   [][]byte
+}
+
+func newWebhookReceiver(t *testing.T) *webhookReceiver {
+	receiver := &webhookReceiver{}
+	receiver.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		receiver.mu.Lock()
+		defer receiver.mu.Unlock()
+
+		if receiver.failures > 0 {
+			receiver.failures--
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		var payload webhooks.Payload
+		json.Unmarshal(body, &payload)
+		receiver.payloads = append(receiver.payloads, payload)
+		receiver.headers = append(receiver.headers, r.Header.Clone())
+		receiver.bodies = append(receiver.bodies, body)
+	}))
+	t.Cleanup(receiver.Close)
+
+	return receiver
+}
+
+func (r *webhookReceiver) failNext(failures int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures = failures
+}
+
+func (r *webhookReceiver) received() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.payloads)
+}
+
+func (s *testServer) deliveries(user string, hook storage.Webhook) []storage.WebhookDelivery {
+	s.t.Helper()
+
+	var answer storage.WebhookDeliveries
+	s.doJSON("GET", "/api/v1/webhooks/"+hook.Id+"/deliveries", user, nil, http.StatusOK, &answer)
+	return answer.Deliveries
+}
+
+func TestWebhooks(t *testing.T) {
+	srv := newTestServer(t)
+	receiver := newWebhookReceiver(t)
+
+	var hook storage.Webhook
+	srv.doJSON("POST", "/api/v1/webhooks", "a1", handlers.WebhookRequestData{
+		URL:    receiver.URL + "/hook",
+		Events: []string{storage.EventPostCreated, storage.EventSubscriberAdded},
+	}, http.StatusOK, &hook)
+	if hook.Id == "" || hook.Secret == "" || hook.User != "a1" || hook.Disabled {
+		t.Fatalf("unexpected webhook %+v", hook)
+	}
+
+	post := srv.createPost("a1", "hello hooks")
+	srv.createPost("b2", "not a1")
+	srv.doJSON("POST", "/api/v1/users/a1/subscribe", "b2", nil, http.StatusOK, nil)
+	srv.doJSON("PATCH", "/api/v1/posts/"+post.Id, "a1", handlers.PostRequestData{Text: "edited"}, http.StatusOK, nil)
+
+	waitFor(t, "deliveries", func() bool { return receiver.received() == 2 })
+
+	receiver.mu.Lock()
+	events := map[string]webhooks.Payload{}
+	for i, payload := range receiver.payloads {
+		events[payload.Event] = payload
+
+		header := receiver.headers[i]
+		if header.Get(webhooks.SignatureHeader) != webhooks.Sign(hook.Secret, receiver.bodies[i]) {
+			t.Errorf("bad signature %q", header.Get(webhooks.SignatureHeader))
+		}
+		if header.Get(webhooks.EventHeader) != payload.Event || header.Get(webhooks.DeliveryHeader) != payload.Id {
+			t.Errorf("bad headers %v for %+v", header, payload)
+		}
+	}
+	receiver.mu.Unlock()
+
+	created, ok := events[storage.EventPostCreated]
+	if data, _ := created.Data.(map[string]interface{}); !ok || data["id"] != post.Id {
+		t.Fatalf("unexpected post.created payload %+v", created)
+	}
+	added, ok := events[storage.EventSubscriberAdded]
+	if data, _ := added.Data.(map[string]interface{}); !ok || data["user"] != "b2" {
+		t.Fatalf("unexpected subscriber.added payload %+v", added)
+	}
+
+	waitFor(t, "delivery log", func() bool {
+		deliveries := srv.deliveries("a1", hook)
+		return len(deliveries) == 2 && deliveries[0].Status == storage.DeliverySucceeded && deliveries[1].Status == storage.DeliverySucceeded
+	})
+
+	// the secret is not shown again
+	var list storage.Webhooks
+	srv.doJSON("GET", "/api/v1/webhooks", "a1", nil, http.StatusOK, &list)
+	if len(list.Webhooks) != 1 || list.Webhooks[0].Id != hook.Id || list.Webhooks[0].Secret != "" {
+		t.Fatalf("unexpected webhooks %+v", list.Webhooks)
+	}
+
+	code, _ := srv.do("GET", "/api/v1/webhooks/"+hook.Id+"/deliveries", "b2", nil)
+	if code != http.StatusForbidden {
+		t.Errorf("other user: got status %d", code)
+	}
+
+	srv.doJSON("DELETE", "/api/v1/webhooks/"+hook.Id, "a1", nil, http.StatusOK, nil)
+	code, _ = srv.do("GET", "/api/v1/webhooks/"+hook.Id+"/deliveries", "a1", nil)
+	if code != http.StatusNotFound {
+		t.Errorf("deleted webhook: got status %d", code)
+	}
+}
+
+func TestWebhookRetriesAndDisabling(t *testing.T) {
+	srv := newTestServer(t)
+	receiver := newWebhookReceiver(t)
+
+	var hook storage.Webhook
+	srv.doJSON("POST", "/api/v1/webhooks", "a1", handlers.WebhookRequestData{
+		URL:    receiver.URL,
+		Events: []string{storage.EventPostCreated},
+	}, http.StatusOK, &hook)
+
+	// retried with backoff until the receiver is back
+	receiver.failNext(1)
+	srv.createPost("a1", "first")
+	waitFor(t, "the delivery", func() bool { return receiver.received() == 1 })
+	waitFor(t, "delivery log", func() bool {
+		delivery := srv.deliveries("a1", hook)[0]
+		return delivery.Status == storage.DeliverySucceeded && delivery.Attempts == 2 && delivery.LastStatusCode == http.StatusOK
+	})
+
+	// gives up after MaxAttempts and disables the webhook after DisableAfter such deliveries
+	receiver.failNext(1000)
+	srv.createPost("a1", "second")
+	srv.createPost("a1", "third")
+	waitFor(t, "failed deliveries", func() bool {
+		deliveries := srv.deliveries("a1", hook)
+		return deliveries[0].Status == storage.DeliveryFailed && deliveries[1].Status == storage.DeliveryFailed
+	})
+
+	var list storage.Webhooks
+	waitFor(t, "disabling", func() bool {
+		srv.doJSON("GET", "/api/v1/webhooks", "a1", nil, http.StatusOK, &list)
+		return list.Webhooks[0].Disabled
+	})
+	failed := srv.deliveries("a1", hook)[0]
+	if failed.Attempts != 3 || failed.LastStatusCode != http.StatusInternalServerError || failed.LastError == "" {
+		t.Fatalf("unexpected delivery %+v", failed)
+	}
+
+	// disabled webhooks get nothing
+	receiver.failNext(0)
+	srv.createPost("a1", "fourth")
+	if deliveries := srv.deliveries("a1", hook); len(deliveries) != 3 {
+		t.Fatalf("got %d deliveries, want 3", len(deliveries))
+	}
+
+	var enabled storage.Webhook
+	srv.doJSON("POST", "/api/v1/webhooks/"+hook.Id+"/enable", "a1", nil, http.StatusOK, &enabled)
+	if enabled.Disabled || enabled.Failures != 0 {
+		t.Fatalf("unexpected webhook %+v", enabled)
+	}
+	srv.createPost("a1", "fifth")
+	waitFor(t, "the delivery", func() bool { return receiver.received() == 2 })
+}
+
+func TestWebhookValidation(t *testing.T) {
+	srv := newTestServer(t)
+
+	for _, data := range []handlers.WebhookRequestData{
+		{URL: "ftp://example.com", Events: []string{storage.EventPostCreated}},
+		{URL: "/relative", Events: []string{storage.EventPostCreated}},
+		{URL: "http://example.com"},
+		{URL: "http://example.com", Events: []string{"post.liked"}},
+	} {
+		code, _ := srv.do("POST", "/api/v1/webhooks", "a1", data)
+		if code != http.StatusBadRequest {
+			t.Errorf("%+v: got status %d", data, code)
+		}
+	}
+
+	code, _ := srv.do("POST", "/api/v1/webhooks", "", handlers.WebhookRequestData{URL: "http://example.com", Events: []string{storage.EventPostCreated}})
+	if code != http.StatusUnauthorized {
+		t.Errorf("no user: got status %d", code)
+	}
+}
+
+func TestWebhookPrivateAddresses(t *testing.T) {
+	srv := newTestServer(t, func(h *handlers.HTTPHandler) {
+		h.Webhooks.AllowPrivate = false
+	})
+
+	for _, hook_url := range []string{
+		"http://127.0.0.1/hook",
+		"http://localhost:27017/",
+		"http://169.254.169.254/latest/meta-data",
+		"http://10.0.0.5/hook",
+		"http://[::1]/hook",
+		"http://0.0.0.0/hook",
+	} {
+		code, _ := srv.do("POST", "/api/v1/webhooks", "a1", handlers.WebhookRequestData{URL: hook_url, Events: []string{storage.EventPostCreated}})
+		if code != http.StatusBadRequest {
+			t.Errorf("%v: got status %d", hook_url, code)
+		}
+	}
+
+	// the dialer stops hosts that resolve to a private address later
+	receiver := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+	defer receiver.Close()
+	_, err := srv.handler.Webhooks.Client.Post(receiver.URL, "application/json", strings.NewReader("{}"))
+	if !errors.Is(err, webhooks.ErrPrivateAddress) {
+		t.Errorf("sending to loopback: got %v", err)
+	}
+}
+
+func (s *testServer) notifications(path string, user string) storage.Notifications {
+	s.t.Helper()
+
+	var page storage.Notifications
+	s.doJSON("GET", path, user, nil, http.StatusOK, &page)
+	return page
+}
+
+func (s *testServer) unreadNotifications(user string) int64 {
+	s.t.Helper()
+
+	var count storage.UnreadNotificationsCount
+	s.doJSON("GET", "/api/v1/notifications/unread-count", user, nil, http.StatusOK, &count)
+	return count.Count
+}
+
+func TestNotifications(t *testing.T) {
+	srv := newTestServer(t)
+
+	srv.doJSON("POST", "/api/v1/users/a1/subscribe", "b2", nil, http.StatusOK, nil)
+	post := srv.createPost("a1", "hello")
+
+	var reply storage.Post
+	srv.doJSON("POST", "/api/v1/posts", "c3", handlers.PostRequestData{Text: "hi @a1 and @b2", ReplyTo: post.Id}, http.StatusOK, &reply)
+	if reply.ReplyTo != post.Id {
+		t.Fatalf("reply has replyTo %q", reply.ReplyTo)
+	}
+
+	// own posts and self mentions do not notify
+	srv.createPost("a1", "me @a1")
+
+	page := srv.notifications("/api/v1/notifications", "a1")
+	if len(page.Notifications) != 2 {
+		t.Fatalf("unexpected notifications %+v", page.Notifications)
+	}
+	// the reply is not notified again as a mention
+	newest, oldest := page.Notifications[0], page.Notifications[1]
+	if newest.Type != storage.NotificationReply || newest.Actor != "c3" || newest.PostId != reply.Id {
+		t.Errorf("unexpected reply notification %+v", newest)
+	}
+	if oldest.Type != storage.NotificationSubscriber || oldest.Actor != "b2" || oldest.PostId != "" {
+		t.Errorf("unexpected subscriber notification %+v", oldest)
+	}
+
+	mentions := srv.notifications("/api/v1/notifications", "b2")
+	if len(mentions.Notifications) != 1 || mentions.Notifications[0].Type != storage.NotificationMention {
+		t.Fatalf("unexpected notifications of b2 %+v", mentions.Notifications)
+	}
+
+	// pagination
+	first := srv.notifications("/api/v1/notifications?size=1", "a1")
+	if len(first.Notifications) != 1 || first.Notifications[0].Id != newest.Id || first.Token != oldest.Id {
+		t.Fatalf("unexpected first page %+v", first)
+	}
+	second := srv.notifications("/api/v1/notifications?size=1&page="+first.Token, "a1")
+	if len(second.Notifications) != 1 || second.Notifications[0].Id != oldest.Id || second.Token != "" {
+		t.Fatalf("unexpected second page %+v", second)
+	}
+
+	// read cursor
+	if count := srv.unreadNotifications("a1"); count != 2 {
+		t.Fatalf("got %d unread, want 2", count)
+	}
+	var count storage.UnreadNotificationsCount
+	srv.doJSON("POST", "/api/v1/notifications/read", "a1", handlers.ReadNotificationsRequestData{UpTo: oldest.Id}, http.StatusOK, &count)
+	if count.Count != 1 {
+		t.Fatalf("got %d unread after reading the oldest, want 1", count.Count)
+	}
+	page = srv.notifications("/api/v1/notifications", "a1")
+	if page.Notifications[0].Read || !page.Notifications[1].Read {
+		t.Errorf("unexpected read flags %+v", page.Notifications)
+	}
+
+	srv.doJSON("POST", "/api/v1/notifications/read", "a1", nil, http.StatusOK, &count)
+	if count.Count != 0 {
+		t.Fatalf("got %d unread after reading all, want 0", count.Count)
+	}
+	// the cursor does not go back
+	srv.doJSON("POST", "/api/v1/notifications/read", "a1", handlers.ReadNotificationsRequestData{UpTo: oldest.Id}, http.StatusOK, &count)
+	if count.Count != 0 {
+		t.Fatalf("got %d unread after reading an old one, want 0", count.Count)
+	}
+
+	code, _ := srv.do("POST", "/api/v1/notifications/read", "a1", handlers.ReadNotificationsRequestData{UpTo: mentions.Notifications[0].Id})
+	if code != http.StatusNotFound {
+		t.Errorf("reading a notification of another user: got status %d", code)
+	}
+	code, _ = srv.do("GET", "/api/v1/notifications", "", nil)
+	if code != http.StatusUnauthorized {
+		t.Errorf("no user: got status %d", code)
+	}
+	code, _ = srv.do("POST", "/api/v1/posts", "a1", handlers.PostRequestData{Text: "?", ReplyTo: "missing"})
+	if code != http.StatusBadRequest {
+		t.Errorf("reply to a missing post: got status %d", code)
+	}
+}
+
+func TestBlock(t *testing.T) {
+	srv := newTestServer(t)
+
+	srv.createPost("a1", "a1 post")
+	srv.createPost("b2", "b2 post")
+	srv.doJSON("POST", "/api/v1/users/b2/subscribe", "a1", nil, http.StatusOK, nil)
+	srv.doJSON("POST", "/api/v1/users/a1/subscribe", "b2", nil, http.StatusOK, nil)
+
+	srv.doJSON("POST", "/api/v1/users/b2/block", "a1", nil, http.StatusOK, nil)
+	// blocking is idempotent
+	srv.doJSON("POST", "/api/v1/users/b2/block", "a1", nil, http.StatusOK, nil)
+
+	// subscriptions in both directions are gone with their feed entries
+	for _, user := range []string{"a1", "b2"} {
+		var subscriptions storage.Subscriptions
+		srv.doJSON("GET", "/api/v1/subscriptions", user, nil, http.StatusOK, &subscriptions)
+		if len(subscriptions.Users) != 0 {
+			t.Errorf("%s still has subscriptions %v", user, subscriptions.Users)
+		}
+		assertTexts(t, srv.getPage("/api/v1/feed", user).Posts)
+	}
+
+	// neither side can subscribe again
+	code, _ := srv.do("POST", "/api/v1/users/a1/subscribe", "b2", nil)
+	if code != http.StatusForbidden {
+		t.Errorf("blocked user subscribes: got status %d", code)
+	}
+	code, _ = srv.do("POST", "/api/v1/users/b2/subscribe", "a1", nil)
+	if code != http.StatusForbidden {
+		t.Errorf("blocking user subscribes: got status %d", code)
+	}
+
+	srv.doJSON("DELETE", "/api/v1/users/b2/block", "a1", nil, http.StatusOK, nil)
+	srv.doJSON("POST", "/api/v1/users/a1/subscribe", "b2", nil, http.StatusOK, nil)
+
+	code, _ = srv.do("POST", "/api/v1/users/a1/block", "a1", nil)
+	if code != http.StatusBadRequest {
+		t.Errorf("self block: got status %d", code)
+	}
+	code, _ = srv.do("POST", "/api/v1/users/b2/block", "", nil)
+	if code != http.StatusUnauthorized {
+		t.Errorf("no user: got status %d", code)
+	}
+}
+
+func TestMute(t *testing.T) {
+	srv := newTestServer(t)
+
+	srv.doJSON("POST", "/api/v1/users/b2/subscribe", "a1", nil, http.StatusOK, nil)
+	srv.doJSON("POST", "/api/v1/users/c3/subscribe", "a1", nil, http.StatusOK, nil)
+	srv.createPost("b2", "b2 first")
+	first := srv.createPost("c3", "c3 first")
+
+	srv.doJSON("POST", "/api/v1/users/b2/mute", "a1", nil, http.StatusOK, nil)
+	srv.createPost("b2", "b2 second")
+	srv.createPost("c3", "c3 second")
+
+	assertTexts(t, srv.getPage("/api/v1/feed", "a1").Posts, "c3 second", "c3 first")
+
+	page := srv.getPage("/api/v1/feed?size=1", "a1")
+	assertTexts(t, page.Posts, "c3 second")
+	assertTexts(t, srv.getPage("/api/v1/feed?size=1&page="+page.Token, "a1").Posts, "c3 first")
+
+	assertTexts(t, srv.getPage("/api/v1/feed?since="+first.Id, "a1").Posts, "c3 second")
+
+	var count handlers.NewPostsCount
+	srv.doJSON("GET", "/api/v1/feed/new-count?since="+first.Id, "a1", nil, http.StatusOK, &count)
+	if count.Count != 1 {
+		t.Errorf("got %d new posts, want 1", count.Count)
+	}
+
+	// the subscription stays and the posts come back after unmuting
+	srv.doJSON("DELETE", "/api/v1/users/b2/mute", "a1", nil, http.StatusOK, nil)
+	assertTexts(t, srv.getPage("/api/v1/feed", "a1").Posts, "c3 second", "b2 second", "c3 first", "b2 first")
+}
+
+// failingRelations fails like a storage that lost its database.
+type failingRelations struct{}
+
+func (failingRelations) Block(ctx context.Context, user string, target string) error {
+	return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+}
+
+func (failingRelations) Unblock(ctx context.Context, user string, target string) error {
+	return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+}
+
+func (failingRelations) Mute(ctx context.Context, user string, target string) error {
+	return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+}
+
+func (failingRelations) Unmute(ctx context.Context, user string, target string) error {
+	return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+}
+
+func TestRelationStorageError(t *testing.T) {
+	srv := newTestServer(t, func(h *handlers.HTTPHandler) {
+		h.Relations = failingRelations{}
+	})
+
+	for _, path := range []string{"/api/v1/users/b2/block", "/api/v1/users/b2/mute"} {
+		for _, method := range []string{"POST", "DELETE"} {
+			code, _ := srv.do(method, path, "a1", nil)
+			if code != http.StatusInternalServerError {
+				t.Errorf("%s %s: got status %d", method, path, code)
+			}
+		}
+	}
+}
+
+func TestPrivateAccount(t *testing.T) {
+	srv := newTestServer(t)
+
+	post := srv.createPost("a1", "private post")
+	srv.doJSON("PUT", "/api/v1/settings", "a1", storage.AccountSettings{Private: true}, http.StatusOK, nil)
+
+	var settings storage.AccountSettings
+	srv.doJSON("GET", "/api/v1/settings", "a1", nil, http.StatusOK, &settings)
+	if !settings.Private {
+		t.Fatalf("settings were not saved")
+	}
+
+	// only the owner reads the post line
+	assertTexts(t, srv.getPage("/api/v1/users/a1/posts", "a1").Posts, "private post")
+	for _, user := range []string{"b2", ""} {
+		code, _ := srv.do("GET", "/api/v1/users/a1/posts", user, nil)
+		if code != http.StatusForbidden {
+			t.Errorf("post line for %q: got status %d", user, code)
+		}
+		// nor the public posts one by one
+		code, _ = srv.do("GET", "/api/v1/posts/"+post.Id, user, nil)
+		if code != http.StatusNotFound {
+			t.Errorf("post for %q: got status %d", user, code)
+		}
+		var batch handlers.BatchGetResponse
+		srv.doJSON("POST", "/api/v1/posts:batchGet", user, handlers.BatchGetRequestData{Ids: []string{post.Id}}, http.StatusOK, &batch)
+		if len(batch.Posts) != 0 {
+			t.Errorf("batch for %q: got %+v", user, batch.Posts)
+		}
+	}
+
+	// subscribing creates a follow request
+	var answer handlers.SubscribeResponse
+	srv.doJSON("POST", "/api/v1/users/a1/subscribe", "b2", nil, http.StatusAccepted, &answer)
+	if answer.Status != storage.SubscriptionPending {
+		t.Fatalf("got subscription status %q", answer.Status)
+	}
+	code, _ := srv.do("POST", "/api/v1/users/a1/subscribe", "b2", nil)
+	if code != http.StatusBadRequest {
+		t.Errorf("repeated follow request: got status %d", code)
+	}
+	srv.doJSON("POST", "/api/v1/users/a1/subscribe", "c3", nil, http.StatusAccepted, nil)
+
+	var subscribers storage.Subscribers
+	srv.doJSON("GET", "/api/v1/subscribers", "a1", nil, http.StatusOK, &subscribers)
+	if len(subscribers.Users) != 0 {
+		t.Fatalf("pending requests became subscribers %v", subscribers.Users)
+	}
+
+	var requests storage.FollowRequests
+	srv.doJSON("GET", "/api/v1/follow-requests", "a1", nil, http.StatusOK, &requests)
+	if len(requests.Requests) != 2 || requests.Requests[0].User != "b2" || requests.Requests[1].User != "c3" {
+		t.Fatalf("unexpected follow requests %+v", requests.Requests)
+	}
+
+	notifications := srv.notifications("/api/v1/notifications", "a1")
+	if len(notifications.Notifications) != 2 || notifications.Notifications[0].Type != storage.NotificationFollowRequest {
+		t.Fatalf("unexpected notifications %+v", notifications.Notifications)
+	}
+
+	// approval backfills the feed and opens the post line
+	srv.doJSON("POST", "/api/v1/follow-requests/b2/approve", "a1", nil, http.StatusOK, nil)
+	assertTexts(t, srv.getPage("/api/v1/feed", "b2").Posts, "private post")
+	notifications = srv.notifications("/api/v1/notifications", "a1")
+	if len(notifications.Notifications) != 3 || notifications.Notifications[0].Type != storage.NotificationSubscriber || notifications.Notifications[0].Actor != "b2" {
+		t.Errorf("no subscriber notification after approval %+v", notifications.Notifications)
+	}
+	assertTexts(t, srv.getPage("/api/v1/users/a1/posts", "b2").Posts, "private post")
+	srv.doJSON("GET", "/api/v1/posts/"+post.Id, "b2", nil, http.StatusOK, nil)
+
+	srv.doJSON("POST", "/api/v1/follow-requests/c3/reject", "a1", nil, http.StatusOK, nil)
+	code, _ = srv.do("GET", "/api/v1/users/a1/posts", "c3", nil)
+	if code != http.StatusForbidden {
+		t.Errorf("rejected user reads the post line: got status %d", code)
+	}
+
+	srv.doJSON("GET", "/api/v1/follow-requests", "a1", nil, http.StatusOK, &requests)
+	if len(requests.Requests) != 0 {
+		t.Fatalf("requests left %+v", requests.Requests)
+	}
+	code, _ = srv.do("POST", "/api/v1/follow-requests/c3/approve", "a1", nil)
+	if code != http.StatusNotFound {
+		t.Errorf("approving a missing request: got status %d", code)
+	}
+}
+
+func TestPostVisibility(t *testing.T) {
+	srv := newTestServer(t)
+
+	// b2 follows a1, c3 does not, d4 is mentioned
+	srv.doJSON("POST", "/api/v1/users/a1/subscribe", "b2", nil, http.StatusOK, nil)
+	srv.doJSON("POST", "/api/v1/users/a1/subscribe", "e5", nil, http.StatusOK, nil)
+
+	var followers, mentioned storage.Post
+	public := srv.createPost("a1", "public")
+	srv.doJSON("POST", "/api/v1/posts", "a1", handlers.PostRequestData{Text: "followers", Visibility: storage.VisibilityFollowers}, http.StatusOK, &followers)
+	srv.doJSON("POST", "/api/v1/posts", "a1", handlers.PostRequestData{Text: "for @d4", Visibility: storage.VisibilityMentioned}, http.StatusOK, &mentioned)
+	if public.Visibility != storage.VisibilityPublic || followers.Visibility != storage.VisibilityFollowers {
+		t.Fatalf("unexpected visibility %q, %q", public.Visibility, followers.Visibility)
+	}
+
+	cases := []struct {
+		viewer string
+		want   []string
+	}{
+		{"a1", []string{"for @d4", "followers", "public"}},
+		{"b2", []string{"followers", "public"}},
+		{"c3", []string{"public"}},
+		{"d4", []string{"for @d4", "public"}},
+		{"", []string{"public"}},
+	}
+	for _, c := range cases {
+		assertTexts(t, srv.getPage("/api/v1/users/a1/posts", c.viewer).Posts, c.want...)
+
+		for _, post := range []storage.Post{public, followers, mentioned} {
+			code, _ := srv.do("GET", "/api/v1/posts/"+post.Id, c.viewer, nil)
+			visible := false
+			for _, text := range c.want {
+				visible = visible || text == post.Text
+			}
+			if visible && code != http.StatusOK || !visible && code != http.StatusNotFound {
+				t.Errorf("%q gets %q: got status %d", c.viewer, post.Text, code)
+			}
+		}
+	}
+
+	// pagination skips hidden posts
+	page := srv.getPage("/api/v1/users/a1/posts?size=1", "c3")
+	assertTexts(t, page.Posts, "public")
+	if page.Token != "" {
+		t.Errorf("unexpected next page %q", page.Token)
+	}
+
+	assertTexts(t, srv.getPage("/api/v1/feed", "b2").Posts, "followers", "public")
+
+	// changes reach the materialized feeds
+	srv.doJSON("PUT", "/api/v1/posts/"+followers.Id+"/visibility", "a1", handlers.VisibilityRequestData{Visibility: storage.VisibilityMentioned}, http.StatusOK, nil)
+	assertTexts(t, srv.getPage("/api/v1/feed", "b2").Posts, "public")
+	srv.doJSON("PUT", "/api/v1/posts/"+mentioned.Id+"/visibility", "a1", handlers.VisibilityRequestData{Visibility: storage.VisibilityFollowers}, http.StatusOK, nil)
+	assertTexts(t, srv.getPage("/api/v1/feed", "b2").Posts, "for @d4", "public")
+
+	// so do edits of mentions
+	srv.doJSON("PUT", "/api/v1/posts/"+mentioned.Id+"/visibility", "a1", handlers.VisibilityRequestData{Visibility: storage.VisibilityMentioned}, http.StatusOK, nil)
+	srv.doJSON("PATCH", "/api/v1/posts/"+mentioned.Id, "a1", handlers.PostRequestData{Text: "for @b2"}, http.StatusOK, nil)
+	assertTexts(t, srv.getPage("/api/v1/feed", "b2").Posts, "for @b2", "public")
+	assertTexts(t, srv.getPage("/api/v1/feed", "e5").Posts, "public")
+
+	// new subscribers get only what they can see
+	srv.doJSON("POST", "/api/v1/users/a1/subscribe", "c3", nil, http.StatusOK, nil)
+	assertTexts(t, srv.getPage("/api/v1/feed", "c3").Posts, "public")
+
+	code, _ := srv.do("PUT", "/api/v1/posts/"+public.Id+"/visibility", "b2", handlers.VisibilityRequestData{Visibility: storage.VisibilityMentioned})
+	if code != http.StatusForbidden {
+		t.Errorf("changing a post of another user: got status %d", code)
+	}
+	code, _ = srv.do("PUT", "/api/v1/posts/"+public.Id+"/visibility", "a1", handlers.VisibilityRequestData{Visibility: "secret"})
+	if code != http.StatusBadRequest {
+		t.Errorf("unknown visibility: got status %d", code)
+	}
+	code, _ = srv.do("POST", "/api/v1/posts", "c3", handlers.PostRequestData{Text: "reply", ReplyTo: followers.Id})
+	if code != http.StatusBadRequest {
+		t.Errorf("reply to a hidden post: got status %d", code)
+	}
+}
+
+func TestPinnedPosts(t *testing.T) {
+	srv := newTestServer(t)
+
+	var posts []storage.Post
+	for i := 1; i <= 6; i++ {
+		posts = append(posts, srv.createPost("a1", "post "+strconv.Itoa(i)))
+	}
+
+	srv.doJSON("POST", "/api/v1/posts/"+posts[1].Id+"/pin", "a1", nil, http.StatusOK, nil)
+	srv.doJSON("POST", "/api/v1/posts/"+posts[4].Id+"/pin", "a1", nil, http.StatusOK, nil)
+	// pinning again changes nothing
+	srv.doJSON("POST", "/api/v1/posts/"+posts[1].Id+"/pin", "a1", nil, http.StatusOK, nil)
+
+	first := srv.getPage("/api/v1/users/a1/posts?size=2", "")
+	assertTexts(t, first.Pinned, "post 5", "post 2")
+	assertTexts(t, first.Posts, "post 6", "post 4")
+
+	second := srv.getPage("/api/v1/users/a1/posts?size=2&page="+first.Token, "")
+	if len(second.Pinned) != 0 {
+		t.Errorf("pinned posts on the second page %v", postTexts(second.Pinned))
+	}
+	assertTexts(t, second.Posts, "post 3", "post 1")
+	if second.Token != "" {
+		t.Errorf("unexpected next page %q", second.Token)
+	}
+
+	// the limit
+	srv.doJSON("POST", "/api/v1/posts/"+posts[0].Id+"/pin", "a1", nil, http.StatusOK, nil)
+	code, _ := srv.do("POST", "/api/v1/posts/"+posts[2].Id+"/pin", "a1", nil)
+	if code != http.StatusConflict {
+		t.Errorf("pinning over the limit: got status %d", code)
+	}
+
+	srv.doJSON("DELETE", "/api/v1/posts/"+posts[4].Id+"/pin", "a1", nil, http.StatusOK, nil)
+	page := srv.getPage("/api/v1/users/a1/posts", "")
+	assertTexts(t, page.Pinned, "post 1", "post 2")
+	assertTexts(t, page.Posts, "post 6", "post 5", "post 4", "post 3")
+
+	code, _ = srv.do("POST", "/api/v1/posts/"+posts[2].Id+"/pin", "b2", nil)
+	if code != http.StatusForbidden {
+		t.Errorf("pinning a post of another user: got status %d", code)
+	}
+	code, _ = srv.do("POST", "/api/v1/posts/missing/pin", "a1", nil)
+	if code != http.StatusNotFound {
+		t.Errorf("pinning a missing post: got status %d", code)
+	}
+}
+
+func TestScheduledPosts(t *testing.T) {
+	srv := newTestServer(t)
+
+	srv.doJSON("POST", "/api/v1/users/a1/subscribe", "b2", nil, http.StatusOK, nil)
+
+	schedule := func(text string, in time.Duration) storage.ScheduledPost {
+		t.Helper()
+
+		var scheduled storage.ScheduledPost
+		data := handlers.PostRequestData{Text: text, PublishAt: time.Now().Add(in).Format(time.RFC3339Nano)}
+		srv.doJSON("POST", "/api/v1/posts", "a1", data, http.StatusAccepted, &scheduled)
+		return scheduled
+	}
+
+	soon := schedule("soon", 300*time.Millisecond)
+	later := schedule("later", time.Hour)
+	cancelled := schedule("cancelled", time.Hour)
+
+	// invisible until published
+	code, _ := srv.do("GET", "/api/v1/posts/"+soon.Post.Id, "a1", nil)
+	if code != http.StatusNotFound {
+		t.Errorf("scheduled post: got status %d", code)
+	}
+	assertTexts(t, srv.getPage("/api/v1/users/a1/posts", "a1").Posts)
+
+	var list storage.ScheduledPosts
+	srv.doJSON("GET", "/api/v1/scheduled-posts", "a1", nil, http.StatusOK, &list)
+	if len(list.Posts) != 3 || list.Posts[0].Post.Text != "soon" {
+		t.Fatalf("unexpected scheduled posts %+v", list.Posts)
+	}
+	srv.doJSON("GET", "/api/v1/scheduled-posts", "b2", nil, http.StatusOK, &list)
+	if len(list.Posts) != 0 {
+		t.Fatalf("scheduled posts of another user %+v", list.Posts)
+	}
+
+	srv.doJSON("DELETE", "/api/v1/scheduled-posts/"+cancelled.Post.Id, "a1", nil, http.StatusOK, nil)
+
+	// moving a post to an earlier time
+	data := handlers.PostRequestData{Text: "later, edited", PublishAt: time.Now().Add(200 * time.Millisecond).Format(time.RFC3339Nano)}
+	srv.doJSON("PUT", "/api/v1/scheduled-posts/"+later.Post.Id, "a1", data, http.StatusOK, nil)
+
+	waitFor(t, "publishing", func() bool {
+		return len(srv.getPage("/api/v1/feed", "b2").Posts) == 2
+	})
+	feed := srv.getPage("/api/v1/feed", "b2").Posts
+	if feed[0].Id != soon.Post.Id && feed[1].Id != soon.Post.Id {
+		t.Errorf("unexpected feed %v", postTexts(feed))
+	}
+	srv.doJSON("GET", "/api/v1/posts/"+later.Post.Id, "c3", nil, http.StatusOK, nil)
+
+	srv.doJSON("GET", "/api/v1/scheduled-posts", "a1", nil, http.StatusOK, &list)
+	if len(list.Posts) != 0 {
+		t.Fatalf("published posts are still scheduled %+v", list.Posts)
+	}
+
+	code, _ = srv.do("POST", "/api/v1/posts", "a1", handlers.PostRequestData{Text: "past", PublishAt: "2000-01-01T00:00:00Z"})
+	if code != http.StatusBadRequest {
+		t.Errorf("publishAt in the past: got status %d", code)
+	}
+	code, _ = srv.do("POST", "/api/v1/posts", "a1", handlers.PostRequestData{Text: "bad", PublishAt: "tomorrow"})
+	if code != http.StatusBadRequest {
+		t.Errorf("bad publishAt: got status %d", code)
+	}
+	other := schedule("other", time.Hour)
+	code, _ = srv.do("DELETE", "/api/v1/scheduled-posts/"+other.Post.Id, "b2", nil)
+	if code != http.StatusForbidden {
+		t.Errorf("cancelling a post of another user: got status %d", code)
+	}
+	code, _ = srv.do("DELETE", "/api/v1/scheduled-posts/"+cancelled.Post.Id, "a1", nil)
+	if code != http.StatusNotFound {
+		t.Errorf("cancelling twice: got status %d", code)
+	}
+}
+
+func TestDrafts(t *testing.T) {
+	srv := newTestServer(t)
+
+	srv.doJSON("POST", "/api/v1/users/a1/subscribe", "b2", nil, http.StatusOK, nil)
+
+	var first, second storage.Draft
+	srv.doJSON("POST", "/api/v1/drafts", "a1", handlers.DraftRequestData{Text: "first"}, http.StatusOK, &first)
+	srv.doJSON("POST", "/api/v1/drafts", "a1", handlers.DraftRequestData{Text: "second"}, http.StatusOK, &second)
+
+	srv.doJSON("PUT", "/api/v1/drafts/"+first.Id, "a1", handlers.DraftRequestData{Text: "first, edited"}, http.StatusOK, nil)
+
+	var drafts storage.Drafts
+	srv.doJSON("GET", "/api/v1/drafts", "a1", nil, http.StatusOK, &drafts)
+	if len(drafts.Drafts) != 2 || drafts.Drafts[0].Text != "first, edited" {
+		t.Fatalf("unexpected drafts %+v", drafts.Drafts)
+	}
+	srv.doJSON("GET", "/api/v1/drafts", "b2", nil, http.StatusOK, &drafts)
+	if len(drafts.Drafts) != 0 {
+		t.Fatalf("drafts of another user %+v", drafts.Drafts)
+	}
+	code, _ := srv.do("GET", "/api/v1/drafts/"+first.Id, "b2", nil)
+	if code != http.StatusForbidden {
+		t.Errorf("draft of another user: got status %d", code)
+	}
+	code, _ = srv.do("POST", "/api/v1/drafts/"+first.Id+"/publish", "b2", nil)
+	if code != http.StatusForbidden {
+		t.Errorf("publishing a draft of another user: got status %d", code)
+	}
+
+	// drafts stay out of lines and feeds
+	assertTexts(t, srv.getPage("/api/v1/users/a1/posts", "a1").Posts)
+	assertTexts(t, srv.getPage("/api/v1/feed", "b2").Posts)
+	code, _ = srv.do("GET", "/api/v1/posts/"+first.Id, "a1", nil)
+	if code != http.StatusNotFound {
+		t.Errorf("draft as a post: got status %d", code)
+	}
+
+	var post storage.Post
+	srv.doJSON("POST", "/api/v1/drafts/"+first.Id+"/publish", "a1", nil, http.StatusOK, &post)
+	if post.Text != "first, edited" || post.AuthorId != "a1" {
+		t.Fatalf("unexpected post %+v", post)
+	}
+	assertTexts(t, srv.getPage("/api/v1/users/a1/posts", "a1").Posts, "first, edited")
+	assertTexts(t, srv.getPage("/api/v1/feed", "b2").Posts, "first, edited")
+
+	code, _ = srv.do("POST", "/api/v1/drafts/"+first.Id+"/publish", "a1", nil)
+	if code != http.StatusNotFound {
+		t.Errorf("publishing twice: got status %d", code)
+	}
+
+	srv.doJSON("DELETE", "/api/v1/drafts/"+second.Id, "a1", nil, http.StatusOK, nil)
+	srv.doJSON("GET", "/api/v1/drafts", "a1", nil, http.StatusOK, &drafts)
+	if len(drafts.Drafts) != 0 {
+		t.Fatalf("unexpected drafts %+v", drafts.Drafts)
+	}
+}
+
+func TestPolls(t *testing.T) {
+	srv := newTestServer(t)
+
+	srv.doJSON("POST", "/api/v1/users/a1/subscribe", "b2", nil, http.StatusOK, nil)
+
+	closes_at := time.Now().Add(time.Hour).Format(time.RFC3339)
+	data := handlers.PostRequestData{Text: "tea or coffee?", Poll: &handlers.PollRequestData{Options: []string{"tea", "coffee"}, ClosesAt: closes_at}}
+	var post storage.Post
+	srv.doJSON("POST", "/api/v1/posts", "a1", data, http.StatusOK, &post)
+	if post.Poll == nil || len(post.Poll.Options) != 2 {
+		t.Fatalf("unexpected poll %+v", post.Poll)
+	}
+
+	vote := func(user string, option int) int {
+		code, _ := srv.do("POST", "/api/v1/posts/"+post.Id+"/poll/vote", user, handlers.VoteRequestData{Option: &option})
+		return code
+	}
+	if code := vote("b2", 1); code != http.StatusOK {
+		t.Fatalf("vote: got status %d", code)
+	}
+	if code := vote("c3", 1); code != http.StatusOK {
+		t.Fatalf("vote: got status %d", code)
+	}
+	if code := vote("a1", 0); code != http.StatusOK {
+		t.Fatalf("vote: got status %d", code)
+	}
+	if code := vote("b2", 0); code != http.StatusConflict {
+		t.Errorf("second vote: got status %d", code)
+	}
+	if code := vote("d4", 2); code != http.StatusNotFound {
+		t.Errorf("unknown option: got status %d", code)
+	}
+
+	assertVotes := func(where string, got storage.Post) {
+		t.Helper()
+		if got.Poll == nil || got.Poll.Options[0].Votes != 1 || got.Poll.Options[1].Votes != 2 {
+			t.Errorf("%s: unexpected poll %+v", where, got.Poll)
+		}
+	}
+	srv.doJSON("GET", "/api/v1/posts/"+post.Id, "c3", nil, http.StatusOK, &post)
+	assertVotes("post", post)
+	assertVotes("post line", srv.getPage("/api/v1/users/a1/posts", "c3").Posts[0])
+	assertVotes("feed", srv.getPage("/api/v1/feed", "b2").Posts[0])
+
+	// the poll closes on time
+	closes_at = time.Now().Add(time.Second).Format(time.RFC3339)
+	data.Poll.ClosesAt = closes_at
+	srv.doJSON("POST", "/api/v1/posts", "a1", data, http.StatusOK, &post)
+	waitFor(t, "closing", func() bool { return vote("b2", 0) == http.StatusForbidden })
+
+	// a post without a poll
+	srv.doJSON("POST", "/api/v1/posts", "a1", handlers.PostRequestData{Text: "no poll"}, http.StatusOK, &post)
+	if code := vote("b2", 0); code != http.StatusNotFound {
+		t.Errorf("post without poll: got status %d", code)
+	}
+
+	data.Poll = &handlers.PollRequestData{Options: []string{"alone"}, ClosesAt: closes_at}
+	code, _ := srv.do("POST", "/api/v1/posts", "a1", data)
+	if code != http.StatusBadRequest {
+		t.Errorf("one option: got status %d", code)
+	}
+	data.Poll = &handlers.PollRequestData{Options: []string{"a", "b"}, ClosesAt: "2000-01-01T00:00:00Z"}
+	code, _ = srv.do("POST", "/api/v1/posts", "a1", data)
+	if code != http.StatusBadRequest {
+		t.Errorf("closed poll: got status %d", code)
+	}
+}
+
+func (s *testServer) upload(user string, content []byte) (int, storage.Media) {
+	s.t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("file", "upload")
+	part.Write(content)
+	form.Close()
+
+	req, _ := http.NewRequest("POST", s.URL+"/api/v1/media", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("System-Design-User-Id", user)
+
+	resp, err := s.Client().Do(req)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var media storage.Media
+	if resp.StatusCode == http.StatusOK {
+		json.NewDecoder(resp.Body).Decode(&media)
+	}
+	return resp.StatusCode, media
+}
+
+func TestMedia(t *testing.T) {
+	srv := newTestServer(t)
+
+	var picture bytes.Buffer
+	png.Encode(&picture, image.NewRGBA(image.Rect(0, 0, 30, 20)))
+
+	code, media := srv.upload("a1", picture.Bytes())
+	if code != http.StatusOK {
+		t.Fatalf("upload: got status %d", code)
+	}
+	if media.Type != "image/png" || media.Width != 30 || media.Height != 20 || media.Size != int64(picture.Len()) {
+		t.Fatalf("unexpected media %+v", media)
+	}
+
+	// whole file and a range of it
+	code, raw := srv.do("GET", media.URL, "", nil)
+	if code != http.StatusOK || !bytes.Equal(raw, picture.Bytes()) {
+		t.Fatalf("download: got status %d and %d bytes", code, len(raw))
+	}
+	req, _ := http.NewRequest("GET", srv.URL+media.URL, nil)
+	req.Header.Set("Range", "bytes=4-9")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(raw, picture.Bytes()[4:10]) {
+		t.Errorf("range: got status %d and %q", resp.StatusCode, raw)
+	}
+
+	var post storage.Post
+	srv.doJSON("POST", "/api/v1/posts", "a1", handlers.PostRequestData{Text: "look", Attachments: []string{media.Id}}, http.StatusOK, &post)
+	srv.doJSON("GET", "/api/v1/posts/"+post.Id, "b2", nil, http.StatusOK, &post)
+	if len(post.Attachments) != 1 || post.Attachments[0].URL != media.URL {
+		t.Fatalf("unexpected attachments %+v", post.Attachments)
+	}
+
+	code, _ = srv.do("POST", "/api/v1/posts", "b2", handlers.PostRequestData{Text: "stolen", Attachments: []string{media.Id}})
+	if code != http.StatusBadRequest {
+		t.Errorf("attaching media of another user: got status %d", code)
+	}
+
+	code, media = srv.upload("a1", []byte("plain text is fine"))
+	if code != http.StatusOK || media.Type != "text/plain; charset=utf-8" {
+		t.Errorf("text upload: got status %d, media %+v", code, media)
+	}
+	code, _ = srv.upload("a1", []byte("PK\x03\x04 an archive"))
+	if code != http.StatusUnsupportedMediaType {
+		t.Errorf("archive upload: got status %d", code)
+	}
+	code, _ = srv.upload("a1", bytes.Repeat([]byte("a"), storage.MaxMediaSize+1))
+	if code != http.StatusRequestEntityTooLarge {
+		t.Errorf("large upload: got status %d", code)
+	}
+	code, _ = srv.do("GET", "/api/v1/media/missing", "", nil)
+	if code != http.StatusNotFound {
+		t.Errorf("missing media: got status %d", code)
+	}
+}
+
+func TestPostValidation(t *testing.T) {
+	srv := newTestServer(t)
+
+	assertInvalid := func(method string, path string, text string) {
+		t.Helper()
+
+		code, raw := srv.do(method, path, "a1", handlers.PostRequestData{Text: text})
+		if code != http.StatusUnprocessableEntity {
+			t.Fatalf("text %.20q: got status %d", text, code)
+		}
+		var answer handlers.ValidationErrors
+		if err := json.Unmarshal(raw, &answer); err != nil || len(answer.Errors) != 1 || answer.Errors[0].Field != "text" {
+			t.Errorf("text %.20q: unexpected answer %s", text, raw)
+		}
+	}
+
+	assertInvalid("POST", "/api/v1/posts", "")
+	assertInvalid("POST", "/api/v1/posts", " \n\t")
+
+	// the length is in characters as people see them
+	family := "\U0001F468‍\U0001F469‍\U0001F467"
+	srv.createPost("a1", strings.Repeat(family, handlers.DefaultMaxTextLength))
+	assertInvalid("POST", "/api/v1/posts", strings.Repeat(family, handlers.DefaultMaxTextLength+1))
+
+	// texts are stored in NFC
+	post := srv.createPost("a1", "cafe\u0301")
+	if post.Text != "caf\u00e9" {
+		t.Errorf("text is not normalized: %q", post.Text)
+	}
+
+	assertInvalid("PATCH", "/api/v1/posts/"+post.Id, "")
+	assertInvalid("PATCH", "/api/v1/posts/"+post.Id, strings.Repeat("a", handlers.DefaultMaxTextLength+1))
+
+	code, _ := srv.do("POST", "/api/v1/posts", "a1", handlers.PostRequestData{Text: strings.Repeat("a", handlers.DefaultMaxBodySize)})
+	if code != http.StatusRequestEntityTooLarge {
+		t.Errorf("large body: got status %d", code)
+	}
+
+	// the other bodies are capped too
+	large := map[string]string{"padding": strings.Repeat("a", handlers.DefaultMaxBodySize)}
+	for _, path := range []string{
+		"PUT /api/v1/posts/" + post.Id + "/visibility",
+		"POST /api/v1/posts/" + post.Id + "/poll/vote",
+		"PUT /api/v1/settings",
+		"POST /api/v1/webhooks",
+		"POST /api/v1/notifications/read",
+	} {
+		route := strings.SplitN(path, " ", 2)
+		code, _ = srv.do(route[0], route[1], "a1", large)
+		if code != http.StatusRequestEntityTooLarge {
+			t.Errorf("large body to %v: got status %d", path, code)
+		}
+	}
+	srv.doJSON("POST", "/api/v1/notifications/read", "a1", nil, http.StatusOK, nil)
+
+	req, _ := http.NewRequest("POST", srv.URL+"/api/v1/posts", strings.NewReader("{\"text\": \"caf\xe9\"}"))
+	req.Header.Set("System-Design-User-Id", "a1")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("latin-1 body: got status %d", resp.StatusCode)
+	}
+}
+
+func withWordList(t *testing.T, rules string) func(*handlers.HTTPHandler) {
+	return func(h *handlers.HTTPHandler) {
+		list, err := moderation.NewWordList(strings.NewReader(rules))
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.Moderation.Moderator = list
+	}
+}
+
+const testRules = `
+# test rules
+reject spam
+reject спам
+flag /free\s+money/
+`
+
+func TestModeration(t *testing.T) {
+	srv := newTestServer(t, withWordList(t, testRules))
+
+	srv.doJSON("POST", "/api/v1/users/a1/subscribe", "b2", nil, http.StatusOK, nil)
+
+	code, raw := srv.do("POST", "/api/v1/posts", "a1", handlers.PostRequestData{Text: "buy SPAM now"})
+	if code != http.StatusUnprocessableEntity {
+		t.Fatalf("rejected post: got status %d", code)
+	}
+	if !strings.Contains(string(raw), "contains spam") {
+		t.Errorf("rejected post: unexpected answer %s", raw)
+	}
+
+	flagged := srv.createPost("a1", "free  money for @c3")
+	if flagged.Moderation != storage.ModerationFlagged {
+		t.Fatalf("unexpected post %+v", flagged)
+	}
+	code, raw = srv.do("POST", "/api/v1/posts", "a1", handlers.PostRequestData{Text: "купи СПАМ!"})
+	if code != http.StatusUnprocessableEntity || !strings.Contains(string(raw), "contains спам") {
+		t.Errorf("rejected non-ASCII post: got status %d, %s", code, raw)
+	}
+	srv.doJSON("POST", "/api/v1/posts", "c3", handlers.PostRequestData{Text: "спамеры"}, http.StatusOK, nil)
+
+	fine := srv.createPost("a1", "spammers are fine")
+	code, _ = srv.do("PATCH", "/api/v1/posts/"+fine.Id, "a1", handlers.PostRequestData{Text: "more spam"})
+	if code != http.StatusUnprocessableEntity {
+		t.Errorf("rejected edit: got status %d", code)
+	}
+	// other users do not learn what moderation thinks of their edits
+	code, _ = srv.do("PATCH", "/api/v1/posts/"+fine.Id, "c3", handlers.PostRequestData{Text: "more spam"})
+	if code != http.StatusForbidden {
+		t.Errorf("rejected edit of another user's post: got status %d", code)
+	}
+
+	// flagged posts are seen by the author only
+	assertTexts(t, srv.getPage("/api/v1/users/a1/posts", "a1").Posts, "spammers are fine", "free  money for @c3")
+	assertTexts(t, srv.getPage("/api/v1/users/a1/posts", "b2").Posts, "spammers are fine")
+	assertTexts(t, srv.getPage("/api/v1/feed", "b2").Posts, "spammers are fine")
+	code, _ = srv.do("GET", "/api/v1/posts/"+flagged.Id, "c3", nil)
+	if code != http.StatusNotFound {
+		t.Errorf("flagged post: got status %d", code)
+	}
+	if n := srv.unreadNotifications("c3"); n != 0 {
+		t.Errorf("mention in a flagged post notified: %d", n)
+	}
+
+	// an edit can flag a post too
+	srv.doJSON("PATCH", "/api/v1/posts/"+fine.Id, "a1", handlers.PostRequestData{Text: "free money"}, http.StatusOK, nil)
+	assertTexts(t, srv.getPage("/api/v1/feed", "b2").Posts)
+
+	code, _ = srv.do("GET", "/api/v1/admin/flagged-posts", "b2", nil)
+	if code != http.StatusForbidden {
+		t.Errorf("flagged posts for non-admin: got status %d", code)
+	}
+	var list storage.FlaggedPosts
+	srv.doJSON("GET", "/api/v1/admin/flagged-posts", "ad", nil, http.StatusOK, &list)
+	if len(list.Posts) != 2 || list.Posts[0].Id != flagged.Id || list.Posts[0].ModerationReason != "contains /free\\s+money/" {
+		t.Fatalf("unexpected flagged posts %+v", list.Posts)
+	}
+
+	srv.doJSON("POST", "/api/v1/admin/flagged-posts/"+flagged.Id+"/approve", "ad", nil, http.StatusOK, nil)
+	srv.doJSON("DELETE", "/api/v1/admin/flagged-posts/"+fine.Id, "ad", nil, http.StatusOK, nil)
+
+	assertTexts(t, srv.getPage("/api/v1/feed", "b2").Posts, "free  money for @c3")
+	assertTexts(t, srv.getPage("/api/v1/users/a1/posts", "a1").Posts, "free  money for @c3")
+	if n := srv.unreadNotifications("c3"); n != 1 {
+		t.Errorf("mention in an approved post: got %d notifications", n)
+	}
+
+	code, _ = srv.do("POST", "/api/v1/admin/flagged-posts/"+flagged.Id+"/approve", "ad", nil)
+	if code != http.StatusNotFound {
+		t.Errorf("approving twice: got status %d", code)
+	}
+	srv.doJSON("GET", "/api/v1/admin/flagged-posts", "ad", nil, http.StatusOK, &list)
+	if len(list.Posts) != 0 {
+		t.Fatalf("unexpected flagged posts %+v", list.Posts)
+	}
+}
+
+func TestModerationInWorker(t *testing.T) {
+	srv := newTestServer(t, withWordList(t, testRules), func(h *handlers.HTTPHandler) {
+		h.Moderation.Queue = h.Scheduler.Queue
+	})
+
+	srv.doJSON("POST", "/api/v1/users/a1/subscribe", "b2", nil, http.StatusOK, nil)
+
+	// saved as is, dealt with afterwards
+	srv.createPost("a1", "spam")
+	srv.createPost("a1", "free money")
+	srv.createPost("a1", "hello")
+
+	waitFor(t, "moderation", func() bool {
+		return len(srv.getPage("/api/v1/feed", "b2").Posts) == 1
+	})
+	assertTexts(t, srv.getPage("/api/v1/feed", "b2").Posts, "hello")
+	assertTexts(t, srv.getPage("/api/v1/users/a1/posts", "a1").Posts, "hello", "free money")
+}
+
+func TestReports(t *testing.T) {
+	srv := newTestServer(t)
+
+	srv.doJSON("POST", "/api/v1/users/a1/subscribe", "b2", nil, http.StatusOK, nil)
+
+	bad := srv.createPost("a1", "bad post")
+	other := srv.createPost("a1", "arguable post")
+
+	var report storage.Report
+	srv.doJSON("POST", "/api/v1/posts/"+bad.Id+"/report", "b2", handlers.ReportRequestData{Reason: "rude"}, http.StatusOK, &report)
+	srv.doJSON("POST", "/api/v1/posts/"+bad.Id+"/report", "c3", handlers.ReportRequestData{Reason: "very rude"}, http.StatusOK, nil)
+	srv.doJSON("POST", "/api/v1/posts/"+other.Id+"/report", "c3", handlers.ReportRequestData{Reason: "hmm"}, http.StatusOK, nil)
+
+	code, _ := srv.do("POST", "/api/v1/posts/"+bad.Id+"/report", "b2", handlers.ReportRequestData{Reason: "again"})
+	if code != http.StatusConflict {
+		t.Errorf("second report: got status %d", code)
+	}
+	code, _ = srv.do("POST", "/api/v1/posts/"+bad.Id+"/report", "a1", handlers.ReportRequestData{Reason: "mine"})
+	if code != http.StatusBadRequest {
+		t.Errorf("own post: got status %d", code)
+	}
+	code, _ = srv.do("POST", "/api/v1/posts/"+bad.Id+"/report", "d4", handlers.ReportRequestData{})
+	if code != http.StatusUnprocessableEntity {
+		t.Errorf("no reason: got status %d", code)
+	}
+
+	code, _ = srv.do("GET", "/api/v1/admin/reports", "b2", nil)
+	if code != http.StatusForbidden {
+		t.Errorf("reports for non-admin: got status %d", code)
+	}
+	var reports storage.Reports
+	srv.doJSON("GET", "/api/v1/admin/reports", "ad", nil, http.StatusOK, &reports)
+	if len(reports.Reports) != 3 || reports.Reports[0].Id != report.Id {
+		t.Fatalf("unexpected reports %+v", reports.Reports)
+	}
+
+	// removing the post closes both its reports
+	srv.doJSON("POST", "/api/v1/admin/reports/"+report.Id+"/resolve", "ad", handlers.ResolveReportRequestData{Action: handlers.ReportActionRemove}, http.StatusOK, &report)
+	if report.Status != storage.ReportResolved || report.ResolvedBy != "ad" {
+		t.Errorf("unexpected report %+v", report)
+	}
+	code, _ = srv.do("GET", "/api/v1/posts/"+bad.Id, "a1", nil)
+	if code != http.StatusNotFound {
+		t.Errorf("removed post: got status %d", code)
+	}
+	assertTexts(t, srv.getPage("/api/v1/feed", "b2").Posts, "arguable post")
+
+	srv.doJSON("GET", "/api/v1/admin/reports", "ad", nil, http.StatusOK, &reports)
+	if len(reports.Reports) != 1 || reports.Reports[0].PostId != other.Id {
+		t.Fatalf("unexpected reports %+v", reports.Reports)
+	}
+	srv.doJSON("POST", "/api/v1/admin/reports/"+reports.Reports[0].Id+"/resolve", "ad", handlers.ResolveReportRequestData{Action: handlers.ReportActionDismiss}, http.StatusOK, nil)
+	srv.doJSON("GET", "/api/v1/admin/reports?status=dismissed", "ad", nil, http.StatusOK, &reports)
+	if len(reports.Reports) != 1 || reports.Reports[0].PostId != other.Id {
+		t.Fatalf("unexpected dismissed reports %+v", reports.Reports)
+	}
+	srv.doJSON("GET", "/api/v1/posts/"+other.Id, "b2", nil, http.StatusOK, nil)
+}
+
+func TestSuspension(t *testing.T) {
+	srv := newTestServer(t)
+
+	post := srv.createPost("a1", "before")
+	var scheduled storage.ScheduledPost
+	data := handlers.PostRequestData{Text: "scheduled", PublishAt: time.Now().Add(100 * time.Millisecond).Format(time.RFC3339Nano)}
+	srv.doJSON("POST", "/api/v1/posts", "a1", data, http.StatusAccepted, &scheduled)
+
+	code, _ := srv.do("POST", "/api/v1/admin/users/a1/suspend", "b2", handlers.SuspendRequestData{Reason: "spam"})
+	if code != http.StatusForbidden {
+		t.Errorf("suspending by non-admin: got status %d", code)
+	}
+	srv.doJSON("POST", "/api/v1/admin/users/a1/suspend", "ad", handlers.SuspendRequestData{Reason: "spam"}, http.StatusOK, nil)
+
+	code, _ = srv.do("POST", "/api/v1/posts", "a1", handlers.PostRequestData{Text: "after"})
+	if code != http.StatusForbidden {
+		t.Errorf("post by suspended user: got status %d", code)
+	}
+	code, _ = srv.do("PATCH", "/api/v1/posts/"+post.Id, "a1", handlers.PostRequestData{Text: "edited"})
+	if code != http.StatusForbidden {
+		t.Errorf("edit by suspended user: got status %d", code)
+	}
+	code, _ = srv.do("POST", "/api/v1/users/b2/subscribe", "a1", nil)
+	if code != http.StatusForbidden {
+		t.Errorf("subscribe by suspended user: got status %d", code)
+	}
+	// reading is fine
+	srv.doJSON("GET", "/api/v1/posts/"+post.Id, "a1", nil, http.StatusOK, nil)
+
+	// scheduled posts wait for the suspension to be lifted
+	time.Sleep(200 * time.Millisecond)
+	code, _ = srv.do("GET", "/api/v1/posts/"+scheduled.Post.Id, "a1", nil)
+	if code != http.StatusNotFound {
+		t.Errorf("scheduled post by suspended user: got status %d", code)
+	}
+	var list storage.ScheduledPosts
+	srv.doJSON("GET", "/api/v1/scheduled-posts", "a1", nil, http.StatusOK, &list)
+	if len(list.Posts) != 1 {
+		t.Fatalf("held post is not scheduled any more %+v", list.Posts)
+	}
+
+	srv.doJSON("DELETE", "/api/v1/admin/users/a1/suspend", "ad", nil, http.StatusOK, nil)
+	srv.createPost("a1", "after")
+	waitFor(t, "publishing", func() bool {
+		code, _ := srv.do("GET", "/api/v1/posts/"+scheduled.Post.Id, "a1", nil)
+		return code == http.StatusOK
+	})
+}
+
+// runCLI runs the admin command line against the storage of srv.
+func (s *testServer) runCLI(args ...string) (string, error) {
+	open := func() (admin.Backend, error) {
+		return admin.Backend{
+			Storage:     s.handler.Storage,
+			Maintenance: s.handler.Storage.(storage.MaintenanceStore),
+			Suspensions: s.handler.Suspensions,
+			Feeds:       s.handler.Feeds,
+			Export:      s.handler.Storage.(storage.ExportStore),
+			Import:      s.handler.Storage.(storage.ImportStore),
+		}, nil
+	}
+	app := admin.NewApp(admin.Config{Open: open})
+
+	var out bytes.Buffer
+	app.Writer = &out
+	app.ErrWriter = &out
+	err := app.Run(append([]string{"microblog"}, args...))
+
+	return out.String(), err
+}
+
+func TestAdminCLI(t *testing.T) {
+	srv := newTestServer(t)
+
+	srv.doJSON("POST", "/api/v1/users/a1/subscribe", "b2", nil, http.StatusOK, nil)
+	srv.createPost("a1", "first")
+	srv.createPost("a1", "second")
+	srv.createPost("b2", "third")
+
+	out, err := srv.runCLI("migrate")
+	if err != nil || out != "storage is up to date\n" {
+		t.Errorf("migrate: got %q, %v", out, err)
+	}
+
+	if _, err := srv.runCLI("reindex"); err == nil {
+		t.Error("reindex without --stopped should fail")
+	}
+	out, err = srv.runCLI("reindex", "--stopped")
+	if err != nil || out != "indexes are rebuilt\n" {
+		t.Errorf("reindex: got %q, %v", out, err)
+	}
+
+	out, err = srv.runCLI("stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stats storage.Stats
+	if err := json.Unmarshal([]byte(out), &stats); err != nil {
+		t.Fatal(err)
+	}
+	want := storage.Stats{Posts: 3, Authors: 2, Subscriptions: 1, FeedPosts: 2}
+	if stats != want {
+		t.Errorf("got stats %+v, want %+v", stats, want)
+	}
+
+	if _, err := srv.runCLI("user", "suspend"); err == nil {
+		t.Error("suspend without a user should fail")
+	}
+	out, err = srv.runCLI("user", "suspend", "--reason", "spam", "a1")
+	if err != nil || out != "a1 is suspended\n" {
+		t.Errorf("suspend: got %q, %v", out, err)
+	}
+	code, _ := srv.do("POST", "/api/v1/posts", "a1", handlers.PostRequestData{Text: "suspended"})
+	if code != http.StatusForbidden {
+		t.Errorf("post by suspended user: got status %d", code)
+	}
+	suspension, err := srv.handler.Suspensions.GetSuspension(context.Background(), "a1")
+	if err != nil || suspension.Reason != "spam" {
+		t.Errorf("got suspension %+v, %v", suspension, err)
+	}
+
+	if _, err := srv.runCLI("user", "unsuspend", "a1"); err != nil {
+		t.Fatal(err)
+	}
+	srv.createPost("a1", "back")
+}
+
+func TestRebuildFeedAPI(t *testing.T) {
+	srv := newTestServer(t)
+
+	srv.doJSON("POST", "/api/v1/users/a1/subscribe", "b2", nil, http.StatusOK, nil)
+	srv.createPost("a1", "first")
+
+	code, _ := srv.do("POST", "/api/v1/admin/users/b2/rebuild-feed", "b2", nil)
+	if code != http.StatusForbidden {
+		t.Errorf("rebuild by non-admin: got status %d", code)
+	}
+
+	var repair storage.FeedRepair
+	srv.doJSON("POST", "/api/v1/admin/users/b2/rebuild-feed", "ad", nil, http.StatusOK, &repair)
+	if repair.User != "b2" || repair.Drifted() {
+		t.Errorf("unexpected repair %+v", repair)
+	}
+	assertTexts(t, srv.getPage("/api/v1/feed", "b2").Posts, "first")
+
+	if _, err := srv.runCLI("rebuild-feed"); err == nil {
+		t.Error("rebuild-feed without --user or --all should fail")
+	}
+	out, err := srv.runCLI("rebuild-feed", "--all")
+	if err != nil || strings.TrimSpace(out) != "[]" {
+		t.Errorf("rebuild-feed --all: got %q, %v", out, err)
+	}
+}
+
+func TestEventOutbox(t *testing.T) {
+	srv := newTestServer(t)
+	source := srv.handler.Storage.(storage.EventSource)
+
+	srv.doJSON("POST", "/api/v1/users/a1/subscribe", "b2", nil, http.StatusOK, nil)
+	post := srv.createPost("a1", "first")
+	srv.doJSON("PATCH", "/api/v1/posts/"+post.Id, "a1", handlers.PostRequestData{Text: "edited"}, http.StatusOK, nil)
+	srv.doJSON("PUT", "/api/v1/posts/"+post.Id+"/visibility", "a1", handlers.VisibilityRequestData{Visibility: storage.VisibilityFollowers}, http.StatusOK, nil)
+	// no moderator in tests, the post is flagged by hand
+	if _, err := srv.handler.Storage.(storage.ModerationStore).FlagPost(context.Background(), post.Id, "spam"); err != nil {
+		t.Fatal(err)
+	}
+	srv.doJSON("POST", "/api/v1/admin/flagged-posts/"+post.Id+"/approve", "ad", nil, http.StatusOK, nil)
+	srv.doJSON("DELETE", "/api/v1/admin/flagged-posts/"+post.Id, "ad", nil, http.StatusOK, nil)
+	srv.doJSON("POST", "/api/v1/users/a1/block", "b2", nil, http.StatusOK, nil)
+
+	bus := events.NewBus()
+	var got []storage.Event
+	bus.Subscribe(func(ctx context.Context, event storage.Event) error {
+		got = append(got, event)
+		return nil
+	})
+	relay := events.NewRelay(source, bus)
+	relay.BatchSize = 2
+
+	count, err := relay.RelayOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		storage.EventSubscriptionAdded,
+		storage.EventPostCreated,
+		storage.EventPostEdited,
+		storage.EventPostEdited,
+		storage.EventPostFlagged,
+		storage.EventPostApproved,
+		storage.EventPostDeleted,
+		storage.EventSubscriptionRemoved,
+	}
+	if count != len(want) || len(got) != len(want) {
+		t.Fatalf("relayed %d events %+v, want %v", count, got, want)
+	}
+	for i, event := range got {
+		if event.Type != want[i] {
+			t.Errorf("event %d is %v, want %v", i, event.Type, want[i])
+		}
+	}
+	if got[2].Post.Text != "edited" || got[3].Post.Visibility != storage.VisibilityFollowers {
+		t.Errorf("unexpected edits %+v, %+v", got[2].Post, got[3].Post)
+	}
+	if got[4].Post.Moderation != storage.ModerationFlagged || got[5].Post.Moderation != "" {
+		t.Errorf("unexpected moderation %+v, %+v", got[4].Post, got[5].Post)
+	}
+	if sub := got[7].Subscription; sub.User != "b2" || sub.ToUser != "a1" {
+		t.Errorf("unexpected subscription %+v", sub)
+	}
+
+	// the relay goes on from its cursor
+	srv.createPost("a1", "second")
+	count, err = relay.RelayOnce(context.Background())
+	if err != nil || count != 1 || got[len(got)-1].Post.Text != "second" {
+		t.Errorf("relayed %d events, %v", count, err)
+	}
+
+	// the relay of another worker waits for the lease to run out
+	other := events.NewRelay(source, bus)
+	srv.createPost("a1", "third")
+	count, err = other.RelayOnce(context.Background())
+	if err != nil || count != 0 {
+		t.Errorf("other relay relayed %d events, %v", count, err)
+	}
+	count, err = relay.RelayOnce(context.Background())
+	if err != nil || count != 1 || got[len(got)-1].Post.Text != "third" {
+		t.Errorf("relayed %d events, %v", count, err)
+	}
+}
+
+func TestBatchGetPosts(t *testing.T) {
+	srv := newTestServer(t, func(h *handlers.HTTPHandler) {
+		h.Limits.MaxBatchSize = 4
+	})
+
+	public := srv.createPost("a1", "public")
+	var private storage.Post
+	srv.doJSON("POST", "/api/v1/posts", "a1", handlers.PostRequestData{Text: "followers", Visibility: storage.VisibilityFollowers}, http.StatusOK, &private)
+	other := srv.createPost("c3", "other")
+
+	var answer handlers.BatchGetResponse
+	ids := []string{other.Id, "nope", private.Id, public.Id, other.Id}
+	srv.doJSON("POST", "/api/v1/posts:batchGet", "b2", handlers.BatchGetRequestData{Ids: ids}, http.StatusOK, &answer)
+	assertTexts(t, answer.Posts, "other", "public")
+	if len(answer.Missing) != 2 || answer.Missing[0] != "nope" || answer.Missing[1] != private.Id {
+		t.Errorf("unexpected missing %v", answer.Missing)
+	}
+
+	srv.doJSON("POST", "/api/v1/users/a1/subscribe", "b2", nil, http.StatusOK, nil)
+	srv.doJSON("POST", "/api/v1/posts:batchGet", "b2", handlers.BatchGetRequestData{Ids: ids}, http.StatusOK, &answer)
+	assertTexts(t, answer.Posts, "other", "followers", "public")
+
+	code, _ := srv.do("POST", "/api/v1/posts:batchGet", "b2", handlers.BatchGetRequestData{})
+	if code != http.StatusUnprocessableEntity {
+		t.Errorf("no ids: got status %d", code)
+	}
+	code, _ = srv.do("POST", "/api/v1/posts:batchGet", "b2", handlers.BatchGetRequestData{Ids: []string{"1", "2", "3", "4", "5"}})
+	if code != http.StatusUnprocessableEntity {
+		t.Errorf("too many ids: got status %d", code)
+	}
+
+	// reading is allowed to suspended users
+	srv.doJSON("POST", "/api/v1/admin/users/b2/suspend", "ad", handlers.SuspendRequestData{Reason: "spam"}, http.StatusOK, nil)
+	srv.doJSON("POST", "/api/v1/posts:batchGet", "b2", handlers.BatchGetRequestData{Ids: ids}, http.StatusOK, nil)
+}
+
+func TestExportImport(t *testing.T) {
+	src := newTestServer(t)
+
+	src.doJSON("POST", "/api/v1/users/a1/subscribe", "b2", nil, http.StatusOK, nil)
+	src.doJSON("POST", "/api/v1/users/b2/subscribe", "c3", nil, http.StatusOK, nil)
+	var posts []storage.Post
+	for i := 1; i <= 4; i++ {
+		posts = append(posts, src.createPost("a1", "post "+strconv.Itoa(i)))
+	}
+	closes_at := time.Now().Add(time.Hour).Format(time.RFC3339)
+	poll := handlers.PostRequestData{Text: "tea or coffee?", Poll: &handlers.PollRequestData{Options: []string{"tea", "coffee"}, ClosesAt: closes_at}}
+	var poll_post storage.Post
+	src.doJSON("POST", "/api/v1/posts", "b2", poll, http.StatusOK, &poll_post)
+	option := 1
+	src.doJSON("POST", "/api/v1/posts/"+poll_post.Id+"/poll/vote", "c3", handlers.VoteRequestData{Option: &option}, http.StatusOK, nil)
+	src.doJSON("POST", "/api/v1/posts/"+posts[3].Id+"/pin", "a1", nil, http.StatusOK, nil)
+	src.doJSON("POST", "/api/v1/posts/"+posts[1].Id+"/pin", "a1", nil, http.StatusOK, nil)
+
+	path := filepath.Join(t.TempDir(), "export.jsonl")
+	out, err := src.runCLI("export", "--out", path)
+	// 5 posts, 2 subscriptions, 4 + 1 feed entries, 1 vote
+	if err != nil || out != "13 records written to "+path+"\n" {
+		t.Fatalf("export: got %q, %v", out, err)
+	}
+	full, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an export that stopped in the middle of a line
+	lines := bytes.SplitAfter(full, []byte("\n"))
+	cut := len(bytes.Join(lines[:6], nil)) + len(lines[6])/2
+	if err := os.WriteFile(path, full[:cut], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.runCLI("export", "--out", path, "--resume"); err != nil {
+		t.Fatal(err)
+	}
+	resumed, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(resumed, full) {
+		t.Errorf("resumed export differs:\n%s\nwant:\n%s", resumed, full)
+	}
+
+	dst := newTestServer(t)
+	out, err = dst.runCLI("import", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stats dump.ImportStats
+	if err := json.Unmarshal([]byte(out), &stats); err != nil {
+		t.Fatal(err)
+	}
+	want := dump.ImportStats{Posts: 5, Subscriptions: 2, FeedEntries: 5, Votes: 1}
+	if stats != want {
+		t.Errorf("got stats %+v, want %+v", stats, want)
+	}
+
+	// nothing happens besides the writes
+	if page := dst.notifications("/api/v1/notifications", "a1"); len(page.Notifications) != 0 {
+		t.Errorf("import notified a1 of %+v", page.Notifications)
+	}
+	imported, err := dst.handler.Storage.(storage.EventSource).ReadEvents(context.Background(), "", 10)
+	if err != nil || len(imported) != 0 {
+		t.Errorf("import saved events %+v, %v", imported, err)
+	}
+
+	for _, path := range []string{"/api/v1/users/a1/posts", "/api/v1/users/b2/posts"} {
+		got, want := dst.getPage(path, ""), src.getPage(path, "")
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got %+v, want %+v", path, got, want)
+		}
+	}
+	for _, user := range []string{"b2", "c3"} {
+		got, want := dst.getPage("/api/v1/feed", user), src.getPage("/api/v1/feed", user)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("feed of %v: got %+v, want %+v", user, got, want)
+		}
+	}
+
+	// the voters of the source have voted here too
+	code, _ := dst.do("POST", "/api/v1/posts/"+poll_post.Id+"/poll/vote", "c3", handlers.VoteRequestData{Option: &option})
+	if code != http.StatusConflict {
+		t.Errorf("second vote after the import: got status %d", code)
+	}
+
+	// importing again finds everything there
+	out, err = dst.runCLI("import", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(out), &stats); err != nil {
+		t.Fatal(err)
+	}
+	want = dump.ImportStats{FeedEntries: 5, Skipped: 8}
+	if stats != want {
+		t.Errorf("second import: got stats %+v, want %+v", stats, want)
+	}
+}
+
+func TestLoadReplay(t *testing.T) {
+	srv := newTestServer(t)
+
+	out, err := srv.runCLI("synthesize", "--users", "20", "--follows", "3", "--duration", "2s", "--post-rate", "20", "--read-rate", "20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	synthesis := loadgen.DefaultSynthesis()
+	synthesis.Users, synthesis.Follows, synthesis.Duration = 20, 3, 2*time.Second
+	synthesis.PostRate, synthesis.ReadRate = 20, 20
+	var traces bytes.Buffer
+	if err := synthesis.Write(&traces); err != nil {
+		t.Fatal(err)
+	}
+	if out != traces.String() {
+		t.Errorf("synthesize: got %q, want %q", out, traces.String())
+	}
+	// a request the server can't route
+	traces.WriteString(`{"method":"GET","path":"/api/v1/nope","at":2000}` + "\n")
+	lines := strings.Count(traces.String(), "\n")
+
+	replayer := loadgen.NewReplayer(srv.URL)
+	replayer.Rate = 1000
+	replayer.Route = loadgen.MuxRoute(setup.NewServer("", srv.handler).Handler.(*mux.Router))
+	report, err := replayer.Replay(context.Background(), &traces)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Requests != lines || report.Errors != 0 {
+		t.Errorf("got %d requests and %d errors, want %d and none", report.Requests, report.Errors, lines)
+	}
+
+	routes := make(map[string]loadgen.RouteStats)
+	for _, stats := range report.Routes {
+		routes[stats.Route] = stats
+	}
+	for _, route := range []string{"POST /api/v1/users/{userId}/subscribe", "POST /api/v1/posts", "GET /api/v1/feed"} {
+		stats, ok := routes[route]
+		if !ok {
+			t.Errorf("no stats of %v in %+v", route, report.Routes)
+			continue
+		}
+		if stats.Statuses[http.StatusOK] != stats.Requests {
+			t.Errorf("%v: got statuses %v", route, stats.Statuses)
+		}
+		if stats.P50 > stats.P90 || stats.P90 > stats.P99 || stats.P99 > stats.Max {
+			t.Errorf("%v: unordered percentiles %+v", route, stats)
+		}
+	}
+	if stats := routes["GET /api/v1/nope"]; stats.Statuses[http.StatusNotFound] != 1 {
+		t.Errorf("unrouted request: got %+v", stats)
+	}
+}
+
+func TestLoadReplayCountsQueueing(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}))
+	t.Cleanup(slow.Close)
+
+	// all due at once, one worker: the last one waits for the other two
+	traces := strings.Repeat(`{"method":"GET","path":"/slow","at":0}`+"\n", 3)
+
+	replayer := loadgen.NewReplayer(slow.URL)
+	replayer.Concurrency = 1
+	report, err := replayer.Replay(context.Background(), strings.NewReader(traces))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Requests != 3 || len(report.Routes) != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if stats := report.Routes[0]; stats.Max < 150 || stats.P50 < 100 {
+		t.Errorf("waiting for a worker is not counted: %+v", stats)
+	}
+	if report.MaxLag < 100 {
+		t.Errorf("got max lag %v, want 100 and more", report.MaxLag)
+	}
+}
+
+func TestAccountExportAndErasure(t *testing.T) {
+	srv := newTestServer(t)
+
+	srv.doJSON("POST", "/api/v1/users/a1/subscribe", "b2", nil, http.StatusOK, nil)
+	srv.doJSON("POST", "/api/v1/users/c3/subscribe", "a1", nil, http.StatusOK, nil)
+	first := srv.createPost("a1", "first")
+	srv.doJSON("POST", "/api/v1/posts", "a1", handlers.PostRequestData{Text: "friends only", Visibility: storage.VisibilityFollowers}, http.StatusOK, nil)
+	srv.createPost("a1", "last")
+	srv.doJSON("POST", "/api/v1/posts/"+first.Id+"/pin", "a1", nil, http.StatusOK, nil)
+	srv.createPost("c3", "other")
+
+	code, media := srv.upload("a1", []byte("notes of a1"))
+	if code != http.StatusOK {
+		t.Fatalf("upload: got status %d", code)
+	}
+	srv.doJSON("POST", "/api/v1/webhooks", "a1", handlers.WebhookRequestData{
+		URL:    "http://receiver.example/hook",
+		Events: []string{storage.EventPostCreated},
+	}, http.StatusOK, nil)
+	srv.doJSON("POST", "/api/v1/users/d4/block", "a1", nil, http.StatusOK, nil)
+	srv.doJSON("POST", "/api/v1/users/a1/mute", "c3", nil, http.StatusOK, nil)
+	closes_at := time.Now().Add(time.Hour).Format(time.RFC3339)
+	var poll storage.Post
+	srv.doJSON("POST", "/api/v1/posts", "c3", handlers.PostRequestData{Text: "tea or coffee?", Poll: &handlers.PollRequestData{Options: []string{"tea", "coffee"}, ClosesAt: closes_at}}, http.StatusOK, &poll)
+	option := 0
+	srv.doJSON("POST", "/api/v1/posts/"+poll.Id+"/poll/vote", "a1", handlers.VoteRequestData{Option: &option}, http.StatusOK, nil)
+
+	var archive handlers.AccountArchive
+	srv.doJSON("GET", "/api/v1/me/export", "a1", nil, http.StatusOK, &archive)
+	assertTexts(t, archive.Posts, "last", "friends only", "first")
+	if archive.User != "a1" || archive.Profile == nil {
+		t.Errorf("unexpected archive %+v", archive)
+	}
+	if len(archive.Subscriptions) != 1 || archive.Subscriptions[0] != "c3" {
+		t.Errorf("unexpected subscriptions %v", archive.Subscriptions)
+	}
+	if len(archive.Subscribers) != 1 || archive.Subscribers[0] != "b2" {
+		t.Errorf("unexpected subscribers %v", archive.Subscribers)
+	}
+
+	code, _ = srv.do("GET", "/api/v1/me/erasure", "a1", nil)
+	if code != http.StatusNotFound {
+		t.Errorf("erasure before asking: got status %d", code)
+	}
+
+	var erasure storage.Erasure
+	srv.doJSON("DELETE", "/api/v1/me", "a1", nil, http.StatusAccepted, &erasure)
+	if erasure.Status != storage.ErasurePending {
+		t.Errorf("unexpected erasure %+v", erasure)
+	}
+	waitFor(t, "the erasure", func() bool {
+		srv.doJSON("GET", "/api/v1/me/erasure", "a1", nil, http.StatusOK, &erasure)
+		return erasure.Status != storage.ErasurePending
+	})
+	if erasure.Status != storage.ErasureDone || erasure.Posts != 3 || erasure.Subscriptions != 2 || erasure.Media != 1 {
+		t.Errorf("unexpected erasure %+v", erasure)
+	}
+
+	code, _ = srv.do("GET", "/api/v1/posts/"+first.Id, "a1", nil)
+	if code != http.StatusNotFound {
+		t.Errorf("erased post: got status %d", code)
+	}
+	assertTexts(t, srv.getPage("/api/v1/users/a1/posts", "a1").Posts)
+	assertTexts(t, srv.getPage("/api/v1/feed", "b2").Posts)
+	assertTexts(t, srv.getPage("/api/v1/feed", "a1").Posts)
+	var users storage.Subscribers
+	srv.doJSON("GET", "/api/v1/subscriptions", "b2", nil, http.StatusOK, &users)
+	if len(users.Users) != 0 {
+		t.Errorf("subscriptions of b2 left %v", users.Users)
+	}
+	srv.doJSON("GET", "/api/v1/subscribers", "c3", nil, http.StatusOK, &users)
+	if len(users.Users) != 0 {
+		t.Errorf("subscribers of c3 left %v", users.Users)
+	}
+
+	code, _ = srv.do("GET", "/api/v1/media/"+media.Id, "", nil)
+	if code != http.StatusNotFound {
+		t.Errorf("erased media: got status %d", code)
+	}
+	var hooks storage.Webhooks
+	srv.doJSON("GET", "/api/v1/webhooks", "a1", nil, http.StatusOK, &hooks)
+	if len(hooks.Webhooks) != 0 {
+		t.Errorf("webhooks left %+v", hooks.Webhooks)
+	}
+	srv.doJSON("GET", "/api/v1/posts/"+poll.Id, "c3", nil, http.StatusOK, &poll)
+	if poll.Poll.Options[0].Votes != 0 {
+		t.Errorf("vote of a1 left %+v", poll.Poll)
+	}
+	for _, notification := range srv.notifications("/api/v1/notifications", "c3").Notifications {
+		if notification.Actor == "a1" {
+			t.Errorf("notification by a1 left %+v", notification)
+		}
+	}
+
+	// an erased account may be erased again
+	srv.doJSON("DELETE", "/api/v1/me", "a1", nil, http.StatusAccepted, nil)
+}
+
+func TestSuspendedAccountErasure(t *testing.T) {
+	srv := newTestServer(t)
+
+	srv.createPost("a1", "spam")
+	srv.doJSON("POST", "/api/v1/admin/users/a1/suspend", "ad", handlers.SuspendRequestData{Reason: "spam"}, http.StatusOK, nil)
+	code, _ := srv.do("POST", "/api/v1/posts", "a1", handlers.PostRequestData{Text: "more spam"})
+	if code != http.StatusForbidden {
+		t.Errorf("post by suspended user: got status %d", code)
+	}
+
+	// suspended users still own their data
+	var archive handlers.AccountArchive
+	srv.doJSON("GET", "/api/v1/me/export", "a1", nil, http.StatusOK, &archive)
+	assertTexts(t, archive.Posts, "spam")
+
+	var erasure storage.Erasure
+	srv.doJSON("DELETE", "/api/v1/me", "a1", nil, http.StatusAccepted, &erasure)
+	waitFor(t, "the erasure", func() bool {
+		srv.doJSON("GET", "/api/v1/me/erasure", "a1", nil, http.StatusOK, &erasure)
+		return erasure.Status != storage.ErasurePending
+	})
+	if erasure.Status != storage.ErasureDone || erasure.Posts != 1 {
+		t.Errorf("unexpected erasure %+v", erasure)
+	}
+}
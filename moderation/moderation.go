@@ -0,0 +1,26 @@
+// Package moderation decides whether posts may be published. A Moderator
+// looks at one post, the Pipeline applies its decision either right in the
+// request or later from a queue task.
+package moderation
+
+import (
+	"context"
+	"microblog/storage"
+)
+
+const (
+	Allow  = "allow"
+	Flag   = "flag"
+	Reject = "reject"
+)
+
+type Decision struct {
+	// one of Allow, Flag and Reject
+	Action string
+	// why the post was flagged or rejected, shown to the author and admins
+	Reason string
+}
+
+type Moderator interface {
+	Moderate(ctx context.Context, post storage.Post) (Decision, error)
+}
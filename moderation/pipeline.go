@@ -0,0 +1,109 @@
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"microblog/queue"
+	"microblog/storage"
+	"time"
+)
+
+// ModerateTask is the queue task that moderates one saved post, its payload
+// is a taskPayload.
+const ModerateTask = "moderate_post"
+
+type taskPayload struct {
+	PostId   string `json:"postId"`
+	AuthorId string `json:"authorId"`
+}
+
+// Pipeline runs Moderator on new and edited posts. Without a Queue it runs in
+// the request: rejected posts are not saved and flagged ones are saved hidden.
+// With a Queue posts are saved as they are and ModerateTask flags or removes
+// them afterwards.
+type Pipeline struct {
+	// nil allows everything, posts are then only reviewed by hand
+	Moderator  Moderator
+	Storage    storage.Storage
+	Moderation storage.ModerationStore
+	Queue      queue.Queue
+}
+
+func NewPipeline(moderator Moderator, s storage.Storage, moderation storage.ModerationStore, q queue.Queue) *Pipeline {
+	return &Pipeline{
+		Moderator:  moderator,
+		Storage:    s,
+		Moderation: moderation,
+		Queue:      q,
+	}
+}
+
+// Check moderates post before it is saved. A flagged post comes back marked
+// as such, a rejected one should not be saved. When moderation runs in the
+// worker everything is allowed here.
+func (p *Pipeline) Check(ctx context.Context, post storage.Post) (storage.Post, Decision, error) {
+	if p.Moderator == nil || p.Queue != nil {
+		return post, Decision{Action: Allow}, nil
+	}
+
+	decision, err := p.Moderator.Moderate(ctx, post)
+	if err != nil {
+		return post, decision, err
+	}
+	if decision.Action == Flag {
+		post.Moderation = storage.ModerationFlagged
+		post.ModerationReason = decision.Reason
+	}
+
+	return post, decision, nil
+}
+
+// Saved is called once post is created or edited, it sends ModerateTask when
+// moderation runs in the worker.
+func (p *Pipeline) Saved(ctx context.Context, post storage.Post) error {
+	if p.Moderator == nil || p.Queue == nil {
+		return nil
+	}
+
+	payload, _ := json.Marshal(taskPayload{PostId: post.Id, AuthorId: post.AuthorId})
+	return p.Queue.Send(ctx, queue.Task{Name: ModerateTask, Payload: string(payload)})
+}
+
+// ModeratePost is the handler of ModerateTask. It reads the post again, so
+// that the latest text is moderated.
+func (p *Pipeline) ModeratePost(ctx context.Context, payload string) error {
+	var task taskPayload
+	if err := json.Unmarshal([]byte(payload), &task); err != nil {
+		return err
+	}
+
+	post, err := p.Storage.GetPost(ctx, task.PostId, task.AuthorId)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			// already removed
+			return nil
+		}
+		return &queue.RetryLater{Delay: time.Minute, Err: err}
+	}
+	if post.Moderation == storage.ModerationFlagged {
+		return nil
+	}
+
+	decision, err := p.Moderator.Moderate(ctx, post)
+	if err != nil {
+		return &queue.RetryLater{Delay: time.Minute, Err: err}
+	}
+
+	switch decision.Action {
+	case Flag:
+		_, err = p.Moderation.FlagPost(ctx, post.Id, decision.Reason)
+	case Reject:
+		_, err = p.Moderation.RemovePost(ctx, post.Id)
+	}
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return &queue.RetryLater{Delay: time.Minute, Err: err}
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package moderation
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"microblog/storage"
+	"os"
+	"regexp"
+	"strings"
+)
+
+type rule struct {
+	action  string
+	pattern *regexp.Regexp
+	source  string
+}
+
+// WordList flags or rejects posts whose text matches one of its rules.
+type WordList struct {
+	rules []rule
+}
+
+// NewWordList reads rules, one per line:
+//
+//	# comment
+//	reject spam
+//	flag /crypto\s+giveaway/
+//
+// A word matches as a whole word regardless of case, a pattern between
+// slashes is a regular expression. Reject rules win over flag rules.
+func NewWordList(r io.Reader) (*WordList, error) {
+	var list WordList
+
+	scanner := bufio.NewScanner(r)
+	line_number := 0
+	for scanner.Scan() {
+		line_number++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 || (fields[0] != Flag && fields[0] != Reject) {
+			return nil, fmt.Errorf("line %v: expected \"flag\" or \"reject\" and a word", line_number)
+		}
+		source := strings.TrimSpace(fields[1])
+
+		var expression string
+		if len(source) > 2 && strings.HasPrefix(source, "/") && strings.HasSuffix(source, "/") {
+			expression = source[1 : len(source)-1]
+		} else {
+			// \b only knows ASCII word characters
+			expression = `(?i)(?:^|[^\p{L}\p{N}])` + regexp.QuoteMeta(source) + `(?:$|[^\p{L}\p{N}])`
+		}
+		pattern, err := regexp.Compile(expression)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %w", line_number, err)
+		}
+
+		list.rules = append(list.rules, rule{action: fields[0], pattern: pattern, source: source})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return &list, nil
+}
+
+func LoadWordList(path string) (*WordList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return NewWordList(file)
+}
+
+func (l *WordList) Moderate(ctx context.Context, post storage.Post) (Decision, error) {
+	decision := Decision{Action: Allow}
+	for _, rule := range l.rules {
+		if !rule.pattern.MatchString(post.Text) {
+			continue
+		}
+		if rule.action == Reject {
+			return Decision{Action: Reject, Reason: "contains " + rule.source}, nil
+		}
+		if decision.Action == Allow {
+			decision = Decision{Action: Flag, Reason: "contains " + rule.source}
+		}
+	}
+	return decision, nil
+}
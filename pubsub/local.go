@@ -0,0 +1,60 @@
+package pubsub
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+type local_pubsub struct {
+	mu       sync.Mutex
+	channels map[string]map[chan []byte]*Subscription
+}
+
+// NewLocal returns an in-process PubSub based on go channels. It is enough
+// when only one instance of the server is running.
+func NewLocal() *local_pubsub {
+	return &local_pubsub{
+		channels: make(map[string]map[chan []byte]*Subscription),
+	}
+}
+
+func (p *local_pubsub) Publish(ctx context.Context, channel string, data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for ch, sub := range p.channels[channel] {
+		select {
+		case ch <- data:
+		default:
+			log.Println("pubsub: subscriber of", channel, "is too slow, message dropped")
+			sub.drop()
+		}
+	}
+
+	return nil
+}
+
+func (p *local_pubsub) Subscribe(ctx context.Context, channel string) (*Subscription, error) {
+	ch := make(chan []byte, SubscriptionBuffer)
+	sub := newSubscription(ch)
+	sub.close = func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		delete(p.channels[channel], ch)
+		if len(p.channels[channel]) == 0 {
+			delete(p.channels, channel)
+		}
+		close(ch)
+	}
+
+	p.mu.Lock()
+	if p.channels[channel] == nil {
+		p.channels[channel] = make(map[chan []byte]*Subscription)
+	}
+	p.channels[channel][ch] = sub
+	p.mu.Unlock()
+
+	return sub, nil
+}
@@ -0,0 +1,50 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+)
+
+// How many messages may wait for a slow subscriber before new ones are dropped.
+const SubscriptionBuffer = 64
+
+// PubSub delivers messages published to a channel to everybody who is
+// subscribed to it at that moment. There is no persistence, missed messages
+// have to be recovered from the storage.
+type PubSub interface {
+	Publish(ctx context.Context, channel string, data []byte) error
+	Subscribe(ctx context.Context, channel string) (*Subscription, error)
+}
+
+type Subscription struct {
+	C <-chan []byte
+	// gets a value when messages were dropped because the subscriber was too
+	// slow, what it missed has to be read from the storage
+	Dropped <-chan struct{}
+
+	dropped   chan struct{}
+	closeOnce sync.Once
+	close     func()
+}
+
+func newSubscription(ch <-chan []byte) *Subscription {
+	dropped := make(chan struct{}, 1)
+	return &Subscription{
+		C:       ch,
+		Dropped: dropped,
+		dropped: dropped,
+	}
+}
+
+// drop tells the subscriber that a message was dropped, drops it has not
+// seen yet count once.
+func (s *Subscription) drop() {
+	select {
+	case s.dropped <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Subscription) Close() {
+	s.closeOnce.Do(s.close)
+}
@@ -0,0 +1,65 @@
+package pubsub
+
+import (
+	"context"
+	"log"
+
+	"github.com/go-redis/redis/v8"
+)
+
+type redis_pubsub struct {
+	client *redis.Client
+}
+
+// NewRedis returns a PubSub on top of Redis PUBLISH/SUBSCRIBE, so messages
+// reach subscribers connected to any instance of the server.
+func NewRedis(client *redis.Client) *redis_pubsub {
+	return &redis_pubsub{
+		client: client,
+	}
+}
+
+func (p *redis_pubsub) Publish(ctx context.Context, channel string, data []byte) error {
+	return p.client.Publish(ctx, channel, data).Err()
+}
+
+func (p *redis_pubsub) Subscribe(ctx context.Context, channel string) (*Subscription, error) {
+	redis_sub := p.client.Subscribe(ctx, channel)
+
+	// wait for the confirmation, so that nothing published after return is lost
+	if _, err := redis_sub.Receive(ctx); err != nil {
+		redis_sub.Close()
+		return nil, err
+	}
+
+	ch := make(chan []byte, SubscriptionBuffer)
+	done := make(chan struct{})
+	sub := newSubscription(ch)
+	sub.close = func() {
+		close(done)
+		redis_sub.Close()
+	}
+
+	go func() {
+		defer close(ch)
+		messages := redis_sub.Channel()
+		for {
+			select {
+			case <-done:
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				select {
+				case ch <- []byte(msg.Payload):
+				default:
+					log.Println("pubsub: subscriber of", channel, "is too slow, message dropped")
+					sub.drop()
+				}
+			}
+		}
+	}()
+
+	return sub, nil
+}
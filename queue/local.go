@@ -0,0 +1,58 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+type local_queue struct {
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+// NewLocal returns a Queue that runs tasks in goroutines of this process.
+// Tasks do not survive a restart, use the machinery worker for that.
+func NewLocal() *local_queue {
+	return &local_queue{
+		handlers: make(map[string]Handler),
+	}
+}
+
+func (q *local_queue) Register(name string, handler Handler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.handlers[name] = handler
+}
+
+func (q *local_queue) Send(ctx context.Context, task Task) error {
+	q.mu.RLock()
+	handler, ok := q.handlers[task.Name]
+	q.mu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("no handler for task %v", task.Name)
+	}
+
+	q.schedule(handler, task, time.Until(task.ETA))
+	return nil
+}
+
+func (q *local_queue) schedule(handler Handler, task Task, delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		err := handler(context.Background(), task.Payload)
+
+		var retry *RetryLater
+		if errors.As(err, &retry) {
+			q.schedule(handler, task, retry.Delay)
+			return
+		}
+		if err != nil {
+			log.Println("Task", task.Name, "failed:", err)
+		}
+	})
+}
@@ -0,0 +1,36 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Task is a named job with a string payload. It is run by the handler
+// registered under Name not earlier than ETA.
+type Task struct {
+	Name    string
+	Payload string
+	ETA     time.Time
+}
+
+type Queue interface {
+	Send(ctx context.Context, task Task) error
+}
+
+type Handler func(ctx context.Context, payload string) error
+
+// RetryLater is returned by a Handler to run the same task again after Delay.
+// Any other error just fails the task.
+type RetryLater struct {
+	Delay time.Duration
+	Err   error
+}
+
+func (e *RetryLater) Error() string {
+	return fmt.Sprintf("retry in %v: %v", e.Delay, e.Err)
+}
+
+func (e *RetryLater) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,151 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"microblog/moderation"
+	"microblog/queue"
+	"microblog/storage"
+	"microblog/webhooks"
+	"time"
+)
+
+// PublishTask is the queue task that publishes one scheduled post, its
+// payload is the post id.
+const PublishTask = "publish_scheduled_post"
+
+// Publisher keeps scheduled posts and publishes them through the queue.
+type Publisher struct {
+	Storage  storage.Storage
+	Schedule storage.ScheduleStore
+	Queue    queue.Queue
+	// nil disables webhooks
+	Webhooks *webhooks.Dispatcher
+	// set when posts are moderated by a worker, in-request moderation happens
+	// when the post is scheduled
+	Moderation *moderation.Pipeline
+	// nil publishes the posts of suspended users too
+	Suspensions storage.SuspensionStore
+	// how often the posts of suspended users are tried again
+	SuspendedDelay time.Duration
+}
+
+func NewPublisher(s storage.Storage, schedule storage.ScheduleStore, q queue.Queue, dispatcher *webhooks.Dispatcher) *Publisher {
+	return &Publisher{
+		Storage:  s,
+		Schedule: schedule,
+		Queue:    q,
+		Webhooks: dispatcher,
+
+		SuspendedDelay: time.Hour,
+	}
+}
+
+// Add saves post to be published at publish_at.
+func (p *Publisher) Add(ctx context.Context, post storage.Post, publish_at time.Time) (storage.ScheduledPost, error) {
+	scheduled := storage.ScheduledPost{
+		Post:      post,
+		PublishAt: publish_at.UTC().Format(time.RFC3339),
+		Timestamp: publish_at.UnixNano(),
+	}
+
+	err := p.Schedule.AddScheduledPost(ctx, scheduled)
+	if err != nil {
+		return scheduled, err
+	}
+
+	return scheduled, p.enqueue(ctx, scheduled)
+}
+
+// Update saves the edited scheduled post. When the time changes one more task
+// is sent, the stale one finds nothing to do.
+func (p *Publisher) Update(ctx context.Context, scheduled storage.ScheduledPost, publish_at time.Time) (storage.ScheduledPost, error) {
+	moved := publish_at.UnixNano() != scheduled.Timestamp
+	scheduled.PublishAt = publish_at.UTC().Format(time.RFC3339)
+	scheduled.Timestamp = publish_at.UnixNano()
+
+	err := p.Schedule.UpdateScheduledPost(ctx, scheduled)
+	if err != nil || !moved {
+		return scheduled, err
+	}
+
+	return scheduled, p.enqueue(ctx, scheduled)
+}
+
+func (p *Publisher) enqueue(ctx context.Context, scheduled storage.ScheduledPost) error {
+	return p.Queue.Send(ctx, queue.Task{
+		Name:    PublishTask,
+		Payload: scheduled.Post.Id,
+		ETA:     time.Unix(0, scheduled.Timestamp),
+	})
+}
+
+// Publish is the handler of PublishTask. The post gets the publishing time
+// and goes through the usual PostPost fan-out.
+func (p *Publisher) Publish(ctx context.Context, postId string) error {
+	scheduled, err := p.Schedule.GetScheduledPost(ctx, postId)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			// cancelled or already published
+			return nil
+		}
+		return err
+	}
+
+	// moved to a later time, wait for it
+	if wait := time.Until(time.Unix(0, scheduled.Timestamp)); wait > 0 {
+		return &queue.RetryLater{Delay: wait, Err: fmt.Errorf("post %v is scheduled at %v", postId, scheduled.PublishAt)}
+	}
+
+	// held until the suspension is lifted, the author may still cancel it
+	if p.Suspensions != nil {
+		_, err = p.Suspensions.GetSuspension(ctx, scheduled.Post.AuthorId)
+		if err == nil {
+			return &queue.RetryLater{Delay: p.SuspendedDelay, Err: fmt.Errorf("author of post %v is suspended", postId)}
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			return &queue.RetryLater{Delay: time.Minute, Err: err}
+		}
+	}
+
+	// whoever deletes it publishes it
+	err = p.Schedule.DeleteScheduledPost(ctx, postId)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	time_now := time.Now()
+	post := scheduled.Post
+	post.CreatedAt = time_now.UTC().Format("2006-01-02T15:04:05Z")
+	post.LastModifiedAt = post.CreatedAt
+	post.Timestamp = time_now.UnixNano()
+
+	err = p.Storage.PostPost(ctx, post)
+	if errors.Is(err, storage.ErrCollision) {
+		log.Println("Scheduled post", postId, "is already published")
+		return nil
+	}
+	if err != nil {
+		// put it back, so that it is not lost
+		if add_err := p.Schedule.AddScheduledPost(ctx, scheduled); add_err != nil {
+			log.Println("Failed to restore scheduled post", postId, "due to an error:", add_err)
+		}
+		return &queue.RetryLater{Delay: time.Minute, Err: err}
+	}
+
+	if post.Moderation == "" {
+		p.Webhooks.Dispatch(ctx, post.AuthorId, storage.EventPostCreated, post)
+	}
+	if p.Moderation != nil {
+		if err = p.Moderation.Saved(ctx, post); err != nil {
+			log.Println("Failed to queue moderation of post", postId, "due to an error:", err)
+		}
+	}
+
+	return nil
+}
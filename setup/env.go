@@ -0,0 +1,138 @@
+package setup
+
+import (
+	"log"
+	"microblog/events"
+	"microblog/handlers"
+	"microblog/moderation"
+	"microblog/pubsub"
+	"microblog/queue"
+	"microblog/storage"
+	"microblog/storage/blobfs"
+	"microblog/storage/cacheredis"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// newRedisClient connects to REDIS_URL, nil when it is not set.
+func newRedisClient() *redis.Client {
+	redisUrl := os.Getenv("REDIS_URL")
+	if redisUrl == "" {
+		return nil
+	}
+
+	opts, err := redis.ParseURL(redisUrl)
+	if err != nil {
+		opts = &redis.Options{Addr: redisUrl}
+	}
+	return redis.NewClient(opts)
+}
+
+// NewPubSub uses Redis when REDIS_URL is set, so that several instances of
+// the server see each other's events, and go channels otherwise.
+func NewPubSub() pubsub.PubSub {
+	client := newRedisClient()
+	if client == nil {
+		return pubsub.NewLocal()
+	}
+	return pubsub.NewRedis(client)
+}
+
+// newEventRelay relays the outbox of source to bus and, when REDIS_URL is
+// set, to a Redis stream.
+func newEventRelay(source storage.EventSource, bus *events.Bus) *events.Relay {
+	publishers := []events.Publisher{bus}
+	if client := newRedisClient(); client != nil {
+		publishers = append(publishers, events.NewRedisStream(client))
+	}
+	return events.NewRelay(source, publishers...)
+}
+
+// postStore is what the cache of posts is put in front of. Erasures go
+// through the cache too, so that the erased posts leave it.
+type postStore interface {
+	storage.Storage
+	storage.ErasureStore
+}
+
+// newPostStorage puts a Redis cache of posts in front of store when
+// REDIS_URL is set. The cache is shared by all instances, the bus of
+// newEventBus keeps it right.
+func newPostStorage(store postStore) postStore {
+	client := newRedisClient()
+	if client == nil {
+		return store
+	}
+	return cacheredis.NewStorage(store, client)
+}
+
+// newEventBus subscribes the consumers of the outbox in this process: the
+// cache of posts, which also has to drop posts changed without it.
+func newEventBus(store storage.Storage) *events.Bus {
+	bus := events.NewBus()
+	if client := newRedisClient(); client != nil {
+		bus.Subscribe(cacheredis.NewStorage(store, client).HandleEvent)
+	}
+	return bus
+}
+
+// newBlobStore keeps media in MEDIA_DIR when it is set and in GridFS otherwise.
+func newBlobStore(mongostorage storage.BlobStore) storage.BlobStore {
+	mediaDir := os.Getenv("MEDIA_DIR")
+	if mediaDir == "" {
+		return mongostorage
+	}
+	return blobfs.NewStorage(mediaDir)
+}
+
+// postLimits reads MAX_POST_LENGTH (in characters), MAX_POST_BODY_SIZE (in
+// bytes) and MAX_BATCH_SIZE (in posts), unset or wrong values leave the
+// defaults.
+func postLimits() handlers.PostLimits {
+	var limits handlers.PostLimits
+	limits.MaxTextLength, _ = strconv.Atoi(os.Getenv("MAX_POST_LENGTH"))
+	limits.MaxBodySize, _ = strconv.ParseInt(os.Getenv("MAX_POST_BODY_SIZE"), 10, 64)
+	limits.MaxBatchSize, _ = strconv.Atoi(os.Getenv("MAX_BATCH_SIZE"))
+	return limits
+}
+
+// newModeration checks posts against the word list in MODERATION_FILE,
+// without it posts are only reviewed by hand. With MODERATION_MODE=worker the
+// posts are checked by a queue task after saving, otherwise in the request.
+func newModeration(s storage.Storage, store storage.ModerationStore, q queue.Queue) *moderation.Pipeline {
+	var moderator moderation.Moderator
+	if path := os.Getenv("MODERATION_FILE"); path != "" {
+		list, err := moderation.LoadWordList(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		moderator = list
+	}
+
+	if os.Getenv("MODERATION_MODE") != "worker" {
+		q = nil
+	}
+	return moderation.NewPipeline(moderator, s, store, q)
+}
+
+// adminUsers reads the comma separated ids of ADMIN_USERS.
+func adminUsers() []string {
+	admins := make([]string, 0)
+	for _, user := range strings.Split(os.Getenv("ADMIN_USERS"), ",") {
+		if user = strings.TrimSpace(user); user != "" {
+			admins = append(admins, user)
+		}
+	}
+	return admins
+}
+
+// feedSweepInterval reads FEED_SWEEP_INTERVAL (like "1h"), unset or wrong
+// values disable the feed sweeper.
+func feedSweepInterval() time.Duration {
+	interval, _ := time.ParseDuration(os.Getenv("FEED_SWEEP_INTERVAL"))
+	return interval
+}
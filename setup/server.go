@@ -0,0 +1,88 @@
+package setup
+
+import (
+	"microblog/handlers"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// NewServer builds the API router on top of handler. All dependencies
+// (storage and friends) are taken from handler, so tests can inject their own.
+func NewServer(addr string, handler *handlers.HTTPHandler) *http.Server {
+	r := mux.NewRouter()
+
+	r.HandleFunc("/", handlers.HandleRoot)
+	r.HandleFunc("/api/v1/posts", handler.HandlePostAPost).Methods("POST")
+	r.HandleFunc("/api/v1/posts:batchGet", handler.HandleBatchGetPosts).Methods("POST")
+	r.HandleFunc("/api/v1/posts/{postId:[A-Za-z0-9_\\-]+}", handler.HandleGetThePost).Methods("GET")
+	r.HandleFunc("/api/v1/posts/{postId:[A-Za-z0-9_\\-]+}", handler.HandleChangeThePostText).Methods("PATCH")
+	r.HandleFunc("/api/v1/posts/{postId:[A-Za-z0-9_\\-]+}/visibility", handler.HandleChangeThePostVisibility).Methods("PUT")
+	r.HandleFunc("/api/v1/posts/{postId:[A-Za-z0-9_\\-]+}/pin", handler.HandlePinPost).Methods("POST")
+	r.HandleFunc("/api/v1/posts/{postId:[A-Za-z0-9_\\-]+}/pin", handler.HandleUnpinPost).Methods("DELETE")
+	r.HandleFunc("/api/v1/posts/{postId:[A-Za-z0-9_\\-]+}/poll/vote", handler.HandleVotePoll).Methods("POST")
+	r.HandleFunc("/api/v1/posts/{postId:[A-Za-z0-9_\\-]+}/report", handler.HandleReportPost).Methods("POST")
+	r.HandleFunc("/api/v1/users/{userId:[0-9a-f]+}/posts", handler.HandleGetThePostLine).Methods("GET")
+	r.HandleFunc("/maintenance/ping", handler.PingHandler).Methods("GET")
+
+	r.HandleFunc("/api/v1/users/{userId:[0-9a-f]+}/subscribe", handler.HandleSubscribe).Methods("POST")
+	r.HandleFunc("/api/v1/users/{userId:[0-9a-f]+}/block", handler.HandleBlock).Methods("POST")
+	r.HandleFunc("/api/v1/users/{userId:[0-9a-f]+}/block", handler.HandleUnblock).Methods("DELETE")
+	r.HandleFunc("/api/v1/users/{userId:[0-9a-f]+}/mute", handler.HandleMute).Methods("POST")
+	r.HandleFunc("/api/v1/users/{userId:[0-9a-f]+}/mute", handler.HandleUnmute).Methods("DELETE")
+	r.HandleFunc("/api/v1/subscriptions", handler.HandleGetSubscriptions).Methods("GET")
+	r.HandleFunc("/api/v1/subscribers", handler.HandleGetSubscribers).Methods("GET")
+	r.HandleFunc("/api/v1/settings", handler.HandleGetSettings).Methods("GET")
+	r.HandleFunc("/api/v1/settings", handler.HandleUpdateSettings).Methods("PUT")
+	r.HandleFunc("/api/v1/me", handler.HandleEraseAccount).Methods("DELETE")
+	r.HandleFunc("/api/v1/me/erasure", handler.HandleGetErasure).Methods("GET")
+	r.HandleFunc("/api/v1/me/export", handler.HandleExportAccount).Methods("GET")
+	r.HandleFunc("/api/v1/scheduled-posts", handler.HandleGetScheduledPosts).Methods("GET")
+	r.HandleFunc("/api/v1/scheduled-posts/{postId:[A-Za-z0-9_\\-]+}", handler.HandleUpdateScheduledPost).Methods("PUT")
+	r.HandleFunc("/api/v1/scheduled-posts/{postId:[A-Za-z0-9_\\-]+}", handler.HandleCancelScheduledPost).Methods("DELETE")
+	r.HandleFunc("/api/v1/media", handler.HandleUploadMedia).Methods("POST")
+	r.HandleFunc("/api/v1/media/{mediaId:[A-Za-z0-9_\\-]+}", handler.HandleGetMedia).Methods("GET")
+	r.HandleFunc("/api/v1/drafts", handler.HandleCreateDraft).Methods("POST")
+	r.HandleFunc("/api/v1/drafts", handler.HandleGetDrafts).Methods("GET")
+	r.HandleFunc("/api/v1/drafts/{draftId:[A-Za-z0-9_\\-]+}", handler.HandleGetDraft).Methods("GET")
+	r.HandleFunc("/api/v1/drafts/{draftId:[A-Za-z0-9_\\-]+}", handler.HandleUpdateDraft).Methods("PUT")
+	r.HandleFunc("/api/v1/drafts/{draftId:[A-Za-z0-9_\\-]+}", handler.HandleDeleteDraft).Methods("DELETE")
+	r.HandleFunc("/api/v1/drafts/{draftId:[A-Za-z0-9_\\-]+}/publish", handler.HandlePublishDraft).Methods("POST")
+	r.HandleFunc("/api/v1/follow-requests", handler.HandleGetFollowRequests).Methods("GET")
+	r.HandleFunc("/api/v1/follow-requests/{userId:[0-9a-f]+}/approve", handler.HandleApproveFollowRequest).Methods("POST")
+	r.HandleFunc("/api/v1/follow-requests/{userId:[0-9a-f]+}/reject", handler.HandleRejectFollowRequest).Methods("POST")
+	r.HandleFunc("/api/v1/feed", handler.GetFeed).Methods("GET") // behave like posts
+	r.HandleFunc("/api/v1/feed/new-count", handler.GetFeedNewCount).Methods("GET")
+	r.HandleFunc("/api/v1/feed/stream", handler.HandleFeedStream).Methods("GET")
+	r.HandleFunc("/api/v1/ws", handler.HandleWebSocket).Methods("GET")
+
+	r.HandleFunc("/api/v1/webhooks", handler.HandleCreateWebhook).Methods("POST")
+	r.HandleFunc("/api/v1/webhooks", handler.HandleGetWebhooks).Methods("GET")
+	r.HandleFunc("/api/v1/webhooks/{webhookId:[A-Za-z0-9_\\-]+}", handler.HandleDeleteWebhook).Methods("DELETE")
+	r.HandleFunc("/api/v1/webhooks/{webhookId:[A-Za-z0-9_\\-]+}/enable", handler.HandleEnableWebhook).Methods("POST")
+	r.HandleFunc("/api/v1/webhooks/{webhookId:[A-Za-z0-9_\\-]+}/deliveries", handler.HandleGetWebhookDeliveries).Methods("GET")
+
+	r.HandleFunc("/api/v1/admin/flagged-posts", handler.HandleGetFlaggedPosts).Methods("GET")
+	r.HandleFunc("/api/v1/admin/reports", handler.HandleGetReports).Methods("GET")
+	r.HandleFunc("/api/v1/admin/reports/{reportId:[A-Za-z0-9_\\-]+}/resolve", handler.HandleResolveReport).Methods("POST")
+	r.HandleFunc("/api/v1/admin/users/{userId:[0-9a-f]+}/suspend", handler.HandleSuspendUser).Methods("POST")
+	r.HandleFunc("/api/v1/admin/users/{userId:[0-9a-f]+}/suspend", handler.HandleUnsuspendUser).Methods("DELETE")
+	r.HandleFunc("/api/v1/admin/users/{userId:[0-9a-f]+}/rebuild-feed", handler.HandleRebuildFeed).Methods("POST")
+	r.HandleFunc("/api/v1/admin/flagged-posts/{postId:[A-Za-z0-9_\\-]+}/approve", handler.HandleApproveFlaggedPost).Methods("POST")
+	r.HandleFunc("/api/v1/admin/flagged-posts/{postId:[A-Za-z0-9_\\-]+}", handler.HandleRemoveFlaggedPost).Methods("DELETE")
+
+	r.HandleFunc("/api/v1/notifications", handler.HandleGetNotifications).Methods("GET")
+	r.HandleFunc("/api/v1/notifications/read", handler.HandleReadNotifications).Methods("POST")
+	r.HandleFunc("/api/v1/notifications/unread-count", handler.HandleGetUnreadNotificationsCount).Methods("GET")
+
+	// suspended users can only read
+	r.Use(handler.RejectSuspended)
+
+	return &http.Server{
+		Handler:      r,
+		Addr:         addr,
+		WriteTimeout: 15 * time.Second,
+		ReadTimeout:  15 * time.Second,
+	}
+}
@@ -0,0 +1,115 @@
+package setup
+
+import (
+	"context"
+	"microblog/erasure"
+	"microblog/events"
+	"microblog/handlers"
+	"microblog/moderation"
+	"microblog/pubsub"
+	"microblog/queue"
+	"microblog/scheduler"
+	"microblog/storage"
+	"microblog/sweeper"
+	"microblog/webhooks"
+)
+
+// Store is everything the services keep in the database, mongostore has all
+// of it.
+type Store interface {
+	postStore
+	storage.BlobStore
+	storage.WebhookStore
+	storage.ModerationStore
+	storage.ScheduleStore
+	storage.NotificationStore
+	storage.RelationStore
+	storage.AccountStore
+	storage.DraftStore
+	storage.MediaStore
+	storage.ReportStore
+	storage.SuspensionStore
+	storage.FeedStore
+	storage.EventSource
+	storage.PendingWriteStore
+}
+
+// Services are built the same way by the server and the worker, only the
+// queue between them differs.
+type Services struct {
+	store Store
+	posts postStore
+	blobs storage.BlobStore
+
+	Webhooks   *webhooks.Dispatcher
+	Moderation *moderation.Pipeline
+	Scheduler  *scheduler.Publisher
+	Eraser     *erasure.Eraser
+}
+
+// NewServices configures the services from the environment, their tasks go
+// to q.
+func NewServices(store Store, q queue.Queue) *Services {
+	posts := newPostStorage(store)
+	blobs := newBlobStore(store)
+
+	dispatcher := webhooks.NewDispatcher(store, q)
+	pipeline := newModeration(store, store, q)
+	publisher := scheduler.NewPublisher(store, store, q, dispatcher)
+	publisher.Moderation = pipeline
+	publisher.Suspensions = store
+
+	return &Services{
+		store:      store,
+		posts:      posts,
+		blobs:      blobs,
+		Webhooks:   dispatcher,
+		Moderation: pipeline,
+		Scheduler:  publisher,
+		Eraser:     erasure.NewEraser(posts, blobs, q),
+	}
+}
+
+// Tasks maps the queue tasks to their handlers.
+func (s *Services) Tasks() map[string]queue.Handler {
+	return map[string]queue.Handler{
+		webhooks.DeliverTask:    s.Webhooks.Deliver,
+		scheduler.PublishTask:   s.Scheduler.Publish,
+		moderation.ModerateTask: s.Moderation.ModeratePost,
+		erasure.EraseTask:       s.Eraser.Erase,
+	}
+}
+
+// Handler builds the API handler on top of the services.
+func (s *Services) Handler(ps pubsub.PubSub) *handlers.HTTPHandler {
+	return &handlers.HTTPHandler{
+		Storage:       s.posts,
+		PubSub:        ps,
+		Webhooks:      s.Webhooks,
+		Notifications: s.store,
+		Relations:     s.store,
+		Accounts:      s.store,
+		Scheduler:     s.Scheduler,
+		Drafts:        s.store,
+		Media:         s.store,
+		Blobs:         s.blobs,
+		Limits:        postLimits(),
+		Moderation:    s.Moderation,
+		Admins:        adminUsers(),
+		Reports:       s.store,
+		Suspensions:   s.store,
+		Feeds:         s.store,
+		Erasure:       s.Eraser,
+	}
+}
+
+// RunBackground starts sweeping the feeds, finishing interrupted writes and
+// relaying the outbox until ctx is done. Only one process per deployment
+// should run it.
+func (s *Services) RunBackground(ctx context.Context) {
+	if interval := feedSweepInterval(); interval > 0 {
+		go sweeper.NewSweeper(s.store).Run(ctx, interval)
+	}
+	go sweeper.FinishPendingWrites(ctx, s.store, sweeper.PendingAge)
+	go newEventRelay(s.store, newEventBus(s.store)).Run(ctx, events.DefaultInterval)
+}
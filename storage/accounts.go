@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// Subscribe answers with one of these
+const (
+	SubscriptionActive  = "active"
+	SubscriptionPending = "pending"
+)
+
+type AccountSettings struct {
+	// only subscribers see the post line of a private account, and new
+	// subscriptions wait for approval
+	Private bool `json:"private" bson:"private"`
+}
+
+// FollowRequest is a pending subscription of User to the private ToUser.
+type FollowRequest struct {
+	User      string `json:"user" bson:"user"`
+	ToUser    string `json:"toUser" bson:"toUser"`
+	CreatedAt string `json:"createdAt" bson:"createdAt"`
+	Timestamp int64  `json:"-" bson:"time"`
+}
+
+func NewFollowRequest(user string, to_user string) FollowRequest {
+	time_now := time.Now()
+	return FollowRequest{
+		User:      user,
+		ToUser:    to_user,
+		CreatedAt: time_now.UTC().Format("2006-01-02T15:04:05Z"),
+		Timestamp: time_now.UnixNano(),
+	}
+}
+
+// Notification tells the private user about the request.
+func (r FollowRequest) Notification() Notification {
+	return Notification{
+		User:      r.ToUser,
+		Type:      NotificationFollowRequest,
+		Actor:     r.User,
+		CreatedAt: r.CreatedAt,
+		Timestamp: r.Timestamp,
+	}
+}
+
+type FollowRequests struct {
+	Requests []FollowRequest `json:"requests"`
+}
+
+// AccountStore keeps account settings and follow requests. Storage backends
+// create follow requests in Subscribe and check privacy wherever posts are
+// read, in GetPostLine as well as in GetPost and GetPosts.
+type AccountStore interface {
+	GetSettings(ctx context.Context, user string) (AccountSettings, error)
+	UpdateSettings(ctx context.Context, user string, settings AccountSettings) error
+
+	// GetFollowRequests returns pending requests to user, oldest first.
+	GetFollowRequests(ctx context.Context, user string) (FollowRequests, error)
+	// ApproveFollowRequest turns the request of from_user into a subscription
+	// to user, Reject just drops it. Both fail with ErrNotFound without one.
+	ApproveFollowRequest(ctx context.Context, user string, from_user string) error
+	RejectFollowRequest(ctx context.Context, user string, from_user string) error
+}
@@ -0,0 +1,98 @@
+// Package blobfs keeps blobs as files in a local directory.
+package blobfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"microblog/storage"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type storage_struct struct {
+	dir string
+}
+
+// NewStorage stores blobs in dir, creating it if needed.
+func NewStorage(dir string) *storage_struct {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		panic(err)
+	}
+
+	return &storage_struct{dir: dir}
+}
+
+func (s *storage_struct) path(blobId string) (string, error) {
+	if blobId == "" || strings.ContainsAny(blobId, `/\.`) {
+		return "", fmt.Errorf("wrong blob id %v - %w", blobId, storage.ErrNotFound)
+	}
+	return filepath.Join(s.dir, blobId), nil
+}
+
+// PutBlob writes to a temporary file first, so that a blob is either
+// complete or missing.
+func (s *storage_struct) PutBlob(ctx context.Context, blobId string, content io.Reader) error {
+	path, err := s.path(blobId)
+	if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(s.dir, ".upload-")
+	if err != nil {
+		return fmt.Errorf("failed to create blob %v: %v - %w", blobId, err, storage.ErrStorage)
+	}
+	defer os.Remove(file.Name())
+
+	_, err = io.Copy(file, content)
+	if close_err := file.Close(); err == nil {
+		err = close_err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write blob %v: %v - %w", blobId, err, storage.ErrStorage)
+	}
+
+	err = os.Rename(file.Name(), path)
+	if err != nil {
+		return fmt.Errorf("failed to write blob %v: %v - %w", blobId, err, storage.ErrStorage)
+	}
+
+	return nil
+}
+
+func (s *storage_struct) OpenBlob(ctx context.Context, blobId string) (io.ReadSeekCloser, error) {
+	path, err := s.path(blobId)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no blob with id %v - %w", blobId, storage.ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to open blob %v: %v - %w", blobId, err, storage.ErrStorage)
+	}
+
+	return file, nil
+}
+
+func (s *storage_struct) DeleteBlob(ctx context.Context, blobId string) error {
+	path, err := s.path(blobId)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no blob with id %v - %w", blobId, storage.ErrNotFound)
+		}
+		return fmt.Errorf("failed to delete blob %v: %v - %w", blobId, err, storage.ErrStorage)
+	}
+
+	return nil
+}
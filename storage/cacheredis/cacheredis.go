@@ -32,6 +32,24 @@ func (s *storage_struct) save_to_cache(ctx context.Context, post storage.Post) {
 	}
 }
 
+// openAccount tells if everyone may read the posts of user. The cache does
+// not know account settings, so it asks the persistent storage.
+func (s *storage_struct) openAccount(ctx context.Context, user string) bool {
+	accounts, ok := s.persistentStorage.(storage.AccountStore)
+	if !ok {
+		return true
+	}
+	settings, err := accounts.GetSettings(ctx, user)
+	return err == nil && !settings.Private
+}
+
+func (s *storage_struct) delete_from_cache(ctx context.Context, postId string) {
+	err := s.client.Del(ctx, postId).Err()
+	if err != nil {
+		fmt.Println("Failed to delete key ", postId, " from cache due to an error: ", err)
+	}
+}
+
 func (s *storage_struct) PostPost(ctx context.Context, post storage.Post) error {
 	// save to db
 	err := s.persistentStorage.PostPost(ctx, post)
@@ -44,7 +62,7 @@ func (s *storage_struct) PostPost(ctx context.Context, post storage.Post) error
 	return nil
 }
 
-func (s *storage_struct) GetPost(ctx context.Context, postId string) (storage.Post, error) {
+func (s *storage_struct) GetPost(ctx context.Context, postId string, viewer string) (storage.Post, error) {
 	//try to get from cache
 
 	str_post, err := s.client.Get(ctx, postId).Result();
@@ -59,11 +77,14 @@ func (s *storage_struct) GetPost(ctx context.Context, postId string) (storage.Po
 			fmt.Println("From cache we couldn't take", postId, "because of error: ", err)
 		default:
 			json.Unmarshal([]byte(str_post), &post)
-			return post, nil
+			// the cache does not know subscriptions, the db decides the rest
+			if storage.CanSee(post, viewer, false) && (viewer == post.AuthorId || s.openAccount(ctx, post.AuthorId)) {
+				return post, nil
+			}
 	}
 
 	// get from db
-	post, err = s.persistentStorage.GetPost(ctx, postId)
+	post, err = s.persistentStorage.GetPost(ctx, postId, viewer)
 	if err != nil {
 		return post, err
 	}
@@ -73,8 +94,63 @@ func (s *storage_struct) GetPost(ctx context.Context, postId string) (storage.Po
 	return post, nil
 }
 
-func (s *storage_struct) GetPostLine(ctx context.Context, user string, page_token string, size int) (storage.PostLineAnswer, error) {
-	answer, err := s.persistentStorage.GetPostLine(ctx, user, page_token, size)
+// GetPosts reads the cached posts with one MGET, the rest comes from the db
+// and is cached for the next time.
+func (s *storage_struct) GetPosts(ctx context.Context, postIds []string, viewer string) ([]storage.Post, error) {
+	found := make(map[string]storage.Post)
+	missing := make([]string, 0)
+
+	var values []interface{}
+	var err error
+	if len(postIds) > 0 {
+		values, err = s.client.MGet(ctx, postIds...).Result()
+		if err != nil {
+			fmt.Println("From cache we couldn't take", postIds, "because of error: ", err)
+		}
+	}
+
+	open := make(map[string]bool)
+	for i, postId := range postIds {
+		var post storage.Post
+		if i < len(values) {
+			str_post, ok := values[i].(string)
+			// the cache does not know subscriptions, the db decides the rest
+			if ok && json.Unmarshal([]byte(str_post), &post) == nil && storage.CanSee(post, viewer, false) {
+				if _, checked := open[post.AuthorId]; !checked {
+					open[post.AuthorId] = viewer == post.AuthorId || s.openAccount(ctx, post.AuthorId)
+				}
+				if open[post.AuthorId] {
+					found[postId] = post
+					continue
+				}
+			}
+		}
+		missing = append(missing, postId)
+	}
+
+	if len(missing) > 0 {
+		posts, err := s.persistentStorage.GetPosts(ctx, missing, viewer)
+		if err != nil {
+			return nil, err
+		}
+		for _, post := range posts {
+			found[post.Id] = post
+			s.save_to_cache(ctx, post)
+		}
+	}
+
+	posts := make([]storage.Post, 0, len(found))
+	for _, postId := range postIds {
+		if post, ok := found[postId]; ok {
+			posts = append(posts, post)
+		}
+	}
+
+	return posts, nil
+}
+
+func (s *storage_struct) GetPostLine(ctx context.Context, user string, page_token string, size int, viewer string) (storage.PostLineAnswer, error) {
+	answer, err := s.persistentStorage.GetPostLine(ctx, user, page_token, size, viewer)
 	if err != nil {
 		return answer, err
 	}
@@ -95,4 +171,73 @@ func (s *storage_struct) ChangePostText(ctx context.Context, postId string, user
 	s.save_to_cache(ctx, post)
 
 	return post, nil
-}
\ No newline at end of file
+}
+
+func (s *storage_struct) ChangePostVisibility(ctx context.Context, postId string, user string, visibility string, new_time string) (storage.Post, error) {
+	post, err := s.persistentStorage.ChangePostVisibility(ctx, postId, user, visibility, new_time)
+	if err != nil {
+		return post, err
+	}
+
+	s.save_to_cache(ctx, post)
+
+	return post, nil
+}
+
+func (s *storage_struct) PinPost(ctx context.Context, postId string, user string) error {
+	return s.persistentStorage.PinPost(ctx, postId, user)
+}
+
+func (s *storage_struct) UnpinPost(ctx context.Context, postId string, user string) error {
+	return s.persistentStorage.UnpinPost(ctx, postId, user)
+}
+
+// VotePoll drops the cached post instead of saving the returned one, so that
+// concurrent votes can't leave stale results in the cache.
+func (s *storage_struct) VotePoll(ctx context.Context, postId string, user string, option int) (storage.Post, error) {
+	post, err := s.persistentStorage.VotePoll(ctx, postId, user, option)
+	if err != nil {
+		return post, err
+	}
+
+	s.delete_from_cache(ctx, postId)
+
+	return post, nil
+}
+
+func (s *storage_struct) Subscribe(ctx context.Context, user string, to_user string) (string, error) {
+	return s.persistentStorage.Subscribe(ctx, user, to_user)
+}
+
+func (s *storage_struct) GetSubscriptions(ctx context.Context, user string) (storage.Subscriptions, error) {
+	return s.persistentStorage.GetSubscriptions(ctx, user)
+}
+
+func (s *storage_struct) GetSubscribers(ctx context.Context, user string) (storage.Subscribers, error) {
+	return s.persistentStorage.GetSubscribers(ctx, user)
+}
+
+func (s *storage_struct) GetFeed(ctx context.Context, user string, page_token string, size int) (storage.PostLineAnswer, error) {
+	return s.persistentStorage.GetFeed(ctx, user, page_token, size)
+}
+
+func (s *storage_struct) GetPostLineSince(ctx context.Context, user string, since_token string, size int, viewer string) (storage.PostLineAnswer, error) {
+	answer, err := s.persistentStorage.GetPostLineSince(ctx, user, since_token, size, viewer)
+	if err != nil {
+		return answer, err
+	}
+
+	for _, post := range answer.Posts {
+		s.save_to_cache(ctx, post)
+	}
+
+	return answer, nil
+}
+
+func (s *storage_struct) GetFeedSince(ctx context.Context, user string, since_token string, size int) (storage.PostLineAnswer, error) {
+	return s.persistentStorage.GetFeedSince(ctx, user, since_token, size)
+}
+
+func (s *storage_struct) CountFeedSince(ctx context.Context, user string, since_token string) (int64, error) {
+	return s.persistentStorage.CountFeedSince(ctx, user, since_token)
+}
@@ -0,0 +1,212 @@
+package cacheredis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"microblog/storage"
+	"microblog/storage/localstorage"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// newTestStorage wraps a local storage with a cache kept in miniredis, the
+// local storage is returned too to change it behind the cache.
+func newTestStorage(t *testing.T) (*storage_struct, storage.Storage, *miniredis.Miniredis) {
+	m := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	persistent := localstorage.NewStorage(nil)
+	return NewStorage(persistent, client), persistent, m
+}
+
+func cachedPost(t *testing.T, m *miniredis.Miniredis, postId string) (storage.Post, bool) {
+	var post storage.Post
+	str_post, err := m.Get(postId)
+	if err != nil {
+		return post, false
+	}
+	if err := json.Unmarshal([]byte(str_post), &post); err != nil {
+		t.Fatalf("cached %v is not a post: %v", postId, err)
+	}
+	return post, true
+}
+
+func TestGetPostFromCache(t *testing.T) {
+	ctx := context.Background()
+	s, persistent, m := newTestStorage(t)
+
+	if err := s.PostPost(ctx, storage.Post{Id: "p1", Text: "hello", AuthorId: "a1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cachedPost(t, m, "p1"); !ok {
+		t.Fatalf("p1 is not cached after PostPost")
+	}
+
+	// a hit is served without asking the db
+	m.Set("p1", `{"id":"p1","text":"from cache","authorId":"a1"}`)
+	post, err := s.GetPost(ctx, "p1", "b2")
+	if err != nil || post.Text != "from cache" {
+		t.Errorf("cache hit: got %+v, %v", post, err)
+	}
+
+	// a miss is read from the db and cached
+	m.Del("p1")
+	post, err = s.GetPost(ctx, "p1", "b2")
+	if err != nil || post.Text != "hello" {
+		t.Errorf("cache miss: got %+v, %v", post, err)
+	}
+	if _, ok := cachedPost(t, m, "p1"); !ok {
+		t.Errorf("p1 is not cached after GetPost")
+	}
+
+	if _, err := persistent.Subscribe(ctx, "b2", "a1"); err != nil {
+		t.Fatal(err)
+	}
+	err = s.PostPost(ctx, storage.Post{Id: "p2", Text: "friends only", AuthorId: "a1", Visibility: storage.VisibilityFollowers})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the cached copy doesn't let strangers in
+	if post, err := s.GetPost(ctx, "p2", "z9"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("stranger: got %+v, %v", post, err)
+	}
+	if post, err := s.GetPost(ctx, "p2", ""); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("anonymous: got %+v, %v", post, err)
+	}
+	// and followers are let in by the db
+	if post, err := s.GetPost(ctx, "p2", "b2"); err != nil || post.Text != "friends only" {
+		t.Errorf("follower: got %+v, %v", post, err)
+	}
+	if post, err := s.GetPost(ctx, "p2", "a1"); err != nil || post.Text != "friends only" {
+		t.Errorf("author: got %+v, %v", post, err)
+	}
+
+	// nor the posts of a private account
+	if err = persistent.(storage.AccountStore).UpdateSettings(ctx, "a1", storage.AccountSettings{Private: true}); err != nil {
+		t.Fatal(err)
+	}
+	if post, err := s.GetPost(ctx, "p1", "z9"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("stranger, private account: got %+v, %v", post, err)
+	}
+	if posts, err := s.GetPosts(ctx, []string{"p1"}, "z9"); err != nil || len(posts) != 0 {
+		t.Errorf("stranger, private account: got %+v, %v", posts, err)
+	}
+	if post, err := s.GetPost(ctx, "p1", "b2"); err != nil || post.Text != "hello" {
+		t.Errorf("follower, private account: got %+v, %v", post, err)
+	}
+}
+
+func TestGetPostsFromCache(t *testing.T) {
+	ctx := context.Background()
+	s, persistent, m := newTestStorage(t)
+
+	// saved behind the cache
+	for _, post := range []storage.Post{
+		{Id: "p1", Text: "one", AuthorId: "a1"},
+		{Id: "p2", Text: "two", AuthorId: "a1"},
+		{Id: "p3", Text: "three", AuthorId: "a1", Visibility: storage.VisibilityFollowers},
+	} {
+		if err := persistent.PostPost(ctx, post); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.Set("p2", `{"id":"p2","text":"two from cache","authorId":"a1"}`)
+
+	posts, err := s.GetPosts(ctx, []string{"p3", "p2", "missing", "p1"}, "b2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	texts := make([]string, 0, len(posts))
+	for _, post := range posts {
+		texts = append(texts, post.Text)
+	}
+	if len(texts) != 2 || texts[0] != "two from cache" || texts[1] != "one" {
+		t.Errorf("got %q, want the cached p2 and p1 from the db in request order", texts)
+	}
+
+	// the misses are backfilled, what the viewer can't see isn't
+	if post, ok := cachedPost(t, m, "p1"); !ok || post.Text != "one" {
+		t.Errorf("p1 is not backfilled: %+v", post)
+	}
+	if _, ok := cachedPost(t, m, "p3"); ok {
+		t.Errorf("p3 is cached although b2 can't see it")
+	}
+	if m.Exists("missing") {
+		t.Errorf("missing post is cached")
+	}
+
+	posts, err = s.GetPosts(ctx, nil, "b2")
+	if err != nil || len(posts) != 0 {
+		t.Errorf("no ids: got %+v, %v", posts, err)
+	}
+}
+
+func TestVotePollDropsCachedPost(t *testing.T) {
+	ctx := context.Background()
+	s, _, m := newTestStorage(t)
+
+	poll := &storage.Poll{
+		Options:  []storage.PollOption{{Text: "tea"}, {Text: "coffee"}},
+		ClosesAt: time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+	}
+	if err := s.PostPost(ctx, storage.Post{Id: "p1", Text: "tea or coffee?", AuthorId: "a1", Poll: poll}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.VotePoll(ctx, "p1", "b2", 1); err != nil {
+		t.Fatal(err)
+	}
+	if m.Exists("p1") {
+		t.Errorf("p1 is still cached after the vote")
+	}
+
+	post, err := s.GetPost(ctx, "p1", "c3")
+	if err != nil || post.Poll == nil || post.Poll.Options[1].Votes != 1 {
+		t.Errorf("after the vote: got %+v, %v", post, err)
+	}
+
+	// a failed vote leaves the cache alone
+	if _, err := s.VotePoll(ctx, "p1", "b2", 0); !errors.Is(err, storage.ErrCollision) {
+		t.Errorf("second vote: got %v", err)
+	}
+	if !m.Exists("p1") {
+		t.Errorf("p1 is dropped after a failed vote")
+	}
+}
+
+func TestEraseAccountDropsCachedPosts(t *testing.T) {
+	ctx := context.Background()
+	s, _, m := newTestStorage(t)
+
+	for _, post := range []storage.Post{
+		{Id: "p1", Text: "one", AuthorId: "a1"},
+		{Id: "p2", Text: "two", AuthorId: "a1"},
+		{Id: "p3", Text: "three", AuthorId: "b2"},
+	} {
+		if err := s.PostPost(ctx, post); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	erased, err := s.EraseAccount(ctx, "a1")
+	if err != nil || len(erased.PostIds) != 2 {
+		t.Fatalf("got %+v, %v", erased, err)
+	}
+	for _, postId := range []string{"p1", "p2"} {
+		if m.Exists(postId) {
+			t.Errorf("%v is still cached", postId)
+		}
+		if _, err := s.GetPost(ctx, postId, "b2"); !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("%v: got %v", postId, err)
+		}
+	}
+	if !m.Exists("p3") {
+		t.Errorf("p3 of another user is dropped")
+	}
+}
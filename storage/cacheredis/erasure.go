@@ -0,0 +1,50 @@
+package cacheredis
+
+import (
+	"context"
+	"fmt"
+	"microblog/storage"
+)
+
+func (s *storage_struct) erasures() (storage.ErasureStore, error) {
+	erasures, ok := s.persistentStorage.(storage.ErasureStore)
+	if !ok {
+		return nil, fmt.Errorf("erasure is not supported - %w", storage.ErrStorage)
+	}
+	return erasures, nil
+}
+
+func (s *storage_struct) SaveErasure(ctx context.Context, erasure storage.Erasure) error {
+	erasures, err := s.erasures()
+	if err != nil {
+		return err
+	}
+
+	return erasures.SaveErasure(ctx, erasure)
+}
+
+func (s *storage_struct) GetErasure(ctx context.Context, user string) (storage.Erasure, error) {
+	erasures, err := s.erasures()
+	if err != nil {
+		return storage.Erasure{}, err
+	}
+
+	return erasures.GetErasure(ctx, user)
+}
+
+// EraseAccount passes through to the persistent storage and drops the erased
+// posts from the cache.
+func (s *storage_struct) EraseAccount(ctx context.Context, user string) (storage.ErasedAccount, error) {
+	erasures, err := s.erasures()
+	if err != nil {
+		return storage.ErasedAccount{}, err
+	}
+
+	// what was erased before a failure is dropped too
+	erased, err := erasures.EraseAccount(ctx, user)
+	for _, postId := range erased.PostIds {
+		s.delete_from_cache(ctx, postId)
+	}
+
+	return erased, err
+}
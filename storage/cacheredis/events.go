@@ -0,0 +1,17 @@
+package cacheredis
+
+import (
+	"context"
+	"microblog/storage"
+)
+
+// HandleEvent drops the cached copy of a changed post. Subscribed to the
+// events of the outbox it keeps the cache right for changes that are made
+// without this storage, like removals by moderators.
+func (s *storage_struct) HandleEvent(ctx context.Context, event storage.Event) error {
+	switch event.Type {
+	case storage.EventPostEdited, storage.EventPostDeleted, storage.EventPostFlagged, storage.EventPostApproved:
+		s.delete_from_cache(ctx, event.Post.Id)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package cacheredis
+
+import (
+	"context"
+	"fmt"
+	"microblog/storage"
+)
+
+// The ModerationStore methods pass through to the persistent storage and drop
+// the post from the cache, so that a flagged or removed post is not served
+// from it.
+
+func (s *storage_struct) moderation() (storage.ModerationStore, error) {
+	moderation, ok := s.persistentStorage.(storage.ModerationStore)
+	if !ok {
+		return nil, fmt.Errorf("moderation is not supported - %w", storage.ErrStorage)
+	}
+	return moderation, nil
+}
+
+func (s *storage_struct) FlagPost(ctx context.Context, postId string, reason string) (storage.Post, error) {
+	moderation, err := s.moderation()
+	if err != nil {
+		return storage.Post{}, err
+	}
+
+	post, err := moderation.FlagPost(ctx, postId, reason)
+	s.delete_from_cache(ctx, postId)
+
+	return post, err
+}
+
+func (s *storage_struct) GetFlaggedPosts(ctx context.Context) (storage.FlaggedPosts, error) {
+	moderation, err := s.moderation()
+	if err != nil {
+		return storage.FlaggedPosts{}, err
+	}
+
+	return moderation.GetFlaggedPosts(ctx)
+}
+
+func (s *storage_struct) ApprovePost(ctx context.Context, postId string) (storage.Post, error) {
+	moderation, err := s.moderation()
+	if err != nil {
+		return storage.Post{}, err
+	}
+
+	post, err := moderation.ApprovePost(ctx, postId)
+	s.delete_from_cache(ctx, postId)
+
+	return post, err
+}
+
+func (s *storage_struct) RemovePost(ctx context.Context, postId string) (storage.Post, error) {
+	moderation, err := s.moderation()
+	if err != nil {
+		return storage.Post{}, err
+	}
+
+	post, err := moderation.RemovePost(ctx, postId)
+	s.delete_from_cache(ctx, postId)
+
+	return post, err
+}
@@ -0,0 +1,31 @@
+package storage
+
+import "context"
+
+// Draft is an unpublished post, it is only visible to its author through the
+// drafts API and never appears in post lines or feeds.
+type Draft struct {
+	Id             string `json:"id" bson:"id"`
+	AuthorId       string `json:"authorId" bson:"authorId"`
+	Text           string `json:"text" bson:"text"`
+	ReplyTo        string `json:"replyTo,omitempty" bson:"replyTo,omitempty"`
+	Visibility     string `json:"visibility,omitempty" bson:"visibility,omitempty"`
+	CreatedAt      string `json:"createdAt" bson:"createdAt"`
+	LastModifiedAt string `json:"lastModifiedAt" bson:"lastModifiedAt"`
+	Timestamp      int64  `json:"-" bson:"time"`
+}
+
+type Drafts struct {
+	Drafts []Draft `json:"drafts"`
+}
+
+type DraftStore interface {
+	AddDraft(ctx context.Context, draft Draft) error
+	GetDraft(ctx context.Context, draftId string) (Draft, error)
+	// GetDrafts returns the drafts of user, last modified first.
+	GetDrafts(ctx context.Context, user string) (Drafts, error)
+	UpdateDraft(ctx context.Context, draft Draft) error
+	// DeleteDraft fails with ErrNotFound when the draft is already gone, so
+	// only one caller gets to publish it.
+	DeleteDraft(ctx context.Context, draftId string) error
+}
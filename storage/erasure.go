@@ -0,0 +1,50 @@
+package storage
+
+import "context"
+
+// Erasure states
+const (
+	ErasurePending = "pending"
+	ErasureDone    = "done"
+	ErasureFailed  = "failed"
+)
+
+// Erasure is the request of User to erase the account. It is kept after the
+// account is gone, to tell how the erasure went.
+type Erasure struct {
+	User        string `json:"user" bson:"user"`
+	Status      string `json:"status" bson:"status"`
+	RequestedAt string `json:"requestedAt" bson:"requestedAt"`
+	FinishedAt  string `json:"finishedAt,omitempty" bson:"finishedAt,omitempty"`
+	// what was erased, when done
+	Posts         int `json:"posts" bson:"posts"`
+	Subscriptions int `json:"subscriptions" bson:"subscriptions"`
+	Media         int `json:"media" bson:"media"`
+	// why the erasure failed
+	Error string `json:"error,omitempty" bson:"error,omitempty"`
+}
+
+// ErasedAccount tells what EraseAccount removed.
+type ErasedAccount struct {
+	PostIds []string
+	// subscriptions of and to the user
+	Subscriptions int
+	// the metadata is gone, the blobs are left to the caller, as they may be
+	// kept in another BlobStore
+	MediaIds []string
+}
+
+type ErasureStore interface {
+	// SaveErasure replaces the erasure of the user if there is one
+	SaveErasure(ctx context.Context, erasure Erasure) error
+	// GetErasure fails with ErrNotFound when the user asked for none
+	GetErasure(ctx context.Context, user string) (Erasure, error)
+	// EraseAccount removes the posts of user from the posts and all feeds,
+	// the subscriptions, follow requests, blocks and mutes of and to user,
+	// the notifications of user and the ones user caused, the votes of user
+	// with the poll results, the reports by and about user, and the feed,
+	// settings, drafts, scheduled posts, media and webhooks with their
+	// deliveries of user. Removals go to the outbox as usual. It may be run
+	// again when it fails midway.
+	EraseAccount(ctx context.Context, user string) (ErasedAccount, error)
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"microblog/pubsub"
+	"regexp"
+)
+
+// FeedEvent is published to FeedChannel(user) when a new post lands in the
+// feed of user. Token is the feed page token of the post. The same event
+// without a token goes to MentionsChannel(user) of every mentioned user.
+type FeedEvent struct {
+	Token string `json:"token,omitempty"`
+	Post  Post   `json:"post"`
+}
+
+// PostEvent is published to PostChannel(post.Id) when the post changes,
+// Type is EventPostEdited or EventPostDeleted.
+type PostEvent struct {
+	Type string `json:"type"`
+	Post Post   `json:"post"`
+}
+
+func FeedChannel(user string) string {
+	return "feed:" + user
+}
+
+func MentionsChannel(user string) string {
+	return "mentions:" + user
+}
+
+func PostChannel(postId string) string {
+	return "post:" + postId
+}
+
+var mentionRegexp = regexp.MustCompile(`(?:^|[^0-9A-Za-z_])@([0-9a-f]+)\b`)
+
+// Mentions returns the users mentioned in text as @userId, without repeats.
+func Mentions(text string) []string {
+	users := make([]string, 0)
+	seen := make(map[string]bool)
+
+	for _, match := range mentionRegexp.FindAllStringSubmatch(text, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			users = append(users, match[1])
+		}
+	}
+
+	return users
+}
+
+// Publish* functions are called by the storages on writes. ps may be nil,
+// then nobody is listening. Failures are only logged: the change is already
+// saved and listeners can always catch up from the storage.
+
+func PublishFeedEvent(ctx context.Context, ps pubsub.PubSub, user string, event FeedEvent) {
+	publish(ctx, ps, FeedChannel(user), event)
+}
+
+// PublishMentions notifies everybody mentioned in a new post except its author.
+// Flagged posts wait for approval.
+func PublishMentions(ctx context.Context, ps pubsub.PubSub, post Post) {
+	if post.Moderation == ModerationFlagged {
+		return
+	}
+	for _, user := range Mentions(post.Text) {
+		if user != post.AuthorId {
+			publish(ctx, ps, MentionsChannel(user), FeedEvent{Post: post})
+		}
+	}
+}
+
+func PublishPostEvent(ctx context.Context, ps pubsub.PubSub, event PostEvent) {
+	publish(ctx, ps, PostChannel(event.Post.Id), event)
+}
+
+func publish(ctx context.Context, ps pubsub.PubSub, channel string, event interface{}) {
+	if ps == nil {
+		return
+	}
+
+	data, _ := json.Marshal(event)
+	err := ps.Publish(ctx, channel, data)
+	if err != nil {
+		log.Println("Failed to publish to", channel, "due to an error:", err)
+	}
+}
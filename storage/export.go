@@ -0,0 +1,73 @@
+package storage
+
+import "context"
+
+// Kinds of export records, an export has them in this order.
+const (
+	ExportPost         = "post"
+	ExportSubscription = "subscription"
+	ExportFeed         = "feed"
+	ExportVote         = "vote"
+)
+
+var ExportKinds = []string{ExportPost, ExportSubscription, ExportFeed, ExportVote}
+
+// FeedEntry is a post in the feed of User.
+type FeedEntry struct {
+	User      string `json:"user"`
+	PostId    string `json:"postId"`
+	Timestamp int64  `json:"time"`
+}
+
+// ExportRecord is a line of an export, it has one of Post, Subscription, Feed
+// and Vote, as told by Kind.
+type ExportRecord struct {
+	Kind string `json:"kind"`
+	// passed as after, continues the export right behind this record. It
+	// only means something to the storage that made it.
+	Cursor string `json:"cursor"`
+
+	Post *Post `json:"post,omitempty"`
+	// fields of Post that are left out of its JSON
+	PinnedAt   int64 `json:"pinnedAt,omitempty"`
+	PollCloses int64 `json:"pollCloses,omitempty"`
+
+	Subscription *Subscription `json:"subscription,omitempty"`
+	Feed         *FeedEntry    `json:"feed,omitempty"`
+	Vote         *PollVote     `json:"vote,omitempty"`
+}
+
+// ExportStore walks everything of a kind a storage keeps, in a stable order.
+// Posts come in the order they were saved.
+type ExportStore interface {
+	// Export returns up to size records of kind that follow the cursor
+	// after, from the start when after is empty.
+	Export(ctx context.Context, kind string, after string, size int) ([]ExportRecord, error)
+}
+
+// ImportStore writes what an export has without the side effects of PostPost
+// and Subscribe: no notifications, pushes or outbox events. The feeds are
+// filled in as usual.
+type ImportStore interface {
+	// ImportPost fails with ErrCollision when there is a post with the id
+	ImportPost(ctx context.Context, post Post) error
+	// ImportSubscription makes an active subscription, private users
+	// approved it in the source already. It fails with ErrCollision when
+	// there is one.
+	ImportSubscription(ctx context.Context, user string, to_user string) error
+	// ImportVote saves the vote without counting it, the results come with
+	// the post. It fails with ErrCollision when the user has voted already.
+	ImportVote(ctx context.Context, vote PollVote) error
+	// the one of Storage, pins have no side effects
+	PinPost(ctx context.Context, postId string, user string) error
+}
+
+// ExportedPost makes the record of post.
+func ExportedPost(post Post, cursor string) ExportRecord {
+	record := ExportRecord{Kind: ExportPost, Cursor: cursor, Post: &post, PinnedAt: post.PinnedAt}
+	if post.Poll != nil {
+		record.PollCloses = post.Poll.Closes
+	}
+
+	return record
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// FeedRepair tells what RebuildFeed changed in the feed of User.
+type FeedRepair struct {
+	User    string `json:"user"`
+	Added   int    `json:"added"`
+	Removed int    `json:"removed"`
+	// copies of posts that were out of date
+	Updated int `json:"updated"`
+}
+
+// Drifted tells if the feed was out of sync.
+func (r FeedRepair) Drifted() bool {
+	return r.Added != 0 || r.Removed != 0 || r.Updated != 0
+}
+
+// FeedStore repairs the feeds that PostPost and Subscribe fill in. A feed of
+// a user has the posts of everyone the user is subscribed to, as far as the
+// user can see them.
+type FeedStore interface {
+	// RebuildFeed recomputes the feed of user from the subscriptions and the
+	// posts, muted authors stay in the feed as usual.
+	RebuildFeed(ctx context.Context, user string) (FeedRepair, error)
+	// FeedUsers returns up to size users who have a feed or subscriptions,
+	// ordered by id and starting after the given one.
+	FeedUsers(ctx context.Context, after string, size int) ([]string, error)
+}
+
+// PendingWriteStore is implemented by storages that can't always save a post
+// with its feed copies, or a subscription with the copied posts, in one
+// write. They note such writes first, so the ones that stop midway can be
+// finished later.
+type PendingWriteStore interface {
+	// FinishPendingWrites finishes the writes started before the given time
+	// and returns how many there were.
+	FinishPendingWrites(ctx context.Context, before time.Time) (int, error)
+}
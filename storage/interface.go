@@ -13,6 +13,7 @@ var (
 	ErrCollision    = fmt.Errorf("%w: collision", ErrStorage)
 	ErrNotFound     = fmt.Errorf("%w: not found", ErrStorage)
 	ErrUnauthorized = fmt.Errorf("%w: unauthorized action", ErrStorage)
+	ErrForbidden    = fmt.Errorf("%w: forbidden", ErrStorage)
 )
 
 var IsReady bool = false
@@ -24,6 +25,20 @@ type Post struct {
 	CreatedAt      string `json:"createdAt" bson:"createdAt"`
 	LastModifiedAt string `json:"lastModifiedAt" bson:"lastModifiedAt"`
 	Timestamp      int64  `bson:"time"`
+	// id of the post this one answers
+	ReplyTo string `json:"replyTo,omitempty" bson:"replyTo,omitempty"`
+	// one of Visibility*, empty means public
+	Visibility string `json:"visibility,omitempty" bson:"visibility,omitempty"`
+	// users mentioned in Text, kept for storages that query by them
+	MentionedUsers []string `json:"-" bson:"mentions,omitempty"`
+	// when the author pinned the post, zero if not pinned
+	PinnedAt int64 `json:"-" bson:"pinnedAt,omitempty"`
+	// optional poll with its current results
+	Poll *Poll `json:"poll,omitempty" bson:"poll,omitempty"`
+	Attachments []Media `json:"attachments,omitempty" bson:"attachments,omitempty"`
+	// ModerationFlagged while waiting for review, empty otherwise
+	Moderation       string `json:"moderation,omitempty" bson:"moderation,omitempty"`
+	ModerationReason string `json:"moderationReason,omitempty" bson:"moderationReason,omitempty"`
 
 	// mongo id to read docs in the right order
 	MongoID primitive.ObjectID `json:"mongoId,omitempty" bson:"_id,omitempty"`
@@ -37,14 +52,23 @@ type FeedPost struct {
 	Post		Post `bson:"post"`
 }
 
+// PostLineAnswer is a page of posts, newest first. Token points to the next
+// (older) page, PrevToken may be passed as since to get the posts newer than
+// this page. The first page of a post line also has the pinned posts (last
+// pinned first), they are not among Posts of any page.
 type PostLineAnswer struct {
-	Posts []Post `json:"posts"`
-	Token string `json:"nextPage,omitempty"`
+	Pinned    []Post `json:"pinned,omitempty"`
+	Posts     []Post `json:"posts"`
+	Token     string `json:"nextPage,omitempty"`
+	PrevToken string `json:"prevPage,omitempty"`
 }
 
+// MaxPinnedPosts is how many posts an author can pin.
+const MaxPinnedPosts = 3
+
 type Subscription struct {
-	User string `bson:"user"`
-	ToUser string `bson:"toUser"`
+	User string `json:"user" bson:"user"`
+	ToUser string `json:"toUser" bson:"toUser"`
 }
 
 type Subscriptions struct {
@@ -57,12 +81,37 @@ type Subscribers struct {
 
 type Storage interface {
 	PostPost(ctx context.Context, post Post) error
-	GetPost(ctx context.Context, postId string) (Post, error)
-	GetPostLine(ctx context.Context, user string, page_token string, size int) (PostLineAnswer, error)
+	// GetPost fails with ErrNotFound when viewer may not see the post
+	GetPost(ctx context.Context, postId string, viewer string) (Post, error)
+	// GetPosts returns the posts viewer may see among postIds, in the same
+	// order, the others are left out
+	GetPosts(ctx context.Context, postIds []string, viewer string) ([]Post, error)
+	// viewer is the user who reads the post line, empty for anonymous
+	GetPostLine(ctx context.Context, user string, page_token string, size int, viewer string) (PostLineAnswer, error)
 	ChangePostText(ctx context.Context, postId string, user string, new_text string, new_time string) (Post, error)
+	// ChangePostVisibility also adds the post to or removes it from the
+	// feeds of subscribers who can see it now or no more
+	ChangePostVisibility(ctx context.Context, postId string, user string, visibility string, new_time string) (Post, error)
+	// PinPost fails with ErrCollision when user already has MaxPinnedPosts
+	// pinned, pinning a pinned post again is fine
+	PinPost(ctx context.Context, postId string, user string) error
+	UnpinPost(ctx context.Context, postId string, user string) error
+	// VotePoll returns the post with the vote counted. It fails with
+	// ErrNotFound when user can't see the post or it has no such option,
+	// ErrForbidden when the poll is closed and ErrCollision when user has
+	// already voted.
+	VotePoll(ctx context.Context, postId string, user string, option int) (Post, error)
 
-	Subscribe(ctx context.Context, user string, to_user string) error
+	// Subscribe returns SubscriptionPending when to_user is private and the
+	// subscription waits for approval
+	Subscribe(ctx context.Context, user string, to_user string) (string, error)
 	GetSubscriptions(ctx context.Context, user string) (Subscriptions, error)
 	GetSubscribers(ctx context.Context, user string) (Subscribers, error)
 	GetFeed(ctx context.Context, user string, page_token string, size int) (PostLineAnswer, error)
+
+	// *Since methods return the size posts that directly follow since_token
+	// (still newest first), so a client can walk up to the newest post.
+	GetPostLineSince(ctx context.Context, user string, since_token string, size int, viewer string) (PostLineAnswer, error)
+	GetFeedSince(ctx context.Context, user string, since_token string, size int) (PostLineAnswer, error)
+	CountFeedSince(ctx context.Context, user string, since_token string) (int64, error)
 }
\ No newline at end of file
@@ -0,0 +1,90 @@
+package localstorage
+
+import (
+	"context"
+	"microblog/storage"
+)
+
+func (s *storage_struct) GetSettings(ctx context.Context, user string) (storage.AccountSettings, error) {
+	s.storageMu.RLock()
+	defer s.storageMu.RUnlock()
+
+	return s.settings[user], nil
+}
+
+func (s *storage_struct) UpdateSettings(ctx context.Context, user string, settings storage.AccountSettings) error {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	s.settings[user] = settings
+
+	return nil
+}
+
+func (s *storage_struct) GetFollowRequests(ctx context.Context, user string) (storage.FollowRequests, error) {
+	s.storageMu.RLock()
+	defer s.storageMu.RUnlock()
+
+	requests := append(make([]storage.FollowRequest, 0), s.followRequests[user]...)
+
+	return storage.FollowRequests{Requests: requests}, nil
+}
+
+func (s *storage_struct) ApproveFollowRequest(ctx context.Context, user string, from_user string) error {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	if !s.removeFollowRequest(from_user, user) {
+		return storage.ErrNotFound
+	}
+
+	s.subscribe(from_user, user)
+	s.addNotifications([]storage.Notification{storage.SubscriberNotification(from_user, user)})
+
+	return nil
+}
+
+func (s *storage_struct) RejectFollowRequest(ctx context.Context, user string, from_user string) error {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	if !s.removeFollowRequest(from_user, user) {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+// canSeePostLine tells if viewer may read the post line of user.
+// Caller must hold storageMu.
+func (s *storage_struct) canSeePostLine(viewer string, user string) bool {
+	if viewer == user || !s.settings[user].Private {
+		return true
+	}
+	return containsString(s.subscriptions[viewer], user)
+}
+
+// followRequestIndex returns the position of the request of user in the
+// requests to to_user or -1. Caller must hold storageMu.
+func (s *storage_struct) followRequestIndex(to_user string, user string) int {
+	for i, request := range s.followRequests[to_user] {
+		if request.User == user {
+			return i
+		}
+	}
+	return -1
+}
+
+// removeFollowRequest drops the request of user to to_user and tells if
+// there was one. Caller must hold storageMu.
+func (s *storage_struct) removeFollowRequest(user string, to_user string) bool {
+	i := s.followRequestIndex(to_user, user)
+	if i < 0 {
+		return false
+	}
+
+	requests := s.followRequests[to_user]
+	s.followRequests[to_user] = append(requests[:i:i], requests[i+1:]...)
+
+	return true
+}
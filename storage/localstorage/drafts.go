@@ -0,0 +1,76 @@
+package localstorage
+
+import (
+	"context"
+	"fmt"
+	"microblog/storage"
+	"sort"
+)
+
+func (s *storage_struct) AddDraft(ctx context.Context, draft storage.Draft) error {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	if _, ok := s.drafts[draft.Id]; ok {
+		return fmt.Errorf("draft %v already exists - %w", draft.Id, storage.ErrCollision)
+	}
+
+	s.drafts[draft.Id] = draft
+
+	return nil
+}
+
+func (s *storage_struct) GetDraft(ctx context.Context, draftId string) (storage.Draft, error) {
+	s.storageMu.RLock()
+	defer s.storageMu.RUnlock()
+
+	draft, ok := s.drafts[draftId]
+	if !ok {
+		return draft, storage.ErrNotFound
+	}
+
+	return draft, nil
+}
+
+func (s *storage_struct) GetDrafts(ctx context.Context, user string) (storage.Drafts, error) {
+	s.storageMu.RLock()
+	defer s.storageMu.RUnlock()
+
+	drafts := make([]storage.Draft, 0)
+	for _, draft := range s.drafts {
+		if draft.AuthorId == user {
+			drafts = append(drafts, draft)
+		}
+	}
+	sort.Slice(drafts, func(i, j int) bool {
+		return drafts[i].Timestamp > drafts[j].Timestamp
+	})
+
+	return storage.Drafts{Drafts: drafts}, nil
+}
+
+func (s *storage_struct) UpdateDraft(ctx context.Context, draft storage.Draft) error {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	if _, ok := s.drafts[draft.Id]; !ok {
+		return storage.ErrNotFound
+	}
+
+	s.drafts[draft.Id] = draft
+
+	return nil
+}
+
+func (s *storage_struct) DeleteDraft(ctx context.Context, draftId string) error {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	if _, ok := s.drafts[draftId]; !ok {
+		return storage.ErrNotFound
+	}
+
+	delete(s.drafts, draftId)
+
+	return nil
+}
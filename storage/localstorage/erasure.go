@@ -0,0 +1,147 @@
+package localstorage
+
+import (
+	"context"
+	"errors"
+	"microblog/storage"
+)
+
+func (s *storage_struct) SaveErasure(ctx context.Context, erasure storage.Erasure) error {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	s.erasures[erasure.User] = erasure
+
+	return nil
+}
+
+func (s *storage_struct) GetErasure(ctx context.Context, user string) (storage.Erasure, error) {
+	s.storageMu.RLock()
+	defer s.storageMu.RUnlock()
+
+	erasure, ok := s.erasures[user]
+	if !ok {
+		return erasure, storage.ErrNotFound
+	}
+
+	return erasure, nil
+}
+
+func (s *storage_struct) EraseAccount(ctx context.Context, user string) (storage.ErasedAccount, error) {
+	erased := storage.ErasedAccount{PostIds: make([]string, 0)}
+
+	s.storageMu.Lock()
+	// nothing is published after the account is gone
+	for postId, scheduled := range s.scheduled {
+		if scheduled.Post.AuthorId == user {
+			delete(s.scheduled, postId)
+		}
+	}
+	for draftId, draft := range s.drafts {
+		if draft.AuthorId == user {
+			delete(s.drafts, draftId)
+		}
+	}
+	postIds := append([]string{}, s.lines[user]...)
+	hookIds := append([]string{}, s.userWebhooks[user]...)
+	s.storageMu.Unlock()
+
+	// DeleteWebhook takes the deliveries too
+	for _, hookId := range hookIds {
+		err := s.DeleteWebhook(ctx, hookId)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return erased, err
+		}
+	}
+
+	// RemovePost takes the posts out of all feeds
+	for _, postId := range postIds {
+		_, err := s.RemovePost(ctx, postId)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return erased, err
+		}
+		erased.PostIds = append(erased.PostIds, postId)
+	}
+
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	for _, to_user := range append([]string{}, s.subscriptions[user]...) {
+		s.unsubscribe(user, to_user)
+		erased.Subscriptions++
+	}
+	for _, subscriber := range append([]string{}, s.subscribers[user]...) {
+		s.unsubscribe(subscriber, user)
+		erased.Subscriptions++
+	}
+	delete(s.feeds, user)
+	delete(s.lines, user)
+	delete(s.pins, user)
+
+	delete(s.followRequests, user)
+	for to_user := range s.followRequests {
+		s.removeFollowRequest(user, to_user)
+	}
+	delete(s.settings, user)
+	delete(s.notifications, user)
+	delete(s.notificationsRead, user)
+
+	delete(s.blocks, user)
+	delete(s.mutes, user)
+	for other := range s.blocks {
+		delete(s.blocks[other], user)
+	}
+	for other := range s.mutes {
+		delete(s.mutes[other], user)
+	}
+
+	for postId, votes := range s.pollVotes {
+		option, ok := votes[user]
+		if !ok {
+			continue
+		}
+		delete(votes, user)
+		if post, ok := s.storage[postId]; ok && post.Poll != nil && option < len(post.Poll.Options) {
+			post.Poll = post.Poll.WithoutVote(option)
+			s.storage[postId] = post
+		}
+	}
+
+	for reportId, report := range s.reports {
+		if report.Reporter == user || report.AuthorId == user {
+			delete(s.reports, reportId)
+		}
+	}
+
+	for other, notifications := range s.notifications {
+		s.removeActorNotifications(other, notifications, user)
+	}
+
+	erased.MediaIds = make([]string, 0)
+	for mediaId, media := range s.media {
+		if media.Owner == user {
+			delete(s.media, mediaId)
+			erased.MediaIds = append(erased.MediaIds, mediaId)
+		}
+	}
+
+	return erased, nil
+}
+
+// removeActorNotifications drops the notifications of user caused by actor,
+// the read count of user keeps pointing behind the same ones.
+func (s *storage_struct) removeActorNotifications(user string, notifications []storage.Notification, actor string) {
+	read := s.notificationsRead[user]
+	kept := make([]storage.Notification, 0, len(notifications))
+	for i, notification := range notifications {
+		if notification.Actor != actor {
+			kept = append(kept, notification)
+			continue
+		}
+		if i < s.notificationsRead[user] {
+			read--
+		}
+	}
+	s.notifications[user] = kept
+	s.notificationsRead[user] = read
+}
@@ -0,0 +1,245 @@
+package localstorage
+
+import (
+	"context"
+	"fmt"
+	"microblog/storage"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func (s *storage_struct) Export(ctx context.Context, kind string, after string, size int) ([]storage.ExportRecord, error) {
+	s.storageMu.RLock()
+	defer s.storageMu.RUnlock()
+
+	switch kind {
+	case storage.ExportPost:
+		return s.exportPosts(after, size)
+	case storage.ExportSubscription:
+		return s.exportSubscriptions(after, size)
+	case storage.ExportFeed:
+		return s.exportFeeds(after, size)
+	case storage.ExportVote:
+		return s.exportVotes(after, size)
+	}
+
+	return nil, fmt.Errorf("unknown kind %v - %w", kind, storage.ErrNotFound)
+}
+
+// Posts are exported by time, the cursor is the id of a post. There are no
+// pin times here, so PinnedAt only keeps the pins in order.
+//
+// Caller must hold storageMu.
+func (s *storage_struct) exportPosts(after string, size int) ([]storage.ExportRecord, error) {
+	posts := make([]storage.Post, 0, len(s.storage))
+	for _, post := range s.storage {
+		posts = append(posts, post)
+	}
+	sort.Slice(posts, func(i, j int) bool {
+		if posts[i].Timestamp != posts[j].Timestamp {
+			return posts[i].Timestamp < posts[j].Timestamp
+		}
+		return posts[i].Id < posts[j].Id
+	})
+
+	start := 0
+	if after != "" {
+		last, ok := s.storage[after]
+		if !ok {
+			return nil, fmt.Errorf("post %v - %w", after, storage.ErrNotFound)
+		}
+		start = sort.Search(len(posts), func(i int) bool {
+			return posts[i].Timestamp > last.Timestamp ||
+				posts[i].Timestamp == last.Timestamp && posts[i].Id > last.Id
+		})
+	}
+
+	records := make([]storage.ExportRecord, 0, size)
+	for _, post := range posts[start:] {
+		if len(records) == size {
+			break
+		}
+		pins := s.pins[post.AuthorId]
+		post.PinnedAt = 0
+		for i, postId := range pins {
+			if postId == post.Id {
+				post.PinnedAt = int64(len(pins) - i)
+			}
+		}
+		records = append(records, storage.ExportedPost(post, post.Id))
+	}
+
+	return records, nil
+}
+
+// Subscriptions are exported by user and then by to_user, the cursor is
+// both of them.
+//
+// Caller must hold storageMu.
+func (s *storage_struct) exportSubscriptions(after string, size int) ([]storage.ExportRecord, error) {
+	subscriptions := make([]storage.Subscription, 0)
+	for user, to_users := range s.subscriptions {
+		for _, to_user := range to_users {
+			subscriptions = append(subscriptions, storage.Subscription{User: user, ToUser: to_user})
+		}
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptionCursor(subscriptions[i]) < subscriptionCursor(subscriptions[j])
+	})
+
+	records := make([]storage.ExportRecord, 0, size)
+	for i := range subscriptions {
+		cursor := subscriptionCursor(subscriptions[i])
+		if cursor <= after {
+			continue
+		}
+		if len(records) == size {
+			break
+		}
+		records = append(records, storage.ExportRecord{
+			Kind:         storage.ExportSubscription,
+			Cursor:       cursor,
+			Subscription: &subscriptions[i],
+		})
+	}
+
+	return records, nil
+}
+
+func subscriptionCursor(subscription storage.Subscription) string {
+	return subscription.User + " " + subscription.ToUser
+}
+
+// Feeds are exported by user, the posts of a feed in its order. The cursor
+// is the user with the position in the feed.
+//
+// Caller must hold storageMu.
+func (s *storage_struct) exportFeeds(after string, size int) ([]storage.ExportRecord, error) {
+	after_user, position := "", -1
+	if after != "" {
+		parts := strings.SplitN(after, " ", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("bad cursor %v - %w", after, storage.ErrNotFound)
+		}
+		i, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("bad cursor %v - %w", after, storage.ErrNotFound)
+		}
+		after_user, position = parts[0], i
+	}
+
+	users := make([]string, 0, len(s.feeds))
+	for user := range s.feeds {
+		if user >= after_user {
+			users = append(users, user)
+		}
+	}
+	sort.Strings(users)
+
+	records := make([]storage.ExportRecord, 0, size)
+	for _, user := range users {
+		for i, postId := range s.feeds[user] {
+			if user == after_user && i <= position {
+				continue
+			}
+			if len(records) == size {
+				return records, nil
+			}
+			records = append(records, storage.ExportRecord{
+				Kind:   storage.ExportFeed,
+				Cursor: user + " " + strconv.Itoa(i),
+				Feed:   &storage.FeedEntry{User: user, PostId: postId, Timestamp: s.storage[postId].Timestamp},
+			})
+		}
+	}
+
+	return records, nil
+}
+
+// Votes are exported by post and then by user, the cursor is both of them.
+//
+// Caller must hold storageMu.
+func (s *storage_struct) exportVotes(after string, size int) ([]storage.ExportRecord, error) {
+	votes := make([]storage.PollVote, 0)
+	for postId, users := range s.pollVotes {
+		for user, option := range users {
+			votes = append(votes, storage.PollVote{PostId: postId, User: user, Option: option})
+		}
+	}
+	sort.Slice(votes, func(i, j int) bool {
+		return voteCursor(votes[i]) < voteCursor(votes[j])
+	})
+
+	records := make([]storage.ExportRecord, 0, size)
+	for i := range votes {
+		cursor := voteCursor(votes[i])
+		if cursor <= after {
+			continue
+		}
+		if len(records) == size {
+			break
+		}
+		records = append(records, storage.ExportRecord{
+			Kind:   storage.ExportVote,
+			Cursor: cursor,
+			Vote:   &votes[i],
+		})
+	}
+
+	return records, nil
+}
+
+func voteCursor(vote storage.PollVote) string {
+	return vote.PostId + " " + vote.User
+}
+
+func (s *storage_struct) ImportPost(ctx context.Context, post storage.Post) error {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	if _, ok := s.storage[post.Id]; ok {
+		return fmt.Errorf("post %v already exists - %w", post.Id, storage.ErrCollision)
+	}
+
+	s.storage[post.Id] = post
+	s.lines[post.AuthorId] = append(s.lines[post.AuthorId], post.Id)
+	for _, subscriber := range s.subscribers[post.AuthorId] {
+		if storage.CanSee(post, subscriber, true) {
+			s.addToFeed(subscriber, post)
+		}
+	}
+
+	return nil
+}
+
+func (s *storage_struct) ImportSubscription(ctx context.Context, user string, to_user string) error {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	if containsString(s.subscriptions[user], to_user) {
+		return fmt.Errorf("user %v is already subscribed to %v - %w", user, to_user, storage.ErrCollision)
+	}
+
+	s.subscriptions[user] = append(s.subscriptions[user], to_user)
+	s.subscribers[to_user] = append(s.subscribers[to_user], user)
+	s.copyPostsToSubscriber(user, to_user)
+
+	return nil
+}
+
+func (s *storage_struct) ImportVote(ctx context.Context, vote storage.PollVote) error {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	if _, ok := s.pollVotes[vote.PostId][vote.User]; ok {
+		return fmt.Errorf("%v already voted in poll of post %v - %w", vote.User, vote.PostId, storage.ErrCollision)
+	}
+
+	if s.pollVotes[vote.PostId] == nil {
+		s.pollVotes[vote.PostId] = make(map[string]int)
+	}
+	s.pollVotes[vote.PostId][vote.User] = vote.Option
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package localstorage
+
+import (
+	"context"
+	"microblog/storage"
+	"sort"
+)
+
+func (s *storage_struct) RebuildFeed(ctx context.Context, user string) (storage.FeedRepair, error) {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	repair := storage.FeedRepair{User: user}
+
+	feed := make([]string, 0)
+	expected := make(map[string]bool)
+	for _, to_user := range s.subscriptions[user] {
+		for _, postId := range s.lines[to_user] {
+			if storage.CanSee(s.storage[postId], user, true) {
+				feed = append(feed, postId)
+				expected[postId] = true
+			}
+		}
+	}
+	sort.SliceStable(feed, func(i, j int) bool {
+		return s.storage[feed[i]].Timestamp < s.storage[feed[j]].Timestamp
+	})
+
+	current := make(map[string]bool)
+	for _, postId := range s.feeds[user] {
+		if !expected[postId] || current[postId] {
+			repair.Removed++
+		}
+		current[postId] = true
+	}
+	for postId := range expected {
+		if !current[postId] {
+			repair.Added++
+		}
+	}
+
+	if len(feed) > 0 || len(s.feeds[user]) > 0 {
+		s.feeds[user] = feed
+	}
+
+	return repair, nil
+}
+
+func (s *storage_struct) FeedUsers(ctx context.Context, after string, size int) ([]string, error) {
+	s.storageMu.RLock()
+	defer s.storageMu.RUnlock()
+
+	seen := make(map[string]bool)
+	users := make([]string, 0)
+	for _, set := range []map[string][]string{s.feeds, s.subscriptions} {
+		for user, ids := range set {
+			if len(ids) > 0 && user > after && !seen[user] {
+				seen[user] = true
+				users = append(users, user)
+			}
+		}
+	}
+	sort.Strings(users)
+
+	if len(users) > size {
+		users = users[:size]
+	}
+
+	return users, nil
+}
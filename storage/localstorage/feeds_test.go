@@ -0,0 +1,46 @@
+package localstorage
+
+import (
+	"context"
+	"microblog/storage"
+	"testing"
+)
+
+func TestRebuildFeed(t *testing.T) {
+	ctx := context.Background()
+	s := NewStorage(nil)
+
+	if _, err := s.Subscribe(ctx, "b2", "a1"); err != nil {
+		t.Fatal(err)
+	}
+	for i, id := range []string{"p1", "p2", "p3"} {
+		post := storage.Post{Id: id, AuthorId: "a1", Text: id, Timestamp: int64(i + 1)}
+		if err := s.PostPost(ctx, post); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repair, err := s.RebuildFeed(ctx, "b2")
+	if err != nil || repair.Drifted() {
+		t.Fatalf("got %+v, %v for a feed in sync", repair, err)
+	}
+
+	// as if fan-out stopped midway and an unsubscribe was half done
+	s.feeds["b2"] = []string{"p3", "p1", "p1", "gone"}
+
+	repair, err = s.RebuildFeed(ctx, "b2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (storage.FeedRepair{User: "b2", Added: 1, Removed: 2}); repair != want {
+		t.Errorf("got %+v, want %+v", repair, want)
+	}
+	if feed := s.feeds["b2"]; len(feed) != 3 || feed[0] != "p1" || feed[1] != "p2" || feed[2] != "p3" {
+		t.Errorf("got feed %v", feed)
+	}
+
+	users, err := s.FeedUsers(ctx, "", 10)
+	if err != nil || len(users) != 1 || users[0] != "b2" {
+		t.Errorf("got feed users %v, %v", users, err)
+	}
+}
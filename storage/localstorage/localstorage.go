@@ -2,22 +2,94 @@ package localstorage
 
 import (
 	"context"
+	"fmt"
+	"microblog/pubsub"
 	"microblog/storage"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 )
 
 type storage_struct struct {
-	storageMu sync.RWMutex
-	storage   map[string]storage.Post
-	lines     map[string][]string
+	storageMu     sync.RWMutex
+	storage       map[string]storage.Post
+	lines         map[string][]string
+	subscriptions map[string][]string
+	subscribers   map[string][]string
+	feeds         map[string][]string
+
+	webhooks     map[string]storage.Webhook
+	userWebhooks map[string][]string
+	deliveries   map[string]storage.WebhookDelivery
+	deliveryLog  map[string][]string
+
+	notifications     map[string][]storage.Notification
+	notificationsRead map[string]int
+
+	// user -> set of blocked / muted users
+	blocks map[string]map[string]bool
+	mutes  map[string]map[string]bool
+
+	settings map[string]storage.AccountSettings
+	// to_user -> pending requests, oldest first
+	followRequests map[string][]storage.FollowRequest
+
+	// user -> pinned posts, last pinned first
+	pins map[string][]string
+
+	// postId -> user -> option voted for
+	pollVotes map[string]map[string]int
+
+	scheduled map[string]storage.ScheduledPost
+	drafts    map[string]storage.Draft
+	media     map[string]storage.Media
+
+	reports     map[string]storage.Report
+	suspensions map[string]storage.Suspension
+	erasures    map[string]storage.Erasure
+
+	// outbox, the id of an event is its position counting from 1
+	events       []storage.Event
+	eventCursors map[string]string
+	eventLeases  map[string]eventLease
+
+	pubsub pubsub.PubSub
 }
 
-func NewStorage() *storage_struct {
+// NewStorage creates an in-memory storage. Fan-out is published to ps
+// (usually pubsub.NewLocal()), ps may be nil.
+func NewStorage(ps pubsub.PubSub) *storage_struct {
 	new_storage := storage_struct{
-		storage: make(map[string]storage.Post),
-		lines:   make(map[string][]string),
+		pubsub:        ps,
+		storage:       make(map[string]storage.Post),
+		lines:         make(map[string][]string),
+		subscriptions: make(map[string][]string),
+		subscribers:   make(map[string][]string),
+		feeds:         make(map[string][]string),
+		webhooks:      make(map[string]storage.Webhook),
+		userWebhooks:  make(map[string][]string),
+		deliveries:    make(map[string]storage.WebhookDelivery),
+		deliveryLog:   make(map[string][]string),
+
+		notifications:     make(map[string][]storage.Notification),
+		notificationsRead: make(map[string]int),
+
+		blocks: make(map[string]map[string]bool),
+		mutes:  make(map[string]map[string]bool),
+
+		settings:       make(map[string]storage.AccountSettings),
+		followRequests: make(map[string][]storage.FollowRequest),
+		pins:           make(map[string][]string),
+		pollVotes:      make(map[string]map[string]int),
+		scheduled:      make(map[string]storage.ScheduledPost),
+		drafts:         make(map[string]storage.Draft),
+		media:          make(map[string]storage.Media),
+		reports:        make(map[string]storage.Report),
+		suspensions:    make(map[string]storage.Suspension),
+		erasures:       make(map[string]storage.Erasure),
+		eventCursors:   make(map[string]string),
+		eventLeases:    make(map[string]eventLease),
 	}
 
 	storage.IsReady = true
@@ -26,36 +98,92 @@ func NewStorage() *storage_struct {
 
 func (s *storage_struct) PostPost(ctx context.Context, post storage.Post) error {
 	s.storageMu.Lock()
-	
+
+	if _, ok := s.storage[post.Id]; ok {
+		s.storageMu.Unlock()
+		return fmt.Errorf("post %v already exists - %w", post.Id, storage.ErrCollision)
+	}
+
 	s.storage[post.Id] = post
 
 	user_posts := s.lines[post.AuthorId]
 	s.lines[post.AuthorId] = append(user_posts, post.Id)
 
+	// добавить также в feed всем, кто подписан на post.authorId
+	subscribers := make([]string, 0, len(s.subscribers[post.AuthorId]))
+	for _, subscriber := range s.subscribers[post.AuthorId] {
+		if !storage.CanSee(post, subscriber, true) {
+			continue
+		}
+		s.addToFeed(subscriber, post)
+		// muted posts stay in the feed, but are not pushed
+		if !s.mutes[subscriber][post.AuthorId] {
+			subscribers = append(subscribers, subscriber)
+		}
+	}
+
+	reply_to_author := ""
+	if parent, ok := s.storage[post.ReplyTo]; ok && post.ReplyTo != "" && s.canSee(post, parent.AuthorId) {
+		reply_to_author = parent.AuthorId
+	}
+	s.addNotifications(storage.PostNotifications(post, reply_to_author))
+	s.addEvent(storage.PostChanged(storage.EventPostCreated, post))
+
 	s.storageMu.Unlock()
 
+	for _, subscriber := range subscribers {
+		storage.PublishFeedEvent(ctx, s.pubsub, subscriber, storage.FeedEvent{Token: post.Id, Post: post})
+	}
+	storage.PublishMentions(ctx, s.pubsub, post)
+
 	return nil
 }
 
-func (s *storage_struct) GetPost(ctx context.Context, postId string) (storage.Post, error) {
+func (s *storage_struct) GetPost(ctx context.Context, postId string, viewer string) (storage.Post, error) {
 	s.storageMu.Lock()
 	post, ok := s.storage[postId]
+	ok = ok && s.canSee(post, viewer)
 	s.storageMu.Unlock()
 
 	if !ok {
-		return post, storage.ErrNotFound
+		return storage.Post{}, storage.ErrNotFound
 	}
 
 	return post, nil
 }
 
-func (s *storage_struct) GetPostLine(ctx context.Context, user string, page_token string, size int) (storage.PostLineAnswer, error) {
+func (s *storage_struct) GetPosts(ctx context.Context, postIds []string, viewer string) ([]storage.Post, error) {
+	s.storageMu.RLock()
+	defer s.storageMu.RUnlock()
+
+	posts := make([]storage.Post, 0, len(postIds))
+	for _, postId := range postIds {
+		if post, ok := s.storage[postId]; ok && s.canSee(post, viewer) {
+			posts = append(posts, post)
+		}
+	}
+
+	return posts, nil
+}
+
+// canSee tells if viewer may see post, posts of private accounts are seen
+// by subscribers only. Caller must hold storageMu.
+func (s *storage_struct) canSee(post storage.Post, viewer string) bool {
+	return s.canSeePostLine(viewer, post.AuthorId) &&
+		storage.CanSee(post, viewer, containsString(s.subscriptions[viewer], post.AuthorId))
+}
+
+func (s *storage_struct) GetPostLine(ctx context.Context, user string, page_token string, size int, viewer string) (storage.PostLineAnswer, error) {
 	var answer storage.PostLineAnswer
 	answer.Posts = make([]storage.Post, 0)
 
 	s.storageMu.Lock()
 	defer s.storageMu.Unlock()
 
+	if !s.canSeePostLine(viewer, user) {
+		return answer, fmt.Errorf("post line of %v is private - %w", user, storage.ErrForbidden)
+	}
+
 	num_of_posts := len(s.lines[user])
 
 	if num_of_posts == 0 {
@@ -72,25 +200,29 @@ func (s *storage_struct) GetPostLine(ctx context.Context, user string, page_toke
 	if page_token == "" {
 		index = num_of_posts - 1
 	} else {
-		token := strings.Split(page_token, "_")
-		if len(token) != 2 || token[0] != user {
-			return answer, storage.ErrNotFound
-		}
-
-		index, err = strconv.Atoi(token[1])
+		index, err = s.lineIndex(user, page_token)
 		if err != nil {
 			return answer, err
 		}
-		if index < 0 || index >= num_of_posts {
-			return answer, storage.ErrNotFound
-		}
 	}
 
-	end := index - size
+	if size > 0 {
+		answer.PrevToken = user + "_" + strconv.Itoa(index)
+	}
+
+	if page_token == "" && len(s.pins[user]) > 0 {
+		answer.Pinned = s.pinnedPosts(user, viewer)
+	}
 
-	for ; index > end && index >= 0; index-- {
+	for ; index >= 0 && len(answer.Posts) < size; index-- {
 		post := s.storage[s.lines[user][index]]
-		answer.Posts = append(answer.Posts, post)
+		if s.inPostLine(post, viewer) {
+			answer.Posts = append(answer.Posts, post)
+		}
+	}
+	// so that the next page is not empty
+	for index >= 0 && !s.inPostLine(s.storage[s.lines[user][index]], viewer) {
+		index--
 	}
 
 	if index >= 0 {
@@ -100,6 +232,56 @@ func (s *storage_struct) GetPostLine(ctx context.Context, user string, page_toke
 	return answer, nil
 }
 
+func (s *storage_struct) GetPostLineSince(ctx context.Context, user string, since_token string, size int, viewer string) (storage.PostLineAnswer, error) {
+	var answer storage.PostLineAnswer
+	answer.Posts = make([]storage.Post, 0)
+
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	if !s.canSeePostLine(viewer, user) {
+		return answer, fmt.Errorf("post line of %v is private - %w", user, storage.ErrForbidden)
+	}
+
+	since, err := s.lineIndex(user, since_token)
+	if err != nil {
+		return answer, err
+	}
+
+	end := since
+	for index := since + 1; index < len(s.lines[user]) && len(answer.Posts) < size; index++ {
+		end = index
+		post := s.storage[s.lines[user][index]]
+		if s.inPostLine(post, viewer) {
+			answer.Posts = append([]storage.Post{post}, answer.Posts...)
+		}
+	}
+
+	answer.Token = since_token
+	answer.PrevToken = user + "_" + strconv.Itoa(end)
+
+	return answer, nil
+}
+
+// lineIndex decodes a post line page token of user into an index in
+// s.lines[user]. Caller must hold storageMu.
+func (s *storage_struct) lineIndex(user string, page_token string) (int, error) {
+	token := strings.Split(page_token, "_")
+	if len(token) != 2 || token[0] != user {
+		return 0, storage.ErrNotFound
+	}
+
+	index, err := strconv.Atoi(token[1])
+	if err != nil {
+		return 0, err
+	}
+	if index < 0 || index >= len(s.lines[user]) {
+		return 0, storage.ErrNotFound
+	}
+
+	return index, nil
+}
+
 func (s *storage_struct) ChangePostText(ctx context.Context, postId string, user string, new_text string, new_time string) (storage.Post, error) {
 	s.storageMu.Lock()
 	defer s.storageMu.Unlock()
@@ -117,6 +299,196 @@ func (s *storage_struct) ChangePostText(ctx context.Context, postId string, user
 	post.LastModifiedAt = new_time
 
 	s.storage[postId] = post
+	s.addEvent(storage.PostChanged(storage.EventPostEdited, post))
+	// mentions decide who sees it
+	if post.Visibility == storage.VisibilityMentioned {
+		s.refanout(post)
+	}
+
+	storage.PublishPostEvent(ctx, s.pubsub, storage.PostEvent{Type: storage.EventPostEdited, Post: post})
 
 	return post, nil
-}
\ No newline at end of file
+}
+
+func (s *storage_struct) Subscribe(ctx context.Context, user string, to_user string) (string, error) {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	for _, subscription := range s.subscriptions[user] {
+		if subscription == to_user {
+			return "", fmt.Errorf("user %v is already subscribed to %v - %w", user, to_user, storage.ErrCollision)
+		}
+	}
+	if s.blocked(user, to_user) {
+		return "", fmt.Errorf("user %v can not subscribe to %v - %w", user, to_user, storage.ErrForbidden)
+	}
+
+	if s.settings[to_user].Private {
+		if s.followRequestIndex(to_user, user) >= 0 {
+			return "", fmt.Errorf("user %v has already asked to subscribe to %v - %w", user, to_user, storage.ErrCollision)
+		}
+
+		request := storage.NewFollowRequest(user, to_user)
+		s.followRequests[to_user] = append(s.followRequests[to_user], request)
+		s.addNotifications([]storage.Notification{request.Notification()})
+
+		return storage.SubscriptionPending, nil
+	}
+
+	s.subscribe(user, to_user)
+	s.addNotifications([]storage.Notification{storage.SubscriberNotification(user, to_user)})
+
+	return storage.SubscriptionActive, nil
+}
+
+// Caller must hold storageMu.
+func (s *storage_struct) subscribe(user string, to_user string) {
+	s.subscriptions[user] = append(s.subscriptions[user], to_user)
+	s.subscribers[to_user] = append(s.subscribers[to_user], user)
+
+	s.copyPostsToSubscriber(user, to_user)
+	s.addEvent(storage.SubscriptionChanged(storage.EventSubscriptionAdded, user, to_user))
+}
+
+func (s *storage_struct) GetSubscriptions(ctx context.Context, user string) (storage.Subscriptions, error) {
+	s.storageMu.RLock()
+	defer s.storageMu.RUnlock()
+
+	var answer storage.Subscriptions
+	answer.Users = append(make([]string, 0), s.subscriptions[user]...)
+
+	return answer, nil
+}
+
+func (s *storage_struct) GetSubscribers(ctx context.Context, user string) (storage.Subscribers, error) {
+	s.storageMu.RLock()
+	defer s.storageMu.RUnlock()
+
+	var answer storage.Subscribers
+	answer.Users = append(make([]string, 0), s.subscribers[user]...)
+
+	return answer, nil
+}
+
+// GetFeed pages the feed backwards from the newest post. The page token is the
+// id of the first post of the requested page.
+func (s *storage_struct) GetFeed(ctx context.Context, user string, page_token string, size int) (storage.PostLineAnswer, error) {
+	var answer storage.PostLineAnswer
+	answer.Posts = make([]storage.Post, 0)
+
+	s.storageMu.RLock()
+	defer s.storageMu.RUnlock()
+
+	feed := s.feeds[user]
+
+	index := len(feed) - 1
+	if page_token != "" {
+		index = s.feedIndex(user, page_token)
+		if index < 0 {
+			return answer, storage.ErrNotFound
+		}
+	}
+
+	if index >= 0 && size > 0 {
+		answer.PrevToken = feed[index]
+	}
+
+	for ; index >= 0 && len(answer.Posts) < size; index-- {
+		if s.visible(user, feed[index]) {
+			answer.Posts = append(answer.Posts, s.storage[feed[index]])
+		}
+	}
+	// so that the next page is not empty
+	for index >= 0 && !s.visible(user, feed[index]) {
+		index--
+	}
+
+	if index >= 0 {
+		answer.Token = feed[index]
+	}
+
+	return answer, nil
+}
+
+func (s *storage_struct) GetFeedSince(ctx context.Context, user string, since_token string, size int) (storage.PostLineAnswer, error) {
+	var answer storage.PostLineAnswer
+	answer.Posts = make([]storage.Post, 0)
+
+	s.storageMu.RLock()
+	defer s.storageMu.RUnlock()
+
+	feed := s.feeds[user]
+
+	since := s.feedIndex(user, since_token)
+	if since < 0 {
+		return answer, storage.ErrNotFound
+	}
+
+	end := since
+	for index := since + 1; index < len(feed) && len(answer.Posts) < size; index++ {
+		end = index
+		if s.visible(user, feed[index]) {
+			answer.Posts = append([]storage.Post{s.storage[feed[index]]}, answer.Posts...)
+		}
+	}
+
+	answer.Token = since_token
+	answer.PrevToken = feed[end]
+
+	return answer, nil
+}
+
+func (s *storage_struct) CountFeedSince(ctx context.Context, user string, since_token string) (int64, error) {
+	s.storageMu.RLock()
+	defer s.storageMu.RUnlock()
+
+	since := s.feedIndex(user, since_token)
+	if since < 0 {
+		return 0, storage.ErrNotFound
+	}
+
+	var count int64
+	for _, postId := range s.feeds[user][since+1:] {
+		if s.visible(user, postId) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// feedIndex returns the position of postId in the feed of user or -1.
+// Caller must hold storageMu.
+func (s *storage_struct) feedIndex(user string, postId string) int {
+	for i, id := range s.feeds[user] {
+		if id == postId {
+			return i
+		}
+	}
+	return -1
+}
+
+// addToFeed inserts post into the feed of user keeping the feed ordered by
+// post timestamp. Caller must hold storageMu.
+func (s *storage_struct) addToFeed(user string, post storage.Post) {
+	feed := s.feeds[user]
+	i := sort.Search(len(feed), func(i int) bool {
+		return s.storage[feed[i]].Timestamp > post.Timestamp
+	})
+
+	feed = append(feed, "")
+	copy(feed[i+1:], feed[i:])
+	feed[i] = post.Id
+
+	s.feeds[user] = feed
+}
+
+// Caller must hold storageMu.
+func (s *storage_struct) copyPostsToSubscriber(user string, to_user string) {
+	for _, postId := range s.lines[to_user] {
+		post := s.storage[postId]
+		if storage.CanSee(post, user, true) {
+			s.addToFeed(user, post)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package localstorage
+
+import (
+	"context"
+	"microblog/storage"
+)
+
+// Migrate has nothing to do, maps need no schema.
+func (s *storage_struct) Migrate(ctx context.Context) error {
+	return nil
+}
+
+// Reindex has nothing to do either.
+func (s *storage_struct) Reindex(ctx context.Context) error {
+	return nil
+}
+
+func (s *storage_struct) Stats(ctx context.Context) (storage.Stats, error) {
+	s.storageMu.RLock()
+	defer s.storageMu.RUnlock()
+
+	stats := storage.Stats{
+		Posts:          int64(len(s.storage)),
+		ScheduledPosts: int64(len(s.scheduled)),
+		Drafts:         int64(len(s.drafts)),
+		SuspendedUsers: int64(len(s.suspensions)),
+	}
+	for _, post_ids := range s.lines {
+		if len(post_ids) > 0 {
+			stats.Authors++
+		}
+	}
+	for _, to_users := range s.subscriptions {
+		stats.Subscriptions += int64(len(to_users))
+	}
+	for _, post_ids := range s.feeds {
+		stats.FeedPosts += int64(len(post_ids))
+	}
+	for _, post := range s.storage {
+		if post.Moderation == storage.ModerationFlagged {
+			stats.FlaggedPosts++
+		}
+	}
+	for _, report := range s.reports {
+		if report.Status == storage.ReportOpen {
+			stats.OpenReports++
+		}
+	}
+
+	return stats, nil
+}
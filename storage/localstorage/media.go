@@ -0,0 +1,32 @@
+package localstorage
+
+import (
+	"context"
+	"fmt"
+	"microblog/storage"
+)
+
+func (s *storage_struct) AddMedia(ctx context.Context, media storage.Media) error {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	if _, ok := s.media[media.Id]; ok {
+		return fmt.Errorf("media %v already exists - %w", media.Id, storage.ErrCollision)
+	}
+
+	s.media[media.Id] = media
+
+	return nil
+}
+
+func (s *storage_struct) GetMedia(ctx context.Context, mediaId string) (storage.Media, error) {
+	s.storageMu.RLock()
+	defer s.storageMu.RUnlock()
+
+	media, ok := s.media[mediaId]
+	if !ok {
+		return media, storage.ErrNotFound
+	}
+
+	return media, nil
+}
@@ -0,0 +1,102 @@
+package localstorage
+
+import (
+	"context"
+	"microblog/storage"
+	"sort"
+)
+
+func (s *storage_struct) FlagPost(ctx context.Context, postId string, reason string) (storage.Post, error) {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	post, ok := s.storage[postId]
+	if !ok {
+		return post, storage.ErrNotFound
+	}
+
+	post.Moderation = storage.ModerationFlagged
+	post.ModerationReason = reason
+
+	s.storage[postId] = post
+	s.refanout(post)
+	s.addEvent(storage.PostChanged(storage.EventPostFlagged, post))
+
+	return post, nil
+}
+
+func (s *storage_struct) GetFlaggedPosts(ctx context.Context) (storage.FlaggedPosts, error) {
+	s.storageMu.RLock()
+	defer s.storageMu.RUnlock()
+
+	posts := make([]storage.Post, 0)
+	for _, post := range s.storage {
+		if post.Moderation == storage.ModerationFlagged {
+			posts = append(posts, post)
+		}
+	}
+	sort.Slice(posts, func(i, j int) bool {
+		return posts[i].Timestamp < posts[j].Timestamp
+	})
+
+	return storage.FlaggedPosts{Posts: posts}, nil
+}
+
+// ApprovePost also sends the notifications held back while the post was
+// flagged.
+func (s *storage_struct) ApprovePost(ctx context.Context, postId string) (storage.Post, error) {
+	s.storageMu.Lock()
+
+	post, ok := s.storage[postId]
+	if !ok || post.Moderation != storage.ModerationFlagged {
+		s.storageMu.Unlock()
+		return post, storage.ErrNotFound
+	}
+
+	post.Moderation = ""
+	post.ModerationReason = ""
+
+	s.storage[postId] = post
+	s.refanout(post)
+	s.addEvent(storage.PostChanged(storage.EventPostApproved, post))
+
+	reply_to_author := ""
+	if parent, ok := s.storage[post.ReplyTo]; ok && post.ReplyTo != "" && s.canSee(post, parent.AuthorId) {
+		reply_to_author = parent.AuthorId
+	}
+	s.addNotifications(storage.PostNotifications(post, reply_to_author))
+
+	s.storageMu.Unlock()
+
+	storage.PublishMentions(ctx, s.pubsub, post)
+
+	return post, nil
+}
+
+func (s *storage_struct) RemovePost(ctx context.Context, postId string) (storage.Post, error) {
+	s.storageMu.Lock()
+
+	post, ok := s.storage[postId]
+	if !ok {
+		s.storageMu.Unlock()
+		return post, storage.ErrNotFound
+	}
+
+	for user := range s.feeds {
+		if index := s.feedIndex(user, postId); index >= 0 {
+			feed := s.feeds[user]
+			s.feeds[user] = append(feed[:index:index], feed[index+1:]...)
+		}
+	}
+	s.lines[post.AuthorId] = removeString(s.lines[post.AuthorId], postId)
+	s.pins[post.AuthorId] = removeString(s.pins[post.AuthorId], postId)
+	delete(s.pollVotes, postId)
+	delete(s.storage, postId)
+	s.addEvent(storage.PostChanged(storage.EventPostDeleted, post))
+
+	s.storageMu.Unlock()
+
+	storage.PublishPostEvent(ctx, s.pubsub, storage.PostEvent{Type: storage.EventPostDeleted, Post: post})
+
+	return post, nil
+}
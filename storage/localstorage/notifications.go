@@ -0,0 +1,94 @@
+package localstorage
+
+import (
+	"context"
+	"microblog/storage"
+	"strconv"
+	"strings"
+)
+
+// addNotifications appends notifications to the inboxes of their users.
+// Caller must hold storageMu.
+func (s *storage_struct) addNotifications(notifications []storage.Notification) {
+	for _, notification := range notifications {
+		inbox := s.notifications[notification.User]
+		notification.Id = notification.User + "_" + strconv.Itoa(len(inbox))
+		s.notifications[notification.User] = append(inbox, notification)
+	}
+}
+
+// notificationIndex decodes a notification id of user into an index in
+// s.notifications[user]. Caller must hold storageMu.
+func (s *storage_struct) notificationIndex(user string, notificationId string) (int, error) {
+	token := strings.Split(notificationId, "_")
+	if len(token) != 2 || token[0] != user {
+		return 0, storage.ErrNotFound
+	}
+
+	index, err := strconv.Atoi(token[1])
+	if err != nil || index < 0 || index >= len(s.notifications[user]) {
+		return 0, storage.ErrNotFound
+	}
+
+	return index, nil
+}
+
+func (s *storage_struct) GetNotifications(ctx context.Context, user string, page_token string, size int) (storage.Notifications, error) {
+	var answer storage.Notifications
+	answer.Notifications = make([]storage.Notification, 0)
+
+	s.storageMu.RLock()
+	defer s.storageMu.RUnlock()
+
+	inbox := s.notifications[user]
+	index := len(inbox) - 1
+	if page_token != "" {
+		var err error
+		index, err = s.notificationIndex(user, page_token)
+		if err != nil {
+			return answer, err
+		}
+	}
+
+	read := s.notificationsRead[user]
+	end := index - size
+	for ; index > end && index >= 0; index-- {
+		notification := inbox[index]
+		notification.Read = index < read
+		answer.Notifications = append(answer.Notifications, notification)
+	}
+
+	if index >= 0 {
+		answer.Token = inbox[index].Id
+	}
+
+	return answer, nil
+}
+
+func (s *storage_struct) MarkNotificationsRead(ctx context.Context, user string, notificationId string) error {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	read := len(s.notifications[user])
+	if notificationId != "" {
+		index, err := s.notificationIndex(user, notificationId)
+		if err != nil {
+			return err
+		}
+		read = index + 1
+	}
+
+	// the cursor never goes back
+	if read > s.notificationsRead[user] {
+		s.notificationsRead[user] = read
+	}
+
+	return nil
+}
+
+func (s *storage_struct) CountUnreadNotifications(ctx context.Context, user string) (int64, error) {
+	s.storageMu.RLock()
+	defer s.storageMu.RUnlock()
+
+	return int64(len(s.notifications[user]) - s.notificationsRead[user]), nil
+}
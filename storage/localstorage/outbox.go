@@ -0,0 +1,72 @@
+package localstorage
+
+import (
+	"context"
+	"fmt"
+	"microblog/storage"
+	"strconv"
+	"time"
+)
+
+// eventLease is the lease of a relay on the cursor of a consumer.
+type eventLease struct {
+	holder string
+	until  time.Time
+}
+
+// addEvent saves event to the outbox. Caller must hold storageMu.
+func (s *storage_struct) addEvent(event storage.Event) {
+	event.Id = strconv.Itoa(len(s.events) + 1)
+	s.events = append(s.events, event)
+}
+
+func (s *storage_struct) ReadEvents(ctx context.Context, after string, size int) ([]storage.Event, error) {
+	s.storageMu.RLock()
+	defer s.storageMu.RUnlock()
+
+	start := 0
+	if after != "" {
+		var err error
+		start, err = strconv.Atoi(after)
+		if err != nil || start < 1 || start > len(s.events) {
+			return nil, fmt.Errorf("no event with id %v - %w", after, storage.ErrNotFound)
+		}
+	}
+
+	end := start + size
+	if end > len(s.events) {
+		end = len(s.events)
+	}
+
+	return append(make([]storage.Event, 0), s.events[start:end]...), nil
+}
+
+func (s *storage_struct) GetEventCursor(ctx context.Context, consumer string) (string, error) {
+	s.storageMu.RLock()
+	defer s.storageMu.RUnlock()
+
+	return s.eventCursors[consumer], nil
+}
+
+func (s *storage_struct) LeaseEventCursor(ctx context.Context, consumer string, holder string, ttl time.Duration) error {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	now := time.Now()
+	lease, ok := s.eventLeases[consumer]
+	if ok && lease.holder != holder && now.Before(lease.until) {
+		return fmt.Errorf("cursor of %v is leased by another relay - %w", consumer, storage.ErrCollision)
+	}
+	s.eventLeases[consumer] = eventLease{holder: holder, until: now.Add(ttl)}
+
+	return nil
+}
+
+func (s *storage_struct) SetEventCursor(ctx context.Context, consumer string, id string) error {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	s.eventCursors[consumer] = id
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package localstorage
+
+import (
+	"context"
+	"fmt"
+	"microblog/storage"
+)
+
+func (s *storage_struct) PinPost(ctx context.Context, postId string, user string) error {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	post, ok := s.storage[postId]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	if post.AuthorId != user {
+		return storage.ErrUnauthorized
+	}
+
+	pins := s.pins[user]
+	if containsString(pins, postId) {
+		return nil
+	}
+	if len(pins) >= storage.MaxPinnedPosts {
+		return fmt.Errorf("at most %v posts can be pinned - %w", storage.MaxPinnedPosts, storage.ErrCollision)
+	}
+
+	s.pins[user] = append([]string{postId}, pins...)
+
+	return nil
+}
+
+func (s *storage_struct) UnpinPost(ctx context.Context, postId string, user string) error {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	post, ok := s.storage[postId]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	if post.AuthorId != user {
+		return storage.ErrUnauthorized
+	}
+
+	s.pins[user] = removeString(s.pins[user], postId)
+
+	return nil
+}
+
+// pinnedPosts returns the pinned posts of user that viewer can see.
+// Caller must hold storageMu.
+func (s *storage_struct) pinnedPosts(user string, viewer string) []storage.Post {
+	posts := make([]storage.Post, 0, len(s.pins[user]))
+	for _, postId := range s.pins[user] {
+		post := s.storage[postId]
+		if s.canSee(post, viewer) {
+			posts = append(posts, post)
+		}
+	}
+	return posts
+}
+
+// inPostLine tells if post is shown to viewer among the usual posts of the
+// post line. Caller must hold storageMu.
+func (s *storage_struct) inPostLine(post storage.Post, viewer string) bool {
+	return s.canSee(post, viewer) && !containsString(s.pins[post.AuthorId], post.Id)
+}
@@ -0,0 +1,37 @@
+package localstorage
+
+import (
+	"context"
+	"fmt"
+	"microblog/storage"
+	"time"
+)
+
+func (s *storage_struct) VotePoll(ctx context.Context, postId string, user string, option int) (storage.Post, error) {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	post, ok := s.storage[postId]
+	if !ok || !s.canSee(post, user) {
+		return storage.Post{}, storage.ErrNotFound
+	}
+	if post.Poll == nil || option < 0 || option >= len(post.Poll.Options) {
+		return post, fmt.Errorf("post %v has no poll option %v - %w", postId, option, storage.ErrNotFound)
+	}
+	if !post.Poll.Open(time.Now()) {
+		return post, fmt.Errorf("poll of post %v is closed - %w", postId, storage.ErrForbidden)
+	}
+	if _, ok := s.pollVotes[postId][user]; ok {
+		return post, fmt.Errorf("%v already voted in poll of post %v - %w", user, postId, storage.ErrCollision)
+	}
+
+	if s.pollVotes[postId] == nil {
+		s.pollVotes[postId] = make(map[string]int)
+	}
+	s.pollVotes[postId][user] = option
+
+	post.Poll = post.Poll.WithVote(option)
+	s.storage[postId] = post
+
+	return post, nil
+}
@@ -0,0 +1,85 @@
+package localstorage
+
+import (
+	"context"
+	"microblog/storage"
+)
+
+func (s *storage_struct) Block(ctx context.Context, user string, target string) error {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	if s.blocks[user] == nil {
+		s.blocks[user] = make(map[string]bool)
+	}
+	s.blocks[user][target] = true
+
+	s.unsubscribe(user, target)
+	s.unsubscribe(target, user)
+	s.removeFollowRequest(user, target)
+	s.removeFollowRequest(target, user)
+
+	return nil
+}
+
+func (s *storage_struct) Unblock(ctx context.Context, user string, target string) error {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	delete(s.blocks[user], target)
+
+	return nil
+}
+
+func (s *storage_struct) Mute(ctx context.Context, user string, target string) error {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	if s.mutes[user] == nil {
+		s.mutes[user] = make(map[string]bool)
+	}
+	s.mutes[user][target] = true
+
+	return nil
+}
+
+func (s *storage_struct) Unmute(ctx context.Context, user string, target string) error {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	delete(s.mutes[user], target)
+
+	return nil
+}
+
+// blocked tells if either user blocks the other. Caller must hold storageMu.
+func (s *storage_struct) blocked(user string, other string) bool {
+	return s.blocks[user][other] || s.blocks[other][user]
+}
+
+// visible tells if post should be shown in the feed of user.
+// Caller must hold storageMu.
+func (s *storage_struct) visible(user string, postId string) bool {
+	return !s.mutes[user][s.storage[postId].AuthorId]
+}
+
+// unsubscribe removes the subscription of user to to_user and the posts of
+// to_user from the feed of user. Caller must hold storageMu.
+func (s *storage_struct) unsubscribe(user string, to_user string) {
+	if !containsString(s.subscriptions[user], to_user) {
+		return
+	}
+
+	s.subscriptions[user] = removeString(s.subscriptions[user], to_user)
+	s.subscribers[to_user] = removeString(s.subscribers[to_user], user)
+
+	feed := s.feeds[user][:0]
+	for _, postId := range s.feeds[user] {
+		if s.storage[postId].AuthorId != to_user {
+			feed = append(feed, postId)
+		}
+	}
+	s.feeds[user] = feed
+
+	s.addEvent(storage.SubscriptionChanged(storage.EventSubscriptionRemoved, user, to_user))
+}
@@ -0,0 +1,98 @@
+package localstorage
+
+import (
+	"context"
+	"fmt"
+	"microblog/storage"
+	"sort"
+)
+
+func (s *storage_struct) AddReport(ctx context.Context, report storage.Report) error {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	for _, other := range s.reports {
+		if other.PostId == report.PostId && other.Reporter == report.Reporter {
+			return fmt.Errorf("%v has already reported post %v - %w", report.Reporter, report.PostId, storage.ErrCollision)
+		}
+	}
+
+	s.reports[report.Id] = report
+
+	return nil
+}
+
+func (s *storage_struct) GetReport(ctx context.Context, reportId string) (storage.Report, error) {
+	s.storageMu.RLock()
+	defer s.storageMu.RUnlock()
+
+	report, ok := s.reports[reportId]
+	if !ok {
+		return report, storage.ErrNotFound
+	}
+
+	return report, nil
+}
+
+func (s *storage_struct) GetReports(ctx context.Context, status string) (storage.Reports, error) {
+	s.storageMu.RLock()
+	defer s.storageMu.RUnlock()
+
+	reports := make([]storage.Report, 0)
+	for _, report := range s.reports {
+		if report.Status == status {
+			reports = append(reports, report)
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Timestamp < reports[j].Timestamp
+	})
+
+	return storage.Reports{Reports: reports}, nil
+}
+
+func (s *storage_struct) CloseReports(ctx context.Context, postId string, status string, admin string, resolved_at string) error {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	for id, report := range s.reports {
+		if report.PostId == postId && report.Status == storage.ReportOpen {
+			report.Status = status
+			report.ResolvedBy = admin
+			report.ResolvedAt = resolved_at
+			s.reports[id] = report
+		}
+	}
+
+	return nil
+}
+
+func (s *storage_struct) SuspendUser(ctx context.Context, suspension storage.Suspension) error {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	s.suspensions[suspension.User] = suspension
+
+	return nil
+}
+
+func (s *storage_struct) UnsuspendUser(ctx context.Context, user string) error {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	delete(s.suspensions, user)
+
+	return nil
+}
+
+func (s *storage_struct) GetSuspension(ctx context.Context, user string) (storage.Suspension, error) {
+	s.storageMu.RLock()
+	defer s.storageMu.RUnlock()
+
+	suspension, ok := s.suspensions[user]
+	if !ok {
+		return suspension, storage.ErrNotFound
+	}
+
+	return suspension, nil
+}
@@ -0,0 +1,76 @@
+package localstorage
+
+import (
+	"context"
+	"fmt"
+	"microblog/storage"
+	"sort"
+)
+
+func (s *storage_struct) AddScheduledPost(ctx context.Context, scheduled storage.ScheduledPost) error {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	if _, ok := s.scheduled[scheduled.Post.Id]; ok {
+		return fmt.Errorf("scheduled post %v already exists - %w", scheduled.Post.Id, storage.ErrCollision)
+	}
+
+	s.scheduled[scheduled.Post.Id] = scheduled
+
+	return nil
+}
+
+func (s *storage_struct) GetScheduledPost(ctx context.Context, postId string) (storage.ScheduledPost, error) {
+	s.storageMu.RLock()
+	defer s.storageMu.RUnlock()
+
+	scheduled, ok := s.scheduled[postId]
+	if !ok {
+		return scheduled, storage.ErrNotFound
+	}
+
+	return scheduled, nil
+}
+
+func (s *storage_struct) GetScheduledPosts(ctx context.Context, user string) (storage.ScheduledPosts, error) {
+	s.storageMu.RLock()
+	defer s.storageMu.RUnlock()
+
+	posts := make([]storage.ScheduledPost, 0)
+	for _, scheduled := range s.scheduled {
+		if scheduled.Post.AuthorId == user {
+			posts = append(posts, scheduled)
+		}
+	}
+	sort.Slice(posts, func(i, j int) bool {
+		return posts[i].Timestamp < posts[j].Timestamp
+	})
+
+	return storage.ScheduledPosts{Posts: posts}, nil
+}
+
+func (s *storage_struct) UpdateScheduledPost(ctx context.Context, scheduled storage.ScheduledPost) error {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	if _, ok := s.scheduled[scheduled.Post.Id]; !ok {
+		return storage.ErrNotFound
+	}
+
+	s.scheduled[scheduled.Post.Id] = scheduled
+
+	return nil
+}
+
+func (s *storage_struct) DeleteScheduledPost(ctx context.Context, postId string) error {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	if _, ok := s.scheduled[postId]; !ok {
+		return storage.ErrNotFound
+	}
+
+	delete(s.scheduled, postId)
+
+	return nil
+}
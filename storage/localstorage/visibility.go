@@ -0,0 +1,46 @@
+package localstorage
+
+import (
+	"context"
+	"microblog/storage"
+)
+
+func (s *storage_struct) ChangePostVisibility(ctx context.Context, postId string, user string, visibility string, new_time string) (storage.Post, error) {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	post, ok := s.storage[postId]
+
+	if !ok {
+		return post, storage.ErrNotFound
+	}
+	if post.AuthorId != user {
+		return post, storage.ErrUnauthorized
+	}
+
+	post.Visibility = visibility
+	post.LastModifiedAt = new_time
+
+	s.storage[postId] = post
+	s.refanout(post)
+	s.addEvent(storage.PostChanged(storage.EventPostEdited, post))
+
+	return post, nil
+}
+
+// refanout puts post into the feeds of the subscribers who can see it and
+// removes it from the others. Caller must hold storageMu.
+func (s *storage_struct) refanout(post storage.Post) {
+	for _, subscriber := range s.subscribers[post.AuthorId] {
+		index := s.feedIndex(subscriber, post.Id)
+		visible := storage.CanSee(post, subscriber, true)
+
+		switch {
+		case visible && index < 0:
+			s.addToFeed(subscriber, post)
+		case !visible && index >= 0:
+			feed := s.feeds[subscriber]
+			s.feeds[subscriber] = append(feed[:index:index], feed[index+1:]...)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package localstorage
+
+import (
+	"context"
+	"fmt"
+	"microblog/storage"
+)
+
+func (s *storage_struct) AddWebhook(ctx context.Context, hook storage.Webhook) error {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	if _, ok := s.webhooks[hook.Id]; ok {
+		return fmt.Errorf("webhook %v already exists - %w", hook.Id, storage.ErrCollision)
+	}
+
+	s.webhooks[hook.Id] = hook
+	s.userWebhooks[hook.User] = append(s.userWebhooks[hook.User], hook.Id)
+
+	return nil
+}
+
+func (s *storage_struct) GetWebhook(ctx context.Context, webhookId string) (storage.Webhook, error) {
+	s.storageMu.RLock()
+	defer s.storageMu.RUnlock()
+
+	hook, ok := s.webhooks[webhookId]
+	if !ok {
+		return hook, storage.ErrNotFound
+	}
+
+	return hook, nil
+}
+
+func (s *storage_struct) GetWebhooks(ctx context.Context, user string, event string) ([]storage.Webhook, error) {
+	s.storageMu.RLock()
+	defer s.storageMu.RUnlock()
+
+	hooks := make([]storage.Webhook, 0)
+	for _, id := range s.userWebhooks[user] {
+		hook := s.webhooks[id]
+		if event == "" || (!hook.Disabled && containsString(hook.Events, event)) {
+			hooks = append(hooks, hook)
+		}
+	}
+
+	return hooks, nil
+}
+
+func (s *storage_struct) AddWebhookFailure(ctx context.Context, webhookId string, disable_after int) (storage.Webhook, error) {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	hook, ok := s.webhooks[webhookId]
+	if !ok {
+		return hook, storage.ErrNotFound
+	}
+
+	hook.Failures++
+	if hook.Failures >= disable_after {
+		hook.Disabled = true
+	}
+	s.webhooks[webhookId] = hook
+
+	return hook, nil
+}
+
+func (s *storage_struct) ResetWebhookFailures(ctx context.Context, webhookId string, enable bool) (storage.Webhook, error) {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	hook, ok := s.webhooks[webhookId]
+	if !ok {
+		return hook, storage.ErrNotFound
+	}
+
+	hook.Failures = 0
+	if enable {
+		hook.Disabled = false
+	}
+	s.webhooks[webhookId] = hook
+
+	return hook, nil
+}
+
+func (s *storage_struct) DeleteWebhook(ctx context.Context, webhookId string) error {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	hook, ok := s.webhooks[webhookId]
+	if !ok {
+		return storage.ErrNotFound
+	}
+
+	delete(s.webhooks, webhookId)
+	s.userWebhooks[hook.User] = removeString(s.userWebhooks[hook.User], webhookId)
+
+	for _, id := range s.deliveryLog[webhookId] {
+		delete(s.deliveries, id)
+	}
+	delete(s.deliveryLog, webhookId)
+
+	return nil
+}
+
+func (s *storage_struct) AddDelivery(ctx context.Context, delivery storage.WebhookDelivery) error {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	if _, ok := s.deliveries[delivery.Id]; ok {
+		return fmt.Errorf("delivery %v already exists - %w", delivery.Id, storage.ErrCollision)
+	}
+
+	s.deliveries[delivery.Id] = delivery
+	s.deliveryLog[delivery.WebhookId] = append(s.deliveryLog[delivery.WebhookId], delivery.Id)
+
+	return nil
+}
+
+func (s *storage_struct) GetDelivery(ctx context.Context, deliveryId string) (storage.WebhookDelivery, error) {
+	s.storageMu.RLock()
+	defer s.storageMu.RUnlock()
+
+	delivery, ok := s.deliveries[deliveryId]
+	if !ok {
+		return delivery, storage.ErrNotFound
+	}
+
+	return delivery, nil
+}
+
+func (s *storage_struct) UpdateDelivery(ctx context.Context, delivery storage.WebhookDelivery) error {
+	s.storageMu.Lock()
+	defer s.storageMu.Unlock()
+
+	if _, ok := s.deliveries[delivery.Id]; !ok {
+		return storage.ErrNotFound
+	}
+
+	s.deliveries[delivery.Id] = delivery
+
+	return nil
+}
+
+func (s *storage_struct) GetDeliveries(ctx context.Context, webhookId string, size int) ([]storage.WebhookDelivery, error) {
+	s.storageMu.RLock()
+	defer s.storageMu.RUnlock()
+
+	deliveries := make([]storage.WebhookDelivery, 0)
+	log := s.deliveryLog[webhookId]
+	for i := len(log) - 1; i >= 0 && len(deliveries) < size; i-- {
+		deliveries = append(deliveries, s.deliveries[log[i]])
+	}
+
+	return deliveries, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(values []string, value string) []string {
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		if v != value {
+			result = append(result, v)
+		}
+	}
+	return result
+}
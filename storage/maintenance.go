@@ -0,0 +1,28 @@
+package storage
+
+import "context"
+
+// Stats counts what a storage keeps.
+type Stats struct {
+	Posts          int64 `json:"posts"`
+	Authors        int64 `json:"authors"`
+	Subscriptions  int64 `json:"subscriptions"`
+	FeedPosts      int64 `json:"feedPosts"`
+	ScheduledPosts int64 `json:"scheduledPosts"`
+	Drafts         int64 `json:"drafts"`
+	FlaggedPosts   int64 `json:"flaggedPosts"`
+	OpenReports    int64 `json:"openReports"`
+	SuspendedUsers int64 `json:"suspendedUsers"`
+}
+
+// MaintenanceStore is used by the admin commands.
+type MaintenanceStore interface {
+	// Migrate brings collections and indexes up to date, it is safe to run
+	// it again.
+	Migrate(ctx context.Context) error
+	// Reindex drops the indexes and builds them anew. Unique constraints are
+	// gone until it is done, so it must only run while no server or worker
+	// is up, storages fail with ErrForbidden when they see one running.
+	Reindex(ctx context.Context) error
+	Stats(ctx context.Context) (Stats, error)
+}
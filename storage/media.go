@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"context"
+	"io"
+)
+
+const (
+	// MaxMediaSize is the largest file that can be uploaded, in bytes
+	MaxMediaSize = 10 << 20
+	// MaxAttachments is how many media a post can have
+	MaxAttachments = 4
+)
+
+// MediaTypes are the MIME types accepted for upload, as detected from the
+// content by http.DetectContentType.
+var MediaTypes = map[string]bool{
+	"image/png":                 true,
+	"image/jpeg":                true,
+	"image/gif":                 true,
+	"image/webp":                true,
+	"application/pdf":           true,
+	"text/plain; charset=utf-8": true,
+}
+
+// Media is the metadata of an uploaded file, its content is kept in a
+// BlobStore under the same id. Width and Height are set for images only.
+type Media struct {
+	Id        string `json:"id" bson:"id"`
+	Type      string `json:"type" bson:"type"`
+	Size      int64  `json:"size" bson:"size"`
+	Width     int    `json:"width,omitempty" bson:"width,omitempty"`
+	Height    int    `json:"height,omitempty" bson:"height,omitempty"`
+	URL       string `json:"url" bson:"url"`
+	Owner     string `json:"-" bson:"owner"`
+	CreatedAt string `json:"createdAt" bson:"createdAt"`
+}
+
+func MediaURL(mediaId string) string {
+	return "/api/v1/media/" + mediaId
+}
+
+type MediaStore interface {
+	AddMedia(ctx context.Context, media Media) error
+	GetMedia(ctx context.Context, mediaId string) (Media, error)
+}
+
+// BlobStore keeps file contents. Blobs are written once and are seekable on
+// read, so that they can be served with range requests.
+type BlobStore interface {
+	PutBlob(ctx context.Context, blobId string, content io.Reader) error
+	// OpenBlob fails with ErrNotFound when there is no such blob
+	OpenBlob(ctx context.Context, blobId string) (io.ReadSeekCloser, error)
+	DeleteBlob(ctx context.Context, blobId string) error
+}
@@ -0,0 +1,23 @@
+package storage
+
+import "context"
+
+// ModerationFlagged is the Moderation of a post waiting for review, such a
+// post is seen by its author only.
+const ModerationFlagged = "flagged"
+
+type FlaggedPosts struct {
+	Posts []Post `json:"posts"`
+}
+
+type ModerationStore interface {
+	// FlagPost hides the post from everybody but its author until reviewed
+	FlagPost(ctx context.Context, postId string, reason string) (Post, error)
+	// GetFlaggedPosts returns the posts waiting for review, oldest first
+	GetFlaggedPosts(ctx context.Context) (FlaggedPosts, error)
+	// ApprovePost makes a flagged post visible again, it fails with
+	// ErrNotFound when the post is not flagged
+	ApprovePost(ctx context.Context, postId string) (Post, error)
+	// RemovePost deletes the post together with its feed copies
+	RemovePost(ctx context.Context, postId string) (Post, error)
+}
@@ -0,0 +1,170 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"microblog/storage"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx"
+)
+
+func configureSettingsIndexes(ctx context.Context, collection *mongo.Collection) {
+	indexModels := []mongo.IndexModel{
+		{
+			Keys:    bsonx.Doc{{Key: "user", Value: bsonx.Int32(1)}},
+			Options: options.Index().SetUnique(true),
+		},
+	}
+	opts := options.CreateIndexes().SetMaxTime(10 * time.Second)
+
+	_, err := collection.Indexes().CreateMany(ctx, indexModels, opts)
+	if err != nil {
+		panic(fmt.Errorf("failed to ensure indexes %w", err))
+	}
+}
+
+func configureFollowRequestsIndexes(ctx context.Context, collection *mongo.Collection) {
+	indexModels := []mongo.IndexModel{
+		{
+			Keys: bsonx.Doc{{Key: "toUser", Value: bsonx.Int32(1)},
+				{Key: "user", Value: bsonx.Int32(1)}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bsonx.Doc{{Key: "toUser", Value: bsonx.Int32(1)},
+				{Key: "time", Value: bsonx.Int32(1)}},
+		},
+	}
+	opts := options.CreateIndexes().SetMaxTime(10 * time.Second)
+
+	_, err := collection.Indexes().CreateMany(ctx, indexModels, opts)
+	if err != nil {
+		panic(fmt.Errorf("failed to ensure indexes %w", err))
+	}
+}
+
+func (s *storage_struct) GetSettings(ctx context.Context, user string) (storage.AccountSettings, error) {
+	var settings storage.AccountSettings
+
+	err := s.settings.FindOne(ctx, bson.M{"user": user}).Decode(&settings)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return settings, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return settings, nil
+}
+
+func (s *storage_struct) UpdateSettings(ctx context.Context, user string, settings storage.AccountSettings) error {
+	opts := options.Update().SetUpsert(true)
+	_, err := s.settings.UpdateOne(ctx, bson.M{"user": user}, bson.M{"$set": settings}, opts)
+	if err != nil {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return nil
+}
+
+func (s *storage_struct) GetFollowRequests(ctx context.Context, user string) (storage.FollowRequests, error) {
+	var answer storage.FollowRequests
+	answer.Requests = make([]storage.FollowRequest, 0)
+
+	opts := options.Find().SetSort(bson.M{"time": 1})
+	cursor, err := s.followRequests.Find(ctx, bson.M{"toUser": user}, opts)
+	if err != nil {
+		return answer, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	if err = cursor.All(ctx, &answer.Requests); err != nil {
+		return answer, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return answer, nil
+}
+
+func (s *storage_struct) ApproveFollowRequest(ctx context.Context, user string, from_user string) error {
+	count, err := s.followRequests.CountDocuments(ctx, bson.M{"user": from_user, "toUser": user})
+	if err != nil {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+	if count == 0 {
+		return fmt.Errorf("no follow request from %v to %v - %w", from_user, user, storage.ErrNotFound)
+	}
+
+	// the request is removed in the write of the subscription, so that it
+	// is not gone without the subscription
+	err = s.subscribe(ctx, from_user, user, func(ctx context.Context) error {
+		_, err := s.followRequests.DeleteOne(ctx, bson.M{"user": from_user, "toUser": user})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	s.addNotifications(ctx, []storage.Notification{storage.SubscriberNotification(from_user, user)})
+
+	return nil
+}
+
+func (s *storage_struct) RejectFollowRequest(ctx context.Context, user string, from_user string) error {
+	return s.removeFollowRequest(ctx, user, from_user)
+}
+
+func (s *storage_struct) removeFollowRequest(ctx context.Context, user string, from_user string) error {
+	result, err := s.followRequests.DeleteOne(ctx, bson.M{"user": from_user, "toUser": user})
+	if err != nil {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("no follow request from %v to %v - %w", from_user, user, storage.ErrNotFound)
+	}
+
+	return nil
+}
+
+// requestFollow saves a pending subscription of user to the private to_user.
+func (s *storage_struct) requestFollow(ctx context.Context, user string, to_user string) (string, error) {
+	request := storage.NewFollowRequest(user, to_user)
+
+	_, err := s.followRequests.InsertOne(ctx, request)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return "", fmt.Errorf("user %v has already asked to subscribe to %v - %w", user, to_user, storage.ErrCollision)
+		}
+		return "", fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	s.addNotifications(ctx, []storage.Notification{request.Notification()})
+
+	return storage.SubscriptionPending, nil
+}
+
+// checkPostLineAccess fails with ErrForbidden when the post line of user is
+// private and viewer is not a subscriber.
+func (s *storage_struct) checkPostLineAccess(ctx context.Context, viewer string, user string) error {
+	if viewer == user {
+		return nil
+	}
+
+	settings, err := s.GetSettings(ctx, user)
+	if err != nil {
+		return err
+	}
+	if !settings.Private {
+		return nil
+	}
+
+	count, err := s.subscriptions.CountDocuments(ctx, bson.M{"user": viewer, "toUser": user})
+	if err != nil {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+	if count == 0 {
+		return fmt.Errorf("post line of %v is private - %w", user, storage.ErrForbidden)
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"microblog/storage"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx"
+)
+
+func configureDraftsIndexes(ctx context.Context, collection *mongo.Collection) {
+	indexModels := []mongo.IndexModel{
+		{
+			Keys:    bsonx.Doc{{Key: "id", Value: bsonx.Int32(1)}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bsonx.Doc{{Key: "authorId", Value: bsonx.Int32(1)},
+				{Key: "time", Value: bsonx.Int32(-1)}},
+		},
+	}
+	opts := options.CreateIndexes().SetMaxTime(10 * time.Second)
+
+	_, err := collection.Indexes().CreateMany(ctx, indexModels, opts)
+	if err != nil {
+		panic(fmt.Errorf("failed to ensure indexes %w", err))
+	}
+}
+
+func (s *storage_struct) AddDraft(ctx context.Context, draft storage.Draft) error {
+	_, err := s.drafts.InsertOne(ctx, draft)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("draft %v already exists - %w", draft.Id, storage.ErrCollision)
+		}
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return nil
+}
+
+func (s *storage_struct) GetDraft(ctx context.Context, draftId string) (storage.Draft, error) {
+	var draft storage.Draft
+
+	err := s.drafts.FindOne(ctx, bson.M{"id": draftId}).Decode(&draft)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return draft, fmt.Errorf("no draft with id %v - %w", draftId, storage.ErrNotFound)
+		}
+		return draft, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return draft, nil
+}
+
+func (s *storage_struct) GetDrafts(ctx context.Context, user string) (storage.Drafts, error) {
+	var answer storage.Drafts
+	answer.Drafts = make([]storage.Draft, 0)
+
+	opts := options.Find().SetSort(bson.M{"time": -1})
+	cursor, err := s.drafts.Find(ctx, bson.M{"authorId": user}, opts)
+	if err != nil {
+		return answer, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	if err = cursor.All(ctx, &answer.Drafts); err != nil {
+		return answer, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return answer, nil
+}
+
+func (s *storage_struct) UpdateDraft(ctx context.Context, draft storage.Draft) error {
+	result, err := s.drafts.ReplaceOne(ctx, bson.M{"id": draft.Id}, draft)
+	if err != nil {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("no draft with id %v - %w", draft.Id, storage.ErrNotFound)
+	}
+
+	return nil
+}
+
+func (s *storage_struct) DeleteDraft(ctx context.Context, draftId string) error {
+	result, err := s.drafts.DeleteOne(ctx, bson.M{"id": draftId})
+	if err != nil {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("no draft with id %v - %w", draftId, storage.ErrNotFound)
+	}
+
+	return nil
+}
@@ -0,0 +1,194 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"microblog/storage"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx"
+)
+
+func configureErasuresIndexes(ctx context.Context, collection *mongo.Collection) {
+	indexModels := []mongo.IndexModel{
+		{
+			Keys:    bsonx.Doc{{Key: "user", Value: bsonx.Int32(1)}},
+			Options: options.Index().SetUnique(true),
+		},
+	}
+	opts := options.CreateIndexes().SetMaxTime(10 * time.Second)
+
+	_, err := collection.Indexes().CreateMany(ctx, indexModels, opts)
+	if err != nil {
+		panic(fmt.Errorf("failed to ensure indexes %w", err))
+	}
+}
+
+func (s *storage_struct) SaveErasure(ctx context.Context, erasure storage.Erasure) error {
+	opts := options.Replace().SetUpsert(true)
+	_, err := s.erasures.ReplaceOne(ctx, bson.M{"user": erasure.User}, erasure, opts)
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return nil
+}
+
+func (s *storage_struct) GetErasure(ctx context.Context, user string) (storage.Erasure, error) {
+	var erasure storage.Erasure
+
+	err := s.erasures.FindOne(ctx, bson.M{"user": user}).Decode(&erasure)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return erasure, fmt.Errorf("%v asked for no erasure - %w", user, storage.ErrNotFound)
+		}
+		return erasure, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return erasure, nil
+}
+
+// EraseAccount removes the posts and subscriptions one by one with RemovePost
+// and unsubscribe, which keep the feeds and the outbox right, and the votes
+// with withdrawVotes. The rest has no copies and goes at once.
+func (s *storage_struct) EraseAccount(ctx context.Context, user string) (storage.ErasedAccount, error) {
+	erased := storage.ErasedAccount{PostIds: make([]string, 0)}
+
+	// nothing is published after the account is gone
+	_, err := s.scheduled.DeleteMany(ctx, bson.M{"post.authorId": user})
+	if err != nil {
+		return erased, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+	_, err = s.drafts.DeleteMany(ctx, bson.M{"authorId": user})
+	if err != nil {
+		return erased, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	postIds, err := s.posts.Distinct(ctx, "id", bson.M{"authorId": user})
+	if err != nil {
+		return erased, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+	for _, value := range postIds {
+		postId, _ := value.(string)
+		_, err = s.RemovePost(ctx, postId)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return erased, err
+		}
+		erased.PostIds = append(erased.PostIds, postId)
+	}
+
+	subscriptions, err := s.GetSubscriptions(ctx, user)
+	if err != nil {
+		return erased, err
+	}
+	for _, to_user := range subscriptions.Users {
+		if err = s.unsubscribe(ctx, user, to_user); err != nil {
+			return erased, err
+		}
+		erased.Subscriptions++
+	}
+	subscribers, err := s.GetSubscribers(ctx, user)
+	if err != nil {
+		return erased, err
+	}
+	for _, subscriber := range subscribers.Users {
+		if err = s.unsubscribe(ctx, subscriber, user); err != nil {
+			return erased, err
+		}
+		erased.Subscriptions++
+	}
+
+	if err = s.withdrawVotes(ctx, user); err != nil {
+		return erased, err
+	}
+
+	// the deliveries go first, they can't be found once the webhooks are gone
+	hookIds, err := s.webhooks.Distinct(ctx, "id", bson.M{"user": user})
+	if err != nil {
+		return erased, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+	mediaIds, err := s.media.Distinct(ctx, "id", bson.M{"owner": user})
+	if err != nil {
+		return erased, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+	erased.MediaIds = make([]string, 0, len(mediaIds))
+	for _, value := range mediaIds {
+		mediaId, _ := value.(string)
+		erased.MediaIds = append(erased.MediaIds, mediaId)
+	}
+
+	deletes := []struct {
+		collection *mongo.Collection
+		filter     bson.M
+	}{
+		{s.feeds, bson.M{"user": user}},
+		{s.followRequests, bson.M{"$or": []bson.M{{"user": user}, {"toUser": user}}}},
+		{s.settings, bson.M{"user": user}},
+		{s.notifications, bson.M{"user": user}},
+		{s.notificationCursors, bson.M{"user": user}},
+		{s.notifications, bson.M{"actor": user}},
+		{s.blocks, bson.M{"$or": []bson.M{{"user": user}, {"target": user}}}},
+		{s.mutes, bson.M{"$or": []bson.M{{"user": user}, {"target": user}}}},
+		{s.reports, bson.M{"$or": []bson.M{{"reporter": user}, {"authorId": user}}}},
+		{s.deliveries, bson.M{"webhookId": bson.M{"$in": hookIds}}},
+		{s.webhooks, bson.M{"user": user}},
+		{s.media, bson.M{"owner": user}},
+	}
+	for _, d := range deletes {
+		_, err = d.collection.DeleteMany(ctx, d.filter)
+		if err != nil {
+			return erased, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+		}
+	}
+
+	return erased, nil
+}
+
+// withdrawVotes takes the votes of user out of the poll results, a vote is
+// deleted first so that it is not taken out twice when the erasure is run
+// again.
+func (s *storage_struct) withdrawVotes(ctx context.Context, user string) error {
+	cursor, err := s.pollVotes.Find(ctx, bson.M{"user": user})
+	if err != nil {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+	votes := make([]storage.PollVote, 0)
+	if err = cursor.All(ctx, &votes); err != nil {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	for _, vote := range votes {
+		result, err := s.pollVotes.DeleteOne(ctx, bson.M{"postId": vote.PostId, "user": user})
+		if err != nil {
+			return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+		}
+		if result.DeletedCount == 0 {
+			continue
+		}
+
+		post, err := s.findPost(ctx, vote.PostId)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		field := "poll.options." + strconv.Itoa(vote.Option) + ".votes"
+		_, err = s.posts.UpdateOne(ctx, bson.M{"id": vote.PostId}, bson.M{"$inc": bson.M{field: -1}})
+		if err != nil {
+			return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+		}
+		_, err = s.feeds.UpdateMany(ctx, bson.M{"postId": post.MongoID}, bson.M{"$inc": bson.M{"post." + field: -1}})
+		if err != nil {
+			return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+		}
+	}
+
+	return nil
+}
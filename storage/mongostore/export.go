@@ -0,0 +1,189 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"microblog/storage"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// subscriptionEntry is a Subscription with its mongo id.
+type subscriptionEntry struct {
+	ID                   primitive.ObjectID `bson:"_id"`
+	storage.Subscription `bson:",inline"`
+}
+
+// voteEntry is a PollVote with its mongo id.
+type voteEntry struct {
+	ID               primitive.ObjectID `bson:"_id"`
+	storage.PollVote `bson:",inline"`
+}
+
+// Every kind is exported in the order of mongo ids, which is the order the
+// docs were saved in, the cursor is the hex of the id.
+func (s *storage_struct) Export(ctx context.Context, kind string, after string, size int) ([]storage.ExportRecord, error) {
+	collections := map[string]*mongo.Collection{
+		storage.ExportPost:         s.posts,
+		storage.ExportSubscription: s.subscriptions,
+		storage.ExportFeed:         s.feeds,
+		storage.ExportVote:         s.pollVotes,
+	}
+	collection, ok := collections[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown kind %v - %w", kind, storage.ErrNotFound)
+	}
+
+	filter := bson.M{}
+	if after != "" {
+		after_id, err := primitive.ObjectIDFromHex(after)
+		if err != nil {
+			return nil, fmt.Errorf("bad cursor %v - %w", after, storage.ErrNotFound)
+		}
+		filter["_id"] = bson.M{"$gt": after_id}
+	}
+
+	opts := options.Find().SetSort(bson.M{"_id": 1}).SetLimit(int64(size))
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+	defer cursor.Close(ctx)
+
+	records := make([]storage.ExportRecord, 0, size)
+	for cursor.Next(ctx) {
+		var record storage.ExportRecord
+		switch kind {
+		case storage.ExportPost:
+			var post storage.Post
+			if err = cursor.Decode(&post); err != nil {
+				return nil, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+			}
+			record = storage.ExportedPost(post, post.MongoID.Hex())
+		case storage.ExportSubscription:
+			var entry subscriptionEntry
+			if err = cursor.Decode(&entry); err != nil {
+				return nil, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+			}
+			record = storage.ExportRecord{Kind: kind, Cursor: entry.ID.Hex(), Subscription: &entry.Subscription}
+		case storage.ExportFeed:
+			var entry feedEntry
+			if err = cursor.Decode(&entry); err != nil {
+				return nil, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+			}
+			record = storage.ExportRecord{Kind: kind, Cursor: entry.ID.Hex(), Feed: &storage.FeedEntry{
+				User:      entry.User,
+				PostId:    entry.Post.Id,
+				Timestamp: entry.Timestamp,
+			}}
+		case storage.ExportVote:
+			var entry voteEntry
+			if err = cursor.Decode(&entry); err != nil {
+				return nil, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+			}
+			record = storage.ExportRecord{Kind: kind, Cursor: entry.ID.Hex(), Vote: &entry.PollVote}
+		}
+		records = append(records, record)
+	}
+	if err = cursor.Err(); err != nil {
+		return nil, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return records, nil
+}
+
+// ImportPost saves the post and its feed copies like PostPost, with a pending
+// write that has no event.
+func (s *storage_struct) ImportPost(ctx context.Context, post storage.Post) error {
+	return importPost(ctx, s, post)
+}
+
+// importStore is what importPost needs of the storage, tests fake it.
+type importStore interface {
+	findPost(ctx context.Context, postId string) (storage.Post, error)
+	insertImportedPost(ctx context.Context, post storage.Post) error
+}
+
+// importPost looks the post up before saving it, post ids are not unique in
+// mongo, so a second import would save every post again.
+func importPost(ctx context.Context, s importStore, post storage.Post) error {
+	_, err := s.findPost(ctx, post.Id)
+	if err == nil {
+		return fmt.Errorf("post %v already exists - %w", post.Id, storage.ErrCollision)
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+
+	return s.insertImportedPost(ctx, post)
+}
+
+func (s *storage_struct) insertImportedPost(ctx context.Context, post storage.Post) error {
+	post.MongoID = primitive.NewObjectID()
+
+	pending := pendingWrite{Type: pendingPost, PostId: post.Id, PostMongoID: post.MongoID}
+	err := s.atomically(ctx, pending, func(ctx context.Context) error {
+		_, err := s.posts.InsertOne(ctx, post)
+		if err != nil {
+			return err
+		}
+
+		_, err = s.fanOut(ctx, post)
+		return err
+	})
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("post %v already exists - %w", post.Id, storage.ErrCollision)
+		}
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return nil
+}
+
+func (s *storage_struct) ImportSubscription(ctx context.Context, user string, to_user string) error {
+	subscribed, err := s.subscribed(ctx, user, to_user)
+	if err != nil {
+		return err
+	}
+	if subscribed {
+		return fmt.Errorf("user %v is already subscribed to %v - %w", user, to_user, storage.ErrCollision)
+	}
+
+	pending := pendingWrite{Type: pendingSubscription, User: user, ToUser: to_user}
+	err = s.atomically(ctx, pending, func(ctx context.Context) error {
+		opts := options.Update().SetUpsert(true)
+		_, err := s.subscriptions.UpdateOne(
+			ctx,
+			bson.M{"user": user, "toUser": to_user},
+			bson.M{"$set": bson.M{"user": user, "toUser": to_user}},
+			opts,
+		)
+		if err != nil {
+			return err
+		}
+
+		return s.copyPostsToSubscriber(ctx, user, to_user)
+	})
+	if err != nil {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return nil
+}
+
+func (s *storage_struct) ImportVote(ctx context.Context, vote storage.PollVote) error {
+	_, err := s.pollVotes.InsertOne(ctx, vote)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("%v already voted in poll of post %v - %w", vote.User, vote.PostId, storage.ErrCollision)
+		}
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return nil
+}
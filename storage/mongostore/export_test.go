@@ -0,0 +1,47 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"microblog/storage"
+	"testing"
+)
+
+// fakeImportStore saves the imported posts in the posts of a fakePendingStore.
+type fakeImportStore struct {
+	*fakePendingStore
+
+	inserted []string
+}
+
+func (f *fakeImportStore) insertImportedPost(ctx context.Context, post storage.Post) error {
+	f.inserted = append(f.inserted, post.Id)
+	f.posts[post.Id] = post
+	return nil
+}
+
+func TestImportPostTwice(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeImportStore{fakePendingStore: newFakePendingStore()}
+
+	post := storage.Post{Id: "p1", Text: "hello", AuthorId: "a1"}
+	if err := importPost(ctx, f, post); err != nil {
+		t.Fatal(err)
+	}
+
+	// an import that is run again skips what it saved before
+	if err := importPost(ctx, f, post); !errors.Is(err, storage.ErrCollision) {
+		t.Errorf("second import: got %v", err)
+	}
+	if len(f.inserted) != 1 {
+		t.Errorf("inserted %v", f.inserted)
+	}
+
+	f.err = storage.ErrStorage
+	if err := importPost(ctx, f, storage.Post{Id: "p2"}); !errors.Is(err, storage.ErrStorage) {
+		t.Errorf("failed read: got %v", err)
+	}
+	if len(f.inserted) != 1 {
+		t.Errorf("inserted after a failed read %v", f.inserted)
+	}
+}
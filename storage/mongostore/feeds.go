@@ -0,0 +1,230 @@
+package mongostore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"microblog/storage"
+	"reflect"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// feedEntry is a FeedPost with its mongo id, so duplicates can be told apart.
+type feedEntry struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	User      string             `bson:"user"`
+	Timestamp int64              `bson:"time"`
+	PostId    primitive.ObjectID `bson:"postId"`
+	Post      storage.Post       `bson:"post"`
+}
+
+// feedSnapshotMargin keeps RebuildFeed off the entries of the last moment,
+// ids of feed entries come from the clock of the database.
+const feedSnapshotMargin = time.Minute
+
+// RebuildFeed walks the feed of user and the posts it should have side by
+// side, both in the order of post ids, so neither is read into memory at
+// once. Entries newer than the start of the rebuild belong to writes that
+// run alongside, they are left alone.
+func (s *storage_struct) RebuildFeed(ctx context.Context, user string) (storage.FeedRepair, error) {
+	repair := storage.FeedRepair{User: user}
+	snapshot := primitive.NewObjectIDFromTimestamp(time.Now().Add(-feedSnapshotMargin))
+
+	subscriptions, err := s.GetSubscriptions(ctx, user)
+	if err != nil {
+		return repair, err
+	}
+
+	var posts *mongo.Cursor
+	if len(subscriptions.Users) > 0 {
+		opts := options.Find().SetSort(bson.M{"_id": 1})
+		posts, err = s.posts.Find(ctx, bson.M{"authorId": bson.M{"$in": subscriptions.Users}}, opts)
+		if err != nil {
+			return repair, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+		}
+		defer posts.Close(ctx)
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "postId", Value: 1}, {Key: "_id", Value: 1}})
+	entries, err := s.feeds.Find(ctx, bson.M{"user": user}, opts)
+	if err != nil {
+		return repair, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+	defer entries.Close(ctx)
+
+	removed := make([]primitive.ObjectID, 0)
+	sync := feedSync{
+		snapshot: snapshot,
+		nextPost: func() (storage.Post, bool, error) {
+			var post storage.Post
+			for posts != nil && posts.Next(ctx) {
+				if err := posts.Decode(&post); err != nil {
+					return post, false, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+				}
+				if storage.CanSee(post, user, true) {
+					return post, true, nil
+				}
+			}
+			if posts != nil && posts.Err() != nil {
+				return post, false, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+			}
+			return post, false, nil
+		},
+		nextEntry: func() (feedEntry, bool, error) {
+			var entry feedEntry
+			if entries.Next(ctx) {
+				if err := entries.Decode(&entry); err != nil {
+					return entry, false, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+				}
+				return entry, true, nil
+			}
+			if entries.Err() != nil {
+				return entry, false, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+			}
+			return entry, false, nil
+		},
+		add: func(post storage.Post) error {
+			return s.addFeedPost(ctx, storage.FeedPost{
+				User:      user,
+				Timestamp: post.Timestamp,
+				PostId:    post.MongoID,
+				Post:      post,
+			})
+		},
+		update: func(entry feedEntry, post storage.Post) error {
+			_, err := s.feeds.UpdateOne(ctx,
+				bson.M{"_id": entry.ID},
+				bson.M{"$set": bson.M{"post": post, "time": post.Timestamp}})
+			if err != nil {
+				return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+			}
+			return nil
+		},
+		remove: func(entry feedEntry) error {
+			removed = append(removed, entry.ID)
+			return nil
+		},
+	}
+	if err = sync.run(&repair); err != nil {
+		return repair, err
+	}
+
+	if len(removed) > 0 {
+		_, err = s.feeds.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": removed}})
+		if err != nil {
+			return repair, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+		}
+	}
+
+	return repair, nil
+}
+
+// feedSync compares the entries of a feed with the posts the feed should
+// have, both sorted by post id, and adds, updates or removes entries to make
+// them match. Only entries older than snapshot are updated or removed.
+type feedSync struct {
+	snapshot  primitive.ObjectID
+	nextPost  func() (storage.Post, bool, error)
+	nextEntry func() (feedEntry, bool, error)
+	add       func(post storage.Post) error
+	update    func(entry feedEntry, post storage.Post) error
+	remove    func(entry feedEntry) error
+}
+
+func (f feedSync) run(repair *storage.FeedRepair) error {
+	post, post_ok, err := f.nextPost()
+	if err != nil {
+		return err
+	}
+	entry, entry_ok, err := f.nextEntry()
+	if err != nil {
+		return err
+	}
+
+	for post_ok || entry_ok {
+		order := 1
+		if post_ok && entry_ok {
+			order = bytes.Compare(entry.PostId[:], post.MongoID[:])
+		} else if entry_ok {
+			order = -1
+		}
+		old := entry_ok && bytes.Compare(entry.ID[:], f.snapshot[:]) < 0
+
+		switch {
+		case order < 0:
+			// the post is gone or hidden, or the entry is a second copy
+			if old {
+				if err = f.remove(entry); err != nil {
+					return err
+				}
+				repair.Removed++
+			}
+			entry, entry_ok, err = f.nextEntry()
+		case order == 0:
+			if old && !reflect.DeepEqual(entry.Post, post) {
+				if err = f.update(entry, post); err != nil {
+					return err
+				}
+				repair.Updated++
+			}
+			post, post_ok, err = f.nextPost()
+			if err == nil {
+				entry, entry_ok, err = f.nextEntry()
+			}
+		default:
+			if err = f.add(post); err != nil {
+				return err
+			}
+			repair.Added++
+			post, post_ok, err = f.nextPost()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FeedUsers seeks the next users in the user indexes of feeds and
+// subscriptions, so a batch reads about size index keys of each.
+func (s *storage_struct) FeedUsers(ctx context.Context, after string, size int) ([]string, error) {
+	seen := make(map[string]bool)
+	users := make([]string, 0)
+	for _, collection := range []*mongo.Collection{s.feeds, s.subscriptions} {
+		next := after
+		for i := 0; i < size; i++ {
+			var doc struct {
+				User string `bson:"user"`
+			}
+			opts := options.FindOne().SetSort(bson.M{"user": 1}).SetProjection(bson.M{"user": 1, "_id": 0})
+			err := collection.FindOne(ctx, bson.M{"user": bson.M{"$gt": next}}, opts).Decode(&doc)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				break
+			}
+			if err != nil {
+				return users, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+			}
+
+			next = doc.User
+			if !seen[next] {
+				seen[next] = true
+				users = append(users, next)
+			}
+		}
+	}
+	sort.Strings(users)
+
+	if len(users) > size {
+		users = users[:size]
+	}
+
+	return users, nil
+}
@@ -0,0 +1,88 @@
+package mongostore
+
+import (
+	"microblog/storage"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestFeedSync(t *testing.T) {
+	ids := make([]primitive.ObjectID, 5)
+	for i := range ids {
+		ids[i] = primitive.NewObjectID()
+	}
+	old := func() primitive.ObjectID {
+		return primitive.NewObjectIDFromTimestamp(time.Now().Add(-time.Hour))
+	}
+
+	posts := []storage.Post{
+		{Id: "p0", Text: "kept", MongoID: ids[0]},
+		{Id: "p2", Text: "edited", MongoID: ids[2]},
+		{Id: "p4", Text: "missing", MongoID: ids[4]},
+	}
+	entries := []feedEntry{
+		{ID: old(), PostId: ids[0], Post: posts[0]},
+		// a second copy
+		{ID: old(), PostId: ids[0], Post: posts[0]},
+		// the post is gone
+		{ID: old(), PostId: ids[1]},
+		{ID: old(), PostId: ids[2], Post: storage.Post{Id: "p2", Text: "before", MongoID: ids[2]}},
+		// saved while the feed is rebuilt, by a post or subscription that
+		// came after the posts were read
+		{ID: primitive.NewObjectID(), PostId: ids[3]},
+	}
+
+	var added, updated []string
+	var removed []primitive.ObjectID
+	sync := feedSync{
+		snapshot: primitive.NewObjectIDFromTimestamp(time.Now().Add(-feedSnapshotMargin)),
+		nextPost: func() (storage.Post, bool, error) {
+			if len(posts) == 0 {
+				return storage.Post{}, false, nil
+			}
+			post := posts[0]
+			posts = posts[1:]
+			return post, true, nil
+		},
+		nextEntry: func() (feedEntry, bool, error) {
+			if len(entries) == 0 {
+				return feedEntry{}, false, nil
+			}
+			entry := entries[0]
+			entries = entries[1:]
+			return entry, true, nil
+		},
+		add: func(post storage.Post) error {
+			added = append(added, post.Id)
+			return nil
+		},
+		update: func(entry feedEntry, post storage.Post) error {
+			updated = append(updated, post.Id)
+			return nil
+		},
+		remove: func(entry feedEntry) error {
+			removed = append(removed, entry.PostId)
+			return nil
+		},
+	}
+
+	repair := storage.FeedRepair{User: "b2"}
+	if err := sync.run(&repair); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(added) != 1 || added[0] != "p4" {
+		t.Errorf("added %v", added)
+	}
+	if len(updated) != 1 || updated[0] != "p2" {
+		t.Errorf("updated %v", updated)
+	}
+	if len(removed) != 2 || removed[0] != ids[0] || removed[1] != ids[1] {
+		t.Errorf("removed %v, want the second copy of p0 and the entry of the gone post", removed)
+	}
+	if repair.Added != 1 || repair.Updated != 1 || repair.Removed != 2 {
+		t.Errorf("unexpected repair %+v", repair)
+	}
+}
@@ -0,0 +1,124 @@
+package mongostore
+
+import (
+	"context"
+	"fmt"
+	"microblog/storage"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type collectionIndexes struct {
+	collection *mongo.Collection
+	configure  func(ctx context.Context, collection *mongo.Collection)
+}
+
+// indexes lists the collections with the functions that set up their
+// indexes, a collection may have several of them.
+func (s *storage_struct) indexes() []collectionIndexes {
+	return []collectionIndexes{
+		{s.posts, configurePostsIndexes},
+		{s.posts, configureModerationIndexes},
+		{s.subscriptions, configureSubscribesIndexes},
+		{s.feeds, configureFeedsIndexes},
+		{s.webhooks, configureWebhooksIndexes},
+		{s.deliveries, configureDeliveriesIndexes},
+		{s.notifications, configureNotificationsIndexes},
+		{s.notificationCursors, configureNotificationCursorsIndexes},
+		{s.settings, configureSettingsIndexes},
+		{s.followRequests, configureFollowRequestsIndexes},
+		{s.scheduled, configureScheduledIndexes},
+		{s.drafts, configureDraftsIndexes},
+		{s.pollVotes, configurePollVotesIndexes},
+		{s.media, configureMediaIndexes},
+		{s.reports, configureReportsIndexes},
+		{s.suspensions, configureSuspensionsIndexes},
+		{s.erasures, configureErasuresIndexes},
+		{s.blocks, configureRelationsIndexes},
+		{s.mutes, configureRelationsIndexes},
+		{s.pendingWrites, configurePendingWritesIndexes},
+		{s.events, configureEventsIndexes},
+		{s.eventCursors, configureEventCursorsIndexes},
+	}
+}
+
+// Migrate creates the indexes, existing ones are left as they are.
+func (s *storage_struct) Migrate(ctx context.Context) (err error) {
+	// configure functions panic, which is fine on start but not for a command
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v - %w", r, storage.ErrStorage)
+		}
+	}()
+
+	for _, indexes := range s.indexes() {
+		indexes.configure(ctx, indexes.collection)
+	}
+
+	return nil
+}
+
+// Reindex refuses to run while an event relay holds a lease, which tells that
+// a worker is up. Servers leave no such trace, the caller has to make sure
+// they are stopped.
+func (s *storage_struct) Reindex(ctx context.Context) error {
+	leased, err := s.eventCursors.CountDocuments(ctx, bson.M{"leaseUntil": bson.M{"$gt": time.Now().UnixNano()}})
+	if err != nil {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+	if leased != 0 {
+		return fmt.Errorf("an event relay is running, stop the workers first - %w", storage.ErrForbidden)
+	}
+
+	dropped := make(map[string]bool)
+	for _, indexes := range s.indexes() {
+		name := indexes.collection.Name()
+		if dropped[name] {
+			continue
+		}
+		dropped[name] = true
+
+		_, err := indexes.collection.Indexes().DropAll(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to drop indexes of %v - %w", name, storage.ErrStorage)
+		}
+	}
+
+	return s.Migrate(ctx)
+}
+
+func (s *storage_struct) Stats(ctx context.Context) (storage.Stats, error) {
+	var stats storage.Stats
+
+	counts := []struct {
+		count      *int64
+		collection *mongo.Collection
+		filter     bson.M
+	}{
+		{&stats.Posts, s.posts, bson.M{}},
+		{&stats.Subscriptions, s.subscriptions, bson.M{}},
+		{&stats.FeedPosts, s.feeds, bson.M{}},
+		{&stats.ScheduledPosts, s.scheduled, bson.M{}},
+		{&stats.Drafts, s.drafts, bson.M{}},
+		{&stats.FlaggedPosts, s.posts, bson.M{"moderation": storage.ModerationFlagged}},
+		{&stats.OpenReports, s.reports, bson.M{"status": storage.ReportOpen}},
+		{&stats.SuspendedUsers, s.suspensions, bson.M{}},
+	}
+	for _, c := range counts {
+		count, err := c.collection.CountDocuments(ctx, c.filter)
+		if err != nil {
+			return stats, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+		}
+		*c.count = count
+	}
+
+	authors, err := s.posts.Distinct(ctx, "authorId", bson.M{})
+	if err != nil {
+		return stats, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+	stats.Authors = int64(len(authors))
+
+	return stats, nil
+}
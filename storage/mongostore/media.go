@@ -0,0 +1,151 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"microblog/storage"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx"
+)
+
+func configureMediaIndexes(ctx context.Context, collection *mongo.Collection) {
+	indexModels := []mongo.IndexModel{
+		{
+			Keys:    bsonx.Doc{{Key: "id", Value: bsonx.Int32(1)}},
+			Options: options.Index().SetUnique(true),
+		},
+	}
+	opts := options.CreateIndexes().SetMaxTime(10 * time.Second)
+
+	_, err := collection.Indexes().CreateMany(ctx, indexModels, opts)
+	if err != nil {
+		panic(fmt.Errorf("failed to ensure indexes %w", err))
+	}
+}
+
+func (s *storage_struct) AddMedia(ctx context.Context, media storage.Media) error {
+	_, err := s.media.InsertOne(ctx, media)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("media %v already exists - %w", media.Id, storage.ErrCollision)
+		}
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return nil
+}
+
+func (s *storage_struct) GetMedia(ctx context.Context, mediaId string) (storage.Media, error) {
+	var media storage.Media
+
+	err := s.media.FindOne(ctx, bson.M{"id": mediaId}).Decode(&media)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return media, fmt.Errorf("no media with id %v - %w", mediaId, storage.ErrNotFound)
+		}
+		return media, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return media, nil
+}
+
+// Blobs are kept in GridFS with the blob id as the file id.
+
+func (s *storage_struct) PutBlob(ctx context.Context, blobId string, content io.Reader) error {
+	err := s.blobs.UploadFromStreamWithID(blobId, blobId, content)
+	if err != nil {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return nil
+}
+
+func (s *storage_struct) OpenBlob(ctx context.Context, blobId string) (io.ReadSeekCloser, error) {
+	stream, err := s.blobs.OpenDownloadStream(blobId)
+	if err != nil {
+		if errors.Is(err, gridfs.ErrFileNotFound) {
+			return nil, fmt.Errorf("no blob with id %v - %w", blobId, storage.ErrNotFound)
+		}
+		return nil, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return &gridfsBlob{bucket: s.blobs, id: blobId, size: stream.GetFile().Length, stream: stream}, nil
+}
+
+func (s *storage_struct) DeleteBlob(ctx context.Context, blobId string) error {
+	err := s.blobs.Delete(blobId)
+	if err != nil {
+		if errors.Is(err, gridfs.ErrFileNotFound) {
+			return fmt.Errorf("no blob with id %v - %w", blobId, storage.ErrNotFound)
+		}
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return nil
+}
+
+// gridfsBlob makes a download stream seekable: seeking drops the stream and
+// the next read opens a new one, skipping to the offset.
+type gridfsBlob struct {
+	bucket *gridfs.Bucket
+	id     string
+	size   int64
+	offset int64
+	stream *gridfs.DownloadStream
+}
+
+func (b *gridfsBlob) Read(p []byte) (int, error) {
+	if b.offset >= b.size {
+		return 0, io.EOF
+	}
+
+	if b.stream == nil {
+		stream, err := b.bucket.OpenDownloadStream(b.id)
+		if err != nil {
+			return 0, err
+		}
+		b.stream = stream
+
+		if _, err = stream.Skip(b.offset); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := b.stream.Read(p)
+	b.offset += int64(n)
+	return n, err
+}
+
+func (b *gridfsBlob) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += b.offset
+	case io.SeekEnd:
+		offset += b.size
+	}
+	if offset < 0 {
+		return b.offset, errors.New("negative offset")
+	}
+
+	if offset != b.offset && b.stream != nil {
+		b.stream.Close()
+		b.stream = nil
+	}
+	b.offset = offset
+
+	return offset, nil
+}
+
+func (b *gridfsBlob) Close() error {
+	if b.stream == nil {
+		return nil
+	}
+	return b.stream.Close()
+}
@@ -0,0 +1,156 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"microblog/storage"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx"
+)
+
+func configureModerationIndexes(ctx context.Context, collection *mongo.Collection) {
+	indexModels := []mongo.IndexModel{
+		{
+			Keys:    bsonx.Doc{{Key: "moderation", Value: bsonx.Int32(1)}},
+			Options: options.Index().SetSparse(true),
+		},
+	}
+	opts := options.CreateIndexes().SetMaxTime(10 * time.Second)
+
+	_, err := collection.Indexes().CreateMany(ctx, indexModels, opts)
+	if err != nil {
+		panic(fmt.Errorf("failed to ensure indexes %w", err))
+	}
+}
+
+func (s *storage_struct) FlagPost(ctx context.Context, postId string, reason string) (storage.Post, error) {
+	post, err := s.findPost(ctx, postId)
+	if err != nil {
+		return post, err
+	}
+
+	post.Moderation = storage.ModerationFlagged
+	post.ModerationReason = reason
+
+	event := storage.PostChanged(storage.EventPostFlagged, post)
+	err = s.atomically(ctx, pendingWrite{Type: pendingChange, Event: &event}, func(ctx context.Context) error {
+		_, err := s.posts.UpdateOne(
+			ctx,
+			bson.M{"id": postId},
+			bson.M{"$set": bson.M{"moderation": storage.ModerationFlagged, "moderationReason": reason}},
+		)
+		if err != nil {
+			return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+		}
+
+		return s.refanout(ctx, post)
+	})
+
+	return post, err
+}
+
+func (s *storage_struct) GetFlaggedPosts(ctx context.Context) (storage.FlaggedPosts, error) {
+	var answer storage.FlaggedPosts
+	answer.Posts = make([]storage.Post, 0)
+
+	opts := options.Find().SetSort(bson.M{"_id": 1})
+	cursor, err := s.posts.Find(ctx, bson.M{"moderation": storage.ModerationFlagged}, opts)
+	if err != nil {
+		return answer, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	if err = cursor.All(ctx, &answer.Posts); err != nil {
+		return answer, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return answer, nil
+}
+
+// ApprovePost also sends the notifications held back while the post was
+// flagged.
+func (s *storage_struct) ApprovePost(ctx context.Context, postId string) (storage.Post, error) {
+	post, err := s.findPost(ctx, postId)
+	if errors.Is(err, storage.ErrNotFound) || err == nil && post.Moderation != storage.ModerationFlagged {
+		return post, fmt.Errorf("no flagged post with id %v - %w", postId, storage.ErrNotFound)
+	}
+	if err != nil {
+		return post, err
+	}
+
+	post.Moderation = ""
+	post.ModerationReason = ""
+
+	event := storage.PostChanged(storage.EventPostApproved, post)
+	err = s.atomically(ctx, pendingWrite{Type: pendingChange, Event: &event}, func(ctx context.Context) error {
+		result, err := s.posts.UpdateOne(
+			ctx,
+			bson.M{"id": postId, "moderation": storage.ModerationFlagged},
+			bson.M{"$unset": bson.M{"moderation": "", "moderationReason": ""}},
+		)
+		if err != nil {
+			return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+		}
+		if result.MatchedCount == 0 {
+			// approved or removed in the meantime
+			return fmt.Errorf("no flagged post with id %v - %w", postId, storage.ErrNotFound)
+		}
+
+		return s.refanout(ctx, post)
+	})
+	if err != nil {
+		return post, err
+	}
+
+	storage.PublishMentions(ctx, s.pubsub, post)
+
+	reply_to_author := ""
+	if post.ReplyTo != "" {
+		parent, err := s.findPost(ctx, post.ReplyTo)
+		if err == nil && s.canSee(ctx, post, parent.AuthorId) {
+			reply_to_author = parent.AuthorId
+		}
+	}
+	s.addNotifications(ctx, storage.PostNotifications(post, reply_to_author))
+
+	return post, nil
+}
+
+func (s *storage_struct) RemovePost(ctx context.Context, postId string) (storage.Post, error) {
+	post, err := s.findPost(ctx, postId)
+	if err != nil {
+		return post, err
+	}
+
+	event := storage.PostChanged(storage.EventPostDeleted, post)
+	err = s.atomically(ctx, pendingWrite{Type: pendingChange, Event: &event}, func(ctx context.Context) error {
+		result, err := s.posts.DeleteOne(ctx, bson.M{"id": postId})
+		if err != nil {
+			return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+		}
+		if result.DeletedCount == 0 {
+			return fmt.Errorf("no post with id %v - %w", postId, storage.ErrNotFound)
+		}
+
+		_, err = s.feeds.DeleteMany(ctx, bson.M{"postId": post.MongoID})
+		if err != nil {
+			return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+		}
+		_, err = s.pollVotes.DeleteMany(ctx, bson.M{"postId": postId})
+		if err != nil {
+			return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+		}
+		return nil
+	})
+	if err != nil {
+		return post, err
+	}
+
+	storage.PublishPostEvent(ctx, s.pubsub, storage.PostEvent{Type: storage.EventPostDeleted, Post: post})
+
+	return post, nil
+}
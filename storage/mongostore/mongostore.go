@@ -4,6 +4,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/x/bsonx"
 
@@ -11,6 +12,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"microblog/pubsub"
 	"microblog/storage"
 	"os"
 	"time"
@@ -20,9 +22,36 @@ type storage_struct struct {
 	posts *mongo.Collection
 	subscriptions *mongo.Collection
 	feeds *mongo.Collection
+	webhooks *mongo.Collection
+	deliveries *mongo.Collection
+	notifications *mongo.Collection
+	notificationCursors *mongo.Collection
+	blocks *mongo.Collection
+	mutes *mongo.Collection
+	settings *mongo.Collection
+	followRequests *mongo.Collection
+	scheduled *mongo.Collection
+	drafts *mongo.Collection
+	pollVotes *mongo.Collection
+	media *mongo.Collection
+	reports *mongo.Collection
+	suspensions *mongo.Collection
+	erasures *mongo.Collection
+	blobs *gridfs.Bucket
+
+	client *mongo.Client
+	// without transactions multi-document writes are noted in pendingWrites
+	transactions bool
+	pendingWrites *mongo.Collection
+	// outbox
+	events *mongo.Collection
+	eventCursors *mongo.Collection
+
+	pubsub pubsub.PubSub
 }
 
-func NewStorage(mongoURL string) *storage_struct {
+// NewStorage connects to mongo. Fan-out is published to ps, which may be nil.
+func NewStorage(mongoURL string, ps pubsub.PubSub) *storage_struct {
 	ctx := context.Background()
 	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURL))
 	if err != nil {
@@ -30,21 +59,67 @@ func NewStorage(mongoURL string) *storage_struct {
 	}
 
 	posts := client.Database(os.Getenv("MONGO_DBNAME")).Collection("Posts")
-	configurePostsIndexes(ctx, posts)
-
 	subscriptions := client.Database(os.Getenv("MONGO_DBNAME")).Collection("Subscribes")
-	configureSubscribesIndexes(ctx, subscriptions)
-
 	feeds := client.Database(os.Getenv("MONGO_DBNAME")).Collection("Feeds")
-	configureFeedsIndexes(ctx, feeds)
+	webhooks := client.Database(os.Getenv("MONGO_DBNAME")).Collection("Webhooks")
+	deliveries := client.Database(os.Getenv("MONGO_DBNAME")).Collection("WebhookDeliveries")
+	notifications := client.Database(os.Getenv("MONGO_DBNAME")).Collection("Notifications")
+	notificationCursors := client.Database(os.Getenv("MONGO_DBNAME")).Collection("NotificationCursors")
+	settings := client.Database(os.Getenv("MONGO_DBNAME")).Collection("Settings")
+	followRequests := client.Database(os.Getenv("MONGO_DBNAME")).Collection("FollowRequests")
+	scheduled := client.Database(os.Getenv("MONGO_DBNAME")).Collection("ScheduledPosts")
+	drafts := client.Database(os.Getenv("MONGO_DBNAME")).Collection("Drafts")
+	pollVotes := client.Database(os.Getenv("MONGO_DBNAME")).Collection("PollVotes")
+	media := client.Database(os.Getenv("MONGO_DBNAME")).Collection("Media")
+	reports := client.Database(os.Getenv("MONGO_DBNAME")).Collection("Reports")
+	suspensions := client.Database(os.Getenv("MONGO_DBNAME")).Collection("Suspensions")
+	erasures := client.Database(os.Getenv("MONGO_DBNAME")).Collection("Erasures")
+	pendingWrites := client.Database(os.Getenv("MONGO_DBNAME")).Collection("PendingWrites")
+	events := client.Database(os.Getenv("MONGO_DBNAME")).Collection("Events")
+	eventCursors := client.Database(os.Getenv("MONGO_DBNAME")).Collection("EventCursors")
+
+	blobs, err := gridfs.NewBucket(client.Database(os.Getenv("MONGO_DBNAME")), options.GridFSBucket().SetName("media"))
+	if err != nil {
+		panic(err)
+	}
 
-	storage.IsReady = true
+	blocks := client.Database(os.Getenv("MONGO_DBNAME")).Collection("Blocks")
+	mutes := client.Database(os.Getenv("MONGO_DBNAME")).Collection("Mutes")
 
-	return &storage_struct{
+	s := &storage_struct{
 		posts: posts,
 		subscriptions: subscriptions,
 		feeds: feeds,
+		webhooks: webhooks,
+		deliveries: deliveries,
+		notifications: notifications,
+		notificationCursors: notificationCursors,
+		blocks: blocks,
+		mutes: mutes,
+		settings: settings,
+		followRequests: followRequests,
+		scheduled: scheduled,
+		drafts: drafts,
+		pollVotes: pollVotes,
+		media: media,
+		reports: reports,
+		suspensions: suspensions,
+		erasures: erasures,
+		blobs: blobs,
+		client: client,
+		transactions: supportsTransactions(ctx, client),
+		pendingWrites: pendingWrites,
+		events: events,
+		eventCursors: eventCursors,
+		pubsub: ps,
+	}
+	if err := s.Migrate(ctx); err != nil {
+		panic(err)
 	}
+
+	storage.IsReady = true
+
+	return s
 }
 
 func configurePostsIndexes(ctx context.Context, collection *mongo.Collection) {
@@ -53,6 +128,9 @@ func configurePostsIndexes(ctx context.Context, collection *mongo.Collection) {
 			Keys: bsonx.Doc{{Key: "authorId", Value: bsonx.Int32(1)},
 				{Key: "_id", Value: bsonx.Int32(1)}},
 		},
+		{
+			Keys: bsonx.Doc{{Key: "id", Value: bsonx.Int32(1)}},
+		},
 	}
 	opts := options.CreateIndexes().SetMaxTime(10 * time.Second)
 
@@ -100,8 +178,24 @@ func configureFeedsIndexes(ctx context.Context, collection *mongo.Collection) {
 func (s *storage_struct) PostPost(ctx context.Context, post storage.Post) error {
 	log.Println("User", post.AuthorId, "created post", post)
 
+	post.MentionedUsers = storage.Mentions(post.Text)
+
 	for attempt := 0; attempt < 5; attempt++ {
-		_, err := s.posts.InsertOne(ctx, post)
+		// generate the id here, so that the feed copies get it too
+		post.MongoID = primitive.NewObjectID()
+
+		var subscribers []string
+		event := storage.PostChanged(storage.EventPostCreated, post)
+		pending := pendingWrite{Type: pendingPost, PostId: post.Id, PostMongoID: post.MongoID, Event: &event}
+		err := s.atomically(ctx, pending, func(ctx context.Context) error {
+			_, err := s.posts.InsertOne(ctx, post)
+			if err != nil {
+				return err
+			}
+
+			subscribers, err = s.fanOut(ctx, post)
+			return err
+		})
 		if err != nil {
 			if mongo.IsDuplicateKeyError(err) {
 				continue
@@ -109,36 +203,28 @@ func (s *storage_struct) PostPost(ctx context.Context, post storage.Post) error
 			return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
 		}
 
-		// добавить также в feed всем, кто подписан на post.authorId
-		var subscription storage.Subscription
-		user := post.AuthorId
-
-		// find all subscribers of the user
-		cursor, err := s.subscriptions.Find(ctx, bson.M{"toUser": user})
-		if err != nil {
-			return err
-		}
-		defer cursor.Close(ctx)
-
-		cursor_ok := cursor.Next(ctx)
-		for cursor_ok{
-			if err = cursor.Decode(&subscription); err != nil {
-				return err
-			}
-			
-			feedpost := storage.FeedPost {
-				User: subscription.User,
-				PostId: post.MongoID,
-				Timestamp: post.Timestamp,
-				Post: post,
-			}
-			err = s.addFeedPost(ctx, feedpost)
+		// push only what is saved
+		for _, subscriber := range subscribers {
+			// muted posts stay in the feed, but are not pushed
+			muted, err := s.muted(ctx, subscriber, post.AuthorId)
 			if err != nil {
 				return err
 			}
+			if !muted {
+				storage.PublishFeedEvent(ctx, s.pubsub, subscriber, storage.FeedEvent{Token: post.MongoID.Hex(), Post: post})
+			}
+		}
 
-			cursor_ok = cursor.Next(ctx)
+		storage.PublishMentions(ctx, s.pubsub, post)
+
+		reply_to_author := ""
+		if post.ReplyTo != "" {
+			parent, err := s.findPost(ctx, post.ReplyTo)
+			if err == nil && s.canSee(ctx, post, parent.AuthorId) {
+				reply_to_author = parent.AuthorId
+			}
 		}
+		s.addNotifications(ctx, storage.PostNotifications(post, reply_to_author))
 
 		return nil
 	}
@@ -146,7 +232,46 @@ func (s *storage_struct) PostPost(ctx context.Context, post storage.Post) error
 	return fmt.Errorf("too much attempts during inserting - %w", storage.ErrCollision)
 }
 
-func (s *storage_struct) GetPost(ctx context.Context, postId string) (storage.Post, error) {
+// fanOut copies post into the feeds of the subscribers of its author who can
+// see it and returns them. Copies that are already there are replaced.
+func (s *storage_struct) fanOut(ctx context.Context, post storage.Post) ([]string, error) {
+	subscribers := make([]string, 0)
+
+	// добавить также в feed всем, кто подписан на post.authorId
+	var subscription storage.Subscription
+
+	// find all subscribers of the user
+	cursor, err := s.subscriptions.Find(ctx, bson.M{"toUser": post.AuthorId})
+	if err != nil {
+		return subscribers, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		if err = cursor.Decode(&subscription); err != nil {
+			return subscribers, err
+		}
+		if !storage.CanSee(post, subscription.User, true) {
+			continue
+		}
+
+		feedpost := storage.FeedPost{
+			User:      subscription.User,
+			PostId:    post.MongoID,
+			Timestamp: post.Timestamp,
+			Post:      post,
+		}
+		err = s.addFeedPost(ctx, feedpost)
+		if err != nil {
+			return subscribers, err
+		}
+		subscribers = append(subscribers, subscription.User)
+	}
+
+	return subscribers, cursor.Err()
+}
+
+func (s *storage_struct) findPost(ctx context.Context, postId string) (storage.Post, error) {
 	var result storage.Post
 
 	err := s.posts.FindOne(ctx, bson.M{"id": postId}).Decode(&result)
@@ -161,11 +286,29 @@ func (s *storage_struct) GetPost(ctx context.Context, postId string) (storage.Po
 	return result, nil
 }
 
-func (s *storage_struct) GetPostLine(ctx context.Context, user string, page_token string, size int) (storage.PostLineAnswer, error) {
+func (s *storage_struct) GetPostLine(ctx context.Context, user string, page_token string, size int, viewer string) (storage.PostLineAnswer, error) {
 	var answer storage.PostLineAnswer
 	answer.Posts = make([]storage.Post, 0)
 	var post storage.Post
 	var err error
+
+	if err = s.checkPostLineAccess(ctx, viewer, user); err != nil {
+		return answer, err
+	}
+	filter, err := s.postLineFilter(ctx, user, viewer)
+	if err != nil {
+		return answer, err
+	}
+
+	if page_token == "" {
+		answer.Pinned, err = s.pinnedPosts(ctx, filter)
+		if err != nil {
+			return answer, err
+		}
+	}
+	// pinned posts are not paginated
+	filter["pinnedAt"] = bson.M{"$exists": false}
+
 	var page_token_decoded primitive.ObjectID
 
 	// check if page_token is correct
@@ -181,7 +324,7 @@ func (s *storage_struct) GetPostLine(ctx context.Context, user string, page_toke
 		// make ObjectID from page_token
 		page_token_decoded, err = primitive.ObjectIDFromHex(page_token)
 		if err != nil {
-			panic(err)
+			return answer, fmt.Errorf("wrong page token %v - %w", page_token, storage.ErrNotFound)
 		}
 	}
 
@@ -193,10 +336,9 @@ func (s *storage_struct) GetPostLine(ctx context.Context, user string, page_toke
 	var cursor *mongo.Cursor
 
 	if page_token != "" {
-		cursor, err = s.posts.Find(ctx, bson.M{"authorId": user, "_id": bson.M{"$lte": page_token_decoded}}, opts)
-	} else {
-		cursor, err = s.posts.Find(ctx, bson.M{"authorId": user}, opts)
+		filter["_id"] = bson.M{"$lte": page_token_decoded}
 	}
+	cursor, err = s.posts.Find(ctx, filter, opts)
 
 	if err != nil {
 		panic(err)
@@ -232,6 +374,11 @@ func (s *storage_struct) GetPostLine(ctx context.Context, user string, page_toke
 		answer.Token = post.MongoID.Hex()
 	}
 
+	// save the token to poll for newer posts
+	if len(answer.Posts) > 0 {
+		answer.PrevToken = answer.Posts[0].MongoID.Hex()
+	}
+
 	// count, err := s.posts.CountDocuments(ctx, bson.D{})
 	// if err != nil {
 	// 	panic(err)
@@ -242,7 +389,7 @@ func (s *storage_struct) GetPostLine(ctx context.Context, user string, page_toke
 }
 
 func (s *storage_struct) ChangePostText(ctx context.Context, postId string, user string, new_text string, new_time string) (storage.Post, error) {
-	post, err := s.GetPost(ctx, postId)
+	post, err := s.findPost(ctx, postId)
 	if err != nil {
 		return post, err
 	}
@@ -251,35 +398,44 @@ func (s *storage_struct) ChangePostText(ctx context.Context, postId string, user
 		return post, storage.ErrUnauthorized
 	}
 
-	_, err = s.posts.UpdateOne(
-		ctx,
-		bson.M{"id": postId},
-		bson.M{"$set": bson.M{"text": new_text, "lastModifiedAt": new_time}},
-	)
-
-	if err != nil {
-		return post, nil
-	}
-
 	post.Text = new_text
 	post.LastModifiedAt = new_time
-	
-	// а еще изменить во всех копиях в feed
+	post.MentionedUsers = storage.Mentions(new_text)
+
+	event := storage.PostChanged(storage.EventPostEdited, post)
+	err = s.atomically(ctx, pendingWrite{Type: pendingChange, Event: &event}, func(ctx context.Context) error {
+		_, err := s.posts.UpdateOne(
+			ctx,
+			bson.M{"id": postId},
+			bson.M{"$set": bson.M{"text": new_text, "lastModifiedAt": new_time, "mentions": post.MentionedUsers}},
+		)
+		if err != nil {
+			return err
+		}
 
-	_, err = s.feeds.UpdateMany(
-		ctx,
-		bson.M{"postId": post.MongoID},
-		bson.M{"$set": bson.M{"post": post}},
-	)
+		// а еще изменить во всех копиях в feed
 
+		if post.Visibility == storage.VisibilityMentioned {
+			// mentions decide who sees it
+			return s.refanout(ctx, post)
+		}
+		_, err = s.feeds.UpdateMany(
+			ctx,
+			bson.M{"postId": post.MongoID},
+			bson.M{"$set": bson.M{"post": post}},
+		)
+		return err
+	})
 	if err != nil {
-		return post, nil
+		return post, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
 	}
 
+	storage.PublishPostEvent(ctx, s.pubsub, storage.PostEvent{Type: storage.EventPostEdited, Post: post})
+
 	return post, err
 }
 
-func (s *storage_struct) Subscribe(ctx context.Context, user string, to_user string) error {
+func (s *storage_struct) Subscribe(ctx context.Context, user string, to_user string) (string, error) {
 	log.Printf("Called Subscribe user %s to %s\n", user, to_user)
 
 	count, err := s.subscriptions.CountDocuments(ctx, bson.M{"user": user, "toUser": to_user})
@@ -288,35 +444,72 @@ func (s *storage_struct) Subscribe(ctx context.Context, user string, to_user str
 	}
 	already_subed := count != 0
 
+	blocked, err := s.blocked(ctx, user, to_user)
+	if err != nil {
+		return "", err
+	}
+	if blocked {
+		return "", fmt.Errorf("user %v can not subscribe to %v - %w", user, to_user, storage.ErrForbidden)
+	}
+
 	if (!already_subed) {
+		settings, err := s.GetSettings(ctx, to_user)
+		if err != nil {
+			return "", err
+		}
+		if settings.Private {
+			return s.requestFollow(ctx, user, to_user)
+		}
+
+		err = s.subscribe(ctx, user, to_user, nil)
+		if err != nil {
+			return "", err
+		}
+
+		s.addNotifications(ctx, []storage.Notification{storage.SubscriberNotification(user, to_user)})
+
+		return storage.SubscriptionActive, nil
+	}
 
-		for attempt := 0; attempt < 5; attempt++ {
+	return "", fmt.Errorf("user %v is already subscribed to %v - %w", user, to_user, storage.ErrCollision)
+}
+
+// subscribe saves the subscription with the copies of the posts of to_user,
+// also is run in the same write when it is set.
+func (s *storage_struct) subscribe(ctx context.Context, user string, to_user string, also func(ctx context.Context) error) error {
+	for attempt := 0; attempt < 5; attempt++ {
+		event := storage.SubscriptionChanged(storage.EventSubscriptionAdded, user, to_user)
+		pending := pendingWrite{Type: pendingSubscription, User: user, ToUser: to_user, Event: &event}
+		err := s.atomically(ctx, pending, func(ctx context.Context) error {
 			// Вставить без дупликатов
 			opts := options.Update().SetUpsert(true)
-			_, err = s.subscriptions.UpdateOne(
-				ctx, 
+			_, err := s.subscriptions.UpdateOne(
+				ctx,
 				bson.M{"user": user, "toUser": to_user},
 				bson.M{"$set": bson.M{"user": user, "toUser": to_user}},
 				opts,
 			)
-
 			if err != nil {
-				if mongo.IsDuplicateKeyError(err) {
-					continue
-				}
-				log.Fatalln("error: ", err.Error())
-				return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+				return err
 			}
 
 			err = s.copyPostsToSubscriber(ctx, user, to_user)
-			if err != nil {
-				log.Fatalln("error: ", err.Error())
-				return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+			if err != nil || also == nil {
+				return err
 			}
 
-			return nil
+			return also(ctx)
+		})
+
+		if err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				continue
+			}
+			log.Println("error: ", err.Error())
+			return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
 		}
 
+		return nil
 	}
 
 	return fmt.Errorf("too much attempts during inserting - %w", storage.ErrCollision)
@@ -326,6 +519,7 @@ func (s *storage_struct) GetSubscriptions(ctx context.Context, user string) (sto
 	log.Printf("Called Subscriptions of user %s\n", user)
 
 	var answer storage.Subscriptions
+	answer.Users = make([]string, 0)
 	var subscription storage.Subscription
 
 	// find all subscriptions of the user
@@ -355,6 +549,7 @@ func (s *storage_struct) GetSubscribers(ctx context.Context, user string) (stora
 	log.Printf("Called Subscribers of user %s\n", user)
 
 	var answer storage.Subscribers
+	answer.Users = make([]string, 0)
 	var subscription storage.Subscription
 	
 	// find all subscriptions of the user
@@ -388,7 +583,7 @@ func (s *storage_struct) GetFeed(ctx context.Context, user string, page_token st
 
 	// check if page_token is correct
 	if page_token != "" {
-		count, err := s.posts.CountDocuments(ctx, bson.M{"user": user})
+		count, err := s.feeds.CountDocuments(ctx, bson.M{"user": user})
 		if err != nil {
 			panic(err)
 		}
@@ -399,21 +594,26 @@ func (s *storage_struct) GetFeed(ctx context.Context, user string, page_token st
 		// make ObjectID from page_token
 		page_token_decoded, err = primitive.ObjectIDFromHex(page_token)
 		if err != nil {
-			panic(err)
+			return answer, fmt.Errorf("wrong page token %v - %w", page_token, storage.ErrNotFound)
 		}
 	}
 
+	// page tokens are post ids, so sort by them too
 	opts := options.Find()
-	opts.SetSort(bson.M{"time": -1})
+	opts.SetSort(bson.M{"postId": -1})
 
-	// find all posts of the user sorted by time beginning from page_token post
+	// find all posts of the feed sorted beginning from page_token post
 	var cursor *mongo.Cursor
 
+	// muted authors are skipped
+	filter, err := s.feedFilter(ctx, user)
+	if err != nil {
+		return answer, err
+	}
 	if page_token != "" {
-		cursor, err = s.feeds.Find(ctx, bson.M{"user": user, "postId": bson.M{"$lte": page_token_decoded}}, opts)
-	} else {
-		cursor, err = s.feeds.Find(ctx, bson.M{"user": user}, opts)
+		filter["postId"] = bson.M{"$lte": page_token_decoded}
 	}
+	cursor, err = s.feeds.Find(ctx, filter, opts)
 
 	if err != nil {
 		return answer, err
@@ -435,6 +635,9 @@ func (s *storage_struct) GetFeed(ctx context.Context, user string, page_token st
 			return answer, err
 		}
 
+		if i == 0 {
+			answer.PrevToken = feedpost.PostId.Hex()
+		}
 		answer.Posts = append(answer.Posts, feedpost.Post)
 
 		i++
@@ -453,6 +656,128 @@ func (s *storage_struct) GetFeed(ctx context.Context, user string, page_token st
 	return answer, nil
 }
 
+func (s *storage_struct) GetPostLineSince(ctx context.Context, user string, since_token string, size int, viewer string) (storage.PostLineAnswer, error) {
+	var answer storage.PostLineAnswer
+	answer.Posts = make([]storage.Post, 0)
+	answer.Token = since_token
+	answer.PrevToken = since_token
+
+	if err := s.checkPostLineAccess(ctx, viewer, user); err != nil {
+		return answer, err
+	}
+
+	since, err := primitive.ObjectIDFromHex(since_token)
+	if err != nil {
+		return answer, fmt.Errorf("wrong page token %v - %w", since_token, storage.ErrNotFound)
+	}
+	if size == 0 {
+		return answer, nil
+	}
+
+	// read the posts right after since in ascending order
+	opts := options.Find()
+	opts.SetSort(bson.M{"_id": 1})
+	opts.SetLimit(int64(size))
+
+	filter, err := s.postLineFilter(ctx, user, viewer)
+	if err != nil {
+		return answer, err
+	}
+	filter["_id"] = bson.M{"$gt": since}
+	filter["pinnedAt"] = bson.M{"$exists": false}
+
+	cursor, err := s.posts.Find(ctx, filter, opts)
+	if err != nil {
+		return answer, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var post storage.Post
+		if err = cursor.Decode(&post); err != nil {
+			return answer, err
+		}
+		// but answer newest first as usual
+		answer.Posts = append([]storage.Post{post}, answer.Posts...)
+	}
+	if err = cursor.Err(); err != nil {
+		return answer, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	if len(answer.Posts) > 0 {
+		answer.PrevToken = answer.Posts[0].MongoID.Hex()
+	}
+
+	return answer, nil
+}
+
+func (s *storage_struct) GetFeedSince(ctx context.Context, user string, since_token string, size int) (storage.PostLineAnswer, error) {
+	var answer storage.PostLineAnswer
+	answer.Posts = make([]storage.Post, 0)
+	answer.Token = since_token
+	answer.PrevToken = since_token
+
+	since, err := primitive.ObjectIDFromHex(since_token)
+	if err != nil {
+		return answer, fmt.Errorf("wrong page token %v - %w", since_token, storage.ErrNotFound)
+	}
+	if size == 0 {
+		return answer, nil
+	}
+
+	// read the feed right after since in ascending order
+	opts := options.Find()
+	opts.SetSort(bson.M{"postId": 1})
+	opts.SetLimit(int64(size))
+
+	filter, err := s.feedFilter(ctx, user)
+	if err != nil {
+		return answer, err
+	}
+	filter["postId"] = bson.M{"$gt": since}
+
+	cursor, err := s.feeds.Find(ctx, filter, opts)
+	if err != nil {
+		return answer, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var feedpost storage.FeedPost
+		if err = cursor.Decode(&feedpost); err != nil {
+			return answer, err
+		}
+		// but answer newest first as usual
+		answer.Posts = append([]storage.Post{feedpost.Post}, answer.Posts...)
+		answer.PrevToken = feedpost.PostId.Hex()
+	}
+	if err = cursor.Err(); err != nil {
+		return answer, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return answer, nil
+}
+
+func (s *storage_struct) CountFeedSince(ctx context.Context, user string, since_token string) (int64, error) {
+	since, err := primitive.ObjectIDFromHex(since_token)
+	if err != nil {
+		return 0, fmt.Errorf("wrong page token %v - %w", since_token, storage.ErrNotFound)
+	}
+
+	filter, err := s.feedFilter(ctx, user)
+	if err != nil {
+		return 0, err
+	}
+	filter["postId"] = bson.M{"$gt": since}
+
+	count, err := s.feeds.CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return count, nil
+}
+
 func (s *storage_struct) copyPostsToSubscriber(ctx context.Context, user string, to_user string) error {
 	cursor, err := s.posts.Find(ctx, bson.M{"authorId": to_user})
 	if err != nil {
@@ -467,6 +792,10 @@ func (s *storage_struct) copyPostsToSubscriber(ctx context.Context, user string,
 		if err = cursor.Decode(&post); err != nil {
 			return err
 		}
+		if !storage.CanSee(post, user, true) {
+			cursor_ok = cursor.Next(ctx)
+			continue
+		}
 
 		feedpost := storage.FeedPost{
 			User: user,
@@ -487,9 +816,17 @@ func (s *storage_struct) copyPostsToSubscriber(ctx context.Context, user string,
 	return nil
 }
 
+// addFeedPost saves feedpost, replacing the copy of the same post in the
+// same feed if there is one, so fan-out may be repeated.
 func (s *storage_struct) addFeedPost(ctx context.Context, feedpost storage.FeedPost) error {
 	for attempt := 0; attempt < 5; attempt++ {
-		_, err := s.feeds.InsertOne(ctx, feedpost)
+		opts := options.Update().SetUpsert(true)
+		_, err := s.feeds.UpdateOne(
+			ctx,
+			bson.M{"user": feedpost.User, "postId": feedpost.PostId},
+			bson.M{"$set": feedpost},
+			opts,
+		)
 		if err != nil {
 			if mongo.IsDuplicateKeyError(err) {
 				continue
@@ -501,4 +838,4 @@ func (s *storage_struct) addFeedPost(ctx context.Context, feedpost storage.FeedP
 	}
 
 	return fmt.Errorf("too much attempts during inserting - %w", storage.ErrCollision)
-}
\ No newline at end of file
+}
@@ -0,0 +1,189 @@
+package mongostore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"microblog/storage"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx"
+)
+
+// notificationCursor keeps the last read notification of a user.
+type notificationCursor struct {
+	User     string             `bson:"user"`
+	ReadUpTo primitive.ObjectID `bson:"readUpTo"`
+}
+
+func configureNotificationsIndexes(ctx context.Context, collection *mongo.Collection) {
+	indexModels := []mongo.IndexModel{
+		{
+			Keys: bsonx.Doc{{Key: "user", Value: bsonx.Int32(1)},
+				{Key: "_id", Value: bsonx.Int32(-1)}},
+		},
+	}
+	opts := options.CreateIndexes().SetMaxTime(10 * time.Second)
+
+	_, err := collection.Indexes().CreateMany(ctx, indexModels, opts)
+	if err != nil {
+		panic(fmt.Errorf("failed to ensure indexes %w", err))
+	}
+}
+
+func configureNotificationCursorsIndexes(ctx context.Context, collection *mongo.Collection) {
+	indexModels := []mongo.IndexModel{
+		{
+			Keys:    bsonx.Doc{{Key: "user", Value: bsonx.Int32(1)}},
+			Options: options.Index().SetUnique(true),
+		},
+	}
+	opts := options.CreateIndexes().SetMaxTime(10 * time.Second)
+
+	_, err := collection.Indexes().CreateMany(ctx, indexModels, opts)
+	if err != nil {
+		panic(fmt.Errorf("failed to ensure indexes %w", err))
+	}
+}
+
+// addNotifications saves notifications. They are a side effect of the write
+// that caused them, so errors are only logged.
+func (s *storage_struct) addNotifications(ctx context.Context, notifications []storage.Notification) {
+	if len(notifications) == 0 {
+		return
+	}
+
+	docs := make([]interface{}, 0, len(notifications))
+	for _, notification := range notifications {
+		notification.MongoID = primitive.NewObjectID()
+		notification.Id = notification.MongoID.Hex()
+		docs = append(docs, notification)
+	}
+
+	_, err := s.notifications.InsertMany(ctx, docs)
+	if err != nil {
+		log.Println("Failed to save notifications due to an error:", err)
+	}
+}
+
+// readUpTo returns the read cursor of user, zero if nothing was read yet.
+func (s *storage_struct) readUpTo(ctx context.Context, user string) (primitive.ObjectID, error) {
+	var cursor notificationCursor
+
+	err := s.notificationCursors.FindOne(ctx, bson.M{"user": user}).Decode(&cursor)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return primitive.NilObjectID, nil
+		}
+		return primitive.NilObjectID, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return cursor.ReadUpTo, nil
+}
+
+func (s *storage_struct) GetNotifications(ctx context.Context, user string, page_token string, size int) (storage.Notifications, error) {
+	var answer storage.Notifications
+	answer.Notifications = make([]storage.Notification, 0)
+
+	filter := bson.M{"user": user}
+	if page_token != "" {
+		page_token_decoded, err := primitive.ObjectIDFromHex(page_token)
+		if err != nil {
+			return answer, fmt.Errorf("wrong page token %v - %w", page_token, storage.ErrNotFound)
+		}
+		filter["_id"] = bson.M{"$lte": page_token_decoded}
+	}
+
+	read_up_to, err := s.readUpTo(ctx, user)
+	if err != nil {
+		return answer, err
+	}
+
+	// one more to know the next page token
+	opts := options.Find().SetSort(bson.M{"_id": -1}).SetLimit(int64(size) + 1)
+	cursor, err := s.notifications.Find(ctx, filter, opts)
+	if err != nil {
+		return answer, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	var notifications []storage.Notification
+	if err = cursor.All(ctx, &notifications); err != nil {
+		return answer, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	if page_token != "" && (len(notifications) == 0 || notifications[0].Id != page_token) {
+		return answer, storage.ErrNotFound
+	}
+
+	if len(notifications) > size {
+		answer.Token = notifications[size].Id
+		notifications = notifications[:size]
+	}
+
+	for _, notification := range notifications {
+		notification.Read = bytes.Compare(notification.MongoID[:], read_up_to[:]) <= 0
+		answer.Notifications = append(answer.Notifications, notification)
+	}
+
+	return answer, nil
+}
+
+func (s *storage_struct) MarkNotificationsRead(ctx context.Context, user string, notificationId string) error {
+	var read_up_to primitive.ObjectID
+
+	if notificationId != "" {
+		var notification storage.Notification
+		err := s.notifications.FindOne(ctx, bson.M{"user": user, "id": notificationId}).Decode(&notification)
+		if err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return fmt.Errorf("no notification with id %v - %w", notificationId, storage.ErrNotFound)
+			}
+			return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+		}
+		read_up_to = notification.MongoID
+	} else {
+		var notification storage.Notification
+		opts := options.FindOne().SetSort(bson.M{"_id": -1})
+		err := s.notifications.FindOne(ctx, bson.M{"user": user}, opts).Decode(&notification)
+		if err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return nil
+			}
+			return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+		}
+		read_up_to = notification.MongoID
+	}
+
+	// $max: the cursor never goes back
+	_, err := s.notificationCursors.UpdateOne(
+		ctx,
+		bson.M{"user": user},
+		bson.M{"$max": bson.M{"readUpTo": read_up_to}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return nil
+}
+
+func (s *storage_struct) CountUnreadNotifications(ctx context.Context, user string) (int64, error) {
+	read_up_to, err := s.readUpTo(ctx, user)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := s.notifications.CountDocuments(ctx, bson.M{"user": user, "_id": bson.M{"$gt": read_up_to}})
+	if err != nil {
+		return 0, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return count, nil
+}
@@ -0,0 +1,183 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"microblog/storage"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx"
+)
+
+// Events are kept for eventRetention, consumers that fall further behind
+// miss some.
+const eventRetention = 7 * 24 * time.Hour
+
+// Writers don't save events in the order of their ids, so ReadEvents only
+// returns events older than eventSettleTime, when the ones before them are
+// saved too.
+const eventSettleTime = 5 * time.Second
+
+type eventDoc struct {
+	storage.Event `bson:",inline"`
+	MongoID       primitive.ObjectID `bson:"_id,omitempty"`
+	// the pending write that saves the event, when there are no transactions
+	PendingWrite primitive.ObjectID `bson:"pendingWrite,omitempty"`
+	SavedAt      time.Time          `bson:"savedAt"`
+}
+
+type eventCursor struct {
+	Consumer string `bson:"consumer"`
+	Id       string `bson:"id"`
+	// the relay that has the lease on the cursor, and until when
+	Holder     string `bson:"holder,omitempty"`
+	LeaseUntil int64  `bson:"leaseUntil,omitempty"`
+}
+
+func configureEventsIndexes(ctx context.Context, collection *mongo.Collection) {
+	indexModels := []mongo.IndexModel{
+		{
+			Keys:    bsonx.Doc{{Key: "pendingWrite", Value: bsonx.Int32(1)}},
+			Options: options.Index().SetUnique(true).SetSparse(true),
+		},
+		{
+			Keys:    bsonx.Doc{{Key: "savedAt", Value: bsonx.Int32(1)}},
+			Options: options.Index().SetExpireAfterSeconds(int32(eventRetention.Seconds())),
+		},
+	}
+	opts := options.CreateIndexes().SetMaxTime(10 * time.Second)
+
+	_, err := collection.Indexes().CreateMany(ctx, indexModels, opts)
+	if err != nil {
+		panic(fmt.Errorf("failed to ensure indexes %w", err))
+	}
+}
+
+func configureEventCursorsIndexes(ctx context.Context, collection *mongo.Collection) {
+	indexModels := []mongo.IndexModel{
+		{
+			Keys:    bsonx.Doc{{Key: "consumer", Value: bsonx.Int32(1)}},
+			Options: options.Index().SetUnique(true),
+		},
+	}
+	opts := options.CreateIndexes().SetMaxTime(10 * time.Second)
+
+	_, err := collection.Indexes().CreateMany(ctx, indexModels, opts)
+	if err != nil {
+		panic(fmt.Errorf("failed to ensure indexes %w", err))
+	}
+}
+
+// saveEvent adds event to the outbox. Events of a pending write are saved
+// once however many times the write is finished.
+func (s *storage_struct) saveEvent(ctx context.Context, event storage.Event, pending primitive.ObjectID) error {
+	doc := eventDoc{Event: event, SavedAt: time.Now()}
+
+	var err error
+	if pending.IsZero() {
+		_, err = s.events.InsertOne(ctx, doc)
+	} else {
+		opts := options.Update().SetUpsert(true)
+		_, err = s.events.UpdateOne(ctx, bson.M{"pendingWrite": pending}, bson.M{"$setOnInsert": doc}, opts)
+	}
+	if err != nil {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return nil
+}
+
+func (s *storage_struct) ReadEvents(ctx context.Context, after string, size int) ([]storage.Event, error) {
+	ids := bson.M{"$lt": primitive.NewObjectIDFromTimestamp(time.Now().Add(-eventSettleTime))}
+	if after != "" {
+		after_id, err := primitive.ObjectIDFromHex(after)
+		if err != nil {
+			return nil, fmt.Errorf("no event with id %v - %w", after, storage.ErrNotFound)
+		}
+		ids["$gt"] = after_id
+	}
+
+	opts := options.Find().SetSort(bson.M{"_id": 1}).SetLimit(int64(size))
+	cursor, err := s.events.Find(ctx, bson.M{"_id": ids}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	var docs []eventDoc
+	if err = cursor.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	events := make([]storage.Event, 0, len(docs))
+	for _, doc := range docs {
+		doc.Event.Id = doc.MongoID.Hex()
+		events = append(events, doc.Event)
+	}
+
+	return events, nil
+}
+
+func (s *storage_struct) GetEventCursor(ctx context.Context, consumer string) (string, error) {
+	var cursor eventCursor
+
+	err := s.eventCursors.FindOne(ctx, bson.M{"consumer": consumer}).Decode(&cursor)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return "", nil
+		}
+		return "", fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return cursor.Id, nil
+}
+
+func (s *storage_struct) SetEventCursor(ctx context.Context, consumer string, id string) error {
+	opts := options.Update().SetUpsert(true)
+	_, err := s.eventCursors.UpdateOne(
+		ctx,
+		bson.M{"consumer": consumer},
+		bson.M{"$set": bson.M{"id": id}},
+		opts,
+	)
+	if err != nil {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return nil
+}
+
+// LeaseEventCursor takes the lease when it is free, expired or held by
+// holder already. Otherwise the filter matches nothing, and the upsert runs
+// into the unique index on the consumer.
+func (s *storage_struct) LeaseEventCursor(ctx context.Context, consumer string, holder string, ttl time.Duration) error {
+	now := time.Now()
+	filter := bson.M{
+		"consumer": consumer,
+		"$or": []bson.M{
+			{"holder": bson.M{"$exists": false}},
+			{"holder": holder},
+			{"leaseUntil": bson.M{"$lt": now.UnixNano()}},
+		},
+	}
+
+	opts := options.Update().SetUpsert(true)
+	_, err := s.eventCursors.UpdateOne(
+		ctx,
+		filter,
+		bson.M{"$set": bson.M{"holder": holder, "leaseUntil": now.Add(ttl).UnixNano()}},
+		opts,
+	)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("cursor of %v is leased by another relay - %w", consumer, storage.ErrCollision)
+		}
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package mongostore
+
+import (
+	"context"
+	"fmt"
+	"microblog/storage"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (s *storage_struct) PinPost(ctx context.Context, postId string, user string) error {
+	post, err := s.findPost(ctx, postId)
+	if err != nil {
+		return err
+	}
+	if post.AuthorId != user {
+		return storage.ErrUnauthorized
+	}
+	if post.PinnedAt != 0 {
+		return nil
+	}
+
+	count, err := s.posts.CountDocuments(ctx, bson.M{"authorId": user, "pinnedAt": bson.M{"$exists": true}})
+	if err != nil {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+	if count >= storage.MaxPinnedPosts {
+		return fmt.Errorf("at most %v posts can be pinned - %w", storage.MaxPinnedPosts, storage.ErrCollision)
+	}
+
+	_, err = s.posts.UpdateOne(ctx, bson.M{"id": postId}, bson.M{"$set": bson.M{"pinnedAt": time.Now().UnixNano()}})
+	if err != nil {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return nil
+}
+
+func (s *storage_struct) UnpinPost(ctx context.Context, postId string, user string) error {
+	post, err := s.findPost(ctx, postId)
+	if err != nil {
+		return err
+	}
+	if post.AuthorId != user {
+		return storage.ErrUnauthorized
+	}
+
+	_, err = s.posts.UpdateOne(ctx, bson.M{"id": postId}, bson.M{"$unset": bson.M{"pinnedAt": ""}})
+	if err != nil {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return nil
+}
+
+// pinnedPosts returns the posts of the post line filter that are pinned,
+// last pinned first.
+func (s *storage_struct) pinnedPosts(ctx context.Context, filter bson.M) ([]storage.Post, error) {
+	pinned_filter := bson.M{"pinnedAt": bson.M{"$exists": true}}
+	for key, value := range filter {
+		pinned_filter[key] = value
+	}
+
+	opts := options.Find().SetSort(bson.M{"pinnedAt": -1})
+	cursor, err := s.posts.Find(ctx, pinned_filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	var posts []storage.Post
+	if err = cursor.All(ctx, &posts); err != nil {
+		return nil, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return posts, nil
+}
@@ -0,0 +1,83 @@
+package mongostore
+
+import (
+	"context"
+	"fmt"
+	"microblog/storage"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx"
+)
+
+func configurePollVotesIndexes(ctx context.Context, collection *mongo.Collection) {
+	indexModels := []mongo.IndexModel{
+		{
+			Keys: bsonx.Doc{{Key: "postId", Value: bsonx.Int32(1)},
+				{Key: "user", Value: bsonx.Int32(1)}},
+			Options: options.Index().SetUnique(true),
+		},
+	}
+	opts := options.CreateIndexes().SetMaxTime(10 * time.Second)
+
+	_, err := collection.Indexes().CreateMany(ctx, indexModels, opts)
+	if err != nil {
+		panic(fmt.Errorf("failed to ensure indexes %w", err))
+	}
+}
+
+// VotePoll records the vote first, the unique index makes sure there is one
+// per user, and then counts it in the post and all its feed copies.
+func (s *storage_struct) VotePoll(ctx context.Context, postId string, user string, option int) (storage.Post, error) {
+	post, err := s.findPost(ctx, postId)
+	if err != nil {
+		return post, err
+	}
+	if !s.canSee(ctx, post, user) {
+		return storage.Post{}, fmt.Errorf("no post with id %v - %w", postId, storage.ErrNotFound)
+	}
+	if post.Poll == nil || option < 0 || option >= len(post.Poll.Options) {
+		return post, fmt.Errorf("post %v has no poll option %v - %w", postId, option, storage.ErrNotFound)
+	}
+	if !post.Poll.Open(time.Now()) {
+		return post, fmt.Errorf("poll of post %v is closed - %w", postId, storage.ErrForbidden)
+	}
+
+	vote := storage.PollVote{PostId: postId, User: user, Option: option}
+	_, err = s.pollVotes.InsertOne(ctx, vote)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return post, fmt.Errorf("%v already voted in poll of post %v - %w", user, postId, storage.ErrCollision)
+		}
+		return post, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	votes := "poll.options." + strconv.Itoa(option) + ".votes"
+	result, err := s.posts.UpdateOne(
+		ctx,
+		bson.M{"id": postId, "poll.closes": bson.M{"$gt": time.Now().UnixNano()}},
+		bson.M{"$inc": bson.M{votes: 1}},
+	)
+	if err != nil || result.MatchedCount == 0 {
+		// closed in the meantime or failed, the vote does not count
+		s.pollVotes.DeleteOne(ctx, bson.M{"postId": postId, "user": user})
+		if err != nil {
+			return post, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+		}
+		return post, fmt.Errorf("poll of post %v is closed - %w", postId, storage.ErrForbidden)
+	}
+
+	_, err = s.feeds.UpdateMany(
+		ctx,
+		bson.M{"postId": post.MongoID},
+		bson.M{"$inc": bson.M{"post." + votes: 1}},
+	)
+	if err != nil {
+		return post, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return s.findPost(ctx, postId)
+}
@@ -0,0 +1,162 @@
+package mongostore
+
+import (
+	"context"
+	"fmt"
+	"microblog/storage"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx"
+)
+
+func configureRelationsIndexes(ctx context.Context, collection *mongo.Collection) {
+	indexModels := []mongo.IndexModel{
+		{
+			Keys: bsonx.Doc{{Key: "user", Value: bsonx.Int32(1)},
+				{Key: "target", Value: bsonx.Int32(1)}},
+			Options: options.Index().SetUnique(true),
+		},
+	}
+	opts := options.CreateIndexes().SetMaxTime(10 * time.Second)
+
+	_, err := collection.Indexes().CreateMany(ctx, indexModels, opts)
+	if err != nil {
+		panic(fmt.Errorf("failed to ensure indexes %w", err))
+	}
+}
+
+func addRelation(ctx context.Context, collection *mongo.Collection, user string, target string) error {
+	relation := storage.Relation{User: user, Target: target}
+
+	opts := options.Update().SetUpsert(true)
+	_, err := collection.UpdateOne(ctx, relation, bson.M{"$set": relation}, opts)
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return nil
+}
+
+func removeRelation(ctx context.Context, collection *mongo.Collection, user string, target string) error {
+	_, err := collection.DeleteOne(ctx, storage.Relation{User: user, Target: target})
+	if err != nil {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return nil
+}
+
+func (s *storage_struct) Block(ctx context.Context, user string, target string) error {
+	// block first, so that no new subscription sneaks in
+	err := addRelation(ctx, s.blocks, user, target)
+	if err != nil {
+		return err
+	}
+
+	err = s.unsubscribe(ctx, user, target)
+	if err != nil {
+		return err
+	}
+
+	err = s.unsubscribe(ctx, target, user)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.followRequests.DeleteMany(ctx, bson.M{"$or": []bson.M{
+		{"user": user, "toUser": target},
+		{"user": target, "toUser": user},
+	}})
+	if err != nil {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return nil
+}
+
+func (s *storage_struct) Unblock(ctx context.Context, user string, target string) error {
+	return removeRelation(ctx, s.blocks, user, target)
+}
+
+func (s *storage_struct) Mute(ctx context.Context, user string, target string) error {
+	return addRelation(ctx, s.mutes, user, target)
+}
+
+func (s *storage_struct) Unmute(ctx context.Context, user string, target string) error {
+	return removeRelation(ctx, s.mutes, user, target)
+}
+
+// blocked tells if either user blocks the other.
+func (s *storage_struct) blocked(ctx context.Context, user string, other string) (bool, error) {
+	count, err := s.blocks.CountDocuments(ctx, bson.M{"$or": []bson.M{
+		{"user": user, "target": other},
+		{"user": other, "target": user},
+	}})
+	if err != nil {
+		return false, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return count != 0, nil
+}
+
+// muted tells if user has muted author.
+func (s *storage_struct) muted(ctx context.Context, user string, author string) (bool, error) {
+	count, err := s.mutes.CountDocuments(ctx, storage.Relation{User: user, Target: author})
+	if err != nil {
+		return false, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return count != 0, nil
+}
+
+// feedFilter selects the feed of user without the muted authors.
+func (s *storage_struct) feedFilter(ctx context.Context, user string) (bson.M, error) {
+	filter := bson.M{"user": user}
+
+	cursor, err := s.mutes.Find(ctx, bson.M{"user": user})
+	if err != nil {
+		return filter, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	var mutes []storage.Relation
+	if err = cursor.All(ctx, &mutes); err != nil {
+		return filter, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	if len(mutes) > 0 {
+		authors := make([]string, 0, len(mutes))
+		for _, mute := range mutes {
+			authors = append(authors, mute.Target)
+		}
+		filter["post.authorId"] = bson.M{"$nin": authors}
+	}
+
+	return filter, nil
+}
+
+// unsubscribe removes the subscription of user to to_user and the posts of
+// to_user from the feed of user.
+func (s *storage_struct) unsubscribe(ctx context.Context, user string, to_user string) error {
+	subscribed, err := s.subscribed(ctx, user, to_user)
+	if err != nil || !subscribed {
+		return err
+	}
+
+	event := storage.SubscriptionChanged(storage.EventSubscriptionRemoved, user, to_user)
+	return s.atomically(ctx, pendingWrite{Type: pendingChange, Event: &event}, func(ctx context.Context) error {
+		_, err := s.subscriptions.DeleteOne(ctx, bson.M{"user": user, "toUser": to_user})
+		if err != nil {
+			return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+		}
+
+		_, err = s.feeds.DeleteMany(ctx, bson.M{"user": user, "post.authorId": to_user})
+		if err != nil {
+			return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+		}
+
+		return nil
+	})
+}
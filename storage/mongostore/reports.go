@@ -0,0 +1,142 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"microblog/storage"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx"
+)
+
+func configureReportsIndexes(ctx context.Context, collection *mongo.Collection) {
+	indexModels := []mongo.IndexModel{
+		{
+			Keys:    bsonx.Doc{{Key: "id", Value: bsonx.Int32(1)}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bsonx.Doc{{Key: "postId", Value: bsonx.Int32(1)},
+				{Key: "reporter", Value: bsonx.Int32(1)}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bsonx.Doc{{Key: "status", Value: bsonx.Int32(1)},
+				{Key: "time", Value: bsonx.Int32(1)}},
+		},
+	}
+	opts := options.CreateIndexes().SetMaxTime(10 * time.Second)
+
+	_, err := collection.Indexes().CreateMany(ctx, indexModels, opts)
+	if err != nil {
+		panic(fmt.Errorf("failed to ensure indexes %w", err))
+	}
+}
+
+func configureSuspensionsIndexes(ctx context.Context, collection *mongo.Collection) {
+	indexModels := []mongo.IndexModel{
+		{
+			Keys:    bsonx.Doc{{Key: "user", Value: bsonx.Int32(1)}},
+			Options: options.Index().SetUnique(true),
+		},
+	}
+	opts := options.CreateIndexes().SetMaxTime(10 * time.Second)
+
+	_, err := collection.Indexes().CreateMany(ctx, indexModels, opts)
+	if err != nil {
+		panic(fmt.Errorf("failed to ensure indexes %w", err))
+	}
+}
+
+func (s *storage_struct) AddReport(ctx context.Context, report storage.Report) error {
+	_, err := s.reports.InsertOne(ctx, report)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("%v has already reported post %v - %w", report.Reporter, report.PostId, storage.ErrCollision)
+		}
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return nil
+}
+
+func (s *storage_struct) GetReport(ctx context.Context, reportId string) (storage.Report, error) {
+	var report storage.Report
+
+	err := s.reports.FindOne(ctx, bson.M{"id": reportId}).Decode(&report)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return report, fmt.Errorf("no report with id %v - %w", reportId, storage.ErrNotFound)
+		}
+		return report, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return report, nil
+}
+
+func (s *storage_struct) GetReports(ctx context.Context, status string) (storage.Reports, error) {
+	var answer storage.Reports
+	answer.Reports = make([]storage.Report, 0)
+
+	opts := options.Find().SetSort(bson.M{"time": 1})
+	cursor, err := s.reports.Find(ctx, bson.M{"status": status}, opts)
+	if err != nil {
+		return answer, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	if err = cursor.All(ctx, &answer.Reports); err != nil {
+		return answer, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return answer, nil
+}
+
+func (s *storage_struct) CloseReports(ctx context.Context, postId string, status string, admin string, resolved_at string) error {
+	_, err := s.reports.UpdateMany(
+		ctx,
+		bson.M{"postId": postId, "status": storage.ReportOpen},
+		bson.M{"$set": bson.M{"status": status, "resolvedBy": admin, "resolvedAt": resolved_at}},
+	)
+	if err != nil {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return nil
+}
+
+func (s *storage_struct) SuspendUser(ctx context.Context, suspension storage.Suspension) error {
+	opts := options.Replace().SetUpsert(true)
+	_, err := s.suspensions.ReplaceOne(ctx, bson.M{"user": suspension.User}, suspension, opts)
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return nil
+}
+
+func (s *storage_struct) UnsuspendUser(ctx context.Context, user string) error {
+	_, err := s.suspensions.DeleteOne(ctx, bson.M{"user": user})
+	if err != nil {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return nil
+}
+
+func (s *storage_struct) GetSuspension(ctx context.Context, user string) (storage.Suspension, error) {
+	var suspension storage.Suspension
+
+	err := s.suspensions.FindOne(ctx, bson.M{"user": user}).Decode(&suspension)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return suspension, fmt.Errorf("%v is not suspended - %w", user, storage.ErrNotFound)
+		}
+		return suspension, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return suspension, nil
+}
@@ -0,0 +1,100 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"microblog/storage"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx"
+)
+
+func configureScheduledIndexes(ctx context.Context, collection *mongo.Collection) {
+	indexModels := []mongo.IndexModel{
+		{
+			Keys:    bsonx.Doc{{Key: "post.id", Value: bsonx.Int32(1)}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bsonx.Doc{{Key: "post.authorId", Value: bsonx.Int32(1)},
+				{Key: "time", Value: bsonx.Int32(1)}},
+		},
+	}
+	opts := options.CreateIndexes().SetMaxTime(10 * time.Second)
+
+	_, err := collection.Indexes().CreateMany(ctx, indexModels, opts)
+	if err != nil {
+		panic(fmt.Errorf("failed to ensure indexes %w", err))
+	}
+}
+
+func (s *storage_struct) AddScheduledPost(ctx context.Context, scheduled storage.ScheduledPost) error {
+	_, err := s.scheduled.InsertOne(ctx, scheduled)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("scheduled post %v already exists - %w", scheduled.Post.Id, storage.ErrCollision)
+		}
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return nil
+}
+
+func (s *storage_struct) GetScheduledPost(ctx context.Context, postId string) (storage.ScheduledPost, error) {
+	var scheduled storage.ScheduledPost
+
+	err := s.scheduled.FindOne(ctx, bson.M{"post.id": postId}).Decode(&scheduled)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return scheduled, fmt.Errorf("no scheduled post with id %v - %w", postId, storage.ErrNotFound)
+		}
+		return scheduled, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return scheduled, nil
+}
+
+func (s *storage_struct) GetScheduledPosts(ctx context.Context, user string) (storage.ScheduledPosts, error) {
+	var answer storage.ScheduledPosts
+	answer.Posts = make([]storage.ScheduledPost, 0)
+
+	opts := options.Find().SetSort(bson.M{"time": 1})
+	cursor, err := s.scheduled.Find(ctx, bson.M{"post.authorId": user}, opts)
+	if err != nil {
+		return answer, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	if err = cursor.All(ctx, &answer.Posts); err != nil {
+		return answer, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return answer, nil
+}
+
+func (s *storage_struct) UpdateScheduledPost(ctx context.Context, scheduled storage.ScheduledPost) error {
+	result, err := s.scheduled.ReplaceOne(ctx, bson.M{"post.id": scheduled.Post.Id}, scheduled)
+	if err != nil {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("no scheduled post with id %v - %w", scheduled.Post.Id, storage.ErrNotFound)
+	}
+
+	return nil
+}
+
+func (s *storage_struct) DeleteScheduledPost(ctx context.Context, postId string) error {
+	result, err := s.scheduled.DeleteOne(ctx, bson.M{"post.id": postId})
+	if err != nil {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("no scheduled post with id %v - %w", postId, storage.ErrNotFound)
+	}
+
+	return nil
+}
@@ -0,0 +1,284 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"microblog/storage"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx"
+)
+
+// pendingWrite types
+const (
+	// a post and its feed copies
+	pendingPost = "post"
+	// a subscription and the posts copied to the feed of the subscriber
+	pendingSubscription = "subscription"
+	// any other change, only its event is saved again
+	pendingChange = "change"
+)
+
+// pendingWrite is saved before a write of several documents when mongo has no
+// transactions, and removed when the write is done.
+type pendingWrite struct {
+	MongoID primitive.ObjectID `bson:"_id,omitempty"`
+	Type    string             `bson:"type"`
+	PostId  string             `bson:"postId,omitempty"`
+	User    string             `bson:"user,omitempty"`
+	ToUser  string             `bson:"toUser,omitempty"`
+	// the post of this write, a post with the same id and another MongoID
+	// was saved by another write
+	PostMongoID primitive.ObjectID `bson:"postMongoId,omitempty"`
+	// saved to the outbox with the change
+	Event     *storage.Event `bson:"event,omitempty"`
+	Timestamp int64          `bson:"time"`
+}
+
+func configurePendingWritesIndexes(ctx context.Context, collection *mongo.Collection) {
+	indexModels := []mongo.IndexModel{
+		{
+			Keys: bsonx.Doc{{Key: "time", Value: bsonx.Int32(1)}},
+		},
+	}
+	opts := options.CreateIndexes().SetMaxTime(10 * time.Second)
+
+	_, err := collection.Indexes().CreateMany(ctx, indexModels, opts)
+	if err != nil {
+		panic(fmt.Errorf("failed to ensure indexes %w", err))
+	}
+}
+
+// supportsTransactions tells if mongo is a replica set or a sharded cluster,
+// standalone servers have no transactions.
+func supportsTransactions(ctx context.Context, client *mongo.Client) bool {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&hello)
+	if err != nil {
+		log.Println("failed to check for transactions:", err)
+		return false
+	}
+
+	return hello.SetName != "" || hello.Msg == "isdbgrid"
+}
+
+// atomically runs write and saves the event of pending to the outbox in a
+// transaction when mongo supports them. Otherwise pending is saved first and
+// removed after write, so a write that stops midway is finished by
+// FinishPendingWrites. Either way write may be run more than once and must be
+// idempotent.
+func (s *storage_struct) atomically(ctx context.Context, pending pendingWrite, write func(ctx context.Context) error) error {
+	if s.transactions {
+		session, err := s.client.StartSession()
+		if err != nil {
+			return fmt.Errorf("failed to start session - %w", storage.ErrStorage)
+		}
+		defer session.EndSession(ctx)
+
+		_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+			if err := write(sc); err != nil {
+				return nil, err
+			}
+			if pending.Event != nil {
+				return nil, s.saveEvent(sc, *pending.Event, primitive.NilObjectID)
+			}
+			return nil, nil
+		})
+		return err
+	}
+
+	pending.MongoID = primitive.NewObjectID()
+	pending.Timestamp = time.Now().UnixNano()
+	_, err := s.pendingWrites.InsertOne(ctx, pending)
+	if err != nil {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	err = write(ctx)
+	if err != nil {
+		if definitive(err) {
+			// nothing is left to finish
+			s.removePendingWrite(ctx, pending.MongoID)
+		}
+		return err
+	}
+	if pending.Event != nil {
+		err = s.saveEvent(ctx, *pending.Event, pending.MongoID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the write is done, finishing it again changes nothing
+	s.removePendingWrite(ctx, pending.MongoID)
+
+	return nil
+}
+
+func (s *storage_struct) removePendingWrite(ctx context.Context, id primitive.ObjectID) {
+	_, err := s.pendingWrites.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		log.Println("failed to remove pending write", id.Hex(), err)
+	}
+}
+
+// definitive tells if err means that the write was refused before it changed
+// anything, rather than that it stopped midway.
+func definitive(err error) bool {
+	return mongo.IsDuplicateKeyError(err) ||
+		errors.Is(err, storage.ErrNotFound) ||
+		errors.Is(err, storage.ErrCollision) ||
+		errors.Is(err, storage.ErrForbidden) ||
+		errors.Is(err, storage.ErrUnauthorized)
+}
+
+// FinishPendingWrites redoes the writes that were started before the given
+// time and have not finished, because the writer failed or died.
+func (s *storage_struct) FinishPendingWrites(ctx context.Context, before time.Time) (int, error) {
+	cursor, err := s.pendingWrites.Find(ctx, bson.M{"time": bson.M{"$lt": before.UnixNano()}})
+	if err != nil {
+		return 0, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+	var pending []pendingWrite
+	if err = cursor.All(ctx, &pending); err != nil {
+		return 0, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	finished := 0
+	for _, write := range pending {
+		saved, err := finishPendingWrite(ctx, s, write)
+		if err != nil {
+			return finished, err
+		}
+		if saved && write.Event != nil {
+			if err = s.saveEvent(ctx, *write.Event, write.MongoID); err != nil {
+				return finished, err
+			}
+		}
+
+		_, err = s.pendingWrites.DeleteOne(ctx, bson.M{"_id": write.MongoID})
+		if err != nil {
+			return finished, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+		}
+		finished++
+	}
+
+	return finished, nil
+}
+
+// pendingStore is what finishPendingWrite needs of the storage, tests fake
+// it.
+type pendingStore interface {
+	findPost(ctx context.Context, postId string) (storage.Post, error)
+	fanOut(ctx context.Context, post storage.Post) ([]string, error)
+	subscribed(ctx context.Context, user string, to_user string) (bool, error)
+	copyPostsToSubscriber(ctx context.Context, user string, to_user string) error
+}
+
+// finishPendingWrite does what is left of write and tells if the change was
+// saved, so that its event belongs to the outbox. Notifications and pushes of
+// the interrupted write are not repeated.
+func finishPendingWrite(ctx context.Context, s pendingStore, write pendingWrite) (bool, error) {
+	switch write.Type {
+	case pendingPost:
+		post, err := s.findPost(ctx, write.PostId)
+		if errors.Is(err, storage.ErrNotFound) {
+			// the post was not saved or is removed already
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if !write.PostMongoID.IsZero() && post.MongoID != write.PostMongoID {
+			// the post of another write, which saves its own event
+			return false, nil
+		}
+
+		_, err = s.fanOut(ctx, post)
+		if err != nil {
+			return false, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+		}
+		return true, nil
+
+	case pendingSubscription:
+		subscribed, err := s.subscribed(ctx, write.User, write.ToUser)
+		if err != nil || !subscribed {
+			// not saved or unsubscribed since
+			return false, err
+		}
+
+		err = s.copyPostsToSubscriber(ctx, write.User, write.ToUser)
+		if err != nil {
+			return false, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+		}
+		return true, nil
+
+	case pendingChange:
+		if write.Event == nil {
+			return false, nil
+		}
+		return changeSaved(ctx, s, *write.Event)
+	}
+
+	log.Println("unknown pending write", write.Type, write.MongoID.Hex())
+	return false, nil
+}
+
+// changeSaved tells if the change of event is in the storage, the rest of the
+// change is left to the feed sweeper.
+func changeSaved(ctx context.Context, s pendingStore, event storage.Event) (bool, error) {
+	switch event.Type {
+	case storage.EventPostEdited:
+		post, err := s.findPost(ctx, event.Post.Id)
+		if errors.Is(err, storage.ErrNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return post.LastModifiedAt == event.Post.LastModifiedAt &&
+			post.Text == event.Post.Text &&
+			post.Visibility == event.Post.Visibility, nil
+
+	case storage.EventPostFlagged, storage.EventPostApproved:
+		post, err := s.findPost(ctx, event.Post.Id)
+		if errors.Is(err, storage.ErrNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return post.Moderation == event.Post.Moderation, nil
+
+	case storage.EventPostDeleted:
+		_, err := s.findPost(ctx, event.Post.Id)
+		if errors.Is(err, storage.ErrNotFound) {
+			return true, nil
+		}
+		return false, err
+
+	case storage.EventSubscriptionRemoved:
+		subscribed, err := s.subscribed(ctx, event.Subscription.User, event.Subscription.ToUser)
+		return !subscribed, err
+	}
+
+	return true, nil
+}
+
+func (s *storage_struct) subscribed(ctx context.Context, user string, to_user string) (bool, error) {
+	count, err := s.subscriptions.CountDocuments(ctx, bson.M{"user": user, "toUser": to_user})
+	if err != nil {
+		return false, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return count != 0, nil
+}
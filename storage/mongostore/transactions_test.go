@@ -0,0 +1,158 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"microblog/storage"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakePendingStore keeps posts and subscriptions in maps and records what
+// finishPendingWrite redoes.
+type fakePendingStore struct {
+	posts map[string]storage.Post
+	// "user toUser"
+	subscriptions map[string]bool
+	// returned by all reads when set
+	err error
+
+	fannedOut []string
+	copied    []string
+}
+
+func newFakePendingStore() *fakePendingStore {
+	return &fakePendingStore{
+		posts:         make(map[string]storage.Post),
+		subscriptions: make(map[string]bool),
+	}
+}
+
+func (f *fakePendingStore) findPost(ctx context.Context, postId string) (storage.Post, error) {
+	if f.err != nil {
+		return storage.Post{}, f.err
+	}
+	post, ok := f.posts[postId]
+	if !ok {
+		return post, storage.ErrNotFound
+	}
+	return post, nil
+}
+
+func (f *fakePendingStore) fanOut(ctx context.Context, post storage.Post) ([]string, error) {
+	f.fannedOut = append(f.fannedOut, post.Id)
+	return nil, nil
+}
+
+func (f *fakePendingStore) subscribed(ctx context.Context, user string, to_user string) (bool, error) {
+	return f.subscriptions[user+" "+to_user], f.err
+}
+
+func (f *fakePendingStore) copyPostsToSubscriber(ctx context.Context, user string, to_user string) error {
+	f.copied = append(f.copied, user+" "+to_user)
+	return nil
+}
+
+func TestFinishPendingPost(t *testing.T) {
+	ctx := context.Background()
+	f := newFakePendingStore()
+
+	mongo_id := primitive.NewObjectID()
+	write := pendingWrite{Type: pendingPost, PostId: "p1", PostMongoID: mongo_id}
+
+	// the post was never saved
+	saved, err := finishPendingWrite(ctx, f, write)
+	if err != nil || saved || len(f.fannedOut) != 0 {
+		t.Errorf("missing post: got %v, %v, fanned out %v", saved, err, f.fannedOut)
+	}
+
+	// saved by another write, like a PostPost that got a duplicate key
+	f.posts["p1"] = storage.Post{Id: "p1", MongoID: primitive.NewObjectID()}
+	saved, err = finishPendingWrite(ctx, f, write)
+	if err != nil || saved || len(f.fannedOut) != 0 {
+		t.Errorf("post of another write: got %v, %v, fanned out %v", saved, err, f.fannedOut)
+	}
+
+	f.posts["p1"] = storage.Post{Id: "p1", MongoID: mongo_id}
+	saved, err = finishPendingWrite(ctx, f, write)
+	if err != nil || !saved || len(f.fannedOut) != 1 || f.fannedOut[0] != "p1" {
+		t.Errorf("saved post: got %v, %v, fanned out %v", saved, err, f.fannedOut)
+	}
+
+	f.err = storage.ErrStorage
+	if _, err = finishPendingWrite(ctx, f, write); !errors.Is(err, storage.ErrStorage) {
+		t.Errorf("failed read: got %v", err)
+	}
+}
+
+func TestFinishPendingSubscription(t *testing.T) {
+	ctx := context.Background()
+	f := newFakePendingStore()
+
+	write := pendingWrite{Type: pendingSubscription, User: "b2", ToUser: "a1"}
+
+	// not saved or unsubscribed since
+	saved, err := finishPendingWrite(ctx, f, write)
+	if err != nil || saved || len(f.copied) != 0 {
+		t.Errorf("missing subscription: got %v, %v, copied %v", saved, err, f.copied)
+	}
+
+	f.subscriptions["b2 a1"] = true
+	saved, err = finishPendingWrite(ctx, f, write)
+	if err != nil || !saved || len(f.copied) != 1 || f.copied[0] != "b2 a1" {
+		t.Errorf("saved subscription: got %v, %v, copied %v", saved, err, f.copied)
+	}
+
+	f.err = storage.ErrStorage
+	if _, err = finishPendingWrite(ctx, f, write); !errors.Is(err, storage.ErrStorage) {
+		t.Errorf("failed read: got %v", err)
+	}
+}
+
+func TestFinishPendingChange(t *testing.T) {
+	ctx := context.Background()
+	f := newFakePendingStore()
+
+	f.posts["p1"] = storage.Post{Id: "p1", Text: "edited", LastModifiedAt: "2021-01-02T00:00:00Z"}
+	f.posts["p2"] = storage.Post{Id: "p2", Moderation: storage.ModerationFlagged}
+
+	change := func(event storage.Event) pendingWrite {
+		return pendingWrite{Type: pendingChange, Event: &event}
+	}
+	edited := storage.PostChanged(storage.EventPostEdited, f.posts["p1"])
+	stale := storage.PostChanged(storage.EventPostEdited, storage.Post{Id: "p1", Text: "older"})
+
+	tests := []struct {
+		name  string
+		write pendingWrite
+		saved bool
+	}{
+		{"no event", pendingWrite{Type: pendingChange}, false},
+		{"edit saved", change(edited), true},
+		{"edit not saved", change(stale), false},
+		{"edit of a removed post", change(storage.PostChanged(storage.EventPostEdited, storage.Post{Id: "gone"})), false},
+		{"flag saved", change(storage.PostChanged(storage.EventPostFlagged, f.posts["p2"])), true},
+		{"approval not saved", change(storage.PostChanged(storage.EventPostApproved, storage.Post{Id: "p2"})), false},
+		{"removal saved", change(storage.PostChanged(storage.EventPostDeleted, storage.Post{Id: "gone"})), true},
+		{"removal not saved", change(storage.PostChanged(storage.EventPostDeleted, f.posts["p1"])), false},
+		{"unsubscribe saved", change(storage.SubscriptionChanged(storage.EventSubscriptionRemoved, "b2", "a1")), true},
+	}
+	for _, test := range tests {
+		saved, err := finishPendingWrite(ctx, f, test.write)
+		if err != nil || saved != test.saved {
+			t.Errorf("%s: got %v, %v, want %v", test.name, saved, err, test.saved)
+		}
+	}
+
+	f.subscriptions["b2 a1"] = true
+	saved, err := finishPendingWrite(ctx, f, change(storage.SubscriptionChanged(storage.EventSubscriptionRemoved, "b2", "a1")))
+	if err != nil || saved {
+		t.Errorf("unsubscribe not saved: got %v, %v", saved, err)
+	}
+
+	// nothing is redone for changes
+	if len(f.fannedOut) != 0 || len(f.copied) != 0 {
+		t.Errorf("redone %v, %v", f.fannedOut, f.copied)
+	}
+}
@@ -0,0 +1,205 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"microblog/storage"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func (s *storage_struct) GetPost(ctx context.Context, postId string, viewer string) (storage.Post, error) {
+	post, err := s.findPost(ctx, postId)
+	if err != nil {
+		return post, err
+	}
+
+	if !s.canSee(ctx, post, viewer) {
+		return storage.Post{}, fmt.Errorf("no post with id %v - %w", postId, storage.ErrNotFound)
+	}
+
+	return post, nil
+}
+
+func (s *storage_struct) GetPosts(ctx context.Context, postIds []string, viewer string) ([]storage.Post, error) {
+	posts := make([]storage.Post, 0, len(postIds))
+	if len(postIds) == 0 {
+		return posts, nil
+	}
+
+	cursor, err := s.posts.Find(ctx, bson.M{"id": bson.M{"$in": postIds}})
+	if err != nil {
+		return posts, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+	var found []storage.Post
+	if err = cursor.All(ctx, &found); err != nil {
+		return posts, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	by_id := make(map[string]storage.Post, len(found))
+	for _, post := range found {
+		by_id[post.Id] = post
+	}
+
+	// privacy and subscriptions are looked up once per author and only when
+	// they matter, as in canSee
+	access := make(map[string]bool)
+	follows := make(map[string]bool)
+	for _, postId := range postIds {
+		post, ok := by_id[postId]
+		if !ok || !storage.CanSee(post, viewer, true) {
+			continue
+		}
+		allowed, checked := access[post.AuthorId]
+		if !checked {
+			err = s.checkPostLineAccess(ctx, viewer, post.AuthorId)
+			if err != nil && !errors.Is(err, storage.ErrForbidden) {
+				return posts, err
+			}
+			allowed = err == nil
+			access[post.AuthorId] = allowed
+		}
+		if !allowed {
+			continue
+		}
+		if !storage.CanSee(post, viewer, false) {
+			subscribed, checked := follows[post.AuthorId]
+			if !checked {
+				subscribed, err = s.subscribed(ctx, viewer, post.AuthorId)
+				if err != nil {
+					return posts, err
+				}
+				follows[post.AuthorId] = subscribed
+			}
+			if !subscribed {
+				continue
+			}
+		}
+		posts = append(posts, post)
+	}
+
+	return posts, nil
+}
+
+// canSee tells if viewer may see post, posts of private accounts are seen by
+// subscribers only. The subscription is only looked up when it matters.
+func (s *storage_struct) canSee(ctx context.Context, post storage.Post, viewer string) bool {
+	// following would not help
+	if !storage.CanSee(post, viewer, true) {
+		return false
+	}
+	if err := s.checkPostLineAccess(ctx, viewer, post.AuthorId); err != nil {
+		if !errors.Is(err, storage.ErrForbidden) {
+			log.Println("Failed to check access of", viewer, "to", post.AuthorId, "due to an error:", err)
+		}
+		return false
+	}
+	if storage.CanSee(post, viewer, false) {
+		return true
+	}
+
+	count, err := s.subscriptions.CountDocuments(ctx, bson.M{"user": viewer, "toUser": post.AuthorId})
+	if err != nil {
+		log.Println("Failed to check subscription of", viewer, "to", post.AuthorId, "due to an error:", err)
+		return false
+	}
+
+	return count != 0
+}
+
+// postLineFilter selects the posts of user that viewer can see, the same as
+// storage.CanSee does.
+func (s *storage_struct) postLineFilter(ctx context.Context, user string, viewer string) (bson.M, error) {
+	filter := bson.M{"authorId": user}
+	if viewer == user {
+		return filter, nil
+	}
+	filter["moderation"] = bson.M{"$exists": false}
+
+	hidden := []string{storage.VisibilityFollowers, storage.VisibilityMentioned}
+	if viewer != "" {
+		count, err := s.subscriptions.CountDocuments(ctx, bson.M{"user": viewer, "toUser": user})
+		if err != nil {
+			return filter, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+		}
+		if count != 0 {
+			hidden = []string{storage.VisibilityMentioned}
+		}
+	}
+
+	filter["$or"] = []bson.M{
+		{"visibility": bson.M{"$nin": hidden}},
+		{"mentions": viewer},
+	}
+
+	return filter, nil
+}
+
+func (s *storage_struct) ChangePostVisibility(ctx context.Context, postId string, user string, visibility string, new_time string) (storage.Post, error) {
+	post, err := s.findPost(ctx, postId)
+	if err != nil {
+		return post, err
+	}
+
+	if post.AuthorId != user {
+		return post, storage.ErrUnauthorized
+	}
+
+	post.Visibility = visibility
+	post.LastModifiedAt = new_time
+
+	event := storage.PostChanged(storage.EventPostEdited, post)
+	err = s.atomically(ctx, pendingWrite{Type: pendingChange, Event: &event}, func(ctx context.Context) error {
+		_, err := s.posts.UpdateOne(
+			ctx,
+			bson.M{"id": postId},
+			bson.M{"$set": bson.M{"visibility": visibility, "lastModifiedAt": new_time}},
+		)
+		if err != nil {
+			return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+		}
+
+		return s.refanout(ctx, post)
+	})
+
+	return post, err
+}
+
+// refanout replaces the feed copies of post, so that only the subscribers
+// who can see it have one.
+func (s *storage_struct) refanout(ctx context.Context, post storage.Post) error {
+	_, err := s.feeds.DeleteMany(ctx, bson.M{"postId": post.MongoID})
+	if err != nil {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	cursor, err := s.subscriptions.Find(ctx, bson.M{"toUser": post.AuthorId})
+	if err != nil {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	var subscriptions []storage.Subscription
+	if err = cursor.All(ctx, &subscriptions); err != nil {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	for _, subscription := range subscriptions {
+		if !storage.CanSee(post, subscription.User, true) {
+			continue
+		}
+
+		err = s.addFeedPost(ctx, storage.FeedPost{
+			User:      subscription.User,
+			Timestamp: post.Timestamp,
+			PostId:    post.MongoID,
+			Post:      post,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
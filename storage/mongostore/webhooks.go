@@ -0,0 +1,234 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"microblog/storage"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx"
+)
+
+func configureWebhooksIndexes(ctx context.Context, collection *mongo.Collection) {
+	indexModels := []mongo.IndexModel{
+		{
+			Keys:    bsonx.Doc{{Key: "id", Value: bsonx.Int32(1)}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bsonx.Doc{{Key: "user", Value: bsonx.Int32(1)},
+				{Key: "_id", Value: bsonx.Int32(1)}},
+		},
+	}
+	opts := options.CreateIndexes().SetMaxTime(10 * time.Second)
+
+	_, err := collection.Indexes().CreateMany(ctx, indexModels, opts)
+	if err != nil {
+		panic(fmt.Errorf("failed to ensure indexes %w", err))
+	}
+}
+
+func configureDeliveriesIndexes(ctx context.Context, collection *mongo.Collection) {
+	indexModels := []mongo.IndexModel{
+		{
+			Keys:    bsonx.Doc{{Key: "id", Value: bsonx.Int32(1)}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bsonx.Doc{{Key: "webhookId", Value: bsonx.Int32(1)},
+				{Key: "time", Value: bsonx.Int32(-1)}},
+		},
+	}
+	opts := options.CreateIndexes().SetMaxTime(10 * time.Second)
+
+	_, err := collection.Indexes().CreateMany(ctx, indexModels, opts)
+	if err != nil {
+		panic(fmt.Errorf("failed to ensure indexes %w", err))
+	}
+}
+
+func (s *storage_struct) AddWebhook(ctx context.Context, hook storage.Webhook) error {
+	_, err := s.webhooks.InsertOne(ctx, hook)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("webhook %v already exists - %w", hook.Id, storage.ErrCollision)
+		}
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return nil
+}
+
+func (s *storage_struct) GetWebhook(ctx context.Context, webhookId string) (storage.Webhook, error) {
+	var hook storage.Webhook
+
+	err := s.webhooks.FindOne(ctx, bson.M{"id": webhookId}).Decode(&hook)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return hook, fmt.Errorf("no webhook with id %v - %w", webhookId, storage.ErrNotFound)
+		}
+		return hook, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return hook, nil
+}
+
+func (s *storage_struct) GetWebhooks(ctx context.Context, user string, event string) ([]storage.Webhook, error) {
+	hooks := make([]storage.Webhook, 0)
+
+	filter := bson.M{"user": user}
+	if event != "" {
+		filter["events"] = event
+		filter["disabled"] = false
+	}
+
+	opts := options.Find()
+	opts.SetSort(bson.M{"_id": 1})
+
+	cursor, err := s.webhooks.Find(ctx, filter, opts)
+	if err != nil {
+		return hooks, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+	defer cursor.Close(ctx)
+
+	if err = cursor.All(ctx, &hooks); err != nil {
+		return hooks, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return hooks, nil
+}
+
+func (s *storage_struct) AddWebhookFailure(ctx context.Context, webhookId string, disable_after int) (storage.Webhook, error) {
+	var hook storage.Webhook
+
+	// both updates are atomic, so concurrent deliveries count correctly
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := s.webhooks.FindOneAndUpdate(
+		ctx,
+		bson.M{"id": webhookId},
+		bson.M{"$inc": bson.M{"failures": 1}},
+		opts,
+	).Decode(&hook)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return hook, fmt.Errorf("no webhook with id %v - %w", webhookId, storage.ErrNotFound)
+		}
+		return hook, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	if hook.Failures >= disable_after && !hook.Disabled {
+		_, err = s.webhooks.UpdateOne(
+			ctx,
+			bson.M{"id": webhookId, "failures": bson.M{"$gte": disable_after}},
+			bson.M{"$set": bson.M{"disabled": true}},
+		)
+		if err != nil {
+			return hook, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+		}
+		hook.Disabled = true
+	}
+
+	return hook, nil
+}
+
+func (s *storage_struct) ResetWebhookFailures(ctx context.Context, webhookId string, enable bool) (storage.Webhook, error) {
+	var hook storage.Webhook
+
+	update := bson.M{"failures": 0}
+	if enable {
+		update["disabled"] = false
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := s.webhooks.FindOneAndUpdate(ctx, bson.M{"id": webhookId}, bson.M{"$set": update}, opts).Decode(&hook)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return hook, fmt.Errorf("no webhook with id %v - %w", webhookId, storage.ErrNotFound)
+		}
+		return hook, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return hook, nil
+}
+
+func (s *storage_struct) DeleteWebhook(ctx context.Context, webhookId string) error {
+	result, err := s.webhooks.DeleteOne(ctx, bson.M{"id": webhookId})
+	if err != nil {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("no webhook with id %v - %w", webhookId, storage.ErrNotFound)
+	}
+
+	_, err = s.deliveries.DeleteMany(ctx, bson.M{"webhookId": webhookId})
+	if err != nil {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return nil
+}
+
+func (s *storage_struct) AddDelivery(ctx context.Context, delivery storage.WebhookDelivery) error {
+	_, err := s.deliveries.InsertOne(ctx, delivery)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("delivery %v already exists - %w", delivery.Id, storage.ErrCollision)
+		}
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return nil
+}
+
+func (s *storage_struct) GetDelivery(ctx context.Context, deliveryId string) (storage.WebhookDelivery, error) {
+	var delivery storage.WebhookDelivery
+
+	err := s.deliveries.FindOne(ctx, bson.M{"id": deliveryId}).Decode(&delivery)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return delivery, fmt.Errorf("no delivery with id %v - %w", deliveryId, storage.ErrNotFound)
+		}
+		return delivery, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return delivery, nil
+}
+
+func (s *storage_struct) UpdateDelivery(ctx context.Context, delivery storage.WebhookDelivery) error {
+	result, err := s.deliveries.ReplaceOne(ctx, bson.M{"id": delivery.Id}, delivery)
+	if err != nil {
+		return fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("no delivery with id %v - %w", delivery.Id, storage.ErrNotFound)
+	}
+
+	return nil
+}
+
+func (s *storage_struct) GetDeliveries(ctx context.Context, webhookId string, size int) ([]storage.WebhookDelivery, error) {
+	deliveries := make([]storage.WebhookDelivery, 0)
+	if size == 0 {
+		return deliveries, nil
+	}
+
+	opts := options.Find()
+	opts.SetSort(bson.M{"time": -1})
+	opts.SetLimit(int64(size))
+
+	cursor, err := s.deliveries.Find(ctx, bson.M{"webhookId": webhookId}, opts)
+	if err != nil {
+		return deliveries, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+	defer cursor.Close(ctx)
+
+	if err = cursor.All(ctx, &deliveries); err != nil {
+		return deliveries, fmt.Errorf("something went wrong - %w", storage.ErrStorage)
+	}
+
+	return deliveries, nil
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	NotificationSubscriber = "subscriber"
+	NotificationMention    = "mention"
+	NotificationReply      = "reply"
+	// somebody asks to subscribe to a private account
+	NotificationFollowRequest = "follow_request"
+)
+
+// Notification tells User that Actor did something: subscribed to them,
+// mentioned them or replied to their post (PostId).
+type Notification struct {
+	Id        string `json:"id" bson:"id"`
+	User      string `json:"user" bson:"user"`
+	Type      string `json:"type" bson:"type"`
+	Actor     string `json:"actor" bson:"actor"`
+	PostId    string `json:"postId,omitempty" bson:"postId,omitempty"`
+	CreatedAt string `json:"createdAt" bson:"createdAt"`
+	Timestamp int64  `json:"-" bson:"time"`
+
+	// filled on read from the read cursor of User
+	Read bool `json:"read" bson:"-"`
+
+	// mongo id to read docs in the right order
+	MongoID primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+}
+
+// Notifications is a page of notifications, newest first.
+type Notifications struct {
+	Notifications []Notification `json:"notifications"`
+	Token         string         `json:"nextPage,omitempty"`
+}
+
+type UnreadNotificationsCount struct {
+	Count int64 `json:"count"`
+}
+
+// PostNotifications returns the notifications caused by post: one for the
+// author of the replied post (reply_to_author, may be empty) and one for
+// every mentioned user. Nobody is notified twice or about their own post,
+// and nobody about a flagged post.
+func PostNotifications(post Post, reply_to_author string) []Notification {
+	notifications := make([]Notification, 0)
+	if post.Moderation == ModerationFlagged {
+		return notifications
+	}
+	notified := map[string]bool{post.AuthorId: true}
+
+	add := func(user string, kind string) {
+		if notified[user] {
+			return
+		}
+		notified[user] = true
+		notifications = append(notifications, Notification{
+			User:      user,
+			Type:      kind,
+			Actor:     post.AuthorId,
+			PostId:    post.Id,
+			CreatedAt: post.CreatedAt,
+			Timestamp: post.Timestamp,
+		})
+	}
+
+	if reply_to_author != "" {
+		add(reply_to_author, NotificationReply)
+	}
+	for _, user := range Mentions(post.Text) {
+		add(user, NotificationMention)
+	}
+
+	return notifications
+}
+
+// SubscriberNotification tells to_user that user has subscribed to them.
+func SubscriberNotification(user string, to_user string) Notification {
+	time_now := time.Now()
+	return Notification{
+		User:      to_user,
+		Type:      NotificationSubscriber,
+		Actor:     user,
+		CreatedAt: time_now.UTC().Format("2006-01-02T15:04:05Z"),
+		Timestamp: time_now.UnixNano(),
+	}
+}
+
+// NotificationStore keeps the notifications inbox of every user. Storage
+// backends create notifications themselves in PostPost and Subscribe.
+type NotificationStore interface {
+	GetNotifications(ctx context.Context, user string, page_token string, size int) (Notifications, error)
+	// MarkNotificationsRead marks everything up to and including
+	// notificationId as read, an empty notificationId means all.
+	MarkNotificationsRead(ctx context.Context, user string, notificationId string) error
+	CountUnreadNotifications(ctx context.Context, user string) (int64, error)
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// Event types besides EventPostCreated and EventPostEdited.
+const (
+	EventPostDeleted         = "post.deleted"
+	EventPostFlagged         = "post.flagged"
+	EventPostApproved        = "post.approved"
+	EventSubscriptionAdded   = "subscription.added"
+	EventSubscriptionRemoved = "subscription.removed"
+)
+
+// Event is a change of state. Storages save it to their outbox in the same
+// write as the change itself.
+type Event struct {
+	// ordered the same way as the events, set by the storage
+	Id   string `json:"id" bson:"-"`
+	Type string `json:"type" bson:"type"`
+	// the post after the change, before it for EventPostDeleted
+	Post         *Post         `json:"post,omitempty" bson:"post,omitempty"`
+	Subscription *Subscription `json:"subscription,omitempty" bson:"subscription,omitempty"`
+	CreatedAt    string        `json:"createdAt" bson:"createdAt"`
+	Timestamp    int64         `json:"-" bson:"time"`
+}
+
+func newEvent(event_type string) Event {
+	time_now := time.Now()
+	return Event{
+		Type:      event_type,
+		CreatedAt: time_now.UTC().Format("2006-01-02T15:04:05Z"),
+		Timestamp: time_now.UnixNano(),
+	}
+}
+
+func PostChanged(event_type string, post Post) Event {
+	event := newEvent(event_type)
+	event.Post = &post
+	return event
+}
+
+func SubscriptionChanged(event_type string, user string, to_user string) Event {
+	event := newEvent(event_type)
+	event.Subscription = &Subscription{User: user, ToUser: to_user}
+	return event
+}
+
+// EventSource reads the outbox of a storage. Every consumer keeps its own
+// cursor, the id of the last event it has handled.
+type EventSource interface {
+	// ReadEvents returns up to size events that follow the event with id
+	// after (from the oldest one when after is empty), oldest first.
+	ReadEvents(ctx context.Context, after string, size int) ([]Event, error)
+	// GetEventCursor returns an empty id for a new consumer.
+	GetEventCursor(ctx context.Context, consumer string) (string, error)
+	SetEventCursor(ctx context.Context, consumer string, id string) error
+	// LeaseEventCursor makes holder the one who moves the cursor of
+	// consumer for ttl, holder renews the lease by taking it again. It fails
+	// with ErrCollision while another holder has the lease.
+	LeaseEventCursor(ctx context.Context, consumer string, holder string, ttl time.Duration) error
+}
@@ -0,0 +1,54 @@
+package storage
+
+import "time"
+
+const (
+	MinPollOptions = 2
+	MaxPollOptions = 4
+)
+
+type PollOption struct {
+	Text  string `json:"text" bson:"text"`
+	Votes int64  `json:"votes" bson:"votes"`
+}
+
+// Poll is attached to a post, its results are kept in the post itself, so
+// they come with every copy of the post in post lines and feeds.
+type Poll struct {
+	Options  []PollOption `json:"options" bson:"options"`
+	ClosesAt string       `json:"closesAt" bson:"closesAt"`
+	// ClosesAt in nanoseconds, for storages that query by it
+	Closes int64 `json:"-" bson:"closes"`
+}
+
+// PollVote is the vote of User, there is at most one per post and user.
+type PollVote struct {
+	PostId string `json:"postId" bson:"postId"`
+	User   string `json:"user" bson:"user"`
+	Option int    `json:"option" bson:"option"`
+}
+
+// Open tells if the poll still takes votes at now.
+func (p *Poll) Open(now time.Time) bool {
+	closes, err := time.Parse(time.RFC3339, p.ClosesAt)
+	return err == nil && now.Before(closes)
+}
+
+// WithVote returns a copy of the poll with one more vote for option, the
+// poll itself is left alone as other copies of the post may share it.
+func (p *Poll) WithVote(option int) *Poll {
+	poll := *p
+	poll.Options = append([]PollOption(nil), p.Options...)
+	poll.Options[option].Votes++
+	return &poll
+}
+
+// WithoutVote is WithVote the other way, for votes that are withdrawn.
+func (p *Poll) WithoutVote(option int) *Poll {
+	poll := *p
+	poll.Options = append([]PollOption(nil), p.Options...)
+	if poll.Options[option].Votes > 0 {
+		poll.Options[option].Votes--
+	}
+	return &poll
+}
@@ -0,0 +1,22 @@
+package storage
+
+import "context"
+
+// Relation is one block or mute of Target by User.
+type Relation struct {
+	User   string `bson:"user"`
+	Target string `bson:"target"`
+}
+
+// RelationStore keeps blocks and mutes, all methods are idempotent.
+//
+// Block removes subscriptions in both directions together with their feed
+// entries, and Subscribe fails with ErrForbidden while either user blocks the
+// other. Posts of muted authors stay in the feed of the muting user, but
+// GetFeed and friends skip them.
+type RelationStore interface {
+	Block(ctx context.Context, user string, target string) error
+	Unblock(ctx context.Context, user string, target string) error
+	Mute(ctx context.Context, user string, target string) error
+	Unmute(ctx context.Context, user string, target string) error
+}
@@ -0,0 +1,55 @@
+package storage
+
+import "context"
+
+const (
+	ReportOpen      = "open"
+	ReportResolved  = "resolved"
+	ReportDismissed = "dismissed"
+)
+
+// Report is a complaint of Reporter about a post. Reports are closed per
+// post: removing the post resolves all its open reports, dismissing them
+// keeps the post.
+type Report struct {
+	Id         string `json:"id" bson:"id"`
+	PostId     string `json:"postId" bson:"postId"`
+	AuthorId   string `json:"authorId" bson:"authorId"`
+	Reporter   string `json:"reporter" bson:"reporter"`
+	Reason     string `json:"reason" bson:"reason"`
+	Status     string `json:"status" bson:"status"`
+	CreatedAt  string `json:"createdAt" bson:"createdAt"`
+	Timestamp  int64  `json:"-" bson:"time"`
+	ResolvedBy string `json:"resolvedBy,omitempty" bson:"resolvedBy,omitempty"`
+	ResolvedAt string `json:"resolvedAt,omitempty" bson:"resolvedAt,omitempty"`
+}
+
+type Reports struct {
+	Reports []Report `json:"reports"`
+}
+
+type ReportStore interface {
+	// AddReport fails with ErrCollision when the reporter has already
+	// reported the post
+	AddReport(ctx context.Context, report Report) error
+	GetReport(ctx context.Context, reportId string) (Report, error)
+	// GetReports returns the reports with status, oldest first
+	GetReports(ctx context.Context, status string) (Reports, error)
+	// CloseReports gives all open reports of the post the status
+	CloseReports(ctx context.Context, postId string, status string, admin string, resolved_at string) error
+}
+
+// Suspension keeps User from writing anything until it is lifted.
+type Suspension struct {
+	User      string `json:"user" bson:"user"`
+	Reason    string `json:"reason" bson:"reason"`
+	CreatedAt string `json:"createdAt" bson:"createdAt"`
+}
+
+type SuspensionStore interface {
+	// SuspendUser replaces the suspension of the user if there is one
+	SuspendUser(ctx context.Context, suspension Suspension) error
+	UnsuspendUser(ctx context.Context, user string) error
+	// GetSuspension fails with ErrNotFound when the user is not suspended
+	GetSuspension(ctx context.Context, user string) (Suspension, error)
+}
@@ -0,0 +1,26 @@
+package storage
+
+import "context"
+
+// ScheduledPost is a post that is published at PublishAt. Until then it is
+// only visible to its author through the scheduled posts API.
+type ScheduledPost struct {
+	Post      Post   `json:"post" bson:"post"`
+	PublishAt string `json:"publishAt" bson:"publishAt"`
+	Timestamp int64  `json:"-" bson:"time"`
+}
+
+type ScheduledPosts struct {
+	Posts []ScheduledPost `json:"posts"`
+}
+
+type ScheduleStore interface {
+	AddScheduledPost(ctx context.Context, scheduled ScheduledPost) error
+	GetScheduledPost(ctx context.Context, postId string) (ScheduledPost, error)
+	// GetScheduledPosts returns the scheduled posts of user, earliest first.
+	GetScheduledPosts(ctx context.Context, user string) (ScheduledPosts, error)
+	UpdateScheduledPost(ctx context.Context, scheduled ScheduledPost) error
+	// DeleteScheduledPost fails with ErrNotFound when the post is already
+	// gone, so only one caller gets to publish it.
+	DeleteScheduledPost(ctx context.Context, postId string) error
+}
@@ -0,0 +1,46 @@
+package storage
+
+const (
+	VisibilityPublic    = "public"
+	VisibilityFollowers = "followers"
+	VisibilityMentioned = "mentioned"
+)
+
+func ValidVisibility(visibility string) bool {
+	switch visibility {
+	case VisibilityPublic, VisibilityFollowers, VisibilityMentioned:
+		return true
+	}
+	return false
+}
+
+// CanSee tells if viewer (empty for anonymous) may see post, follows tells if
+// viewer is subscribed to the author. Posts without visibility are public.
+// Mentioned users see followers-only posts too. Flagged posts are seen by
+// their authors only.
+func CanSee(post Post, viewer string, follows bool) bool {
+	if post.Moderation == ModerationFlagged {
+		return viewer != "" && viewer == post.AuthorId
+	}
+
+	switch post.Visibility {
+	case "", VisibilityPublic:
+		return true
+	}
+	if viewer == "" {
+		return false
+	}
+	if viewer == post.AuthorId {
+		return true
+	}
+	if post.Visibility == VisibilityFollowers && follows {
+		return true
+	}
+
+	for _, user := range Mentions(post.Text) {
+		if user == viewer {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package storage
+
+import "context"
+
+// Events a webhook may be subscribed to. They are about the owner of the
+// webhook: posts of the owner and new subscribers of the owner.
+const (
+	EventPostCreated     = "post.created"
+	EventPostEdited      = "post.edited"
+	EventSubscriberAdded = "subscriber.added"
+)
+
+var WebhookEvents = []string{EventPostCreated, EventPostEdited, EventSubscriberAdded}
+
+type Webhook struct {
+	Id        string   `json:"id" bson:"id"`
+	User      string   `json:"user" bson:"user"`
+	URL       string   `json:"url" bson:"url"`
+	Events    []string `json:"events" bson:"events"`
+	Secret    string   `json:"secret,omitempty" bson:"secret"`
+	CreatedAt string   `json:"createdAt" bson:"createdAt"`
+
+	// deliveries failed in a row, the webhook is disabled after too many
+	Failures int  `json:"failures" bson:"failures"`
+	Disabled bool `json:"disabled" bson:"disabled"`
+}
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+type WebhookDelivery struct {
+	Id        string `json:"id" bson:"id"`
+	WebhookId string `json:"webhookId" bson:"webhookId"`
+	Event     string `json:"event" bson:"event"`
+	Payload   string `json:"payload" bson:"payload"`
+	Status    string `json:"status" bson:"status"`
+	Attempts  int    `json:"attempts" bson:"attempts"`
+
+	LastStatusCode int    `json:"lastStatusCode,omitempty" bson:"lastStatusCode"`
+	LastError      string `json:"lastError,omitempty" bson:"lastError"`
+
+	CreatedAt      string `json:"createdAt" bson:"createdAt"`
+	LastModifiedAt string `json:"lastModifiedAt" bson:"lastModifiedAt"`
+	Timestamp      int64  `json:"-" bson:"time"`
+}
+
+type Webhooks struct {
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+type WebhookDeliveries struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
+type WebhookStore interface {
+	AddWebhook(ctx context.Context, hook Webhook) error
+	GetWebhook(ctx context.Context, webhookId string) (Webhook, error)
+	// GetWebhooks returns all webhooks of user, or only the enabled ones
+	// subscribed to event if it is not empty.
+	GetWebhooks(ctx context.Context, user string, event string) ([]Webhook, error)
+	// AddWebhookFailure counts one more failed delivery in a row and
+	// disables the webhook when there are disable_after of them.
+	AddWebhookFailure(ctx context.Context, webhookId string, disable_after int) (Webhook, error)
+	// ResetWebhookFailures is called after a successful delivery, or with
+	// enable when the owner turns the webhook back on.
+	ResetWebhookFailures(ctx context.Context, webhookId string, enable bool) (Webhook, error)
+	DeleteWebhook(ctx context.Context, webhookId string) error
+
+	AddDelivery(ctx context.Context, delivery WebhookDelivery) error
+	GetDelivery(ctx context.Context, deliveryId string) (WebhookDelivery, error)
+	UpdateDelivery(ctx context.Context, delivery WebhookDelivery) error
+	// GetDeliveries returns the last size deliveries of the webhook, newest first.
+	GetDeliveries(ctx context.Context, webhookId string, size int) ([]WebhookDelivery, error)
+}
@@ -0,0 +1,101 @@
+// Package sweeper keeps the materialized feeds in sync with the subscriptions
+// and posts they are built from.
+package sweeper
+
+import (
+	"context"
+	"log"
+	"microblog/storage"
+	"time"
+)
+
+const DefaultBatchSize = 100
+
+// PendingAge is how long a write may take before FinishPendingWrites
+// finishes it instead of the writer.
+const PendingAge = time.Minute
+
+type Sweeper struct {
+	Feeds storage.FeedStore
+	// how many users are read at once
+	BatchSize int
+}
+
+func NewSweeper(feeds storage.FeedStore) *Sweeper {
+	return &Sweeper{
+		Feeds:     feeds,
+		BatchSize: DefaultBatchSize,
+	}
+}
+
+// Sweep rebuilds every feed once and returns the repairs of the feeds that
+// were out of sync.
+func (s *Sweeper) Sweep(ctx context.Context) ([]storage.FeedRepair, error) {
+	repairs := make([]storage.FeedRepair, 0)
+
+	after := ""
+	for {
+		users, err := s.Feeds.FeedUsers(ctx, after, s.BatchSize)
+		if err != nil {
+			return repairs, err
+		}
+
+		for _, user := range users {
+			repair, err := s.Feeds.RebuildFeed(ctx, user)
+			if err != nil {
+				return repairs, err
+			}
+			if repair.Drifted() {
+				log.Printf("feed of %v drifted: %d added, %d removed, %d updated", user, repair.Added, repair.Removed, repair.Updated)
+				repairs = append(repairs, repair)
+			}
+		}
+
+		if len(users) < s.BatchSize {
+			return repairs, nil
+		}
+		after = users[len(users)-1]
+	}
+}
+
+// Run sweeps every interval until ctx is done.
+func (s *Sweeper) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		_, err := s.Sweep(ctx)
+		if err != nil {
+			log.Println("feed sweep failed:", err)
+		}
+	}
+}
+
+// FinishPendingWrites finishes the interrupted writes of pending every
+// interval until ctx is done.
+func FinishPendingWrites(ctx context.Context, pending storage.PendingWriteStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		count, err := pending.FinishPendingWrites(ctx, time.Now().Add(-PendingAge))
+		if err != nil {
+			log.Println("finishing pending writes failed:", err)
+		}
+		if count > 0 {
+			log.Printf("finished %d pending writes", count)
+		}
+	}
+}
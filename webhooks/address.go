@@ -0,0 +1,76 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrPrivateAddress is returned for webhook urls that lead into our own
+// network, like loopback, private, link-local or unspecified addresses.
+var ErrPrivateAddress = errors.New("webhook address is not public")
+
+func publicIP(ip net.IP) bool {
+	return ip != nil &&
+		!ip.IsLoopback() &&
+		!ip.IsPrivate() &&
+		!ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() &&
+		!ip.IsUnspecified()
+}
+
+// CheckURL resolves the host of hook_url and fails with ErrPrivateAddress
+// when any of its addresses is not public.
+func (d *Dispatcher) CheckURL(ctx context.Context, hook_url *url.URL) error {
+	if d.AllowPrivate {
+		return nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, hook_url.Hostname())
+	if err != nil {
+		return fmt.Errorf("can't resolve %v: %w", hook_url.Hostname(), err)
+	}
+	for _, addr := range addrs {
+		if !publicIP(addr.IP) {
+			return fmt.Errorf("%v resolves to %v: %w", hook_url.Hostname(), addr.IP, ErrPrivateAddress)
+		}
+	}
+
+	return nil
+}
+
+// newClient makes the client of d. Its dialer checks the address it actually
+// connects to, so a host that resolves differently later gets nowhere either.
+func (d *Dispatcher) newClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network string, address string, c syscall.RawConn) error {
+			if d.AllowPrivate {
+				return nil
+			}
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if !publicIP(net.ParseIP(host)) {
+				return fmt.Errorf("%v: %w", host, ErrPrivateAddress)
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		// no proxy, the dialer has to see the webhook address
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+}
@@ -0,0 +1,236 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"microblog/queue"
+	"microblog/storage"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DeliverTask is the queue task that sends one delivery, its payload is the
+// delivery id.
+const DeliverTask = "deliver_webhook"
+
+const (
+	SignatureHeader = "X-Microblog-Signature"
+	EventHeader     = "X-Microblog-Event"
+	DeliveryHeader  = "X-Microblog-Delivery"
+)
+
+// Payload is the body of every webhook request.
+type Payload struct {
+	Id        string      `json:"id"`
+	Event     string      `json:"event"`
+	User      string      `json:"user"`
+	CreatedAt string      `json:"createdAt"`
+	Data      interface{} `json:"data"`
+}
+
+// Dispatcher turns events into deliveries and sends them through the queue.
+type Dispatcher struct {
+	Store  storage.WebhookStore
+	Queue  queue.Queue
+	Client *http.Client
+
+	// a delivery is tried MaxAttempts times, waiting BaseDelay, 2*BaseDelay,
+	// 4*BaseDelay... in between
+	MaxAttempts int
+	BaseDelay   time.Duration
+	// the webhook is disabled after DisableAfter failed deliveries in a row
+	DisableAfter int
+	// lets webhooks reach loopback and private addresses, only for tests
+	AllowPrivate bool
+}
+
+func NewDispatcher(store storage.WebhookStore, q queue.Queue) *Dispatcher {
+	d := &Dispatcher{
+		Store:        store,
+		Queue:        q,
+		MaxAttempts:  6,
+		BaseDelay:    30 * time.Second,
+		DisableAfter: 5,
+	}
+	d.Client = d.newClient()
+
+	return d
+}
+
+func NewSecret() string {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(raw)
+}
+
+// Sign returns the value of SignatureHeader for body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func now() (string, int64) {
+	time_now := time.Now()
+	return time_now.UTC().Format("2006-01-02T15:04:05Z"), time_now.UnixNano()
+}
+
+// Dispatch schedules a delivery of event to every enabled webhook of user
+// subscribed to it. Errors are only logged: the event itself has happened.
+func (d *Dispatcher) Dispatch(ctx context.Context, user string, event string, data interface{}) {
+	if d == nil {
+		return
+	}
+
+	hooks, err := d.Store.GetWebhooks(ctx, user, event)
+	if err != nil {
+		log.Println("Failed to get webhooks of", user, "due to an error:", err)
+		return
+	}
+
+	for _, hook := range hooks {
+		iso_timestamp, timestamp := now()
+
+		delivery_id := uuid.NewString()
+		body, _ := json.Marshal(Payload{
+			Id:        delivery_id,
+			Event:     event,
+			User:      user,
+			CreatedAt: iso_timestamp,
+			Data:      data,
+		})
+
+		delivery := storage.WebhookDelivery{
+			Id:             delivery_id,
+			WebhookId:      hook.Id,
+			Event:          event,
+			Payload:        string(body),
+			Status:         storage.DeliveryPending,
+			CreatedAt:      iso_timestamp,
+			LastModifiedAt: iso_timestamp,
+			Timestamp:      timestamp,
+		}
+
+		err = d.Store.AddDelivery(ctx, delivery)
+		if err != nil {
+			log.Println("Failed to save delivery to webhook", hook.Id, "due to an error:", err)
+			continue
+		}
+
+		err = d.Queue.Send(ctx, queue.Task{Name: DeliverTask, Payload: delivery_id})
+		if err != nil {
+			log.Println("Failed to queue delivery", delivery_id, "due to an error:", err)
+		}
+	}
+}
+
+// Deliver is the handler of DeliverTask. It makes one attempt and asks the
+// queue to retry with exponential backoff while attempts are left.
+func (d *Dispatcher) Deliver(ctx context.Context, delivery_id string) error {
+	delivery, err := d.Store.GetDelivery(ctx, delivery_id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			// the webhook was deleted with its deliveries
+			return nil
+		}
+		return err
+	}
+	if delivery.Status != storage.DeliveryPending {
+		return nil
+	}
+
+	hook, err := d.Store.GetWebhook(ctx, delivery.WebhookId)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if hook.Disabled {
+		delivery.Status = storage.DeliveryFailed
+		delivery.LastError = "webhook is disabled"
+		delivery.LastModifiedAt, _ = now()
+		return d.Store.UpdateDelivery(ctx, delivery)
+	}
+
+	delivery.Attempts++
+	delivery.LastStatusCode, err = d.send(ctx, hook, delivery)
+	delivery.LastModifiedAt, _ = now()
+
+	if err == nil {
+		delivery.Status = storage.DeliverySucceeded
+		delivery.LastError = ""
+		if err = d.Store.UpdateDelivery(ctx, delivery); err != nil {
+			return err
+		}
+
+		if hook.Failures != 0 {
+			_, err = d.Store.ResetWebhookFailures(ctx, hook.Id, false)
+		}
+		return err
+	}
+
+	delivery.LastError = err.Error()
+
+	if delivery.Attempts < d.MaxAttempts {
+		if err = d.Store.UpdateDelivery(ctx, delivery); err != nil {
+			return err
+		}
+		delay := d.BaseDelay << (delivery.Attempts - 1)
+		return &queue.RetryLater{Delay: delay, Err: fmt.Errorf("delivery %v: %v", delivery.Id, delivery.LastError)}
+	}
+
+	delivery.Status = storage.DeliveryFailed
+	if err = d.Store.UpdateDelivery(ctx, delivery); err != nil {
+		return err
+	}
+
+	updated, err := d.Store.AddWebhookFailure(ctx, hook.Id, d.DisableAfter)
+	if err == nil && updated.Disabled && !hook.Disabled {
+		log.Println("Webhook", hook.Id, "of", hook.User, "is disabled after", updated.Failures, "failed deliveries")
+	}
+	return err
+}
+
+// send posts the payload and returns the status code of the answer.
+// Anything but 2xx is an error.
+func (d *Dispatcher) send(ctx context.Context, hook storage.Webhook, delivery storage.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "MicroBlog-Webhooks")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.Id)
+	req.Header.Set(SignatureHeader, Sign(hook.Secret, body))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook answered %v", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}